@@ -0,0 +1,1082 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+INFO: Authentication and ACL users
+Every client is authenticated as a user, a user has:
+- passwords, stored as SHA-256 hex digests
+- command rules (+get -@dangerous ...), evaluated in order against the
+  command table flags and ACL categories
+- key patterns with read/write permissions and pub/sub channel patterns
+The "default" user is used by new connections, requirepass sets its password.
+Users can be persisted in the aclfile (ACL SAVE / ACL LOAD), one
+"user <name> <rules>" line per user. Denied commands and failed
+authentications are recorded in the ACL LOG.
+*/
+
+// user flags
+const (
+	USER_FLAG_ENABLED               = 1 << iota // on
+	USER_FLAG_DISABLED                          // off
+	USER_FLAG_NOPASS                            // any password works
+	USER_FLAG_SANITIZE_PAYLOAD                  // sanitize-payload
+	USER_FLAG_SANITIZE_PAYLOAD_SKIP             // skip-sanitize-payload
+	USER_FLAG_ALLKEYS                           // ~* or allkeys
+	USER_FLAG_ALLCHANNELS                       // &* or allchannels
+)
+
+// key permissions
+const (
+	ACL_READ_PERMISSION  = 1 << 0
+	ACL_WRITE_PERMISSION = 1 << 1
+	ACL_ALL_PERMISSION   = ACL_READ_PERMISSION | ACL_WRITE_PERMISSION
+)
+
+// result of the permission checks
+const (
+	ACL_OK = iota
+	ACL_DENIED_CMD
+	ACL_DENIED_KEY
+	ACL_DENIED_AUTH
+	ACL_DENIED_CHANNEL
+)
+
+// ACL categories of the commands
+const (
+	ACL_CATEGORY_KEYSPACE = 1 << iota
+	ACL_CATEGORY_READ
+	ACL_CATEGORY_WRITE
+	ACL_CATEGORY_SET
+	ACL_CATEGORY_SORTEDSET
+	ACL_CATEGORY_LIST
+	ACL_CATEGORY_HASH
+	ACL_CATEGORY_STRING
+	ACL_CATEGORY_BITMAP
+	ACL_CATEGORY_HYPERLOGLOG
+	ACL_CATEGORY_GEO
+	ACL_CATEGORY_STREAM
+	ACL_CATEGORY_PUBSUB
+	ACL_CATEGORY_ADMIN
+	ACL_CATEGORY_FAST
+	ACL_CATEGORY_SLOW
+	ACL_CATEGORY_BLOCKING
+	ACL_CATEGORY_DANGEROUS
+	ACL_CATEGORY_CONNECTION
+	ACL_CATEGORY_TRANSACTION
+	ACL_CATEGORY_SCRIPTING
+)
+
+var aclCategoryNames = []struct {
+	name     string
+	category int
+}{
+	{"keyspace", ACL_CATEGORY_KEYSPACE},
+	{"read", ACL_CATEGORY_READ},
+	{"write", ACL_CATEGORY_WRITE},
+	{"set", ACL_CATEGORY_SET},
+	{"sortedset", ACL_CATEGORY_SORTEDSET},
+	{"list", ACL_CATEGORY_LIST},
+	{"hash", ACL_CATEGORY_HASH},
+	{"string", ACL_CATEGORY_STRING},
+	{"bitmap", ACL_CATEGORY_BITMAP},
+	{"hyperloglog", ACL_CATEGORY_HYPERLOGLOG},
+	{"geo", ACL_CATEGORY_GEO},
+	{"stream", ACL_CATEGORY_STREAM},
+	{"pubsub", ACL_CATEGORY_PUBSUB},
+	{"admin", ACL_CATEGORY_ADMIN},
+	{"fast", ACL_CATEGORY_FAST},
+	{"slow", ACL_CATEGORY_SLOW},
+	{"blocking", ACL_CATEGORY_BLOCKING},
+	{"dangerous", ACL_CATEGORY_DANGEROUS},
+	{"connection", ACL_CATEGORY_CONNECTION},
+	{"transaction", ACL_CATEGORY_TRANSACTION},
+	{"scripting", ACL_CATEGORY_SCRIPTING},
+}
+
+const DEFAULT_USER_NAME = "default"
+
+// ACL LOG contexts, the command was denied at the top level or inside EXEC
+const (
+	ACL_LOG_CTX_TOPLEVEL = iota
+	ACL_LOG_CTX_MULTI
+)
+
+// entries denied in this window for the same reason are grouped
+const ACL_LOG_GROUPING_MAX_TIME_DELTA = 60 * time.Second
+
+// for an ACL LOG entry
+type aclLogEntry struct {
+	count      int
+	reason     int
+	context    int
+	object     string
+	username   string
+	clientInfo string
+	entryID    int64
+	created    time.Time
+	updated    time.Time
+}
+
+var (
+	// newest first
+	aclLog         []*aclLogEntry
+	aclLogNextID   int64
+	aclLogMaxLen   = 128
+	aclLogReasons  = map[int]string{ACL_DENIED_CMD: "command", ACL_DENIED_KEY: "key", ACL_DENIED_AUTH: "auth", ACL_DENIED_CHANNEL: "channel"}
+	aclLogContexts = map[int]string{ACL_LOG_CTX_TOPLEVEL: "toplevel", ACL_LOG_CTX_MULTI: "multi"}
+	// path of the ACL file, empty if users are not persisted
+	aclFile string
+)
+
+var (
+	// ACL users by name
+	users       map[string]*User
+	defaultUser *User
+	// requirepass, the password of the default user
+	requirepass string
+)
+
+// for a key pattern of a user, ex: %R~cache:*
+type aclKeyPattern struct {
+	pattern string
+	flags   int
+}
+
+// ROLE: create the default user and apply requirepass, then load the ACL file
+// must run after the command table is ready
+func (app *App) initACL(password string, file string) error {
+	users = make(map[string]*User)
+	defaultUser = app.aclCreateUser(DEFAULT_USER_NAME)
+	app.aclSetDefaultUserRules(defaultUser)
+	app.aclUpdateRequirePass(password)
+	aclFile = file
+	if aclFile == "" {
+		return nil
+	}
+	return app.aclLoadFromFile(nil)
+}
+
+// ROLE: rules of the default user when it is not configured
+func (app *App) aclSetDefaultUserRules(user *User) {
+	for _, op := range []string{"+@all", "~*", "&*", "on", "nopass", "sanitize-payload"} {
+		app.aclSetUser(user, op)
+	}
+}
+
+// ROLE: create a new user: disabled, without passwords, commands, keys or channels
+func (app *App) aclCreateUser(name string) *User {
+	user := &User{
+		name:            name,
+		flags:           USER_FLAG_DISABLED | USER_FLAG_SANITIZE_PAYLOAD,
+		allowedCommands: make(map[string]bool),
+	}
+	users[name] = user
+	return user
+}
+
+// ROLE: set the password of the default user, an empty password means nopass
+func (app *App) aclUpdateRequirePass(password string) {
+	requirepass = password
+	app.aclSetUser(defaultUser, "resetpass")
+	if password == "" {
+		app.aclSetUser(defaultUser, "nopass")
+	} else {
+		app.aclSetUser(defaultUser, ">"+password)
+	}
+}
+
+// ROLE: deep copy of the user, rules are applied to a copy first so a
+// failing ACL SETUSER leaves the user untouched
+func (user *User) copy() *User {
+	copied := *user
+	copied.passwords = append([]string(nil), user.passwords...)
+	copied.commandRules = append([]string(nil), user.commandRules...)
+	copied.keyPatterns = append([]aclKeyPattern(nil), user.keyPatterns...)
+	copied.channelPatterns = append([]string(nil), user.channelPatterns...)
+	copied.allowedCommands = make(map[string]bool, len(user.allowedCommands))
+	for name, allowed := range user.allowedCommands {
+		copied.allowedCommands[name] = allowed
+	}
+	return &copied
+}
+
+// ROLE: SHA-256 hex digest of the password
+func aclHashPassword(password string) string {
+	hash := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
+
+// ROLE: validate a password hash given with #<hash>
+func aclValidPasswordHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if !(hash[i] >= '0' && hash[i] <= '9') && !(hash[i] >= 'a' && hash[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ROLE: get the ACL category by its name, 0 if unknown
+func aclGetCategoryByName(name string) int {
+	for _, category := range aclCategoryNames {
+		if strings.EqualFold(category.name, name) {
+			return category.category
+		}
+	}
+	return 0
+}
+
+// ROLE: categories of the command derived from its flags
+func aclImplicitCategories(command *Command) int {
+	categories := 0
+	if command.flags&CMD_WRITE != 0 {
+		categories |= ACL_CATEGORY_WRITE
+	}
+	if command.flags&CMD_READONLY != 0 {
+		categories |= ACL_CATEGORY_READ
+	}
+	if command.flags&CMD_ADMIN != 0 {
+		categories |= ACL_CATEGORY_ADMIN | ACL_CATEGORY_DANGEROUS
+	}
+	if command.flags&CMD_PUBSUB != 0 {
+		categories |= ACL_CATEGORY_PUBSUB
+	}
+	if command.flags&CMD_FAST != 0 {
+		categories |= ACL_CATEGORY_FAST
+	} else {
+		categories |= ACL_CATEGORY_SLOW
+	}
+	return categories
+}
+
+// ROLE: find the command for a rule: name or container|subcommand
+func aclLookupCommandRule(name string) *Command {
+	parent, subcommand, found := strings.Cut(name, "|")
+	command := lookupCommand(parent)
+	if command == nil || !found {
+		return command
+	}
+	return command.subcommands[strings.ToLower(subcommand)]
+}
+
+// ROLE: add the +/- command rule to the user, the rules which are overridden are dropped
+func (user *User) addCommandRule(rule string) {
+	target := rule[1:]
+	if target == "@all" {
+		user.commandRules = []string{rule}
+		return
+	}
+	if !strings.HasPrefix(target, "@") {
+		rules := user.commandRules[:0]
+		for _, existing := range user.commandRules {
+			if existing[1:] != target {
+				rules = append(rules, existing)
+			}
+		}
+		user.commandRules = rules
+	}
+	user.commandRules = append(user.commandRules, rule)
+}
+
+// ROLE: evaluate the command rules of the user for the command
+func (user *User) commandAllowedByRules(command *Command) bool {
+	allowed := false
+	for _, rule := range user.commandRules {
+		add, target := rule[0] == '+', rule[1:]
+		switch {
+		case target == "@all":
+			allowed = add
+		case strings.HasPrefix(target, "@"):
+			if command.aclCategories&aclGetCategoryByName(target[1:]) != 0 {
+				allowed = add
+			}
+		case target == command.fullName || (command.parent != nil && target == command.parent.name):
+			allowed = add
+		}
+	}
+	return allowed
+}
+
+// ROLE: compute the commands (and subcommands) allowed to the user
+func (user *User) updateAllowedCommands() {
+	user.allowedCommands = make(map[string]bool)
+	for _, command := range commandTable {
+		user.allowedCommands[command.fullName] = user.commandAllowedByRules(command)
+		for _, subcommand := range command.subcommands {
+			user.allowedCommands[subcommand.fullName] = user.commandAllowedByRules(subcommand)
+		}
+	}
+}
+
+// ROLE: apply one ACL SETUSER rule to the user
+func (app *App) aclSetUser(user *User, op string) error {
+	if op == "" {
+		return errors.New("Syntax error")
+	}
+	switch lower := strings.ToLower(op); {
+	case lower == "on":
+		user.flags = user.flags&^USER_FLAG_DISABLED | USER_FLAG_ENABLED
+	case lower == "off":
+		user.flags = user.flags&^USER_FLAG_ENABLED | USER_FLAG_DISABLED
+	case lower == "sanitize-payload":
+		user.flags = user.flags&^USER_FLAG_SANITIZE_PAYLOAD_SKIP | USER_FLAG_SANITIZE_PAYLOAD
+	case lower == "skip-sanitize-payload":
+		user.flags = user.flags&^USER_FLAG_SANITIZE_PAYLOAD | USER_FLAG_SANITIZE_PAYLOAD_SKIP
+	case lower == "nopass":
+		user.flags |= USER_FLAG_NOPASS
+		user.passwords = nil
+	case lower == "resetpass":
+		user.flags &^= USER_FLAG_NOPASS
+		user.passwords = nil
+	case lower == "allkeys" || op == "~*":
+		user.flags |= USER_FLAG_ALLKEYS
+		user.keyPatterns = nil
+	case lower == "resetkeys":
+		user.flags &^= USER_FLAG_ALLKEYS
+		user.keyPatterns = nil
+	case lower == "allchannels" || op == "&*":
+		user.flags |= USER_FLAG_ALLCHANNELS
+		user.channelPatterns = nil
+	case lower == "resetchannels":
+		user.flags &^= USER_FLAG_ALLCHANNELS
+		user.channelPatterns = nil
+	case lower == "allcommands":
+		return app.aclSetUser(user, "+@all")
+	case lower == "nocommands":
+		return app.aclSetUser(user, "-@all")
+	case lower == "clearselectors":
+		// selectors are not supported, there is nothing to clear
+	case lower == "reset":
+		for _, reset := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			app.aclSetUser(user, reset)
+		}
+	case op[0] == '>' || op[0] == '#':
+		hash := op[1:]
+		if op[0] == '>' {
+			hash = aclHashPassword(op[1:])
+		} else if !aclValidPasswordHash(hash) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		user.flags &^= USER_FLAG_NOPASS
+		for _, existing := range user.passwords {
+			if existing == hash {
+				return nil
+			}
+		}
+		user.passwords = append(user.passwords, hash)
+	case op[0] == '<' || op[0] == '!':
+		hash := op[1:]
+		if op[0] == '<' {
+			hash = aclHashPassword(op[1:])
+		} else if !aclValidPasswordHash(hash) {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		for i, existing := range user.passwords {
+			if existing == hash {
+				user.passwords = append(user.passwords[:i], user.passwords[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("The password you are trying to remove from the user does not exist")
+	case op[0] == '~' || op[0] == '%':
+		return user.addKeyPattern(op)
+	case op[0] == '&':
+		if user.flags&USER_FLAG_ALLCHANNELS != 0 {
+			return errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. Try 'resetchannels' to start with an empty list of channels")
+		}
+		for _, existing := range user.channelPatterns {
+			if existing == op[1:] {
+				return nil
+			}
+		}
+		user.channelPatterns = append(user.channelPatterns, op[1:])
+	case op[0] == '+' || op[0] == '-':
+		target := strings.ToLower(op[1:])
+		if strings.HasPrefix(target, "@") {
+			if target != "@all" && aclGetCategoryByName(target[1:]) == 0 {
+				return errors.New("Unknown command or category name in ACL")
+			}
+		} else if aclLookupCommandRule(target) == nil {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		user.addCommandRule(op[:1] + target)
+		user.updateAllowedCommands()
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+// ROLE: add a key pattern: ~pattern (read and write), %R~, %W~ or %RW~pattern
+func (user *User) addKeyPattern(op string) error {
+	permissions := ACL_ALL_PERMISSION
+	pattern := op[1:]
+	if op[0] == '%' {
+		flags, rest, found := strings.Cut(op[1:], "~")
+		if !found || flags == "" {
+			return errors.New("Syntax error")
+		}
+		permissions = 0
+		for _, flag := range strings.ToUpper(flags) {
+			switch flag {
+			case 'R':
+				permissions |= ACL_READ_PERMISSION
+			case 'W':
+				permissions |= ACL_WRITE_PERMISSION
+			default:
+				return errors.New("Syntax error")
+			}
+		}
+		pattern = rest
+	}
+	if user.flags&USER_FLAG_ALLKEYS != 0 {
+		return errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+	}
+	if pattern == "*" && permissions == ACL_ALL_PERMISSION {
+		user.flags |= USER_FLAG_ALLKEYS
+		user.keyPatterns = nil
+		return nil
+	}
+	for i, existing := range user.keyPatterns {
+		if existing.pattern == pattern {
+			user.keyPatterns[i].flags |= permissions
+			return nil
+		}
+	}
+	user.keyPatterns = append(user.keyPatterns, aclKeyPattern{pattern: pattern, flags: permissions})
+	return nil
+}
+
+// ROLE: check the user can access the key with the permissions
+func (user *User) keyAllowed(key string, permissions int) bool {
+	if user.flags&USER_FLAG_ALLKEYS != 0 {
+		return true
+	}
+	for _, pattern := range user.keyPatterns {
+		if pattern.flags&permissions == permissions && globMatch(pattern.pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// ROLE: check the user can access the channel
+// a pattern (PSUBSCRIBE) must be one of the user patterns literally
+func (user *User) channelAllowed(channel string, isPattern bool) bool {
+	if user.flags&USER_FLAG_ALLCHANNELS != 0 {
+		return true
+	}
+	for _, pattern := range user.channelPatterns {
+		if (isPattern && pattern == channel) || (!isPattern && globMatch(pattern, channel, false)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ROLE: check if the user can run the command with these arguments
+// returns ACL_OK or the ACL_DENIED_* reason and the denied key or channel
+func (app *App) aclCheckAllUserPerm(user *User, command *Command, commands []string) (int, string) {
+	// no user is the superuser, ex: our master
+	if user == nil {
+		return ACL_OK, ""
+	}
+	checked := command
+	if subcommand := lookupSubcommand(command, commands); subcommand != nil {
+		checked = subcommand
+	}
+	if !user.allowedCommands[checked.fullName] {
+		return ACL_DENIED_CMD, checked.fullName
+	}
+
+	permissions := ACL_READ_PERMISSION
+	if command.flags&CMD_WRITE != 0 {
+		permissions = ACL_WRITE_PERMISSION
+	}
+	for _, key := range getKeysFromCommand(command, commands) {
+		if !user.keyAllowed(key, permissions) {
+			return ACL_DENIED_KEY, key
+		}
+	}
+
+	var channels []string
+	isPattern := false
+	switch command.name {
+	case "publish":
+		channels = commands[1:2]
+	case "subscribe":
+		channels = commands[1:]
+	case "psubscribe":
+		channels, isPattern = commands[1:], true
+	}
+	for _, channel := range channels {
+		if !user.channelAllowed(channel, isPattern) {
+			return ACL_DENIED_CHANNEL, channel
+		}
+	}
+	return ACL_OK, ""
+}
+
+// ROLE: check if the client can run the command
+func (app *App) aclCheckAllPerm(client *Client, command *Command, commands []string) (int, string) {
+	return app.aclCheckAllUserPerm(client.user, command, commands)
+}
+
+// ROLE: error message of a denied permission check
+func aclDeniedMessage(user *User, reason int, object string) string {
+	switch reason {
+	case ACL_DENIED_CMD:
+		return fmt.Sprintf("User %s has no permissions to run the '%s' command", user.name, object)
+	case ACL_DENIED_KEY:
+		return "No permissions to access a key"
+	case ACL_DENIED_CHANNEL:
+		return "No permissions to access a channel"
+	}
+	return "no permission"
+}
+
+// ROLE: -NOPERM error of a denied permission check
+func (app *App) aclDeniedResponse(client *Client, reason int, object string) []byte {
+	return []byte(fmt.Sprintf("-NOPERM %s\r\n", aclDeniedMessage(client.user, reason, object)))
+}
+
+// ROLE: check if the client must authenticate before running commands
+func (app *App) authRequired(client *Client) bool {
+	return (defaultUser.flags&USER_FLAG_NOPASS == 0 || defaultUser.flags&USER_FLAG_DISABLED != 0) && !client.authenticated
+}
+
+// ROLE: check the username and password, returns the user if they are valid
+func (app *App) aclCheckUserCredentials(username, password string) *User {
+	user, ok := users[username]
+	if !ok || user.flags&USER_FLAG_DISABLED != 0 {
+		return nil
+	}
+	if user.flags&USER_FLAG_NOPASS != 0 {
+		return user
+	}
+	// compared in constant time, the time taken doesn't tell how much of the hash matched
+	hash := []byte(aclHashPassword(password))
+	for _, existing := range user.passwords {
+		if subtle.ConstantTimeCompare([]byte(existing), hash) == 1 {
+			return user
+		}
+	}
+	return nil
+}
+
+// ROLE: authenticate the client as the user, failures are recorded in the ACL LOG
+func (app *App) aclAuthenticateUser(client *Client, username, password string) bool {
+	user := app.aclCheckUserCredentials(username, password)
+	if user == nil {
+		app.addACLLogEntry(client, ACL_DENIED_AUTH, ACL_LOG_CTX_TOPLEVEL, "AUTH", username)
+		return false
+	}
+	client.user = user
+	client.authenticated = true
+	return true
+}
+
+// ROLE: disconnect the clients authenticated as the user, ex: the user was deleted
+func (app *App) aclKillUserClients(current *Client, user *User) {
+	for _, client := range clients {
+		if client.user != user {
+			continue
+		}
+		if client == current {
+			client.flags |= CLIENT_CLOSE_AFTER_REPLY
+		} else {
+			app.freeClient(client)
+		}
+	}
+}
+
+// ROLE: describe the user as ACL rules, the ACL LIST format
+func (app *App) aclDescribeUser(user *User) string {
+	rules := []string{"user", user.name}
+	rules = append(rules, app.aclDescribeUserFlags(user)...)
+	for _, hash := range user.passwords {
+		rules = append(rules, "#"+hash)
+	}
+	if keys := aclDescribeKeyPatterns(user); keys != "" {
+		rules = append(rules, keys)
+	}
+	rules = append(rules, aclDescribeChannelPatterns(user))
+	rules = append(rules, aclDescribeCommandRules(user))
+	return strings.Join(rules, " ")
+}
+
+// ROLE: on/off, nopass and the payload sanitization flags of the user
+func (app *App) aclDescribeUserFlags(user *User) []string {
+	flags := []string{"off"}
+	if user.flags&USER_FLAG_ENABLED != 0 {
+		flags[0] = "on"
+	}
+	if user.flags&USER_FLAG_NOPASS != 0 {
+		flags = append(flags, "nopass")
+	}
+	if user.flags&USER_FLAG_SANITIZE_PAYLOAD != 0 {
+		flags = append(flags, "sanitize-payload")
+	}
+	if user.flags&USER_FLAG_SANITIZE_PAYLOAD_SKIP != 0 {
+		flags = append(flags, "skip-sanitize-payload")
+	}
+	return flags
+}
+
+func aclDescribeKeyPatterns(user *User) string {
+	if user.flags&USER_FLAG_ALLKEYS != 0 {
+		return "~*"
+	}
+	patterns := make([]string, 0, len(user.keyPatterns))
+	for _, pattern := range user.keyPatterns {
+		switch pattern.flags {
+		case ACL_ALL_PERMISSION:
+			patterns = append(patterns, "~"+pattern.pattern)
+		case ACL_READ_PERMISSION:
+			patterns = append(patterns, "%R~"+pattern.pattern)
+		case ACL_WRITE_PERMISSION:
+			patterns = append(patterns, "%W~"+pattern.pattern)
+		}
+	}
+	return strings.Join(patterns, " ")
+}
+
+func aclDescribeChannelPatterns(user *User) string {
+	if user.flags&USER_FLAG_ALLCHANNELS != 0 {
+		return "&*"
+	}
+	if len(user.channelPatterns) == 0 {
+		return "resetchannels"
+	}
+	patterns := make([]string, 0, len(user.channelPatterns))
+	for _, pattern := range user.channelPatterns {
+		patterns = append(patterns, "&"+pattern)
+	}
+	return strings.Join(patterns, " ")
+}
+
+func aclDescribeCommandRules(user *User) string {
+	if len(user.commandRules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(user.commandRules, " ")
+}
+
+// ROLE: sorted names of the users
+func aclUserNames() []string {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ROLE: handle AUTH [username] password
+func (app *App) executeAUTH(client *Client, commands []string) []byte {
+	if len(commands) > 3 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	username, password := DEFAULT_USER_NAME, commands[1]
+	if len(commands) == 3 {
+		username, password = commands[1], commands[2]
+	} else if defaultUser.flags&USER_FLAG_NOPASS != 0 {
+		return []byte("-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n")
+	}
+	if !app.aclAuthenticateUser(client, username, password) {
+		return []byte("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	}
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle the ACL subcommands
+func (app *App) executeACL(client *Client, commands []string) []byte {
+	subcommand := strings.ToUpper(commands[1])
+	switch {
+	case subcommand == "SETUSER" && len(commands) >= 3:
+		return app.aclSETUSER(commands)
+	case subcommand == "GETUSER" && len(commands) == 3:
+		return app.aclGETUSER(client, commands[2])
+	case subcommand == "DELUSER" && len(commands) >= 3:
+		deleted := 0
+		for _, name := range commands[2:] {
+			if name == DEFAULT_USER_NAME {
+				return []byte("-ERR The 'default' user cannot be removed\r\n")
+			}
+		}
+		for _, name := range commands[2:] {
+			user, ok := users[name]
+			if !ok {
+				continue
+			}
+			delete(users, name)
+			app.aclKillUserClients(client, user)
+			deleted++
+		}
+		return app.createIntegerResponse(deleted)
+	case subcommand == "LIST" && len(commands) == 2:
+		var list []string
+		for _, name := range aclUserNames() {
+			list = append(list, app.aclDescribeUser(users[name]))
+		}
+		return []byte(app.createRESPArray(list))
+	case subcommand == "USERS" && len(commands) == 2:
+		return []byte(app.createRESPArray(aclUserNames()))
+	case subcommand == "WHOAMI" && len(commands) == 2:
+		if client.user == nil {
+			return app.createNullResponse(client)
+		}
+		return app.createBulkStringResponse(client.user.name)
+	case subcommand == "CAT" && (len(commands) == 2 || len(commands) == 3):
+		return app.aclCAT(commands)
+	case subcommand == "GENPASS" && (len(commands) == 2 || len(commands) == 3):
+		bits := 256
+		if len(commands) == 3 {
+			parsed, err := strconv.Atoi(commands[2])
+			if err != nil || parsed <= 0 || parsed > 4096 {
+				return []byte("-ERR ACL GENPASS argument must be the number of bits for the output password, a positive number up to 4096\r\n")
+			}
+			bits = parsed
+		}
+		// 4 bits per hex char
+		random := make([]byte, (bits+7)/8)
+		if _, err := rand.Read(random); err != nil {
+			return []byte(fmt.Sprintf("-ERR %s\r\n", err))
+		}
+		return app.createBulkStringResponse(hex.EncodeToString(random)[:(bits+3)/4])
+	case subcommand == "DRYRUN" && len(commands) >= 4:
+		return app.aclDRYRUN(commands)
+	case subcommand == "LOG" && (len(commands) == 2 || len(commands) == 3):
+		return app.aclLOG(client, commands)
+	case (subcommand == "LOAD" || subcommand == "SAVE") && len(commands) == 2:
+		if aclFile == "" {
+			return []byte("-ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.\r\n")
+		}
+		var err error
+		if subcommand == "LOAD" {
+			err = app.aclLoadFromFile(client)
+		} else {
+			err = app.aclSaveToFile()
+		}
+		if err != nil {
+			return []byte(fmt.Sprintf("-ERR %s\r\n", err))
+		}
+		return []byte("+OK\r\n")
+	case subcommand == "HELP" && len(commands) == 2:
+		return []byte(app.createRESPArray([]string{
+			"ACL <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CAT [<category>]",
+			"    List all commands that belong to <category>, or all command categories",
+			"    when no category is specified.",
+			"DELUSER <username> [<username> ...]",
+			"    Delete a list of users.",
+			"DRYRUN <username> <command> [<arg> ...]",
+			"    Returns whether the user can execute the given command without executing the command.",
+			"GETUSER <username>",
+			"    Get the user's details.",
+			"GENPASS [<bits>]",
+			"    Generate a secure 256-bit user password. The optional `bits` argument can",
+			"    be used to specify a different size.",
+			"LIST",
+			"    Show users details in config file format.",
+			"LOAD",
+			"    Reload users from the ACL file.",
+			"LOG [<count> | RESET]",
+			"    Show the ACL log entries.",
+			"SAVE",
+			"    Save the current config to the ACL file.",
+			"SETUSER <username> <attribute> [<attribute> ...]",
+			"    Create or modify a user with the specified attributes.",
+			"USERS",
+			"    List all the registered usernames.",
+			"WHOAMI",
+			"    Return the current connection username.",
+		}))
+	}
+	return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try ACL HELP.\r\n", commands[1]))
+}
+
+// ROLE: handle ACL SETUSER username [rule ...], all the rules are applied or none
+func (app *App) aclSETUSER(commands []string) []byte {
+	name := commands[2]
+	if strings.ContainsAny(name, " \x00") {
+		return []byte("-ERR Usernames can't contain spaces or null characters\r\n")
+	}
+	user, exists := users[name]
+	var modified *User
+	if exists {
+		modified = user.copy()
+	} else {
+		modified = &User{name: name, flags: USER_FLAG_DISABLED | USER_FLAG_SANITIZE_PAYLOAD, allowedCommands: make(map[string]bool)}
+	}
+	for _, op := range commands[3:] {
+		if err := app.aclSetUser(modified, op); err != nil {
+			return []byte(fmt.Sprintf("-ERR Error in ACL SETUSER modifier '%s': %s\r\n", op, err))
+		}
+	}
+	// clients keep their pointer to the user, it is updated in place
+	if exists {
+		*user = *modified
+	} else {
+		users[name] = modified
+	}
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle ACL GETUSER username
+func (app *App) aclGETUSER(client *Client, name string) []byte {
+	user, ok := users[name]
+	if !ok {
+		return app.createNullResponse(client)
+	}
+	return app.createMapResponse(client, [][]byte{
+		app.createBulkStringResponse("flags"), []byte(app.createRESPArray(app.aclDescribeUserFlags(user))),
+		app.createBulkStringResponse("passwords"), []byte(app.createRESPArray(user.passwords)),
+		app.createBulkStringResponse("commands"), app.createBulkStringResponse(aclDescribeCommandRules(user)),
+		app.createBulkStringResponse("keys"), app.createBulkStringResponse(aclDescribeKeyPatterns(user)),
+		app.createBulkStringResponse("channels"), app.createBulkStringResponse(strings.TrimPrefix(aclDescribeChannelPatterns(user), "resetchannels")),
+		app.createBulkStringResponse("selectors"), app.createRESPArrayOfElements(nil),
+	})
+}
+
+// ROLE: handle ACL CAT [category]
+func (app *App) aclCAT(commands []string) []byte {
+	if len(commands) == 2 {
+		names := make([]string, 0, len(aclCategoryNames))
+		for _, category := range aclCategoryNames {
+			names = append(names, category.name)
+		}
+		return []byte(app.createRESPArray(names))
+	}
+	category := aclGetCategoryByName(commands[2])
+	if category == 0 {
+		return []byte(fmt.Sprintf("-ERR Unknown category '%s'\r\n", commands[2]))
+	}
+	var names []string
+	for _, command := range commandTable {
+		if command.aclCategories&category != 0 {
+			names = append(names, command.fullName)
+		}
+		for _, subcommand := range command.subcommands {
+			if subcommand.aclCategories&category != 0 {
+				names = append(names, subcommand.fullName)
+			}
+		}
+	}
+	sort.Strings(names)
+	return []byte(app.createRESPArray(names))
+}
+
+// ROLE: handle ACL DRYRUN username command [arg ...]
+func (app *App) aclDRYRUN(commands []string) []byte {
+	user, ok := users[commands[2]]
+	if !ok {
+		return []byte(fmt.Sprintf("-ERR User '%s' not found\r\n", commands[2]))
+	}
+	command := lookupCommand(commands[3])
+	if command == nil {
+		return []byte(fmt.Sprintf("-ERR Command '%s' not found\r\n", commands[3]))
+	}
+	arguments := commands[3:]
+	if (command.arity > 0 && len(arguments) != command.arity) || len(arguments) < -command.arity {
+		return []byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", command.name))
+	}
+	if reason, object := app.aclCheckAllUserPerm(user, command, arguments); reason != ACL_OK {
+		return app.createBulkStringResponse(aclDeniedMessage(user, reason, object))
+	}
+	return []byte("+OK\r\n")
+}
+
+// ROLE: record a denied command or a failed authentication in the ACL LOG
+// a similar entry of the last minute is updated instead of adding a new one
+func (app *App) addACLLogEntry(client *Client, reason int, context int, object string, username string) {
+	switch reason {
+	case ACL_DENIED_AUTH:
+		stats.aclAccessDeniedAuth++
+	case ACL_DENIED_CMD:
+		stats.aclAccessDeniedCmd++
+	case ACL_DENIED_KEY:
+		stats.aclAccessDeniedKey++
+	case ACL_DENIED_CHANNEL:
+		stats.aclAccessDeniedChannel++
+	}
+	if username == "" && client.user != nil {
+		username = client.user.name
+	}
+	now := time.Now()
+	for _, entry := range aclLog {
+		if entry.reason == reason && entry.context == context && entry.object == object &&
+			entry.username == username && now.Sub(entry.updated) < ACL_LOG_GROUPING_MAX_TIME_DELTA {
+			entry.count++
+			entry.updated = now
+			entry.clientInfo = app.clientInfoString(client)
+			return
+		}
+	}
+
+	aclLogNextID++
+	aclLog = append([]*aclLogEntry{{
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: app.clientInfoString(client),
+		entryID:    aclLogNextID,
+		created:    now,
+		updated:    now,
+	}}, aclLog...)
+	app.trimACLLog()
+}
+
+// ROLE: keep at most acllog-max-len entries, the oldest are dropped
+func (app *App) trimACLLog() {
+	if len(aclLog) > aclLogMaxLen {
+		aclLog = aclLog[:aclLogMaxLen]
+	}
+}
+
+// ROLE: handle ACL LOG [count|RESET]
+func (app *App) aclLOG(client *Client, commands []string) []byte {
+	count := 10
+	if len(commands) == 3 {
+		if strings.EqualFold(commands[2], "RESET") {
+			aclLog = nil
+			return []byte("+OK\r\n")
+		}
+		parsed, err := strconv.Atoi(commands[2])
+		if err != nil || parsed < 0 {
+			return []byte("-ERR value is out of range, must be positive\r\n")
+		}
+		count = parsed
+	}
+
+	now := time.Now()
+	var entries [][]byte
+	for i := 0; i < count && i < len(aclLog); i++ {
+		entry := aclLog[i]
+		entries = append(entries, app.createMapResponse(client, [][]byte{
+			app.createBulkStringResponse("count"), app.createIntegerResponse(entry.count),
+			app.createBulkStringResponse("reason"), app.createBulkStringResponse(aclLogReasons[entry.reason]),
+			app.createBulkStringResponse("context"), app.createBulkStringResponse(aclLogContexts[entry.context]),
+			app.createBulkStringResponse("object"), app.createBulkStringResponse(entry.object),
+			app.createBulkStringResponse("username"), app.createBulkStringResponse(entry.username),
+			app.createBulkStringResponse("age-seconds"), app.createBulkStringResponse(fmt.Sprintf("%.3f", now.Sub(entry.created).Seconds())),
+			app.createBulkStringResponse("client-info"), app.createBulkStringResponse(entry.clientInfo),
+			app.createBulkStringResponse("entry-id"), app.createIntegerResponse(int(entry.entryID)),
+			app.createBulkStringResponse("timestamp-created"), app.createIntegerResponse(int(entry.created.UnixMilli())),
+			app.createBulkStringResponse("timestamp-last-updated"), app.createIntegerResponse(int(entry.updated.UnixMilli())),
+		}))
+	}
+	return app.createRESPArrayOfElements(entries)
+}
+
+// ROLE: load the users from the ACL file, all or nothing
+// users which are no longer defined are deleted and their clients disconnected,
+// the default user gets its default rules if the file doesn't define it
+func (app *App) aclLoadFromFile(current *Client) error {
+	file, err := os.Open(aclFile)
+	if err != nil {
+		return fmt.Errorf("Error loading ACLs, opening file '%s': %s", aclFile, err)
+	}
+	defer file.Close()
+
+	loaded := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: line should start with user keyword", aclFile, lineNumber)
+		}
+		name := fields[1]
+		if _, ok := loaded[name]; ok {
+			return fmt.Errorf("%s:%d: Duplicate user '%s' found", aclFile, lineNumber, name)
+		}
+		user := &User{name: name, flags: USER_FLAG_DISABLED | USER_FLAG_SANITIZE_PAYLOAD, allowedCommands: make(map[string]bool)}
+		for _, op := range fields[2:] {
+			if err := app.aclSetUser(user, op); err != nil {
+				return fmt.Errorf("%s:%d: Error in user declaration '%s': %s", aclFile, lineNumber, op, err)
+			}
+		}
+		loaded[name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error loading ACLs, reading file '%s': %s", aclFile, err)
+	}
+
+	if _, ok := loaded[DEFAULT_USER_NAME]; !ok {
+		user := &User{name: DEFAULT_USER_NAME, allowedCommands: make(map[string]bool)}
+		app.aclSetDefaultUserRules(user)
+		loaded[DEFAULT_USER_NAME] = user
+	}
+
+	// clients keep their pointer to the user, existing users are updated in place
+	for name, user := range users {
+		if _, ok := loaded[name]; !ok {
+			delete(users, name)
+			app.aclKillUserClients(current, user)
+		}
+	}
+	for name, user := range loaded {
+		if existing, ok := users[name]; ok {
+			*existing = *user
+		} else {
+			users[name] = user
+		}
+	}
+	defaultUser = users[DEFAULT_USER_NAME]
+	return nil
+}
+
+// ROLE: save the users to the ACL file
+// written to a temporary file first, the old file is replaced only on success
+func (app *App) aclSaveToFile() error {
+	var content strings.Builder
+	for _, name := range aclUserNames() {
+		content.WriteString(app.aclDescribeUser(users[name]))
+		content.WriteByte('\n')
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(aclFile), "temp-acl-*.acl")
+	if err != nil {
+		return fmt.Errorf("Opening temp ACL file for ACL SAVE: %s", err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.WriteString(content.String()); err != nil {
+		temp.Close()
+		return fmt.Errorf("Writing ACL file for ACL SAVE: %s", err)
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return fmt.Errorf("Syncing ACL file for ACL SAVE: %s", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("Closing ACL file for ACL SAVE: %s", err)
+	}
+	if err := os.Rename(temp.Name(), aclFile); err != nil {
+		return fmt.Errorf("Renaming ACL file for ACL SAVE: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestACLCheckAllPerm(t *testing.T) {
+	tests := []struct {
+		rules   string
+		command string
+		reason  int
+		object  string
+	}{
+		{"+@string ~*", "GET key", ACL_OK, ""},
+		{"+@string ~*", "DEL key", ACL_DENIED_CMD, "del"},
+		{"+@all -@string ~*", "SET key value", ACL_DENIED_CMD, "set"},
+		{"+@all -@string ~*", "DEL key", ACL_OK, ""},
+		{"+config -config|set", "CONFIG GET port", ACL_OK, ""},
+		{"+config -config|set", "CONFIG SET port 1", ACL_DENIED_CMD, "config|set"},
+		{"+config|get", "CONFIG GET port", ACL_OK, ""},
+		{"+config|get", "CONFIG RESETSTAT", ACL_DENIED_CMD, "config|resetstat"},
+		{"+@all ~user:*", "GET user:1", ACL_OK, ""},
+		{"+@all ~user:*", "GET order:1", ACL_DENIED_KEY, "order:1"},
+		{"+@all ~user:*", "DEL user:1 order:1", ACL_DENIED_KEY, "order:1"},
+		{"+@all %R~user:*", "GET user:1", ACL_OK, ""},
+		{"+@all %R~user:*", "SET user:1 value", ACL_DENIED_KEY, "user:1"},
+		{"+@all %W~user:*", "SET user:1 value", ACL_OK, ""},
+		{"+@all %W~user:*", "GET user:1", ACL_DENIED_KEY, "user:1"},
+		{"+@all %R~user:* %W~user:*", "SET user:1 value", ACL_OK, ""},
+		{"+@all &news.*", "PUBLISH news.sport hello", ACL_OK, ""},
+		{"+@all &news.*", "PUBLISH weather hello", ACL_DENIED_CHANNEL, "weather"},
+		{"+@all &news.*", "SUBSCRIBE news.sport weather", ACL_DENIED_CHANNEL, "weather"},
+		// a pattern must be one of the user patterns
+		{"+@all &news.*", "PSUBSCRIBE news.*", ACL_OK, ""},
+		{"+@all &news.*", "PSUBSCRIBE news.s*", ACL_DENIED_CHANNEL, "news.s*"},
+		{"+@all allchannels", "PSUBSCRIBE *", ACL_OK, ""},
+	}
+	startTestServer(t)
+	for _, test := range tests {
+		app := &App{}
+		user := app.aclCreateUser("tester")
+		for _, rule := range strings.Fields("on nopass " + test.rules) {
+			if err := app.aclSetUser(user, rule); err != nil {
+				t.Fatalf("%s: %v", rule, err)
+			}
+		}
+		commands := strings.Fields(test.command)
+		reason, object := app.aclCheckAllPerm(&Client{user: user}, lookupCommand(commands[0]), commands)
+		if reason != test.reason || object != test.object {
+			t.Fatalf("%s with %q: got %d %q, want %d %q", test.command, test.rules, reason, object, test.reason, test.object)
+		}
+	}
+}
+
+func TestACLCheckUserCredentials(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	client.do("ACL", "SETUSER", "alice", "on", ">first", ">second", "+@all", "~*")
+	client.do("ACL", "SETUSER", "bob", "off", ">secret")
+
+	app := server.app
+	server.locked(func() {
+		for _, test := range []struct {
+			username, password string
+			valid              bool
+		}{
+			{"alice", "first", true},
+			{"alice", "second", true},
+			{"alice", "firs", false},
+			{"alice", "", false},
+			{"bob", "secret", false},
+			{"nobody", "first", false},
+		} {
+			if user := app.aclCheckUserCredentials(test.username, test.password); (user != nil) != test.valid {
+				t.Fatalf("%s/%s: got %v, want valid %v", test.username, test.password, user, test.valid)
+			}
+		}
+	})
+	expectReply(t, client.do("AUTH", "alice", "wrong"), respError("WRONGPASS invalid username-password pair or user is disabled."))
+	expectReply(t, client.do("AUTH", "alice", "second"), "OK")
+	expectReply(t, client.do("ACL", "WHOAMI"), "alice")
+}
+
+// ROLE: the fields of the ACL LOG entries
+func aclLogEntries(t *testing.T, reply any) []map[string]any {
+	t.Helper()
+	list, ok := reply.([]any)
+	if !ok {
+		t.Fatalf("ACL LOG: %v", reply)
+	}
+	entries := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		fields := item.([]any)
+		entry := make(map[string]any)
+		for i := 0; i < len(fields); i += 2 {
+			entry[fields[i].(string)] = fields[i+1]
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestACLLog(t *testing.T) {
+	server := startTestServer(t, "acllog-max-len 3")
+	admin := server.connect(t)
+	admin.do("ACL", "SETUSER", "reader", "on", ">pass", "+get", "+auth", "+multi", "+exec", "%R~public:*")
+	client := server.connect(t)
+	client.do("AUTH", "reader", "pass")
+
+	client.do("AUTH", "reader", "wrong")
+	expectReply(t, client.do("SET", "public:1", "value"), respError("NOPERM User reader has no permissions to run the 'set' command"))
+	expectReply(t, client.do("GET", "private"), respError("NOPERM No permissions to access a key"))
+	expectReply(t, client.do("GET", "private"), respError("NOPERM No permissions to access a key"))
+	entries := aclLogEntries(t, admin.do("ACL", "LOG"))
+	if len(entries) != 3 {
+		t.Fatalf("ACL LOG has %d entries", len(entries))
+	}
+	// the newest first, the same denial within a minute is grouped
+	for i, want := range []struct {
+		reason, object, username string
+		count                    int64
+	}{
+		{"key", "private", "reader", 2},
+		{"command", "set", "reader", 1},
+		{"auth", "AUTH", "reader", 1},
+	} {
+		entry := entries[i]
+		if entry["reason"] != want.reason || entry["object"] != want.object || entry["username"] != want.username ||
+			entry["count"] != want.count || entry["context"] != "toplevel" {
+			t.Fatalf("entry %d: %v", i, entry)
+		}
+	}
+
+	// denied inside a transaction when the permissions changed after queuing, and the oldest entry dropped
+	client.do("MULTI")
+	client.do("GET", "public:1")
+	admin.do("ACL", "SETUSER", "reader", "resetkeys", "%R~secret")
+	expectReply(t, client.do("EXEC"), []any{respError("NOPERM No permissions to access a key")})
+	entries = aclLogEntries(t, admin.do("ACL", "LOG"))
+	if len(entries) != 3 || entries[0]["context"] != "multi" || entries[0]["object"] != "public:1" || entries[2]["reason"] != "command" {
+		t.Fatalf("ACL LOG after EXEC: %v", entries)
+	}
+	if entries := aclLogEntries(t, admin.do("ACL", "LOG", "1")); len(entries) != 1 {
+		t.Fatalf("ACL LOG 1 has %d entries", len(entries))
+	}
+	expectReply(t, admin.do("ACL", "LOG", "-1"), respError("ERR value is out of range, must be positive"))
+	expectReply(t, admin.do("ACL", "LOG", "RESET"), "OK")
+	expectReply(t, admin.do("ACL", "LOG"), []any{})
+}
+
+func TestACLFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "users.acl")
+	content := "user default on nopass ~* &* +@all\nuser alice on #" + aclHashPassword("secret") + " ~cache:* resetchannels -@all +get\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := startTestServer(t, "aclfile "+file)
+	admin := server.connect(t)
+	expectReply(t, admin.do("ACL", "USERS"), []any{"alice", "default"})
+	alice := server.connect(t)
+	expectReply(t, alice.do("AUTH", "alice", "secret"), "OK")
+	expectReply(t, alice.do("GET", "cache:1"), nil)
+
+	// SAVE writes what ACL LIST shows, LOAD brings it back
+	admin.do("ACL", "SETUSER", "bob", "on", ">pass", "+@all", "%R~*")
+	list := admin.do("ACL", "LIST")
+	expectReply(t, admin.do("ACL", "SAVE"), "OK")
+	saved, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []any
+	for _, line := range strings.Split(strings.TrimSpace(string(saved)), "\n") {
+		lines = append(lines, line)
+	}
+	expectReply(t, lines, list)
+	admin.do("ACL", "DELUSER", "bob")
+	admin.do("ACL", "SETUSER", "carol", "on", "nopass")
+	expectReply(t, admin.do("ACL", "LOAD"), "OK")
+	expectReply(t, admin.do("ACL", "LIST"), list)
+
+	// the users deleted by LOAD are disconnected, the others keep their session
+	if err := os.WriteFile(file, []byte("user default on nopass ~* &* +@all\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectReply(t, admin.do("ACL", "LOAD"), "OK")
+	alice.connection.SetReadDeadline(time.Now().Add(TEST_TIMEOUT))
+	if _, err := alice.reader.ReadByte(); err == nil {
+		t.Fatal("client of a deleted user still connected")
+	}
+	expectReply(t, admin.do("ACL", "USERS"), []any{"default"})
+
+	// an invalid file changes nothing
+	if err := os.WriteFile(file, []byte("user default on nopass ~* &* +@all\nuser dave on +nosuch\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := admin.do("ACL", "LOAD").(respError); !ok {
+		t.Fatal("ACL LOAD of an invalid file")
+	}
+	expectReply(t, admin.do("ACL", "USERS"), []any{"default"})
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
-	"log"
+	"log/slog"
+	"net"
+	"sync"
 	"time"
 )
 
@@ -11,12 +13,172 @@ Contains all the custom classes
 
 // for main Application
 type App struct {
-	infoLogger  *log.Logger
-	errorLogger *log.Logger
+	// server log, see log.go
+	logger *slog.Logger
 }
 
 // for value used in saving KEY:VALUE pair
 type Value struct {
 	value      string
 	expiration time.Time
+	// OBJ_ENCODING_* of the value
+	encoding uint8
+	// last access, unix time in milliseconds (LRU eviction)
+	lru int64
+	// logarithmic access counter and its last decrement time in minutes (LFU eviction)
+	lfuCounter  uint8
+	lfuDecrTime uint16
+	// epoch of the last background snapshot the value was written in (BGSAVE)
+	rdbEpoch uint64
+}
+
+// for a logical database, selected with SELECT
+type Database struct {
+	id   int
+	dict map[string]*Value
+	// keys with a TTL, sampled by active expiry and volatile eviction
+	expires map[string]bool
+	// estimated memory used by the keys and values
+	memory int64
+	// average TTL in milliseconds, estimated by active expiry
+	avgTTL int64
+	// watched key -> clients watching it
+	watchedKeys map[string]map[*Client]bool
+	// background save of dict, keeps the values it hasn't written yet
+	snapshot *snapshotDatabase
+}
+
+// for a connected client, the connection and its per-connection state
+type Client struct {
+	connection net.Conn
+	// unique, incremental ID (CLIENT ID)
+	id int64
+	// remote and local address of the connection
+	addr  string
+	laddr string
+	// set with CLIENT SETNAME and CLIENT SETINFO
+	name       string
+	libName    string
+	libVersion string
+	// authenticated user, nil for the superuser (our master)
+	user          *User
+	authenticated bool
+	// RESP protocol version, switched with HELLO
+	protocol int
+	// CLIENT_* flags
+	flags int
+	// selected database
+	db *Database
+
+	// port the replica listens on, sent with REPLCONF listening-port
+	slaveListeningPort int
+
+	// CLIENT LIST info
+	createdAt       time.Time
+	lastInteraction time.Time
+	lastCommand     string
+	// unparsed bytes read from the connection after the last command
+	queryBufferSize int
+
+	// MULTI: queued commands, writes to propagate on EXEC and watched keys
+	// watched key -> it was already logically expired when watched
+	multiState         []*multiCommand
+	pendingPropagation []propagatedCommand
+	watchedKeys        map[watchedKey]bool
+
+	// CLIENT TRACKING: client receiving the invalidations, 0 for this client,
+	// and the BCAST prefixes
+	trackingRedirect int64
+	trackingPrefixes map[string]bool
+
+	// pub/sub channels and patterns the client is subscribed to
+	channels map[string]bool
+	patterns map[string]bool
+
+	// output buffer, replies are queued here and written to the
+	// connection by the client writer goroutine
+	outputMutex  sync.Mutex
+	outputBuffer [][]byte
+	outputSize   int
+	// last time the output buffer was empty, the lag of a replica
+	outputDrainedTime time.Time
+	// when the output buffer went over the soft limit, zero when under it
+	outputSoftLimitReachedTime time.Time
+	outputSignal               chan struct{}
+	closed                     bool
+}
+
+// for the server statistics
+type Stats struct {
+	evictedKeys int64
+	// connections refused by maxclients
+	rejectedConnections int64
+	// clients disconnected by client-output-buffer-limit
+	clientOutputBufferLimitDisconnections int64
+	// accepted connections and executed commands
+	totalConnectionsReceived int64
+	totalCommandsProcessed   int64
+	// keys deleted by expiry, active expiry cycles stopped by their time limit
+	expiredKeys                int64
+	expiredTimeCapReachedCount int64
+	// successful and failed key lookups
+	keyspaceHits   int64
+	keyspaceMisses int64
+	// full resyncs served to replicas and RDB saves
+	syncFull int64
+	rdbSaves int64
+	// error replies, in total and by prefix (errorstats)
+	totalErrorReplies int64
+	errorReplies      map[string]int64
+	// commands, keys, AUTH and channels denied by the ACLs
+	aclAccessDeniedAuth    int64
+	aclAccessDeniedCmd     int64
+	aclAccessDeniedKey     int64
+	aclAccessDeniedChannel int64
+}
+
+// for an ACL user
+type User struct {
+	name string
+	// USER_FLAG_*
+	flags int
+	// SHA-256 hex digests of the passwords
+	passwords []string
+	// command rules in the order they apply, ex: +@all -flushall,
+	// and the commands (full names) they allow
+	commandRules    []string
+	allowedCommands map[string]bool
+	// key patterns with their permissions and pub/sub channel patterns
+	keyPatterns     []aclKeyPattern
+	channelPatterns []string
+}
+
+// for the command table entry
+type Command struct {
+	name string
+	// name|subcommand for subcommands, the name otherwise
+	fullName string
+	// number of arguments including the command name
+	// negative means at least -arity arguments
+	arity int
+	flags int
+	// position of the first and last key argument and the step between keys
+	// negative lastKey counts from the end, firstKey 0 means no keys
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// ACL_CATEGORY_*, the ones implied by the flags are added
+	aclCategories int
+	// subcommands only carry their flags and ACL categories,
+	// the handler of the container runs them
+	subcommands map[string]*Command
+	parent      *Command
+	handler     func(app *App, client *Client, commands []string) []byte
+	// INFO commandstats, the rejected calls didn't run, the failed ones replied an error
+	calls         int64
+	microseconds  int64
+	rejectedCalls int64
+	failedCalls   int64
+	// latency-tracking, nil until the first call
+	latencyHistogram *latencyHistogram
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+/*
+INFO: Background save (BGSAVE)
+Redis forks and lets the child write the memory as it was at the fork. Go
+can't fork, the snapshot is kept with copy-on-write instead:
+- the saver walks the dicts of the databases, holding the serverMutex only to
+  pick a batch of keys, the batch is written while the clients keep writing
+- the values are never modified once in a dict, a write replaces them; before
+  the first write to a key during the save, dbAdd and dbDelete keep the value
+  the key had at the snapshot (its preimage, nil if it had none)
+- the saver writes the preimage in place of the live value, and at the end the
+  preimages of the keys deleted before it reached them
+- a value written is marked with the epoch of the snapshot, it's never written
+  twice, ex: a key deleted and added again can be met again by the map range
+FLUSHDB and FLUSHALL swap the dict, the saver keeps writing the old one.
+SAVE and the full resync of a replica write a snapshot in the foreground,
+holding the serverMutex, the dicts can't change: they write the live values
+and leave the marks of a running background save alone.
+The file is written to a temp file renamed over the RDB file when complete.
+The save points, `save <seconds> <changes>`, start a BGSAVE from the serverCron.
+*/
+
+const (
+	// keys picked by the saver each time it holds the serverMutex
+	RDB_SAVE_BATCH = 1000
+	// a failed background save is retried by the save points after this delay
+	CONFIG_BGSAVE_RETRY_DELAY = 5 * time.Second
+)
+
+// for a point-in-time copy of the databases, written by SAVE and BGSAVE
+type rdbSnapshot struct {
+	epoch      uint64
+	background bool
+	dbs        []*snapshotDatabase
+	tempPath   string
+	start      time.Time
+	// changes when the snapshot was taken, the later ones stay dirty
+	dirty int64
+	// set when SHUTDOWN stops the background save
+	aborted bool
+}
+
+// for a database in the snapshot, non empty when it was taken
+type snapshotDatabase struct {
+	id   int
+	dict map[string]*Value
+	// number of keys and keys with a TTL at the snapshot
+	keys    int
+	expires int
+	// key -> its value at the snapshot, nil for the keys added after it
+	preimages map[string]*Value
+}
+
+var (
+	// epoch of the last background snapshot, see Value.rdbEpoch
+	rdbSnapshotEpoch uint64
+	// background save running, nil when none
+	bgsaveSnapshot *rdbSnapshot
+	// BGSAVE SCHEDULE, started when the running background save ends
+	bgsaveScheduled bool
+	// start of the last background save and its duration, -1 before the first one
+	lastBgsaveTry      time.Time
+	lastBgsaveDuration = time.Duration(-1)
+
+	errRdbSaveAborted = errors.New("background save aborted")
+)
+
+// ROLE: handle BGSAVE [SCHEDULE]
+func (app *App) executeBGSAVE(client *Client, commands []string) []byte {
+	schedule := false
+	if len(commands) == 2 && strings.EqualFold(commands[1], "SCHEDULE") {
+		schedule = true
+	} else if len(commands) > 1 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	if bgsaveSnapshot != nil {
+		if schedule {
+			bgsaveScheduled = true
+			return []byte("+Background saving scheduled\r\n")
+		}
+		return []byte("-ERR Background save already in progress\r\n")
+	}
+	if err := app.rdbSaveBackground(); err != nil {
+		return []byte(fmt.Sprintf("-ERR %s\r\n", err))
+	}
+	return []byte("+Background saving started\r\n")
+}
+
+// ROLE: handle LASTSAVE, unix time of the last successful save
+func (app *App) executeLASTSAVE(client *Client, commands []string) []byte {
+	return app.createIntegerResponse(int(lastSaveTime.Unix()))
+}
+
+// ROLE: take the snapshot of the databases
+// in the background, the databases keep the preimages of their keys for the saver
+// caller must hold the serverMutex
+func (app *App) createRdbSnapshot(background bool) *rdbSnapshot {
+	snapshot := &rdbSnapshot{
+		background: background,
+		tempPath:   path.Join(dir, fmt.Sprintf("temp-%d.rdb", os.Getpid())),
+		start:      time.Now(),
+		dirty:      dirty,
+	}
+	if background {
+		rdbSnapshotEpoch++
+		snapshot.epoch = rdbSnapshotEpoch
+		snapshot.tempPath = path.Join(dir, fmt.Sprintf("temp-bgsave-%d.rdb", os.Getpid()))
+	}
+	for _, db := range dbs {
+		if len(db.dict) == 0 {
+			continue
+		}
+		snapshotDb := &snapshotDatabase{
+			id:      db.id,
+			dict:    db.dict,
+			keys:    len(db.dict),
+			expires: len(db.expires),
+		}
+		if background {
+			snapshotDb.preimages = make(map[string]*Value)
+			db.snapshot = snapshotDb
+		}
+		snapshot.dbs = append(snapshot.dbs, snapshotDb)
+	}
+	return snapshot
+}
+
+// ROLE: copy on write, keep the value of the key at the snapshot before its first change
+// caller must hold the serverMutex
+func (app *App) snapshotPreserveKey(db *Database, key string) {
+	snapshotDb := db.snapshot
+	if snapshotDb == nil {
+		return
+	}
+	if _, ok := snapshotDb.preimages[key]; !ok {
+		snapshotDb.preimages[key] = db.dict[key]
+	}
+}
+
+// ROLE: the value of the key at the background snapshot, nil if it had none or
+// it's already written, the value is marked as written
+// caller must hold the serverMutex
+func (db *snapshotDatabase) valueToSave(key string, live *Value, epoch uint64) *Value {
+	value := live
+	if preimage, ok := db.preimages[key]; ok {
+		value = preimage
+	}
+	if value == nil || value.rdbEpoch == epoch {
+		return nil
+	}
+	value.rdbEpoch = epoch
+	return value
+}
+
+// ROLE: start a background save
+// caller must hold the serverMutex
+func (app *App) rdbSaveBackground() error {
+	rdbPath, err := app.checkRDBfile()
+	if err != nil {
+		lastSaveStatusOK = false
+		return err
+	}
+	lastBgsaveTry = time.Now()
+	bgsaveScheduled = false
+	start := time.Now()
+	snapshot := app.createRdbSnapshot(true)
+	// what the fork is for Redis
+	app.latencyAddSampleIfNeeded("fork", time.Since(start))
+	bgsaveSnapshot = snapshot
+	app.logNotice("Background saving started")
+	go app.backgroundSave(snapshot, rdbPath)
+	return nil
+}
+
+// ROLE: write the snapshot and replace the RDB file, runs in its own goroutine
+func (app *App) backgroundSave(snapshot *rdbSnapshot, rdbPath string) {
+	tempPath, err := app.writeRdbFile(snapshot)
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	if snapshot.aborted {
+		if err == nil {
+			os.Remove(tempPath)
+		}
+		return
+	}
+	// renamed with the serverMutex, a SAVE can't replace the file meanwhile
+	if err == nil {
+		err = os.Rename(tempPath, rdbPath)
+	}
+	app.backgroundSaveDone(snapshot, err)
+}
+
+// ROLE: update the save info once the background save ended
+// caller must hold the serverMutex
+func (app *App) backgroundSaveDone(snapshot *rdbSnapshot, err error) {
+	app.stopSnapshotPreimages()
+	bgsaveSnapshot = nil
+	lastBgsaveDuration = time.Since(snapshot.start)
+	if err != nil {
+		lastSaveStatusOK = false
+		app.logWarning("Background saving error", "error", err)
+		return
+	}
+	dirty -= snapshot.dirty
+	lastSaveTime = time.Now()
+	lastSaveStatusOK = true
+	stats.rdbSaves++
+	app.logNotice("Background saving terminated with success")
+}
+
+// ROLE: stop the background save, SHUTDOWN saves in the foreground instead
+// caller must hold the serverMutex
+func (app *App) abortBackgroundSave() {
+	if bgsaveSnapshot == nil {
+		return
+	}
+	bgsaveSnapshot.aborted = true
+	bgsaveSnapshot = nil
+	bgsaveScheduled = false
+	app.stopSnapshotPreimages()
+	app.logWarning("Background saving aborted")
+}
+
+// ROLE: the databases stop keeping the preimages of their keys
+// caller must hold the serverMutex
+func (app *App) stopSnapshotPreimages() {
+	for _, db := range dbs {
+		db.snapshot = nil
+	}
+}
+
+// ROLE: start the scheduled background save, or one when a save point is reached
+// a failed background save is retried after CONFIG_BGSAVE_RETRY_DELAY
+// caller must hold the serverMutex
+func (app *App) rdbSaveCron() {
+	if bgsaveSnapshot != nil {
+		return
+	}
+	if bgsaveScheduled {
+		if err := app.rdbSaveBackground(); err != nil {
+			app.logWarning("Can't start the scheduled background save", "error", err)
+		}
+		return
+	}
+	for _, point := range saveParams {
+		if dirty < int64(point.changes) || time.Since(lastSaveTime) < time.Duration(point.seconds)*time.Second {
+			continue
+		}
+		if !lastSaveStatusOK && time.Since(lastBgsaveTry) < CONFIG_BGSAVE_RETRY_DELAY {
+			continue
+		}
+		app.logNotice(fmt.Sprintf("%d changes in %d seconds. Saving...", point.changes, point.seconds))
+		if err := app.rdbSaveBackground(); err != nil {
+			app.logWarning("Can't start the background save", "error", err)
+		}
+		return
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// ROLE: the content of every database, holding the serverMutex
+func datasetContent() map[int]map[string]string {
+	content := make(map[int]map[string]string)
+	for _, db := range dbs {
+		if len(db.dict) > 0 {
+			content[db.id] = databaseContent(db)
+		}
+	}
+	return content
+}
+
+func TestBackgroundSaveWritesTheSnapshot(t *testing.T) {
+	server := startTestServer(t, "databases 4")
+	client := server.connect(t)
+	// enough keys for the saver to release the serverMutex between batches
+	keys := 3 * RDB_SAVE_BATCH
+	var atFork map[int]map[string]string
+	server.locked(func() {
+		for i := 0; i < keys; i++ {
+			value := &Value{value: strconv.Itoa(i)}
+			if i%10 == 0 {
+				value.expiration = time.Now().Add(time.Hour)
+			}
+			server.app.initAccessInfo(value)
+			server.app.dbAdd(dbs[0], "key:"+strconv.Itoa(i), value)
+		}
+		server.app.dbAdd(dbs[1], "one", &Value{value: "1"})
+		server.app.dbAdd(dbs[3], "three", &Value{value: "3"})
+		atFork = datasetContent()
+
+		if err := server.app.rdbSaveBackground(); err != nil {
+			t.Fatal(err)
+		}
+		// the saver waits for the serverMutex, these writes come before it writes anything
+		writer := server.clientOf(client)
+		for _, command := range [][]string{
+			{"SET", "key:1", "changed"},
+			{"DEL", "key:2"},
+			{"SET", "added", "x"},
+			{"MOVE", "key:3", "1"},
+			{"MOVE", "key:4", "2"},
+			{"SWAPDB", "1", "2"},
+			{"SET", "key:5", "changed", "PX", "100000"},
+		} {
+			if response := server.app.processCommand(writer, command); isErrorResponse(response) {
+				t.Fatalf("%v: %s", command, response)
+			}
+		}
+	})
+
+	// the writes between the batches of the saver
+	for i := 6; i < keys; i += 7 {
+		client.do("SET", "key:"+strconv.Itoa(i), "changed")
+		client.do("DEL", "key:"+strconv.Itoa(i+1))
+		client.do("MOVE", "key:"+strconv.Itoa(i+2), "2")
+	}
+	client.do("SWAPDB", "0", "3")
+	client.do("SELECT", "2")
+	client.do("FLUSHDB")
+	client.do("FLUSHALL")
+	waitFor(t, func() bool { return bgsaveSnapshot == nil })
+
+	// the file has the dataset as it was when the save started
+	server.locked(func() {
+		if !lastSaveStatusOK {
+			t.Fatal("background save failed")
+		}
+		for _, db := range dbs {
+			server.app.emptyDatabase(db, false)
+		}
+		if err := server.app.DeserializeRDB(); err != nil {
+			t.Fatal(err)
+		}
+		saved := datasetContent()
+		if len(saved) != len(atFork) {
+			t.Fatalf("databases saved %d, want %d", len(saved), len(atFork))
+		}
+		for id, want := range atFork {
+			if len(saved[id]) != len(want) {
+				t.Fatalf("db%d: %d keys saved, want %d", id, len(saved[id]), len(want))
+			}
+			for key, value := range want {
+				if saved[id][key] != value {
+					t.Fatalf("db%d %s: %q saved, want %q", id, key, saved[id][key], value)
+				}
+			}
+		}
+		if len(dbs[0].expires) != keys/10 {
+			t.Fatalf("%d keys with a TTL saved, want %d", len(dbs[0].expires), keys/10)
+		}
+	})
+}
+
+// for the output of the saver, blocks it at its first write until released
+type pausedWriter struct {
+	out      bytes.Buffer
+	paused   chan struct{}
+	released chan struct{}
+}
+
+func (writer *pausedWriter) Write(data []byte) (int, error) {
+	if writer.out.Len() == 0 {
+		close(writer.paused)
+		<-writer.released
+	}
+	return writer.out.Write(data)
+}
+
+func TestBackgroundSaveDuringFullResync(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	keys := 3 * RDB_SAVE_BATCH
+	var snapshot *rdbSnapshot
+	server.locked(func() {
+		for i := 0; i < keys; i++ {
+			server.app.dbAdd(dbs[0], "key:"+strconv.Itoa(i), &Value{value: strconv.Itoa(i)})
+		}
+		snapshot = server.app.createRdbSnapshot(true)
+		bgsaveSnapshot = snapshot
+	})
+	writer := &pausedWriter{paused: make(chan struct{}), released: make(chan struct{})}
+	done := make(chan error)
+	go func() { done <- server.app.writeRdb(writer, snapshot) }()
+
+	// the saver writes its first batch without the serverMutex, the replica
+	// gets a foreground snapshot, then the keys already saved are overwritten
+	<-writer.paused
+	server.connectReplica(t)
+	for i := 0; i < keys; i++ {
+		client.do("SET", "key:"+strconv.Itoa(i), "changed")
+	}
+	close(writer.released)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	server.locked(func() {
+		server.app.stopSnapshotPreimages()
+		bgsaveSnapshot = nil
+	})
+
+	// every key once, with its value at the snapshot
+	file := writer.out.Bytes()
+	for i := 0; i < keys; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if count := bytes.Count(file, append([]byte{byte(len(key))}, key...)); count != 1 {
+			t.Fatalf("%s saved %d times", key, count)
+		}
+	}
+	server.locked(func() {
+		server.app.emptyDatabase(dbs[0], false)
+		if err := server.app.loadRdb(bytes.NewReader(file)); err != nil {
+			t.Fatal(err)
+		}
+		if len(dbs[0].dict) != keys || dbs[0].dict["key:1"].value != "1" {
+			t.Fatalf("%d keys loaded, key:1 %q", len(dbs[0].dict), dbs[0].dict["key:1"].value)
+		}
+	})
+}
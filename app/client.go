@@ -0,0 +1,806 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+INFO: Client connection state and per-client output buffer
+Replies are never written to the socket by the command handlers, they are
+queued in the client output buffer and a writer goroutine per client drains it.
+A slow reader can't block the server (ex: PUBLISH to a slow subscriber).
+The output buffer is limited by the client-output-buffer-limit of the client
+class, a client over the hard limit, or over the soft limit for longer than
+the soft seconds, is disconnected and its pending replies dropped.
+*/
+
+// client flags
+const (
+	CLIENT_MULTI             = 1 << iota // inside MULTI
+	CLIENT_DIRTY_CAS         = 1 << iota // a watched key was modified, EXEC will fail
+	CLIENT_DIRTY_EXEC        = 1 << iota // a command failed while queueing, EXEC will fail
+	CLIENT_EXECUTING_MULTI   = 1 << iota // running the EXEC queue
+	CLIENT_MASTER            = 1 << iota // the link to our master, gets no replies
+	CLIENT_CLOSE_AFTER_REPLY = 1 << iota // close once the pending replies are written
+	CLIENT_SLAVE             = 1 << iota // a replica of this server
+	CLIENT_REPLY_OFF         = 1 << iota // CLIENT REPLY OFF
+	CLIENT_REPLY_SKIP_NEXT   = 1 << iota // CLIENT REPLY SKIP, skip the reply of the next command
+	CLIENT_REPLY_SKIP        = 1 << iota // skip the reply of the current command
+	CLIENT_NO_EVICT          = 1 << iota // CLIENT NO-EVICT ON
+	CLIENT_NO_TOUCH          = 1 << iota // CLIENT NO-TOUCH ON, reads don't update the access info
+	CLIENT_UNIX_SOCKET       = 1 << iota // connected to the unixsocket
+	CLIENT_BLOCKED           = 1 << iota // waiting for a blocking command to finish (SHUTDOWN)
+	CLIENT_MONITOR           = 1 << iota // MONITOR, receives every command
+	CLIENT_POSTPONED         = 1 << iota // its command waits for the end of CLIENT PAUSE or SHUTDOWN
+
+	CLIENT_TRACKING              = 1 << iota // CLIENT TRACKING ON
+	CLIENT_TRACKING_BROKEN_REDIR = 1 << iota // the client we redirect invalidations to is gone
+	CLIENT_TRACKING_BCAST        = 1 << iota // invalidations for every key matching the prefixes
+	CLIENT_TRACKING_OPTIN        = 1 << iota // only track keys read after CLIENT CACHING YES
+	CLIENT_TRACKING_OPTOUT       = 1 << iota // don't track keys read after CLIENT CACHING NO
+	CLIENT_TRACKING_CACHING      = 1 << iota // CLIENT CACHING YES/NO was called
+	CLIENT_TRACKING_NOLOOP       = 1 << iota // no invalidations for our own writes
+)
+
+// client types, for CLIENT LIST TYPE and CLIENT KILL TYPE
+const (
+	CLIENT_TYPE_NORMAL = iota
+	CLIENT_TYPE_SLAVE
+	CLIENT_TYPE_PUBSUB
+	CLIENT_TYPE_MASTER
+)
+
+// CLIENT PAUSE modes
+const (
+	CLIENT_PAUSE_OFF = iota
+	CLIENT_PAUSE_WRITE
+	CLIENT_PAUSE_ALL
+)
+
+var errClientClosed = errors.New("client connection is closed")
+
+// for the output buffer limits of a client class, 0 means no limit
+type clientBufferLimit struct {
+	hardLimit   int64
+	softLimit   int64
+	softSeconds int
+}
+
+// default output buffer limits by CLIENT_TYPE_*, our master gets no replies
+var defaultClientOutputBufferLimits = [3]clientBufferLimit{
+	CLIENT_TYPE_NORMAL: {},
+	CLIENT_TYPE_SLAVE:  {hardLimit: 256 << 20, softLimit: 64 << 20, softSeconds: 60},
+	CLIENT_TYPE_PUBSUB: {hardLimit: 32 << 20, softLimit: 8 << 20, softSeconds: 60},
+}
+
+var clientOutputBufferLimits = defaultClientOutputBufferLimits
+
+var (
+	// connected clients by ID
+	clients      = make(map[int64]*Client)
+	nextClientID int64
+	// client running the current command
+	currentClient *Client
+
+	// CLIENT PAUSE, paused clients wait on the condition until the end
+	clientsPauseType int
+	clientsPauseEnd  time.Time
+	clientsPauseCond = sync.NewCond(&serverMutex)
+)
+
+// ROLE: create the client for the accepted connection and start its writer
+// caller must hold the serverMutex
+func (app *App) newClient(connection net.Conn) *Client {
+	nextClientID++
+	now := time.Now()
+	client := &Client{
+		connection:       connection,
+		id:               nextClientID,
+		addr:             connection.RemoteAddr().String(),
+		laddr:            connection.LocalAddr().String(),
+		user:             defaultUser,
+		protocol:         2,
+		channels:         make(map[string]bool),
+		patterns:         make(map[string]bool),
+		watchedKeys:      make(map[watchedKey]bool),
+		trackingPrefixes: make(map[string]bool),
+		db:               dbs[0],
+		createdAt:        now,
+		lastInteraction:  now,
+		outputSignal:     make(chan struct{}, 1),
+	}
+	client.outputDrainedTime = now
+	// without a password for the default user, nothing to authenticate
+	client.authenticated = defaultUser.flags&USER_FLAG_NOPASS != 0 && defaultUser.flags&USER_FLAG_DISABLED == 0
+	// unix socket peers have no address, like Redis show the socket path
+	if connection.LocalAddr().Network() == "unix" {
+		client.flags |= CLIENT_UNIX_SOCKET
+		client.addr = connection.LocalAddr().String() + ":0"
+		client.laddr = client.addr
+	}
+	clients[client.id] = client
+	clientWriters.Add(1)
+	go app.clientWriter(client)
+	return client
+}
+
+// ROLE: queue the data in the client output buffer
+// it does not block on the connection
+func (app *App) WriteToClient(client *Client, dataToSend []byte) error {
+	// the master doesn't read our replies, CLIENT REPLY can turn them off
+	if len(dataToSend) == 0 || client.flags&(CLIENT_MASTER|CLIENT_REPLY_OFF|CLIENT_REPLY_SKIP) != 0 {
+		return nil
+	}
+	client.outputMutex.Lock()
+	defer client.outputMutex.Unlock()
+	if client.closed {
+		return errClientClosed
+	}
+	client.outputBuffer = append(client.outputBuffer, dataToSend)
+	client.outputSize += len(dataToSend)
+	if app.outputBufferLimitReached(client) {
+		app.logWarning("Client closed for overcoming of output buffer limits.", "client", client.addr)
+		stats.clientOutputBufferLimitDisconnections++
+		// the pending replies are dropped and the connection closed right away,
+		// a writer stuck on the slow reader returns
+		for _, data := range client.outputBuffer {
+			client.outputSize -= len(data)
+		}
+		client.outputBuffer = nil
+		client.closed = true
+		client.connection.Close()
+		app.signalClientWriter(client)
+		return errClientClosed
+	}
+	app.signalClientWriter(client)
+	return nil
+}
+
+// ROLE: check the output buffer limits of the client class
+// the soft limit must be exceeded for more than the soft seconds in a row
+// caller must hold the serverMutex and the outputMutex
+func (app *App) outputBufferLimitReached(client *Client) bool {
+	class := getClientType(client)
+	if class == CLIENT_TYPE_MASTER {
+		class = CLIENT_TYPE_NORMAL
+	}
+	limit := clientOutputBufferLimits[class]
+	size := int64(client.outputSize)
+	if limit.hardLimit > 0 && size >= limit.hardLimit {
+		return true
+	}
+	if limit.softLimit == 0 || size < limit.softLimit {
+		client.outputSoftLimitReachedTime = time.Time{}
+		return false
+	}
+	now := time.Now()
+	if client.outputSoftLimitReachedTime.IsZero() {
+		client.outputSoftLimitReachedTime = now
+		return false
+	}
+	return now.Sub(client.outputSoftLimitReachedTime) > time.Duration(limit.softSeconds)*time.Second
+}
+
+// ROLE: parse client-output-buffer-limit, ex: "pubsub 32mb 8mb 60"
+// <class> <hard limit> <soft limit> <soft seconds>, the classes not given keep their limits
+func parseClientOutputBufferLimits(value string, limits [3]clientBufferLimit) ([3]clientBufferLimit, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields)%4 != 0 {
+		return limits, fmt.Errorf("wrong number of arguments in buffer limit configuration")
+	}
+	for i := 0; i < len(fields); i += 4 {
+		class := getClientTypeByName(fields[i])
+		if class == -1 || class == CLIENT_TYPE_MASTER {
+			return limits, fmt.Errorf("invalid client class specified in buffer limit configuration")
+		}
+		hardLimit, err1 := parseMemory(fields[i+1])
+		softLimit, err2 := parseMemory(fields[i+2])
+		softSeconds, err3 := strconv.Atoi(fields[i+3])
+		if err1 != nil || err2 != nil || err3 != nil || softSeconds < 0 {
+			return limits, fmt.Errorf("error in hard, soft or soft_seconds setting in buffer limit configuration")
+		}
+		limits[class] = clientBufferLimit{hardLimit: hardLimit, softLimit: softLimit, softSeconds: softSeconds}
+	}
+	return limits, nil
+}
+
+// ROLE: client-output-buffer-limit as shown by CONFIG GET
+func clientOutputBufferLimitsString(limits [3]clientBufferLimit) string {
+	var fields []string
+	for class, name := range []string{"normal", "slave", "pubsub"} {
+		limit := limits[class]
+		fields = append(fields, fmt.Sprintf("%s %d %d %d", name, limit.hardLimit, limit.softLimit, limit.softSeconds))
+	}
+	return strings.Join(fields, " ")
+}
+
+// ROLE: wake up the writer goroutine, never blocks
+func (app *App) signalClientWriter(client *Client) {
+	select {
+	case client.outputSignal <- struct{}{}:
+	default:
+	}
+}
+
+// ROLE: drain the output buffer to the connection
+// the connection is closed once the client is closed and the buffer is empty
+func (app *App) clientWriter(client *Client) {
+	defer clientWriters.Done()
+	defer client.connection.Close()
+	for range client.outputSignal {
+		for {
+			client.outputMutex.Lock()
+			pending := client.outputBuffer
+			client.outputBuffer = nil
+			closed := client.closed
+			client.outputMutex.Unlock()
+
+			if len(pending) == 0 {
+				if closed {
+					return
+				}
+				break
+			}
+
+			for _, data := range pending {
+				written, err := client.connection.Write(data)
+				netOutputBytes.Add(int64(written))
+				if err != nil {
+					app.logVerbose("failed to write to the client", "client", client.addr, "error", err)
+					app.closeClient(client)
+					return
+				}
+				client.outputMutex.Lock()
+				client.outputSize -= len(data)
+				if client.outputSize == 0 {
+					client.outputDrainedTime = time.Now()
+				}
+				client.outputMutex.Unlock()
+			}
+		}
+	}
+}
+
+// ROLE: close the client, pending replies are still flushed by the writer
+func (app *App) closeClient(client *Client) {
+	client.outputMutex.Lock()
+	defer client.outputMutex.Unlock()
+	if client.closed {
+		return
+	}
+	client.closed = true
+	app.signalClientWriter(client)
+}
+
+// ROLE: check if the client was closed, ex: by CLIENT KILL
+func (app *App) isClientClosed(client *Client) bool {
+	client.outputMutex.Lock()
+	defer client.outputMutex.Unlock()
+	return client.closed
+}
+
+// ROLE: release everything the server holds for the client
+// caller must hold the serverMutex
+func (app *App) freeClient(client *Client) {
+	delete(clients, client.id)
+	app.disableTracking(client)
+	app.unwatchAllKeys(client)
+	app.pubsubUnsubscribeAll(client, false)
+	app.stopMonitor(client)
+	for i, slave := range slaveConnections {
+		if slave == client {
+			slaveConnections = append(slaveConnections[:i], slaveConnections[i+1:]...)
+			break
+		}
+	}
+	app.closeClient(client)
+}
+
+// ROLE: close the clients idle for more than timeout seconds
+// replicas, our master, monitors, subscribers, blocked clients and the clients
+// waiting for the end of a pause wait for data, they are never idle
+// caller must hold the serverMutex
+func (app *App) closeTimedoutClients() {
+	if clientTimeout == 0 {
+		return
+	}
+	maxIdle := time.Duration(clientTimeout) * time.Second
+	now := time.Now()
+	for _, client := range clients {
+		if client.flags&(CLIENT_SLAVE|CLIENT_MASTER|CLIENT_MONITOR|CLIENT_BLOCKED|CLIENT_POSTPONED) != 0 || getClientType(client) == CLIENT_TYPE_PUBSUB {
+			continue
+		}
+		if now.Sub(client.lastInteraction) > maxIdle {
+			app.logVerbose("Closing idle client", "client", client.addr)
+			app.freeClient(client)
+		}
+	}
+}
+
+// ROLE: get the CLIENT_TYPE_* of the client
+func getClientType(client *Client) int {
+	switch {
+	case client.flags&CLIENT_MASTER != 0:
+		return CLIENT_TYPE_MASTER
+	case client.flags&CLIENT_SLAVE != 0:
+		return CLIENT_TYPE_SLAVE
+	case len(client.channels)+len(client.patterns) > 0:
+		return CLIENT_TYPE_PUBSUB
+	}
+	return CLIENT_TYPE_NORMAL
+}
+
+// ROLE: get the CLIENT_TYPE_* by its name, -1 if unknown
+func getClientTypeByName(name string) int {
+	switch strings.ToLower(name) {
+	case "normal":
+		return CLIENT_TYPE_NORMAL
+	case "slave", "replica":
+		return CLIENT_TYPE_SLAVE
+	case "pubsub":
+		return CLIENT_TYPE_PUBSUB
+	case "master":
+		return CLIENT_TYPE_MASTER
+	}
+	return -1
+}
+
+// ROLE: check if the client has to wait for the end of CLIENT PAUSE
+// before running the command, the master and the replicas are never paused
+// caller must hold the serverMutex
+func (app *App) clientPaused(client *Client, commands []string) bool {
+	if !app.clientsArePaused() || client.flags&(CLIENT_MASTER|CLIENT_SLAVE) != 0 || app.isClientClosed(client) {
+		return false
+	}
+	if clientsPauseType == CLIENT_PAUSE_ALL {
+		return true
+	}
+	command := lookupCommand(commands[0])
+	if command == nil {
+		return false
+	}
+	// inside MULTI the writes are only queued, EXEC waits if one of them is a write
+	if client.flags&CLIENT_MULTI != 0 {
+		if command.name != "exec" {
+			return false
+		}
+		for _, queued := range client.multiState {
+			if queued.command.flags&CMD_WRITE != 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return command.flags&CMD_WRITE != 0
+}
+
+// ROLE: check if CLIENT PAUSE is in effect, writes (and so expiry and eviction)
+// are paused in both modes
+func (app *App) clientsArePaused() bool {
+	if clientsPauseType == CLIENT_PAUSE_OFF {
+		return false
+	}
+	if !time.Now().Before(clientsPauseEnd) {
+		clientsPauseType = CLIENT_PAUSE_OFF
+		return false
+	}
+	return true
+}
+
+// ROLE: pause the clients until the end time, the most restrictive mode
+// and the latest end time win when already paused
+func (app *App) pauseClients(pauseType int, end time.Time) {
+	paused := app.clientsArePaused()
+	if !paused || pauseType > clientsPauseType {
+		clientsPauseType = pauseType
+	}
+	if !paused || end.After(clientsPauseEnd) {
+		clientsPauseEnd = end
+	}
+	time.AfterFunc(time.Until(end), func() {
+		serverMutex.Lock()
+		clientsPauseCond.Broadcast()
+		serverMutex.Unlock()
+	})
+}
+
+// ROLE: end CLIENT PAUSE and wake up the paused clients
+func (app *App) unpauseClients() {
+	clientsPauseType = CLIENT_PAUSE_OFF
+	clientsPauseCond.Broadcast()
+}
+
+// ROLE: flags of the client for CLIENT LIST
+func (app *App) clientFlagsString(client *Client) string {
+	var flags strings.Builder
+	if client.flags&CLIENT_SLAVE != 0 {
+		flags.WriteByte('S')
+	}
+	if client.flags&CLIENT_MASTER != 0 {
+		flags.WriteByte('M')
+	}
+	if client.flags&CLIENT_MONITOR != 0 {
+		flags.WriteByte('O')
+	}
+	if len(client.channels)+len(client.patterns) > 0 {
+		flags.WriteByte('P')
+	}
+	if client.flags&CLIENT_MULTI != 0 {
+		flags.WriteByte('x')
+	}
+	if client.flags&CLIENT_BLOCKED != 0 {
+		flags.WriteByte('b')
+	}
+	if client.flags&CLIENT_DIRTY_CAS != 0 {
+		flags.WriteByte('d')
+	}
+	if client.flags&CLIENT_CLOSE_AFTER_REPLY != 0 {
+		flags.WriteByte('c')
+	}
+	if client.flags&CLIENT_NO_EVICT != 0 {
+		flags.WriteByte('e')
+	}
+	if client.flags&CLIENT_TRACKING != 0 {
+		flags.WriteByte('t')
+	}
+	if client.flags&CLIENT_TRACKING_BROKEN_REDIR != 0 {
+		flags.WriteByte('R')
+	}
+	if client.flags&CLIENT_TRACKING_BCAST != 0 {
+		flags.WriteByte('B')
+	}
+	if client.flags&CLIENT_NO_TOUCH != 0 {
+		flags.WriteByte('T')
+	}
+	if client.flags&CLIENT_UNIX_SOCKET != 0 {
+		flags.WriteByte('U')
+	}
+	if flags.Len() == 0 {
+		return "N"
+	}
+	return flags.String()
+}
+
+// ROLE: one line of CLIENT LIST / CLIENT INFO
+func (app *App) clientInfoString(client *Client) string {
+	multi := -1
+	if client.flags&CLIENT_MULTI != 0 {
+		multi = len(client.multiState)
+	}
+	redirect := -1
+	if client.flags&CLIENT_TRACKING != 0 {
+		redirect = int(client.trackingRedirect)
+	}
+	userName := "(superuser)"
+	if client.user != nil {
+		userName = client.user.name
+	}
+	lastCommand := client.lastCommand
+	if lastCommand == "" {
+		lastCommand = "NULL"
+	}
+	client.outputMutex.Lock()
+	outputListLength, outputMemory := len(client.outputBuffer), client.outputSize
+	client.outputMutex.Unlock()
+
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d multi=%d watch=%d qbuf=%d obl=0 oll=%d omem=%d cmd=%s user=%s redir=%d resp=%d lib-name=%s lib-ver=%s",
+		client.id, client.addr, client.laddr, client.name,
+		int(now.Sub(client.createdAt).Seconds()), int(now.Sub(client.lastInteraction).Seconds()),
+		app.clientFlagsString(client), client.db.id, len(client.channels), len(client.patterns),
+		multi, len(client.watchedKeys), client.queryBufferSize, outputListLength, outputMemory,
+		lastCommand, userName, redirect, client.protocol, client.libName, client.libVersion)
+}
+
+// ROLE: list the clients ordered by ID, optionally only some types or IDs
+func (app *App) clientList(clientType int, ids map[int64]bool) string {
+	var list []*Client
+	for _, client := range clients {
+		if clientType != -1 && getClientType(client) != clientType {
+			continue
+		}
+		if ids != nil && !ids[client.id] {
+			continue
+		}
+		list = append(list, client)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+
+	var result strings.Builder
+	for _, client := range list {
+		result.WriteString(app.clientInfoString(client))
+		result.WriteByte('\n')
+	}
+	return result.String()
+}
+
+// ROLE: validate a client name or library info, no spaces or special characters
+func validClientInfoString(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < '!' || value[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// ROLE: handle the CLIENT subcommands
+func (app *App) executeCLIENT(client *Client, commands []string) []byte {
+	subcommand := strings.ToUpper(commands[1])
+	switch {
+	case subcommand == "ID" && len(commands) == 2:
+		return app.createIntegerResponse(int(client.id))
+	case subcommand == "INFO" && len(commands) == 2:
+		return app.createVerbatimStringResponse(client, app.clientInfoString(client)+"\n")
+	case subcommand == "LIST":
+		return app.clientLIST(client, commands)
+	case subcommand == "SETNAME" && len(commands) == 3:
+		if !validClientInfoString(commands[2]) {
+			return []byte("-ERR Client names cannot contain spaces, newlines or special characters.\r\n")
+		}
+		client.name = commands[2]
+		return []byte("+OK\r\n")
+	case subcommand == "GETNAME" && len(commands) == 2:
+		if client.name == "" {
+			return app.createNullResponse(client)
+		}
+		return app.createBulkStringResponse(client.name)
+	case subcommand == "SETINFO" && len(commands) == 4:
+		attribute := strings.ToLower(commands[2])
+		if attribute != "lib-name" && attribute != "lib-ver" {
+			return []byte(fmt.Sprintf("-ERR Unrecognized option '%s'\r\n", commands[2]))
+		}
+		if !validClientInfoString(commands[3]) {
+			return []byte(fmt.Sprintf("-ERR %s cannot contain spaces, newlines or special characters.\r\n", attribute))
+		}
+		if attribute == "lib-name" {
+			client.libName = commands[3]
+		} else {
+			client.libVersion = commands[3]
+		}
+		return []byte("+OK\r\n")
+	case subcommand == "TRACKING" && len(commands) >= 3:
+		return app.clientTRACKING(client, commands)
+	case subcommand == "CACHING" && len(commands) == 3:
+		return app.clientCACHING(client, commands)
+	case subcommand == "GETREDIR" && len(commands) == 2:
+		return app.clientGETREDIR(client)
+	case subcommand == "TRACKINGINFO" && len(commands) == 2:
+		return app.clientTRACKINGINFO(client)
+	case subcommand == "KILL" && len(commands) >= 3:
+		return app.clientKILL(client, commands)
+	case subcommand == "PAUSE" && (len(commands) == 3 || len(commands) == 4):
+		timeout, err := strconv.ParseInt(commands[2], 10, 64)
+		if err != nil {
+			return []byte("-ERR timeout is not an integer or out of range\r\n")
+		}
+		if timeout < 0 {
+			return []byte("-ERR timeout is negative\r\n")
+		}
+		pauseType := CLIENT_PAUSE_ALL
+		if len(commands) == 4 {
+			switch strings.ToUpper(commands[3]) {
+			case "WRITE":
+				pauseType = CLIENT_PAUSE_WRITE
+			case "ALL":
+			default:
+				return []byte("-ERR syntax error\r\n")
+			}
+		}
+		app.pauseClients(pauseType, time.Now().Add(time.Duration(timeout)*time.Millisecond))
+		return []byte("+OK\r\n")
+	case subcommand == "UNPAUSE" && len(commands) == 2:
+		app.unpauseClients()
+		return []byte("+OK\r\n")
+	case subcommand == "REPLY" && len(commands) == 3:
+		switch strings.ToUpper(commands[2]) {
+		case "ON":
+			client.flags &^= CLIENT_REPLY_OFF | CLIENT_REPLY_SKIP_NEXT
+			return []byte("+OK\r\n")
+		case "OFF":
+			client.flags |= CLIENT_REPLY_OFF
+			return nil
+		case "SKIP":
+			if client.flags&CLIENT_REPLY_OFF == 0 {
+				client.flags |= CLIENT_REPLY_SKIP_NEXT
+			}
+			return nil
+		}
+		return []byte("-ERR syntax error\r\n")
+	case (subcommand == "NO-EVICT" || subcommand == "NO-TOUCH") && len(commands) == 3:
+		flag := CLIENT_NO_EVICT
+		if subcommand == "NO-TOUCH" {
+			flag = CLIENT_NO_TOUCH
+		}
+		switch strings.ToUpper(commands[2]) {
+		case "ON":
+			client.flags |= flag
+		case "OFF":
+			client.flags &^= flag
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+		return []byte("+OK\r\n")
+	case subcommand == "HELP" && len(commands) == 2:
+		return []byte(app.createRESPArray([]string{
+			"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CACHING (YES|NO)",
+			"    Enable/disable tracking of the keys for next command in OPTIN/OPTOUT modes.",
+			"GETREDIR",
+			"    Return the client ID we are redirecting to when tracking is enabled.",
+			"GETNAME",
+			"    Return the name of the current connection.",
+			"ID",
+			"    Return the ID of the current connection.",
+			"INFO",
+			"    Return information about the current client connection.",
+			"KILL <ip:port>",
+			"    Kill connection made from <ip:port>.",
+			"KILL <option> <value> [<option> <value> [...]]",
+			"    Kill connections. Options are:",
+			"    * ADDR (<ip:port>|<unixsocket>:0)",
+			"      Kill connections made from the specified address",
+			"    * LADDR (<ip:port>|<unixsocket>:0)",
+			"      Kill connections made to specified local address",
+			"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+			"      Kill connections by type.",
+			"    * USER <username>",
+			"      Kill connections authenticated by <username>.",
+			"    * SKIPME (YES|NO)",
+			"      Skip killing current connection (default: yes).",
+			"    * ID <client-id>",
+			"      Kill connections by client id.",
+			"    * MAXAGE <maxage>",
+			"      Kill connections older than the specified age.",
+			"LIST [options ...]",
+			"    Return information about client connections. Options:",
+			"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+			"      Return clients of specified type.",
+			"    * ID <client-id> [<client-id> ...]",
+			"      Return clients of specified IDs only.",
+			"PAUSE <timeout> [WRITE|ALL]",
+			"    Suspend all, or just write, clients for <timeout> milliseconds.",
+			"UNPAUSE",
+			"    Stop the current client pause, resuming traffic.",
+			"SETNAME <name>",
+			"    Assign the name <name> to the current connection.",
+			"SETINFO <option> <value>",
+			"    Set client meta attr. Options are:",
+			"    * LIB-NAME: the client lib name.",
+			"    * LIB-VER: the client lib version.",
+			"TRACKING (ON|OFF) [REDIRECT <id>] [BCAST] [PREFIX <prefix> [...]]",
+			"         [OPTIN] [OPTOUT] [NOLOOP]",
+			"    Control server assisted client side caching.",
+			"TRACKINGINFO",
+			"    Report tracking status for the current connection.",
+			"REPLY (ON|OFF|SKIP)",
+			"    Control the replies sent to the current connection.",
+			"NO-EVICT (ON|OFF)",
+			"    Protect current client connection from eviction.",
+			"NO-TOUCH (ON|OFF)",
+			"    Will not touch LRU/LFU stats when this mode is on.",
+		}))
+	}
+	return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.\r\n", commands[1]))
+}
+
+// ROLE: handle CLIENT LIST [TYPE type] [ID id [id ...]]
+func (app *App) clientLIST(client *Client, commands []string) []byte {
+	clientType := -1
+	var ids map[int64]bool
+	switch {
+	case len(commands) == 2:
+	case len(commands) == 4 && strings.EqualFold(commands[2], "TYPE"):
+		clientType = getClientTypeByName(commands[3])
+		if clientType == -1 {
+			return []byte(fmt.Sprintf("-ERR Unknown client type '%s'\r\n", commands[3]))
+		}
+	case len(commands) >= 4 && strings.EqualFold(commands[2], "ID"):
+		ids = make(map[int64]bool)
+		for _, arg := range commands[3:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id <= 0 {
+				return []byte("-ERR Invalid client ID\r\n")
+			}
+			ids[id] = true
+		}
+	default:
+		return []byte("-ERR syntax error\r\n")
+	}
+	return app.createVerbatimStringResponse(client, app.clientList(clientType, ids))
+}
+
+// ROLE: handle CLIENT KILL addr, or CLIENT KILL <filter> <value> ...
+// the old form replies OK, the new one the number of killed clients
+func (app *App) clientKILL(client *Client, commands []string) []byte {
+	var (
+		id                int64
+		clientType        = -1
+		addr, laddr, user string
+		maxAge            int64
+		skipMe            = true
+	)
+	oldForm := len(commands) == 3
+	if oldForm {
+		addr = commands[2]
+		skipMe = false
+	} else {
+		if len(commands)%2 != 0 {
+			return []byte("-ERR syntax error\r\n")
+		}
+		for i := 2; i < len(commands); i += 2 {
+			value := commands[i+1]
+			switch strings.ToUpper(commands[i]) {
+			case "ID":
+				parsed, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || parsed <= 0 {
+					return []byte("-ERR client-id should be greater than 0\r\n")
+				}
+				id = parsed
+			case "TYPE":
+				clientType = getClientTypeByName(value)
+				if clientType == -1 {
+					return []byte(fmt.Sprintf("-ERR Unknown client type '%s'\r\n", value))
+				}
+			case "ADDR":
+				addr = value
+			case "LADDR":
+				laddr = value
+			case "USER":
+				user = value
+			case "SKIPME":
+				switch strings.ToLower(value) {
+				case "yes":
+					skipMe = true
+				case "no":
+					skipMe = false
+				default:
+					return []byte("-ERR syntax error\r\n")
+				}
+			case "MAXAGE":
+				parsed, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || parsed < 0 {
+					return []byte("-ERR syntax error\r\n")
+				}
+				maxAge = parsed
+			default:
+				return []byte("-ERR syntax error\r\n")
+			}
+		}
+	}
+
+	killed := 0
+	for _, target := range clients {
+		if (id != 0 && target.id != id) ||
+			(clientType != -1 && getClientType(target) != clientType) ||
+			(addr != "" && target.addr != addr) ||
+			(laddr != "" && target.laddr != laddr) ||
+			(user != "" && (target.user == nil || target.user.name != user)) ||
+			(maxAge != 0 && time.Since(target.createdAt) < time.Duration(maxAge)*time.Second) ||
+			(skipMe && target == client) {
+			continue
+		}
+		// the current client gets the reply before the connection is closed
+		if target == client {
+			client.flags |= CLIENT_CLOSE_AFTER_REPLY
+		} else {
+			app.freeClient(target)
+		}
+		killed++
+	}
+
+	if oldForm {
+		if killed == 0 {
+			return []byte("-ERR No such client\r\n")
+		}
+		return []byte("+OK\r\n")
+	}
+	return app.createIntegerResponse(killed)
+}
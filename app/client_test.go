@@ -0,0 +1,277 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ROLE: the fields of a CLIENT INFO line
+func parseClientInfo(t *testing.T, reply any) map[string]string {
+	t.Helper()
+	line, ok := reply.(string)
+	if !ok {
+		t.Fatalf("client info: %v", reply)
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Fields(line) {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+	return fields
+}
+
+func TestClientNameAndInfo(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+
+	expectReply(t, client.do("CLIENT", "GETNAME"), nil)
+	expectReply(t, client.do("CLIENT", "SETNAME", "worker-1"), "OK")
+	expectReply(t, client.do("CLIENT", "GETNAME"), "worker-1")
+	expectReply(t, client.do("CLIENT", "SETNAME", "with space"), respError("ERR Client names cannot contain spaces, newlines or special characters."))
+	expectReply(t, client.do("CLIENT", "SETINFO", "LIB-NAME", "go-redis"), "OK")
+	expectReply(t, client.do("CLIENT", "SETINFO", "lib-ver", "9.0"), "OK")
+	expectReply(t, client.do("CLIENT", "SETINFO", "other", "x"), respError("ERR Unrecognized option 'other'"))
+
+	client.do("SELECT", "2")
+	id := client.do("CLIENT", "ID")
+	info := parseClientInfo(t, client.do("CLIENT", "INFO"))
+	for name, want := range map[string]string{
+		"id": strconv.FormatInt(id.(int64), 10), "name": "worker-1", "db": "2", "flags": "N",
+		"cmd": "client|info", "user": "default", "resp": "2", "multi": "-1",
+		"lib-name": "go-redis", "lib-ver": "9.0", "laddr": server.addr,
+	} {
+		if info[name] != want {
+			t.Fatalf("%s=%s, want %s", name, info[name], want)
+		}
+	}
+
+	client.do("MULTI")
+	client.do("SET", "key", "value")
+	// CLIENT LIST shows the transaction of the client
+	other := server.connect(t)
+	list, _ := other.do("CLIENT", "LIST", "ID", info["id"]).(string)
+	fields := parseClientInfo(t, list)
+	if fields["flags"] != "x" || fields["multi"] != "1" {
+		t.Fatalf("client in MULTI: %s", list)
+	}
+	client.do("DISCARD")
+}
+
+func TestClientList(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	subscriber := server.connect(t)
+	subscriber.do("SUBSCRIBE", "news")
+	server.connectReplica(t)
+
+	list, _ := client.do("CLIENT", "LIST").(string)
+	if lines := strings.Count(list, "\n"); lines != 3 {
+		t.Fatalf("CLIENT LIST has %d clients:\n%s", lines, list)
+	}
+	for clientType, flags := range map[string]string{"pubsub": "P", "replica": "S", "normal": "N"} {
+		list, _ := client.do("CLIENT", "LIST", "TYPE", clientType).(string)
+		if strings.Count(list, "\n") != 1 || parseClientInfo(t, list)["flags"] != flags {
+			t.Fatalf("CLIENT LIST TYPE %s:\n%s", clientType, list)
+		}
+	}
+	expectReply(t, client.do("CLIENT", "LIST", "TYPE", "nosuch"), respError("ERR Unknown client type 'nosuch'"))
+	expectReply(t, client.do("CLIENT", "LIST", "ID", "0"), respError("ERR Invalid client ID"))
+
+	// RESP3 gets a verbatim string
+	client.do("HELLO", "3")
+	if info, ok := client.do("CLIENT", "INFO").(string); !ok || !strings.HasPrefix(info, "id=") {
+		t.Fatalf("RESP3 CLIENT INFO: %v", info)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	victim := server.connect(t)
+	other := server.connect(t)
+	victimInfo := parseClientInfo(t, victim.do("CLIENT", "INFO"))
+
+	expectReply(t, client.do("CLIENT", "KILL", victimInfo["addr"]), "OK")
+	expectReply(t, client.do("CLIENT", "KILL", victimInfo["addr"]), respError("ERR No such client"))
+	victim.connection.SetReadDeadline(time.Now().Add(TEST_TIMEOUT))
+	if _, err := victim.reader.ReadByte(); err == nil {
+		t.Fatal("killed client still connected")
+	}
+
+	// the filters, SKIPME yes by default
+	expectReply(t, client.do("CLIENT", "KILL", "TYPE", "normal"), 1)
+	other.connection.SetReadDeadline(time.Now().Add(TEST_TIMEOUT))
+	if _, err := other.reader.ReadByte(); err == nil {
+		t.Fatal("killed client still connected")
+	}
+	expectReply(t, client.do("CLIENT", "KILL", "ID", "0"), respError("ERR client-id should be greater than 0"))
+	expectReply(t, client.do("CLIENT", "KILL", "USER", "default", "MAXAGE", "1000"), 0)
+
+	// killing ourselves, the reply is sent first
+	expectReply(t, client.do("CLIENT", "KILL", "SKIPME", "no"), 1)
+	client.connection.SetReadDeadline(time.Now().Add(TEST_TIMEOUT))
+	if _, err := client.reader.ReadByte(); err == nil {
+		t.Fatal("client still connected after killing itself")
+	}
+}
+
+func TestClientReply(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+
+	client.send("CLIENT", "REPLY", "OFF")
+	client.send("SET", "key", "off")
+	expectReply(t, client.do("CLIENT", "REPLY", "ON"), "OK")
+	expectReply(t, client.do("GET", "key"), "off")
+
+	client.send("CLIENT", "REPLY", "SKIP")
+	client.send("SET", "key", "skip")
+	expectReply(t, client.do("GET", "key"), "skip")
+}
+
+func TestClientPause(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	writer := server.connect(t)
+	client.do("SET", "key", "before")
+
+	expectReply(t, client.do("CLIENT", "PAUSE", "10000", "WRITE"), "OK")
+	// reads go on, writes wait for the end of the pause
+	writer.send("SET", "key", "after")
+	expectReply(t, client.do("GET", "key"), "before")
+	writer.expectNoReply()
+	expectReply(t, client.do("CLIENT", "UNPAUSE"), "OK")
+	expectReply(t, writer.read(), "OK")
+	expectReply(t, client.do("GET", "key"), "after")
+
+	// ALL pauses the reads too, until the timeout
+	client.do("CLIENT", "PAUSE", "200")
+	start := time.Now()
+	expectReply(t, writer.do("GET", "key"), "after")
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Fatalf("read not paused, replied after %s", waited)
+	}
+	expectReply(t, client.do("CLIENT", "PAUSE", "-1"), respError("ERR timeout is negative"))
+}
+
+func TestClientTimeout(t *testing.T) {
+	server := startTestServer(t, "timeout 10")
+	client := server.connect(t)
+	idle := server.connect(t)
+	writer := server.connect(t)
+
+	// a client waiting for the end of the pause is not idle
+	client.do("CLIENT", "PAUSE", "100000", "WRITE")
+	writer.send("SET", "key", "value")
+	waitFor(t, func() bool { return server.clientOf(writer).flags&CLIENT_POSTPONED != 0 })
+	server.locked(func() {
+		longAgo := time.Now().Add(-time.Minute)
+		server.clientOf(idle).lastInteraction = longAgo
+		server.clientOf(writer).lastInteraction = longAgo
+		server.app.closeTimedoutClients()
+	})
+	idle.connection.SetReadDeadline(time.Now().Add(TEST_TIMEOUT))
+	if _, err := idle.reader.ReadByte(); err == nil {
+		t.Fatal("idle client still connected")
+	}
+	client.do("CLIENT", "UNPAUSE")
+	expectReply(t, writer.read(), "OK")
+	expectReply(t, writer.do("GET", "key"), "value")
+}
+
+func TestParseClientOutputBufferLimits(t *testing.T) {
+	defaults := [3]clientBufferLimit{
+		CLIENT_TYPE_SLAVE:  {hardLimit: 256 << 20, softLimit: 64 << 20, softSeconds: 60},
+		CLIENT_TYPE_PUBSUB: {hardLimit: 32 << 20, softLimit: 8 << 20, softSeconds: 60},
+	}
+	tests := []struct {
+		value string
+		want  [3]clientBufferLimit
+		valid bool
+	}{
+		// the other classes keep their limits
+		{"pubsub 1mb 512kb 10", [3]clientBufferLimit{
+			CLIENT_TYPE_SLAVE:  defaults[CLIENT_TYPE_SLAVE],
+			CLIENT_TYPE_PUBSUB: {hardLimit: 1 << 20, softLimit: 512 << 10, softSeconds: 10},
+		}, true},
+		{"normal 100 50 1 replica 0 0 0", [3]clientBufferLimit{
+			CLIENT_TYPE_NORMAL: {hardLimit: 100, softLimit: 50, softSeconds: 1},
+			CLIENT_TYPE_PUBSUB: defaults[CLIENT_TYPE_PUBSUB],
+		}, true},
+		{"pubsub 1mb 512kb", defaults, false},
+		{"", defaults, false},
+		{"master 0 0 0", defaults, false},
+		{"nosuch 0 0 0", defaults, false},
+		{"normal 1xb 0 0", defaults, false},
+		{"normal 0 0 -1", defaults, false},
+	}
+	for _, test := range tests {
+		limits, err := parseClientOutputBufferLimits(test.value, defaults)
+		if (err == nil) != test.valid || limits != test.want {
+			t.Fatalf("%q: got %v, error %v, want %v", test.value, limits, err, test.want)
+		}
+	}
+}
+
+func TestOutputBufferLimitReached(t *testing.T) {
+	startTestServer(t, "client-output-buffer-limit normal 1000 100 10")
+	app := &App{}
+	client := &Client{}
+	for _, test := range []struct {
+		size int
+		// how long the soft limit is already exceeded, 0 for not yet
+		softFor time.Duration
+		reached bool
+	}{
+		{99, 0, false},
+		{1000, 0, true},
+		// the first time over the soft limit starts the timer
+		{100, 0, false},
+		{500, 5 * time.Second, false},
+		{500, 11 * time.Second, true},
+	} {
+		client.outputSize = test.size
+		client.outputSoftLimitReachedTime = time.Time{}
+		if test.softFor > 0 {
+			client.outputSoftLimitReachedTime = time.Now().Add(-test.softFor)
+		}
+		if reached := app.outputBufferLimitReached(client); reached != test.reached {
+			t.Fatalf("size %d over the soft limit for %s: reached %v", test.size, test.softFor, reached)
+		}
+	}
+	// back under the soft limit resets the timer
+	client.outputSize = 10
+	app.outputBufferLimitReached(client)
+	if !client.outputSoftLimitReachedTime.IsZero() {
+		t.Fatal("soft limit timer not reset")
+	}
+}
+
+func TestOutputBufferLimitDisconnects(t *testing.T) {
+	server := startTestServer(t, "client-output-buffer-limit pubsub 1kb 0 0")
+	subscriber := server.connect(t)
+	client := server.connect(t)
+	subscriber.do("SUBSCRIBE", "news")
+	expectReply(t, client.do("CONFIG", "GET", "client-output-buffer-limit"),
+		[]any{"client-output-buffer-limit", "normal 0 0 0 slave 268435456 67108864 60 pubsub 1024 0 0"})
+
+	// the normal clients have no limit
+	large := strings.Repeat("x", 2048)
+	client.do("SET", "key", large)
+	expectReply(t, client.do("GET", "key"), large)
+
+	expectReply(t, client.do("PUBLISH", "news", "small"), 1)
+	expectReply(t, subscriber.read(), []any{"message", "news", "small"})
+	client.do("PUBLISH", "news", large)
+	subscriber.connection.SetReadDeadline(time.Now().Add(TEST_TIMEOUT))
+	if _, err := subscriber.reader.ReadByte(); err == nil {
+		t.Fatal("subscriber over the hard limit still connected")
+	}
+	server.locked(func() {
+		if stats.clientOutputBufferLimitDisconnections != 1 {
+			t.Fatalf("client_output_buffer_limit_disconnections %d", stats.clientOutputBufferLimitDisconnections)
+		}
+	})
+}
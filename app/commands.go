@@ -0,0 +1,230 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+/*
+INFO: Command table
+Name, arity, flags, key positions, ACL categories and the handler of every
+command the server knows.
+*/
+
+// command flags
+const (
+	CMD_WRITE    = 1 << iota // modifies the keyspace, propagated to replicas
+	CMD_READONLY = 1 << iota // only reads the keyspace
+	CMD_ADMIN    = 1 << iota // administrative command
+	CMD_PUBSUB   = 1 << iota // pub/sub related command
+	CMD_FAST     = 1 << iota // O(1) or O(log(N)) command
+	CMD_NO_MULTI = 1 << iota // not allowed inside MULTI
+	CMD_DENYOOM  = 1 << iota // may use more memory, refused when over maxmemory
+	CMD_NO_AUTH  = 1 << iota // allowed before the client is authenticated
+)
+
+var commandTable map[string]*Command
+
+func init() {
+	commandTable = make(map[string]*Command)
+	for _, command := range []*Command{
+		{name: "command", arity: -1, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executeCOMMAND},
+		{name: "ping", arity: -1, flags: CMD_FAST, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executePING},
+		{name: "echo", arity: 2, flags: CMD_FAST, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executeECHO},
+		{name: "set", arity: -3, flags: CMD_WRITE | CMD_DENYOOM, firstKey: 1, lastKey: 1, keyStep: 1, aclCategories: ACL_CATEGORY_STRING, handler: (*App).executeSET},
+		{name: "get", arity: 2, flags: CMD_READONLY | CMD_FAST, firstKey: 1, lastKey: 1, keyStep: 1, aclCategories: ACL_CATEGORY_STRING, handler: (*App).executeGET},
+		{name: "del", arity: -2, flags: CMD_WRITE, firstKey: 1, lastKey: -1, keyStep: 1, aclCategories: ACL_CATEGORY_KEYSPACE, handler: (*App).executeDEL},
+		{name: "keys", arity: 2, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS, handler: (*App).executeKEYS},
+		{name: "select", arity: 2, flags: CMD_FAST, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executeSELECT},
+		{name: "move", arity: 3, flags: CMD_WRITE | CMD_FAST, firstKey: 1, lastKey: 1, keyStep: 1, aclCategories: ACL_CATEGORY_KEYSPACE, handler: (*App).executeMOVE},
+		{name: "swapdb", arity: 3, flags: CMD_WRITE | CMD_FAST, aclCategories: ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS, handler: (*App).executeSWAPDB},
+		{name: "flushdb", arity: -1, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS, handler: (*App).executeFLUSHDB},
+		{name: "flushall", arity: -1, flags: CMD_WRITE, aclCategories: ACL_CATEGORY_KEYSPACE | ACL_CATEGORY_DANGEROUS, handler: (*App).executeFLUSHALL},
+		{name: "dbsize", arity: 1, flags: CMD_READONLY | CMD_FAST, aclCategories: ACL_CATEGORY_KEYSPACE, handler: (*App).executeDBSIZE},
+		{name: "object", arity: -2, flags: CMD_READONLY, firstKey: 2, lastKey: 2, keyStep: 1, aclCategories: ACL_CATEGORY_KEYSPACE, handler: (*App).executeOBJECT},
+		{name: "memory", arity: -2, flags: CMD_READONLY, firstKey: 2, lastKey: 2, keyStep: 1, handler: (*App).executeMEMORY},
+		{name: "randomkey", arity: 1, flags: CMD_READONLY, aclCategories: ACL_CATEGORY_KEYSPACE, handler: (*App).executeRANDOMKEY},
+		{name: "config", arity: -2, flags: CMD_ADMIN, handler: (*App).executeCONFIG, subcommands: newSubcommands(
+			&Command{name: "get", flags: CMD_ADMIN},
+			&Command{name: "set", flags: CMD_ADMIN},
+			&Command{name: "rewrite", flags: CMD_ADMIN},
+			&Command{name: "resetstat", flags: CMD_ADMIN},
+			&Command{name: "help"},
+		)},
+		{name: "save", arity: 1, flags: CMD_ADMIN | CMD_NO_MULTI, handler: (*App).executeSAVE},
+		{name: "bgsave", arity: -1, flags: CMD_ADMIN, handler: (*App).executeBGSAVE},
+		{name: "lastsave", arity: 1, flags: CMD_FAST, aclCategories: ACL_CATEGORY_DANGEROUS, handler: (*App).executeLASTSAVE},
+		{name: "shutdown", arity: -1, flags: CMD_ADMIN | CMD_NO_MULTI, handler: (*App).executeSHUTDOWN},
+		{name: "slowlog", arity: -2, flags: CMD_ADMIN, handler: (*App).executeSLOWLOG, subcommands: newSubcommands(
+			&Command{name: "get", flags: CMD_ADMIN},
+			&Command{name: "len", flags: CMD_ADMIN},
+			&Command{name: "reset", flags: CMD_ADMIN},
+			&Command{name: "help"},
+		)},
+		{name: "latency", arity: -2, flags: CMD_ADMIN, handler: (*App).executeLATENCY, subcommands: newSubcommands(
+			&Command{name: "doctor", flags: CMD_ADMIN},
+			&Command{name: "graph", flags: CMD_ADMIN},
+			&Command{name: "history", flags: CMD_ADMIN},
+			&Command{name: "latest", flags: CMD_ADMIN},
+			&Command{name: "reset", flags: CMD_ADMIN},
+			&Command{name: "histogram", flags: CMD_ADMIN},
+			&Command{name: "help"},
+		)},
+		{name: "monitor", arity: 1, flags: CMD_ADMIN | CMD_NO_MULTI, handler: (*App).executeMONITOR},
+		{name: "info", arity: -1, aclCategories: ACL_CATEGORY_DANGEROUS, handler: (*App).executeINFO},
+		{name: "replconf", arity: -1, flags: CMD_ADMIN, handler: (*App).executeREPLCONF},
+		{name: "psync", arity: -3, flags: CMD_ADMIN | CMD_NO_MULTI, handler: (*App).executePSYNC},
+		{name: "subscribe", arity: -2, flags: CMD_PUBSUB, handler: (*App).executeSUBSCRIBE},
+		{name: "psubscribe", arity: -2, flags: CMD_PUBSUB, handler: (*App).executePSUBSCRIBE},
+		{name: "unsubscribe", arity: -1, flags: CMD_PUBSUB, handler: (*App).executeUNSUBSCRIBE},
+		{name: "punsubscribe", arity: -1, flags: CMD_PUBSUB, handler: (*App).executePUNSUBSCRIBE},
+		{name: "publish", arity: 3, flags: CMD_PUBSUB | CMD_FAST, handler: (*App).executePUBLISH},
+		{name: "pubsub", arity: -2, flags: CMD_PUBSUB, handler: (*App).executePUBSUB},
+		{name: "client", arity: -2, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executeCLIENT, subcommands: newSubcommands(
+			&Command{name: "id", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "info", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "list", flags: CMD_ADMIN, aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "setname", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "getname", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "setinfo", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "kill", flags: CMD_ADMIN, aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "pause", flags: CMD_ADMIN, aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "unpause", flags: CMD_ADMIN, aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "reply", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "no-evict", flags: CMD_ADMIN, aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "no-touch", flags: CMD_FAST, aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "tracking", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "caching", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "getredir", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "trackinginfo", aclCategories: ACL_CATEGORY_CONNECTION},
+			&Command{name: "help", aclCategories: ACL_CATEGORY_CONNECTION},
+		)},
+		{name: "auth", arity: -2, flags: CMD_FAST | CMD_NO_AUTH, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executeAUTH},
+		{name: "acl", arity: -2, handler: (*App).executeACL, subcommands: newSubcommands(
+			&Command{name: "cat"},
+			&Command{name: "deluser", flags: CMD_ADMIN},
+			&Command{name: "dryrun", flags: CMD_ADMIN},
+			&Command{name: "genpass"},
+			&Command{name: "getuser", flags: CMD_ADMIN},
+			&Command{name: "list", flags: CMD_ADMIN},
+			&Command{name: "load", flags: CMD_ADMIN},
+			&Command{name: "log", flags: CMD_ADMIN},
+			&Command{name: "save", flags: CMD_ADMIN},
+			&Command{name: "setuser", flags: CMD_ADMIN},
+			&Command{name: "users", flags: CMD_ADMIN},
+			&Command{name: "whoami"},
+			&Command{name: "help"},
+		)},
+		{name: "hello", arity: -1, flags: CMD_FAST | CMD_NO_AUTH, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executeHELLO},
+		{name: "reset", arity: 1, flags: CMD_FAST | CMD_NO_AUTH, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executeRESET},
+		{name: "quit", arity: -1, flags: CMD_FAST | CMD_NO_AUTH, aclCategories: ACL_CATEGORY_CONNECTION, handler: (*App).executeQUIT},
+		{name: "multi", arity: 1, flags: CMD_FAST, aclCategories: ACL_CATEGORY_TRANSACTION, handler: (*App).executeMULTI},
+		{name: "exec", arity: 1, aclCategories: ACL_CATEGORY_TRANSACTION, handler: (*App).executeEXEC},
+		{name: "discard", arity: 1, flags: CMD_FAST, aclCategories: ACL_CATEGORY_TRANSACTION, handler: (*App).executeDISCARD},
+		{name: "watch", arity: -2, flags: CMD_FAST, firstKey: 1, lastKey: -1, keyStep: 1, aclCategories: ACL_CATEGORY_TRANSACTION, handler: (*App).executeWATCH},
+		{name: "unwatch", arity: 1, flags: CMD_FAST, aclCategories: ACL_CATEGORY_TRANSACTION, handler: (*App).executeUNWATCH},
+	} {
+		command.fullName = command.name
+		command.aclCategories |= aclImplicitCategories(command)
+		for _, subcommand := range command.subcommands {
+			subcommand.parent = command
+			subcommand.fullName = command.name + "|" + subcommand.name
+			subcommand.aclCategories |= aclImplicitCategories(subcommand)
+		}
+		commandTable[command.name] = command
+	}
+}
+
+// ROLE: build the subcommand table of a container command
+func newSubcommands(subcommands ...*Command) map[string]*Command {
+	table := make(map[string]*Command, len(subcommands))
+	for _, subcommand := range subcommands {
+		table[subcommand.name] = subcommand
+	}
+	return table
+}
+
+// ROLE: find the command in the command table, case insensitive
+func lookupCommand(name string) *Command {
+	return commandTable[strings.ToLower(name)]
+}
+
+// ROLE: find the command or the subcommand by its full name, ex: config|get
+func lookupCommandByFullName(name string) *Command {
+	name, subcommandName, isSubcommand := strings.Cut(strings.ToLower(name), "|")
+	command := commandTable[name]
+	if command == nil || !isSubcommand {
+		return command
+	}
+	return command.subcommands[subcommandName]
+}
+
+// ROLE: find the subcommand of a container command, nil if there is none
+func lookupSubcommand(command *Command, commands []string) *Command {
+	if command.subcommands == nil || len(commands) < 2 {
+		return nil
+	}
+	return command.subcommands[strings.ToLower(commands[1])]
+}
+
+// ROLE: get the key arguments of the command, as per its key positions
+func getKeysFromCommand(command *Command, commands []string) []string {
+	if command.firstKey == 0 {
+		return nil
+	}
+	last := command.lastKey
+	if last < 0 {
+		last = len(commands) + last
+	}
+	var keys []string
+	for i := command.firstKey; i <= last && i < len(commands); i += command.keyStep {
+		keys = append(keys, commands[i])
+	}
+	return keys
+}
+
+// ROLE: check if the command is CLIENT CACHING
+func isClientCachingCommand(commands []string) bool {
+	return len(commands) >= 2 && strings.EqualFold(commands[0], "client") && strings.EqualFold(commands[1], "caching")
+}
+
+// replaces the secrets in the arguments shown by SLOWLOG
+const REDACTED_ARGUMENT = "(redacted)"
+
+// ROLE: copy of the arguments with the passwords redacted:
+// AUTH, HELLO AUTH, the password rules of ACL SETUSER and the sensitive CONFIG SET values
+func redactCommandArguments(commands []string) []string {
+	args := slices.Clone(commands)
+	switch strings.ToLower(args[0]) {
+	case "auth":
+		for i := 1; i < len(args); i++ {
+			args[i] = REDACTED_ARGUMENT
+		}
+	case "hello":
+		for i := 2; i < len(args); i++ {
+			if strings.EqualFold(args[i], "AUTH") {
+				for j := i + 1; j <= i+2 && j < len(args); j++ {
+					args[j] = REDACTED_ARGUMENT
+				}
+				i += 2
+			}
+		}
+	case "acl":
+		if len(args) >= 3 && strings.EqualFold(args[1], "setuser") {
+			for i := 3; i < len(args); i++ {
+				if args[i] != "" && strings.ContainsRune("><#!", rune(args[i][0])) {
+					args[i] = REDACTED_ARGUMENT
+				}
+			}
+		}
+	case "config":
+		if len(args) >= 2 && strings.EqualFold(args[1], "set") {
+			for i := 2; i+1 < len(args); i += 2 {
+				if param := lookupConfig(args[i]); param != nil && param.flags&CONFIG_SENSITIVE != 0 {
+					args[i+1] = REDACTED_ARGUMENT
+				}
+			}
+		}
+	}
+	return args
+}
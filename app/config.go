@@ -0,0 +1,924 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+INFO: Configuration
+Every parameter is in the configTable with its default, how to read it and
+how to validate and apply a new value. The configuration is loaded from the
+defaults, then the redis.conf file given as the first argument, then the
+--name value overrides of the command line, ex:
+	redis-go /etc/redis.conf --port 7000 --replicaof localhost 6379
+CONFIG SET sets several parameters at once, if one fails they all keep their
+previous value. CONFIG REWRITE writes the current values back to the file,
+comments and unknown lines are kept. On SIGHUP the config file is read again
+and the parameters of the file that changed are set like with CONFIG SET, the
+others keep their value, even the ones removed from the file.
+*/
+
+// config parameter flags
+const (
+	CONFIG_IMMUTABLE  = 1 << iota // can't be changed once the server started
+	CONFIG_MULTI_ARG  = 1 << iota // the value is several arguments, ex: save 3600 1 300 100
+	CONFIG_MULTI_LINE = 1 << iota // the lines of the config file add up, ex: save
+	CONFIG_SENSITIVE  = 1 << iota // a secret, redacted from SLOWLOG, MONITOR and the log
+)
+
+// side effects applied once after the parameters are set, whatever the number of parameters
+const (
+	CONFIG_APPLY_NONE = iota
+	CONFIG_APPLY_LISTENERS
+	CONFIG_APPLY_DIR
+	CONFIG_APPLY_MAXMEMORY
+	CONFIG_APPLY_ACLLOG
+)
+
+const CONFIG_REWRITE_SIGNATURE = "# Generated by CONFIG REWRITE"
+
+// for a configuration parameter
+type configParam struct {
+	name string
+	// old name still accepted, ex: slaveof
+	alias        string
+	flags        int
+	defaultValue string
+	// CONFIG_APPLY_* run after CONFIG SET
+	apply int
+	get   func() string
+	set   func(app *App, value string) error
+	// the value as the getter returns it once set, without setting it, ex:
+	// maxmemory 10mb is 10485760, nil when the getter returns the value as set
+	normalize func(value string) (string, error)
+}
+
+// for a parameter read from the config file
+type configEntry struct {
+	param *configParam
+	value string
+	line  int
+}
+
+// absolute path of the config file, empty without one
+var configFile string
+
+// config lines of the --name value overrides, they still win on SIGHUP
+var configOverrides string
+
+var (
+	configTable  []*configParam
+	configByName map[string]*configParam
+)
+
+func init() {
+	configTable = []*configParam{
+		stringConfig("dir", 0, &dir, ".redis/rdb/").onApply(CONFIG_APPLY_DIR),
+		{name: "dbfilename", defaultValue: "redis.rdb",
+			get: func() string { return dbFileName },
+			set: func(app *App, value string) error {
+				if value != filepath.Base(value) {
+					return fmt.Errorf("dbfilename can't be a path, just a filename")
+				}
+				dbFileName = value
+				return nil
+			}},
+		intConfig("port", 0, &port, "6379", 0, 65535).onApply(CONFIG_APPLY_LISTENERS),
+		{name: "bind", flags: CONFIG_MULTI_ARG, defaultValue: "127.0.0.1 -::1", apply: CONFIG_APPLY_LISTENERS,
+			get: func() string { return bind },
+			set: func(app *App, value string) error {
+				if _, err := parseBindAddresses(value); err != nil {
+					return err
+				}
+				bind = value
+				return nil
+			}},
+		stringConfig("unixsocket", CONFIG_IMMUTABLE, &unixsocket, ""),
+		{name: "unixsocketperm", flags: CONFIG_IMMUTABLE, defaultValue: "0",
+			get: func() string { return unixsocketperm },
+			set: func(app *App, value string) error {
+				if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+					return fmt.Errorf("argument must be an octal number")
+				}
+				unixsocketperm = value
+				return nil
+			}},
+		boolConfig("protected-mode", 0, &protectedMode, "yes"),
+		intConfig("maxclients", 0, &maxclients, "10000", 1, 1<<30),
+		intConfig("timeout", 0, &clientTimeout, "0", 0, 1<<30),
+		intConfig("tcp-keepalive", 0, &tcpKeepalive, "300", 0, 1<<30),
+		{name: "client-output-buffer-limit", flags: CONFIG_MULTI_ARG,
+			defaultValue: "normal 0 0 0 slave 268435456 67108864 60 pubsub 33554432 8388608 60",
+			get:          func() string { return clientOutputBufferLimitsString(clientOutputBufferLimits) },
+			set: func(app *App, value string) error {
+				limits, err := parseClientOutputBufferLimits(value, clientOutputBufferLimits)
+				if err != nil {
+					return err
+				}
+				clientOutputBufferLimits = limits
+				return nil
+			},
+			// the classes not given keep their default, like at startup
+			normalize: func(value string) (string, error) {
+				limits, err := parseClientOutputBufferLimits(value, defaultClientOutputBufferLimits)
+				return clientOutputBufferLimitsString(limits), err
+			}},
+		{name: "save", flags: CONFIG_MULTI_ARG | CONFIG_MULTI_LINE, defaultValue: "3600 1 300 100 60 10000",
+			get: func() string { return saveParamsString(saveParams) },
+			set: func(app *App, value string) error {
+				points, err := parseSaveParams(value)
+				if err != nil {
+					return err
+				}
+				saveParams = points
+				return nil
+			},
+			normalize: func(value string) (string, error) {
+				points, err := parseSaveParams(value)
+				return saveParamsString(points), err
+			}},
+		intConfig("shutdown-timeout", 0, &shutdownTimeout, "10", 0, 1<<30),
+		stringConfig("pidfile", CONFIG_IMMUTABLE, &pidfile, ""),
+		{name: "replicaof", alias: "slaveof", flags: CONFIG_IMMUTABLE | CONFIG_MULTI_ARG,
+			get: func() string { return replicaof },
+			set: func(app *App, value string) error {
+				master, err := parseReplicaof(value)
+				if err != nil {
+					return err
+				}
+				replicaof = master
+				return nil
+			},
+			normalize: parseReplicaof},
+		stringConfig("masteruser", 0, &masteruser, ""),
+		intConfig("repl-ping-replica-period", 0, &replPingReplicaPeriod, "10", 1, 1<<30),
+		stringConfig("masterauth", CONFIG_SENSITIVE, &masterauth, ""),
+		intConfig("databases", CONFIG_IMMUTABLE, &databases, "16", 1, 1<<20),
+		{name: "notify-keyspace-events",
+			get: func() string { return notifyKeyspaceEventsString(notifyKeyspaceEvents) },
+			set: func(app *App, value string) error {
+				flags, err := parseNotifyKeyspaceEvents(value)
+				if err != nil {
+					return err
+				}
+				notifyKeyspaceEvents = flags
+				return nil
+			},
+			normalize: func(value string) (string, error) {
+				flags, err := parseNotifyKeyspaceEvents(value)
+				return notifyKeyspaceEventsString(flags), err
+			}},
+		{name: "maxmemory", defaultValue: "0", apply: CONFIG_APPLY_MAXMEMORY,
+			get: func() string { return strconv.FormatInt(maxmemoryBytes, 10) },
+			set: func(app *App, value string) error {
+				limit, err := parseMemory(value)
+				if err != nil {
+					return err
+				}
+				maxmemoryBytes = limit
+				return nil
+			},
+			normalize: func(value string) (string, error) {
+				limit, err := parseMemory(value)
+				return strconv.FormatInt(limit, 10), err
+			}},
+		{name: "maxmemory-policy", defaultValue: MAXMEMORY_NO_EVICTION,
+			get: func() string { return maxmemoryPolicy },
+			set: func(app *App, value string) error {
+				policy, err := parseMaxmemoryPolicy(value)
+				if err != nil {
+					return err
+				}
+				if policy != maxmemoryPolicy {
+					// scores of another policy are meaningless
+					evictionPool = nil
+				}
+				maxmemoryPolicy = policy
+				return nil
+			},
+			normalize: parseMaxmemoryPolicy},
+		intConfig("maxmemory-samples", 0, &maxmemorySamples, "5", 1, 64),
+		intConfig("lfu-log-factor", 0, &lfuLogFactor, "10", 0, 1<<30),
+		intConfig("lfu-decay-time", 0, &lfuDecayTime, "1", 0, 1<<30),
+		{name: "requirepass", flags: CONFIG_SENSITIVE,
+			get: func() string { return requirepass },
+			set: func(app *App, value string) error {
+				// applied to the default user by initACL at startup
+				if defaultUser == nil {
+					requirepass = value
+				} else {
+					app.aclUpdateRequirePass(value)
+				}
+				return nil
+			}},
+		stringConfig("aclfile", CONFIG_IMMUTABLE, &aclFile, ""),
+		intConfig("acllog-max-len", 0, &aclLogMaxLen, "128", 0, 1<<30).onApply(CONFIG_APPLY_ACLLOG),
+		{name: "loglevel", defaultValue: "notice",
+			get: func() string { return logLevelOf(logMinLevel.Level()).name },
+			set: func(app *App, value string) error {
+				level, err := parseLogLevel(value)
+				if err != nil {
+					return err
+				}
+				logMinLevel.Set(level)
+				return nil
+			},
+			normalize: func(value string) (string, error) {
+				level, err := parseLogLevel(value)
+				return logLevelOf(level).name, err
+			}},
+		stringConfig("logfile", CONFIG_IMMUTABLE, &logFile, ""),
+		{name: "log-format", defaultValue: "legacy",
+			get: func() string {
+				if logFormatJSON.Load() {
+					return "json"
+				}
+				return "legacy"
+			},
+			set: func(app *App, value string) error {
+				format, err := parseLogFormat(value)
+				if err != nil {
+					return err
+				}
+				logFormatJSON.Store(format == "json")
+				return nil
+			},
+			normalize: parseLogFormat},
+		boolConfig("syslog-enabled", CONFIG_IMMUTABLE, &syslogEnabled, "no"),
+		stringConfig("syslog-ident", CONFIG_IMMUTABLE, &syslogIdent, "redis"),
+		enumConfig("syslog-facility", CONFIG_IMMUTABLE, &syslogFacility, "local0",
+			"user", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"),
+		intConfig("slowlog-log-slower-than", 0, &slowlogLogSlowerThan, "10000", -1<<31, 1<<31-1),
+		intConfig("slowlog-max-len", 0, &slowlogMaxLen, "128", 0, 1<<30),
+		intConfig("latency-monitor-threshold", 0, &latencyMonitorThreshold, "0", 0, 1<<30),
+		boolConfig("latency-tracking", 0, &latencyTracking, "yes"),
+		{name: "latency-tracking-info-percentiles", flags: CONFIG_MULTI_ARG, defaultValue: "50 99 99.9",
+			get: func() string { return latencyPercentilesString(latencyTrackingInfoPercentiles) },
+			set: func(app *App, value string) error {
+				percentiles, err := parseLatencyPercentiles(value)
+				if err != nil {
+					return err
+				}
+				latencyTrackingInfoPercentiles = percentiles
+				return nil
+			},
+			normalize: func(value string) (string, error) {
+				percentiles, err := parseLatencyPercentiles(value)
+				return latencyPercentilesString(percentiles), err
+			}},
+		intConfig("metrics-port", CONFIG_IMMUTABLE, &metricsPort, "0", 0, 65535),
+		intConfig("readiness-max-replica-lag", 0, &readinessMaxReplicaLag, "0", 0, 1<<30),
+		intConfig("tls-port", 0, &tlsPort, "0", 0, 65535).onApply(CONFIG_APPLY_LISTENERS),
+		stringConfig("tls-cert-file", 0, &tlsCertFile, "").onApply(CONFIG_APPLY_LISTENERS),
+		stringConfig("tls-key-file", 0, &tlsKeyFile, "").onApply(CONFIG_APPLY_LISTENERS),
+		stringConfig("tls-ca-cert-file", 0, &tlsCACertFile, "").onApply(CONFIG_APPLY_LISTENERS),
+		enumConfig("tls-auth-clients", 0, &tlsAuthClients, "yes", "yes", "no", "optional").onApply(CONFIG_APPLY_LISTENERS),
+		enumConfig("tls-auth-clients-user", 0, &tlsAuthClientsUser, "", "", "CN").onApply(CONFIG_APPLY_LISTENERS),
+		stringConfig("tls-protocols", 0, &tlsProtocols, "TLSv1.2 TLSv1.3").onApply(CONFIG_APPLY_LISTENERS),
+		stringConfig("tls-ciphers", 0, &tlsCiphers, "").onApply(CONFIG_APPLY_LISTENERS),
+		boolConfig("tls-replication", 0, &tlsReplication, "no"),
+		stringConfig("tls-client-cert-file", 0, &tlsClientCertFile, ""),
+		stringConfig("tls-client-key-file", 0, &tlsClientKeyFile, ""),
+	}
+
+	configByName = make(map[string]*configParam)
+	for _, param := range configTable {
+		configByName[param.name] = param
+		if param.alias != "" {
+			configByName[param.alias] = param
+		}
+	}
+}
+
+// ROLE: typed parameters bound to a variable
+
+func stringConfig(name string, flags int, value *string, defaultValue string) *configParam {
+	return &configParam{name: name, flags: flags, defaultValue: defaultValue,
+		get: func() string { return *value },
+		set: func(app *App, newValue string) error {
+			*value = newValue
+			return nil
+		}}
+}
+
+func intConfig(name string, flags int, value *int, defaultValue string, min int, max int) *configParam {
+	parse := func(newValue string) (int, error) {
+		number, err := strconv.Atoi(newValue)
+		if err != nil {
+			return 0, fmt.Errorf("argument couldn't be parsed into an integer")
+		}
+		if number < min || number > max {
+			return 0, fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+		}
+		return number, nil
+	}
+	return &configParam{name: name, flags: flags, defaultValue: defaultValue,
+		get: func() string { return strconv.Itoa(*value) },
+		set: func(app *App, newValue string) error {
+			number, err := parse(newValue)
+			if err != nil {
+				return err
+			}
+			*value = number
+			return nil
+		},
+		normalize: func(newValue string) (string, error) {
+			number, err := parse(newValue)
+			return strconv.Itoa(number), err
+		}}
+}
+
+func boolConfig(name string, flags int, value *bool, defaultValue string) *configParam {
+	return &configParam{name: name, flags: flags, defaultValue: defaultValue,
+		get: func() string { return yesNoString(*value) },
+		set: func(app *App, newValue string) error {
+			enabled, err := parseYesNo(newValue)
+			if err != nil {
+				return err
+			}
+			*value = enabled
+			return nil
+		},
+		normalize: func(newValue string) (string, error) {
+			enabled, err := parseYesNo(newValue)
+			return yesNoString(enabled), err
+		}}
+}
+
+func enumConfig(name string, flags int, value *string, defaultValue string, values ...string) *configParam {
+	parse := func(newValue string) (string, error) {
+		for _, allowed := range values {
+			if strings.EqualFold(newValue, allowed) {
+				return allowed, nil
+			}
+		}
+		return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(values, ", "))
+	}
+	return &configParam{name: name, flags: flags, defaultValue: defaultValue,
+		get: func() string { return *value },
+		set: func(app *App, newValue string) error {
+			allowed, err := parse(newValue)
+			if err != nil {
+				return err
+			}
+			*value = allowed
+			return nil
+		},
+		normalize: parse}
+}
+
+func (param *configParam) onApply(apply int) *configParam {
+	param.apply = apply
+	return param
+}
+
+// ROLE: parse replicaof, "no one" is no master
+func parseReplicaof(value string) (string, error) {
+	if strings.EqualFold(value, "no one") {
+		return "", nil
+	}
+	if value != "" && len(strings.Fields(value)) != 2 {
+		return "", fmt.Errorf("replicaof expects <host> <port> or 'no one'")
+	}
+	return value, nil
+}
+
+// ROLE: parse log-format, legacy or json
+func parseLogFormat(value string) (string, error) {
+	format := strings.ToLower(value)
+	if format != "legacy" && format != "json" {
+		return "", fmt.Errorf("argument(s) must be one of the following: legacy, json")
+	}
+	return format, nil
+}
+
+// ROLE: find the parameter by its name or alias
+func lookupConfig(name string) *configParam {
+	return configByName[strings.ToLower(name)]
+}
+
+// ROLE: load the configuration: the defaults, the config file given as the first
+// argument, then the --name value overrides
+func (app *App) loadServerConfig(args []string) error {
+	for _, param := range configTable {
+		if err := param.set(app, param.defaultValue); err != nil {
+			return fmt.Errorf("invalid default of %s: %w", param.name, err)
+		}
+		// as returned by the getter, compared by CONFIG REWRITE
+		param.defaultValue = param.get()
+	}
+
+	var content strings.Builder
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("Fatal error, can't open config file '%s': %s", path, err)
+		}
+		configFile = path
+		content.Write(file)
+		args = args[1:]
+	}
+	// the overrides are config lines after the file, ex: --port 7000 or --port=7000 is "port 7000"
+	var overrides strings.Builder
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			name, value, found := strings.Cut(arg[2:], "=")
+			overrides.WriteString("\n" + name)
+			if found {
+				overrides.WriteString(" " + configQuote(value))
+			}
+		} else {
+			overrides.WriteString(" " + configQuote(arg))
+		}
+	}
+	configOverrides = overrides.String()
+	content.WriteString(configOverrides)
+
+	entries, err := parseConfig(content.String())
+	if err != nil {
+		return fatalConfigError(err.(*configError))
+	}
+	for _, entry := range entries {
+		if err := entry.param.set(app, entry.value); err != nil {
+			return fatalConfigError(&configError{line: entry.line, content: entry.param.name + " " + entry.value, reason: err.Error()})
+		}
+	}
+	return nil
+}
+
+// ROLE: parse the lines of the config file, the values of a CONFIG_MULTI_LINE
+// parameter add up, ex: "save 3600 1" then "save 300 100" is "3600 1 300 100"
+func parseConfig(content string) ([]configEntry, error) {
+	var entries []configEntry
+	indexes := make(map[*configParam]int)
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := splitConfigArgs(line)
+		if err != nil {
+			return nil, &configError{line: i + 1, content: line, reason: err.Error()}
+		}
+		param := lookupConfig(args[0])
+		if param == nil || len(args) < 2 || (len(args) > 2 && param.flags&CONFIG_MULTI_ARG == 0) {
+			return nil, &configError{line: i + 1, content: line, reason: "Bad directive or wrong number of arguments"}
+		}
+		value := strings.Join(args[1:], " ")
+
+		index, seen := indexes[param]
+		if seen && param.flags&CONFIG_MULTI_LINE != 0 {
+			// an empty value resets the previous lines, ex: save ""
+			if value != "" {
+				value = strings.TrimSpace(entries[index].value + " " + value)
+			}
+			entries[index].value = value
+			continue
+		}
+		if seen {
+			// the last line wins
+			entries[index] = configEntry{param: param, value: value, line: i + 1}
+			continue
+		}
+		indexes[param] = len(entries)
+		entries = append(entries, configEntry{param: param, value: value, line: i + 1})
+	}
+	return entries, nil
+}
+
+// for an invalid line of the config file
+type configError struct {
+	line    int
+	content string
+	reason  string
+}
+
+func (err *configError) Error() string {
+	return fmt.Sprintf("line %d '%s': %s", err.line, err.content, err.reason)
+}
+
+// ROLE: the error the server exits with at startup
+func fatalConfigError(err *configError) error {
+	return fmt.Errorf("\n*** FATAL CONFIG FILE ERROR (Redis %s) ***\nReading the configuration file, at line %d\n>>> '%s'\n%s",
+		REDIS_SERVER_VERSION, err.line, err.content, err.reason)
+}
+
+// ROLE: re-read the config file on SIGHUP and set its parameters that changed,
+// all or none of them, the immutable ones are only logged, then reload the aclfile
+// caller must hold the serverMutex
+func (app *App) reloadConfig() {
+	if configFile == "" {
+		app.logWarning("SIGHUP received but the server is running without a config file")
+		return
+	}
+	app.logNotice("SIGHUP received, reloading the config file", "file", configFile)
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		app.logWarning("failed to reload the config file", "error", err)
+		return
+	}
+	entries, err := parseConfig(string(content) + configOverrides)
+	if err != nil {
+		app.logWarning("failed to reload the config file, nothing changed", "error", err)
+		return
+	}
+
+	// the parameters not in the file keep their value, ex: set by CONFIG SET
+	var params []*configParam
+	var values, previous []string
+	for _, entry := range entries {
+		param := entry.param
+		current := param.get()
+		// compared as the getter returns them, ex: maxmemory 10mb is 10485760
+		normalized, err := normalizeConfigValue(param, entry.value)
+		if err != nil {
+			app.logWarning("failed to reload the config file, nothing changed", "param", param.name, "error", err)
+			return
+		}
+		if normalized == current {
+			continue
+		}
+		if param.flags&CONFIG_IMMUTABLE != 0 {
+			app.logWarning("config reload: the parameter can't change without a restart, keeping its value", "param", param.name, "value", configLogValue(param, current))
+			continue
+		}
+		params = append(params, param)
+		values = append(values, normalized)
+		previous = append(previous, current)
+	}
+	if param, err := app.configSet(params, values); err != nil {
+		app.logWarning("failed to reload the config file, nothing changed", "param", param.name, "error", err)
+		return
+	}
+	for i, param := range params {
+		app.logNotice("config reload: parameter changed", "param", param.name, "from", configLogValue(param, previous[i]), "to", configLogValue(param, values[i]))
+	}
+
+	if aclFile != "" {
+		if err := app.aclLoadFromFile(nil); err != nil {
+			app.logWarning("config reload: failed to load the users", "error", err)
+		} else {
+			app.logNotice("config reload: users loaded", "file", aclFile)
+		}
+	}
+}
+
+// ROLE: the value of the parameter once set, as returned by its getter, the
+// parameter is not set
+func normalizeConfigValue(param *configParam, value string) (string, error) {
+	if param.normalize == nil {
+		return value, nil
+	}
+	return param.normalize(value)
+}
+
+// ROLE: the value of the parameter for the log, the secrets are redacted
+func configLogValue(param *configParam, value string) string {
+	if param.flags&CONFIG_SENSITIVE != 0 {
+		return REDACTED_ARGUMENT
+	}
+	return value
+}
+
+// ROLE: split a config line into arguments, "double quotes" support the
+// \n \r \t \\ \" and \xHH escapes, 'single quotes' only \'
+func splitConfigArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg strings.Builder
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, fmt.Errorf("Unbalanced quotes in configuration line")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble && c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+				value, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+				arg.WriteByte(byte(value))
+				i += 3
+			case inDouble && c == '\\' && i+1 < len(line):
+				i++
+				switch line[i] {
+				case 'n':
+					arg.WriteByte('\n')
+				case 'r':
+					arg.WriteByte('\r')
+				case 't':
+					arg.WriteByte('\t')
+				default:
+					arg.WriteByte(line[i])
+				}
+			case inDouble && c == '"', inSingle && c == '\'':
+				// the closing quote must end the argument
+				if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+					return nil, fmt.Errorf("Unbalanced quotes in configuration line")
+				}
+				done = true
+			case inSingle && c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+				i++
+				arg.WriteByte('\'')
+			case inDouble || inSingle:
+				arg.WriteByte(c)
+			case c == ' ' || c == '\t':
+				done = true
+			case c == '"' && arg.Len() == 0:
+				inDouble = true
+			case c == '\'' && arg.Len() == 0:
+				inSingle = true
+			default:
+				arg.WriteByte(c)
+			}
+			i++
+		}
+		args = append(args, arg.String())
+	}
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// ROLE: quote the value for the config file when it is empty or has special characters
+func configQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\\r\n") {
+		return value
+	}
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString("\\n")
+		case '\r':
+			quoted.WriteString("\\r")
+		case '\t':
+			quoted.WriteString("\\t")
+		default:
+			quoted.WriteByte(c)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// ROLE: the config file line of the parameter with its current value
+func configLine(param *configParam) string {
+	value := param.get()
+	if param.flags&CONFIG_MULTI_ARG != 0 && value != "" {
+		return param.name + " " + value
+	}
+	return param.name + " " + configQuote(value)
+}
+
+// ROLE: set the parameters, all or none of them
+// returns the parameter that failed with the error
+// caller must hold the serverMutex
+func (app *App) configSet(params []*configParam, values []string) (*configParam, error) {
+	seen := make(map[*configParam]bool)
+	for _, param := range params {
+		if param.flags&CONFIG_IMMUTABLE != 0 {
+			return param, fmt.Errorf("can't set immutable config")
+		}
+		if seen[param] {
+			return param, fmt.Errorf("duplicate parameter")
+		}
+		seen[param] = true
+	}
+
+	previous := make([]string, len(params))
+	for i, param := range params {
+		previous[i] = param.get()
+	}
+	rollback := func(count int) {
+		for i := 0; i < count; i++ {
+			params[i].set(app, previous[i])
+		}
+	}
+
+	for i, param := range params {
+		if err := param.set(app, values[i]); err != nil {
+			rollback(i)
+			return param, err
+		}
+	}
+
+	// each side effect runs once, ex: port and tls-port reopen the listeners once
+	applied := make(map[int]bool)
+	for _, param := range params {
+		if param.apply == CONFIG_APPLY_NONE || applied[param.apply] {
+			continue
+		}
+		applied[param.apply] = true
+		if err := app.configApply(param.apply); err != nil {
+			rollback(len(params))
+			for apply := range applied {
+				app.configApply(apply)
+			}
+			return param, err
+		}
+	}
+	return nil, nil
+}
+
+// ROLE: apply the side effect of a parameter change
+func (app *App) configApply(apply int) error {
+	switch apply {
+	case CONFIG_APPLY_LISTENERS:
+		app.closeListeners()
+		return app.openListeners()
+	case CONFIG_APPLY_DIR:
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	case CONFIG_APPLY_MAXMEMORY:
+		// a lower limit takes effect right away
+		app.performEvictions()
+	case CONFIG_APPLY_ACLLOG:
+		app.trimACLLog()
+	}
+	return nil
+}
+
+// ROLE: write the current configuration to the config file
+// the lines of the parameters are updated in place, the parameters not in the
+// file and not at their default are added at the end
+func (app *App) rewriteConfig() error {
+	if configFile == "" {
+		return fmt.Errorf("The server is running without a config file")
+	}
+	content, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	written := make(map[*configParam]bool)
+	// the signature of a previous rewrite stays, the new lines follow it
+	signed := false
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == CONFIG_REWRITE_SIGNATURE {
+			if !signed {
+				lines = append(lines, CONFIG_REWRITE_SIGNATURE)
+				signed = true
+			}
+			continue
+		}
+		args, err := splitConfigArgs(trimmed)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || err != nil || len(args) == 0 {
+			lines = append(lines, line)
+			continue
+		}
+		param := lookupConfig(args[0])
+		if param == nil {
+			lines = append(lines, line)
+			continue
+		}
+		// the other lines of a parameter are merged in the first one
+		if !written[param] {
+			lines = append(lines, configLine(param))
+			written[param] = true
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, param := range configTable {
+		if written[param] || param.get() == param.defaultValue {
+			continue
+		}
+		if !signed {
+			lines = append(lines, CONFIG_REWRITE_SIGNATURE)
+			signed = true
+		}
+		lines = append(lines, configLine(param))
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(configFile), "temp-config-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(configFile); err == nil {
+		os.Chmod(temp.Name(), info.Mode())
+	}
+	return os.Rename(temp.Name(), configFile)
+}
+
+// ROLE: handle CONFIG GET, SET, REWRITE, RESETSTAT and HELP
+func (app *App) executeCONFIG(client *Client, commands []string) []byte {
+	subcommand := strings.ToUpper(commands[1])
+	switch {
+	case subcommand == "GET" && len(commands) >= 3:
+		return app.configGET(client, commands[2:])
+	case subcommand == "SET" && len(commands) >= 4 && len(commands)%2 == 0:
+		return app.configSET(commands[2:])
+	case subcommand == "SET":
+		return []byte("-ERR wrong number of arguments for 'config|set' command\r\n")
+	case subcommand == "REWRITE" && len(commands) == 2:
+		if err := app.rewriteConfig(); err != nil {
+			app.logWarning("CONFIG REWRITE failed", "error", err)
+			return []byte(fmt.Sprintf("-ERR Rewriting config file: %s\r\n", err))
+		}
+		app.logNotice("CONFIG REWRITE executed with success.")
+		return []byte("+OK\r\n")
+	case subcommand == "RESETSTAT" && len(commands) == 2:
+		app.resetServerStats()
+		return []byte("+OK\r\n")
+	case subcommand == "HELP" && len(commands) == 2:
+		return []byte(app.createRESPArray([]string{
+			"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET <pattern> [<pattern> ...]",
+			"    Return parameters matching the glob-like <pattern> and their values.",
+			"SET <directive> <value> [<directive> <value> ...]",
+			"    Set the configuration <directive> to <value>.",
+			"RESETSTAT",
+			"    Reset statistics reported by the INFO command.",
+			"REWRITE",
+			"    Rewrite the configuration file.",
+			"HELP",
+			"    Print this help.",
+		}))
+	}
+	return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try CONFIG HELP.\r\n", commands[1]))
+}
+
+// ROLE: CONFIG GET pattern [pattern ...], the parameters matching one of the glob patterns
+func (app *App) configGET(client *Client, patterns []string) []byte {
+	var pairs [][]byte
+	seen := make(map[*configParam]bool)
+	for _, pattern := range patterns {
+		// an exact name may be an alias
+		if param := lookupConfig(pattern); param != nil && !strings.ContainsAny(pattern, "*?[") {
+			if !seen[param] {
+				seen[param] = true
+				pairs = append(pairs, app.createBulkStringResponse(strings.ToLower(pattern)), app.createBulkStringResponse(param.get()))
+			}
+			continue
+		}
+		for _, param := range configTable {
+			if seen[param] || !globMatch(pattern, param.name, true) {
+				continue
+			}
+			seen[param] = true
+			pairs = append(pairs, app.createBulkStringResponse(param.name), app.createBulkStringResponse(param.get()))
+		}
+	}
+	return app.createMapResponse(client, pairs)
+}
+
+// ROLE: CONFIG SET parameter value [parameter value ...]
+func (app *App) configSET(arguments []string) []byte {
+	var params []*configParam
+	var values []string
+	for i := 0; i < len(arguments); i += 2 {
+		param := lookupConfig(arguments[i])
+		if param == nil {
+			return []byte(fmt.Sprintf("-ERR Unknown option or number of arguments for CONFIG SET - '%s'\r\n", arguments[i]))
+		}
+		params = append(params, param)
+		values = append(values, arguments[i+1])
+	}
+	if param, err := app.configSet(params, values); err != nil {
+		return []byte(fmt.Sprintf("-ERR CONFIG SET failed (possibly related to argument '%s') - %s\r\n", param.name, err))
+	}
+	return []byte("+OK\r\n")
+}
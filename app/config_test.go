@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// parameter -> value
+		want map[string]string
+		// line of the error, 0 when valid
+		errorLine int
+	}{
+		{"comments and blank lines", "# comment\n\n  port 7000  \n", map[string]string{"port": "7000"}, 0},
+		{"last line wins", "port 7000\nport 7001", map[string]string{"port": "7001"}, 0},
+		{"alias and case", "SLAVEOF 127.0.0.1 6380\nMaxMemory 10mb", map[string]string{"replicaof": "127.0.0.1 6380", "maxmemory": "10mb"}, 0},
+		{"multi-line values add up", "save 3600 1\nsave 300 100", map[string]string{"save": "3600 1 300 100"}, 0},
+		{"empty value resets", "save 3600 1\nsave \"\"\nsave 60 1", map[string]string{"save": "60 1"}, 0},
+		{"quotes", "requirepass \"a b\\x41\\n\"\nmasterauth 'it\\'s'", map[string]string{"requirepass": "a bA\n", "masterauth": "it's"}, 0},
+		{"unknown directive", "port 7000\nnosuch 1", nil, 2},
+		{"missing value", "port", nil, 1},
+		{"too many values", "port 7000 7001", nil, 1},
+		{"unbalanced quotes", "requirepass \"secret", nil, 1},
+		{"text after a quote", "requirepass \"secret\"x", nil, 1},
+	}
+	for _, test := range tests {
+		entries, err := parseConfig(test.content)
+		if test.errorLine != 0 {
+			configErr, ok := err.(*configError)
+			if !ok || configErr.line != test.errorLine {
+				t.Fatalf("%s: error %v, want one at line %d", test.name, err, test.errorLine)
+			}
+			continue
+		}
+		if err != nil || len(entries) != len(test.want) {
+			t.Fatalf("%s: %v %v", test.name, entries, err)
+		}
+		for _, entry := range entries {
+			if want, ok := test.want[entry.param.name]; !ok || entry.value != want {
+				t.Fatalf("%s: %s is %q, want %q", test.name, entry.param.name, entry.value, want)
+			}
+		}
+	}
+}
+
+func TestLoadServerConfigOverrides(t *testing.T) {
+	server := startTestServer(t, "port 7000", "maxmemory 10mb", "save 60 1")
+	file := filepath.Join(server.dir, "redis.conf")
+	server.locked(func() {
+		err := server.app.loadServerConfig([]string{file, "--port=7001", "--maxmemory", "1mb", "--save=", "--requirepass", "with space", "--databases=8"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != 7001 || maxmemoryBytes != 1<<20 || len(saveParams) != 0 || requirepass != "with space" || databases != 8 {
+			t.Fatalf("port %d, maxmemory %d, save %v, requirepass %q, databases %d", port, maxmemoryBytes, saveParams, requirepass, databases)
+		}
+		if err := server.app.loadServerConfig([]string{file, "--port=x"}); err == nil {
+			t.Fatal("invalid override accepted")
+		}
+	})
+}
+
+func TestConfigSetRollback(t *testing.T) {
+	port := freePort(t)
+	server := startTestServer(t, "port "+port, "maxmemory 10mb", "bind 127.0.0.1")
+	if err := server.openListeners(t); err != nil {
+		t.Fatal(err)
+	}
+	client := server.connect(t)
+
+	// all or none of the parameters are set
+	expectReply(t, client.do("CONFIG", "SET", "maxmemory", "1mb", "timeout", "x"),
+		respError("ERR CONFIG SET failed (possibly related to argument 'timeout') - argument couldn't be parsed into an integer"))
+	expectReply(t, client.do("CONFIG", "GET", "maxmemory"), []any{"maxmemory", "10485760"})
+	expectReply(t, client.do("CONFIG", "SET", "timeout", "5", "timeout", "6"),
+		respError("ERR CONFIG SET failed (possibly related to argument 'timeout') - duplicate parameter"))
+	expectReply(t, client.do("CONFIG", "SET", "timeout", "5", "databases", "4"),
+		respError("ERR CONFIG SET failed (possibly related to argument 'databases') - can't set immutable config"))
+	expectReply(t, client.do("CONFIG", "GET", "timeout"), []any{"timeout", "0"})
+
+	// a failed side effect restores the values and the previous listeners
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyPort := strings.TrimPrefix(busy.Addr().String(), "127.0.0.1:")
+	if _, ok := client.do("CONFIG", "SET", "maxmemory", "1mb", "port", busyPort).(respError); !ok {
+		t.Fatal("CONFIG SET of a port in use")
+	}
+	expectReply(t, client.do("CONFIG", "GET", "maxmemory"), []any{"maxmemory", "10485760"})
+	expectReply(t, client.do("CONFIG", "GET", "port"), []any{"port", port})
+	again := &testServer{addr: "127.0.0.1:" + port}
+	expectReply(t, again.connect(t).do("PING"), "PONG")
+}
+
+func TestConfigRewrite(t *testing.T) {
+	server := startTestServer(t, "# a comment", "timeout 5", "save 3600 1", "save 300 100", "maxmemory-samples 5")
+	client := server.connect(t)
+	client.do("CONFIG", "SET", "timeout", "10", "save", "60 1", "maxmemory-policy", "allkeys-lru", "masterauth", "a \"b\"")
+	expectReply(t, client.do("CONFIG", "REWRITE"), "OK")
+
+	file := filepath.Join(server.dir, "redis.conf")
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// updated in place, the lines of a parameter merged in the first one,
+	// the parameters not at their default appended
+	want := strings.Join([]string{
+		"dir " + server.dir,
+		"logfile " + filepath.Join(server.dir, "redis.log"),
+		"save 60 1",
+		"# a comment",
+		"timeout 10",
+		"maxmemory-samples 5",
+		CONFIG_REWRITE_SIGNATURE,
+		`masterauth "a \"b\""`,
+		"maxmemory-policy allkeys-lru",
+	}, "\n") + "\n"
+	if string(content) != want {
+		t.Fatalf("rewritten config:\n%s\nwant:\n%s", content, want)
+	}
+
+	// loading the file gives the same configuration
+	server.locked(func() {
+		if err := server.app.loadServerConfig([]string{file}); err != nil {
+			t.Fatal(err)
+		}
+		if clientTimeout != 10 || saveParamsString(saveParams) != "60 1" || maxmemoryPolicy != "allkeys-lru" || masterauth != `a "b"` {
+			t.Fatalf("timeout %d, save %s, maxmemory-policy %s, masterauth %s", clientTimeout, saveParamsString(saveParams), maxmemoryPolicy, masterauth)
+		}
+	})
+	// a second rewrite changes nothing
+	client.do("CONFIG", "REWRITE")
+	if again, _ := os.ReadFile(file); string(again) != want {
+		t.Fatalf("second rewrite:\n%s", again)
+	}
+}
+
+// ROLE: replace the config file of the test server and reload it like on SIGHUP
+// returns the lines logged by the reload
+func (server *testServer) reloadConfig(t *testing.T, lines ...string) string {
+	t.Helper()
+	logFile := filepath.Join(server.dir, "redis.log")
+	before, _ := os.ReadFile(logFile)
+	content := append([]string{"dir " + server.dir, "logfile " + logFile, "save \"\""}, lines...)
+	if err := os.WriteFile(filepath.Join(server.dir, "redis.conf"), []byte(strings.Join(content, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server.locked(server.app.reloadConfig)
+	after, _ := os.ReadFile(logFile)
+	return string(after[len(before):])
+}
+
+func TestReloadConfig(t *testing.T) {
+	server := startTestServer(t, "maxmemory 10mb", "maxmemory-policy allkeys-lru", "databases 4", "timeout 5",
+		"client-output-buffer-limit pubsub 1mb 512kb 10", "notify-keyspace-events KEA")
+	client := server.connect(t)
+
+	// the same values written differently change nothing
+	logged := server.reloadConfig(t, "maxmemory 10485760", "MAXMEMORY-POLICY ALLKEYS-LRU", "databases 4", "timeout 5",
+		"client-output-buffer-limit pubsub 1048576 524288 10", "notify-keyspace-events AKE")
+	if strings.Contains(logged, "parameter changed") || strings.Contains(logged, "without a restart") {
+		t.Fatalf("reload of the same values:\n%s", logged)
+	}
+
+	// the parameters removed from the file keep their value, the immutable ones stay
+	client.do("CONFIG", "SET", "maxmemory-samples", "7")
+	logged = server.reloadConfig(t, "maxmemory 20mb", "databases 8")
+	for _, want := range []string{"param=maxmemory from=10485760 to=20971520", "param=databases value=4"} {
+		if !strings.Contains(logged, want) {
+			t.Fatalf("reload log without %q:\n%s", want, logged)
+		}
+	}
+	if strings.Count(logged, "parameter changed") != 1 {
+		t.Fatalf("reload changed more than maxmemory:\n%s", logged)
+	}
+	for name, value := range map[string]string{"maxmemory": "20971520", "databases": "4", "timeout": "5", "maxmemory-samples": "7",
+		"maxmemory-policy": "allkeys-lru", "notify-keyspace-events": "AKE",
+		"client-output-buffer-limit": "normal 0 0 0 slave 268435456 67108864 60 pubsub 1048576 524288 10"} {
+		expectReply(t, client.do("CONFIG", "GET", name), []any{name, value})
+	}
+
+	// an invalid value changes nothing
+	logged = server.reloadConfig(t, "maxmemory 20mb", "timeout 7", "maxmemory-policy nosuch")
+	if !strings.Contains(logged, "nothing changed") {
+		t.Fatalf("reload of an invalid file:\n%s", logged)
+	}
+	expectReply(t, client.do("CONFIG", "GET", "timeout"), []any{"timeout", "5"})
+}
+
+func TestReloadConfigKeepsTheRuntimeState(t *testing.T) {
+	server := startTestServer(t, "requirepass secret", "maxmemory-policy allkeys-lru")
+	client := server.connect(t)
+	client.do("AUTH", "secret")
+	expectReply(t, client.do("ACL", "SETUSER", "default", ">other"), "OK")
+	server.locked(func() { evictionPool = []evictionPoolEntry{{key: "a"}} })
+
+	// unchanged, requirepass and maxmemory-policy are not set again
+	server.reloadConfig(t, "requirepass secret", "maxmemory-policy ALLKEYS-LRU")
+	expectReply(t, server.connect(t).do("AUTH", "other"), "OK")
+	expectReply(t, server.connect(t).do("AUTH", "secret"), "OK")
+	server.locked(func() {
+		if len(evictionPool) != 1 {
+			t.Fatalf("eviction pool cleared by the reload: %v", evictionPool)
+		}
+	})
+}
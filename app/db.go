@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+INFO: Logical databases
+The server has `databases` numbered databases, each client works on its
+selected database (SELECT), 0 by default.
+*/
+
+// ROLE: create the databases, called once at startup
+func (app *App) initDatabases(count int) {
+	dbs = make([]*Database, count)
+	for i := range dbs {
+		dbs[i] = &Database{
+			id:          i,
+			dict:        make(map[string]*Value),
+			expires:     make(map[string]bool),
+			watchedKeys: make(map[string]map[*Client]bool),
+		}
+	}
+}
+
+// ROLE: parse and validate a database index argument
+func (app *App) parseDatabaseIndex(arg string) (int, []byte) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return -1, []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	if index < 0 || index >= len(dbs) {
+		return -1, []byte("-ERR DB index is out of range\r\n")
+	}
+	return index, nil
+}
+
+// ROLE: handle SELECT index
+func (app *App) executeSELECT(client *Client, commands []string) []byte {
+	index, errResponse := app.parseDatabaseIndex(commands[1])
+	if errResponse != nil {
+		return errResponse
+	}
+	client.db = dbs[index]
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle MOVE key db, move the key to another database
+// replies 1 if moved, 0 if the key is missing or already exists in the target
+func (app *App) executeMOVE(client *Client, commands []string) []byte {
+	index, errResponse := app.parseDatabaseIndex(commands[2])
+	if errResponse != nil {
+		return errResponse
+	}
+	source, target := client.db, dbs[index]
+	if source == target {
+		return []byte("-ERR source and destination objects are the same\r\n")
+	}
+	key := commands[1]
+	app.expireIfNeeded(source, key)
+	value, ok := source.dict[key]
+	if !ok {
+		return app.createIntegerResponse(0)
+	}
+	app.expireIfNeeded(target, key)
+	if _, exists := target.dict[key]; exists {
+		return app.createIntegerResponse(0)
+	}
+	app.deleteKey(source, key)
+	app.notifyKeyspaceEvent(NOTIFY_GENERIC, "move_from", key, source.id)
+	// a copy, the value may still be written by a background save of the source
+	copied := *value
+	app.setKey(target, key, &copied, NOTIFY_GENERIC, "move_to")
+	return app.createIntegerResponse(1)
+}
+
+// ROLE: handle SWAPDB index1 index2
+// clients connected to a database see the data of the other one right away
+func (app *App) executeSWAPDB(client *Client, commands []string) []byte {
+	first, err := strconv.Atoi(commands[1])
+	if err != nil || first < 0 || first >= len(dbs) {
+		return []byte("-ERR invalid first DB index\r\n")
+	}
+	second, err := strconv.Atoi(commands[2])
+	if err != nil || second < 0 || second >= len(dbs) {
+		return []byte("-ERR invalid second DB index\r\n")
+	}
+	if first == second {
+		return []byte("+OK\r\n")
+	}
+	dbs[first].dict, dbs[second].dict = dbs[second].dict, dbs[first].dict
+	dbs[first].expires, dbs[second].expires = dbs[second].expires, dbs[first].expires
+	dbs[first].memory, dbs[second].memory = dbs[second].memory, dbs[first].memory
+	dbs[first].avgTTL, dbs[second].avgTTL = dbs[second].avgTTL, dbs[first].avgTTL
+	// a background save follows the dict it is writing
+	dbs[first].snapshot, dbs[second].snapshot = dbs[second].snapshot, dbs[first].snapshot
+	dirty++
+	// the watched keys now point to the data of the other database
+	app.touchAllWatchedKeysInDb(dbs[first], dbs[second])
+	app.touchAllWatchedKeysInDb(dbs[second], dbs[first])
+	return []byte("+OK\r\n")
+}
+
+// ROLE: parse the optional ASYNC|SYNC argument of FLUSHDB and FLUSHALL
+func (app *App) parseFlushFlags(commands []string) (bool, []byte) {
+	if len(commands) == 1 {
+		return false, nil
+	}
+	if len(commands) == 2 && strings.EqualFold(commands[1], "ASYNC") {
+		return true, nil
+	}
+	if len(commands) == 2 && strings.EqualFold(commands[1], "SYNC") {
+		return false, nil
+	}
+	return false, []byte("-ERR syntax error\r\n")
+}
+
+// ROLE: handle FLUSHDB [ASYNC|SYNC]
+func (app *App) executeFLUSHDB(client *Client, commands []string) []byte {
+	async, errResponse := app.parseFlushFlags(commands)
+	if errResponse != nil {
+		return errResponse
+	}
+	app.emptyDatabase(client.db, async)
+	app.trackingInvalidateKeysOnFlush()
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle FLUSHALL [ASYNC|SYNC]
+func (app *App) executeFLUSHALL(client *Client, commands []string) []byte {
+	async, errResponse := app.parseFlushFlags(commands)
+	if errResponse != nil {
+		return errResponse
+	}
+	for _, db := range dbs {
+		app.emptyDatabase(db, async)
+	}
+	app.trackingInvalidateKeysOnFlush()
+	return []byte("+OK\r\n")
+}
+
+// ROLE: remove all the keys of the database
+// the dict is swapped for an empty one, with ASYNC the old entries are
+// left to the garbage collector, with SYNC they are released right away
+// unless a background save is still writing them
+func (app *App) emptyDatabase(db *Database, async bool) {
+	app.touchAllWatchedKeysInDb(db, nil)
+	old := db.dict
+	if db.snapshot != nil {
+		// the old dict doesn't change anymore, the save writes it as it is
+		db.snapshot = nil
+		async = true
+	}
+	dirty += int64(len(old))
+	db.dict = make(map[string]*Value)
+	db.expires = make(map[string]bool)
+	db.memory = 0
+	db.avgTTL = 0
+	if !async {
+		clear(old)
+	}
+}
+
+// ROLE: handle DBSIZE
+func (app *App) executeDBSIZE(client *Client, commands []string) []byte {
+	return app.createIntegerResponse(len(client.db.dict))
+}
+
+// ROLE: handle RANDOMKEY, expired keys found on the way are deleted
+func (app *App) executeRANDOMKEY(client *Client, commands []string) []byte {
+	for key := range client.db.dict {
+		if app.expireIfNeeded(client.db, key) {
+			continue
+		}
+		return app.createBulkStringResponse(key)
+	}
+	return app.createNullResponse(client)
+}
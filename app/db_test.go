@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDatabaseCommands(t *testing.T) {
+	server := startTestServer(t, "databases 4")
+	client := server.connect(t)
+
+	client.do("SET", "key", "zero")
+	expectReply(t, client.do("SELECT", "4"), respError("ERR DB index is out of range"))
+	expectReply(t, client.do("MOVE", "key", "1"), 1)
+	expectReply(t, client.do("MOVE", "key", "1"), 0)
+	expectReply(t, client.do("DBSIZE"), 0)
+	client.do("SELECT", "1")
+	expectReply(t, client.do("GET", "key"), "zero")
+
+	// MOVE doesn't overwrite the key of the target
+	client.do("SELECT", "0")
+	client.do("SET", "key", "new")
+	expectReply(t, client.do("MOVE", "key", "1"), 0)
+	expectReply(t, client.do("MOVE", "key", "0"), respError("ERR source and destination objects are the same"))
+
+	// the clients of a database see the data of the other one after SWAPDB
+	other := server.connect(t)
+	other.do("SELECT", "1")
+	expectReply(t, client.do("SWAPDB", "0", "1"), "OK")
+	expectReply(t, client.do("GET", "key"), "zero")
+	expectReply(t, other.do("GET", "key"), "new")
+	expectReply(t, client.do("SWAPDB", "0", "9"), respError("ERR invalid second DB index"))
+
+	expectReply(t, other.do("FLUSHDB"), "OK")
+	expectReply(t, other.do("DBSIZE"), 0)
+	expectReply(t, client.do("DBSIZE"), 1)
+	client.do("SELECT", "2")
+	client.do("SET", "key", "two")
+	expectReply(t, client.do("FLUSHALL", "ASYNC"), "OK")
+	for _, db := range []string{"0", "1", "2"} {
+		client.do("SELECT", db)
+		expectReply(t, client.do("DBSIZE"), 0)
+	}
+}
+
+// the writes of a master session, the replica must end up with the same databases
+var replicationSessions = []struct {
+	name     string
+	commands [][]string
+	stream   [][]string
+	// db -> key -> value on the replica
+	want map[int]map[string]string
+}{
+	{
+		name: "swapdb",
+		commands: [][]string{
+			{"SET", "a", "0"},
+			{"SELECT", "1"},
+			{"SET", "b", "1"},
+			{"SWAPDB", "0", "1"},
+			{"SET", "c", "1"},
+			{"SELECT", "0"},
+			{"SET", "d", "0"},
+		},
+		stream: [][]string{
+			{"SELECT", "0"},
+			{"SET", "a", "0"},
+			{"SELECT", "1"},
+			{"SET", "b", "1"},
+			{"SWAPDB", "0", "1"},
+			{"SET", "c", "1"},
+			{"SELECT", "0"},
+			{"SET", "d", "0"},
+		},
+		want: map[int]map[string]string{0: {"b": "1", "d": "0"}, 1: {"a": "0", "c": "1"}},
+	},
+	{
+		name: "multi spanning databases",
+		commands: [][]string{
+			{"MULTI"},
+			{"SET", "a", "0"},
+			{"SELECT", "1"},
+			{"SET", "b", "1"},
+			{"MOVE", "b", "2"},
+			{"EXEC"},
+			// the client stays in the database selected inside the transaction
+			{"SET", "c", "1"},
+		},
+		stream: [][]string{
+			{"SELECT", "0"},
+			{"MULTI"},
+			{"SET", "a", "0"},
+			{"SELECT", "1"},
+			{"SET", "b", "1"},
+			{"MOVE", "b", "2"},
+			{"EXEC"},
+			{"SET", "c", "1"},
+		},
+		want: map[int]map[string]string{0: {"a": "0"}, 1: {"c": "1"}, 2: {"b": "1"}},
+	},
+}
+
+func TestReplicationStreamSelectsDatabase(t *testing.T) {
+	for _, session := range replicationSessions {
+		t.Run(session.name, func(t *testing.T) {
+			master := startTestServer(t)
+			replica := master.connectReplica(t)
+			client := master.connect(t)
+			for _, command := range session.commands {
+				client.do(command...)
+			}
+			replica.expectCommands(session.stream...)
+			replica.expectNoReply()
+			master.stop()
+
+			// the stream applied by a replica from its master link
+			server := startTestServer(t)
+			link := server.connectMaster(t)
+			for _, command := range session.stream {
+				link.send(command...)
+			}
+			link.send("ECHO", "done")
+			waitFor(t, func() bool { return server.clientOf(link).lastCommand == "echo" })
+			server.locked(func() {
+				for _, db := range dbs {
+					if len(db.dict) != len(session.want[db.id]) {
+						t.Fatalf("db %d has %d keys, want %d", db.id, len(db.dict), len(session.want[db.id]))
+					}
+					for key, want := range session.want[db.id] {
+						if value, ok := db.dict[key]; !ok || value.value != want {
+							t.Fatalf("db %d key %s: got %v, want %s", db.id, key, value, want)
+						}
+					}
+				}
+			})
+			// our master gets no replies
+			link.expectNoReply()
+		})
+	}
+}
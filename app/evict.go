@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+INFO: maxmemory and eviction
+When the estimated memory used by the keys is over maxmemory, keys are evicted
+before running the next command, as per maxmemory-policy. The estimate is
+used_memory_keys in INFO memory, not used_memory, the heap of the process.
+LRU, LFU and TTL policies are approximated like Redis: maxmemory-samples keys
+are sampled from every database into the eviction pool, and the best
+candidate of the pool is evicted.
+*/
+
+const (
+	MAXMEMORY_NO_EVICTION     = "noeviction"
+	MAXMEMORY_ALLKEYS_LRU     = "allkeys-lru"
+	MAXMEMORY_ALLKEYS_LFU     = "allkeys-lfu"
+	MAXMEMORY_ALLKEYS_RANDOM  = "allkeys-random"
+	MAXMEMORY_VOLATILE_LRU    = "volatile-lru"
+	MAXMEMORY_VOLATILE_LFU    = "volatile-lfu"
+	MAXMEMORY_VOLATILE_RANDOM = "volatile-random"
+	MAXMEMORY_VOLATILE_TTL    = "volatile-ttl"
+
+	EVICTION_POOL_SIZE = 16
+
+	// LFU counter of a new key, so it is not evicted right away
+	LFU_INIT_VAL = 5
+)
+
+var maxmemoryPolicies = []string{
+	MAXMEMORY_NO_EVICTION,
+	MAXMEMORY_ALLKEYS_LRU,
+	MAXMEMORY_ALLKEYS_LFU,
+	MAXMEMORY_ALLKEYS_RANDOM,
+	MAXMEMORY_VOLATILE_LRU,
+	MAXMEMORY_VOLATILE_LFU,
+	MAXMEMORY_VOLATILE_RANDOM,
+	MAXMEMORY_VOLATILE_TTL,
+}
+
+var (
+	// parsed maxmemory settings
+	maxmemoryBytes   int64
+	maxmemoryPolicy  = MAXMEMORY_NO_EVICTION
+	maxmemorySamples = 5
+	lfuLogFactor     = 10
+	lfuDecayTime     = 1
+
+	// eviction candidates sorted by idle score, the best one is the last
+	evictionPool []evictionPoolEntry
+	// next database for the random policies
+	nextRandomDb = 0
+)
+
+// for a key in the eviction pool
+type evictionPoolEntry struct {
+	idle uint64
+	key  string
+	db   *Database
+}
+
+// ROLE: parse a memory amount, ex: 100, 1kb, 5mb, 2gb (k, m, g are powers of 1000)
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(value))
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			multiplier = unit.multiplier
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			break
+		}
+	}
+	number, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return number * multiplier, nil
+}
+
+// ROLE: validate the maxmemory-policy name
+func parseMaxmemoryPolicy(value string) (string, error) {
+	for _, policy := range maxmemoryPolicies {
+		if strings.EqualFold(policy, value) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(maxmemoryPolicies, ", "))
+}
+
+// ROLE: estimated memory used by the dataset
+func (app *App) usedMemory() int64 {
+	var used int64
+	for _, db := range dbs {
+		used += db.memory
+	}
+	return used
+}
+
+// ROLE: minutes clock used by LFU, it wraps every ~45 days
+func lfuTimeInMinutes() uint16 {
+	return uint16(time.Now().Unix() / 60)
+}
+
+// ROLE: access info of a new value
+func (app *App) initAccessInfo(value *Value) {
+	value.lru = time.Now().UnixMilli()
+	value.lfuCounter = LFU_INIT_VAL
+	value.lfuDecrTime = lfuTimeInMinutes()
+}
+
+// ROLE: update the access info on every read
+func (app *App) updateAccessInfo(value *Value) {
+	value.lru = time.Now().UnixMilli()
+	value.lfuCounter = app.lfuLogIncr(app.lfuDecrAndReturn(value))
+	value.lfuDecrTime = lfuTimeInMinutes()
+}
+
+// ROLE: increment the LFU counter, the higher the counter the less likely
+func (app *App) lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return counter
+	}
+	baseval := float64(counter) - LFU_INIT_VAL
+	if baseval < 0 {
+		baseval = 0
+	}
+	probability := 1.0 / (baseval*float64(lfuLogFactor) + 1)
+	if rand.Float64() < probability {
+		counter++
+	}
+	return counter
+}
+
+// ROLE: decrement the LFU counter by the lfu-decay-time periods since the last decrement
+func (app *App) lfuDecrAndReturn(value *Value) uint8 {
+	if lfuDecayTime == 0 {
+		return value.lfuCounter
+	}
+	// the minutes clock wraps around
+	elapsed := lfuTimeInMinutes() - value.lfuDecrTime
+	periods := int(elapsed) / lfuDecayTime
+	if periods >= int(value.lfuCounter) {
+		return 0
+	}
+	return value.lfuCounter - uint8(periods)
+}
+
+// ROLE: score of the key for the current policy, the higher the better to evict
+func (app *App) evictionScore(value *Value) uint64 {
+	switch maxmemoryPolicy {
+	case MAXMEMORY_ALLKEYS_LFU, MAXMEMORY_VOLATILE_LFU:
+		return uint64(255 - app.lfuDecrAndReturn(value))
+	case MAXMEMORY_VOLATILE_TTL:
+		// the sooner the key expires the better
+		return math.MaxUint64 - uint64(value.expiration.UnixMilli())
+	default:
+		return uint64(app.evictionIdleMilliseconds(value))
+	}
+}
+
+// ROLE: milliseconds since the last access of the value
+func (app *App) evictionIdleMilliseconds(value *Value) int64 {
+	idle := time.Now().UnixMilli() - value.lru
+	if idle < 0 {
+		idle = 0
+	}
+	return idle
+}
+
+// ROLE: sample keys of the database and add the good candidates to the pool
+func (app *App) evictionPoolPopulate(db *Database, volatile bool) {
+	sampled := 0
+	add := func(key string) bool {
+		value := db.dict[key]
+		app.evictionPoolInsert(evictionPoolEntry{
+			idle: app.evictionScore(value),
+			key:  key,
+			db:   db,
+		})
+		sampled++
+		return sampled < maxmemorySamples
+	}
+	// map iteration order is random, this is our sampling
+	if volatile {
+		for key := range db.expires {
+			if !add(key) {
+				return
+			}
+		}
+		return
+	}
+	for key := range db.dict {
+		if !add(key) {
+			return
+		}
+	}
+}
+
+// ROLE: insert the entry in the pool, sorted by ascending idle score
+// when the pool is full the worst candidate makes room, or the entry is dropped
+func (app *App) evictionPoolInsert(entry evictionPoolEntry) {
+	for _, existing := range evictionPool {
+		if existing.db == entry.db && existing.key == entry.key {
+			return
+		}
+	}
+	i := sort.Search(len(evictionPool), func(i int) bool {
+		return evictionPool[i].idle >= entry.idle
+	})
+	if len(evictionPool) >= EVICTION_POOL_SIZE {
+		if i == 0 {
+			return
+		}
+		evictionPool = evictionPool[1:]
+		i--
+	}
+	evictionPool = append(evictionPool, evictionPoolEntry{})
+	copy(evictionPool[i+1:], evictionPool[i:])
+	evictionPool[i] = entry
+}
+
+// ROLE: choose the key to evict as per maxmemory-policy
+// returns nil when there is nothing to evict
+func (app *App) selectEvictionKey() (*Database, string) {
+	volatile := strings.HasPrefix(maxmemoryPolicy, "volatile-")
+
+	if maxmemoryPolicy == MAXMEMORY_ALLKEYS_RANDOM || maxmemoryPolicy == MAXMEMORY_VOLATILE_RANDOM {
+		for i := 0; i < len(dbs); i++ {
+			db := dbs[nextRandomDb]
+			nextRandomDb = (nextRandomDb + 1) % len(dbs)
+			if volatile {
+				for key := range db.expires {
+					return db, key
+				}
+				continue
+			}
+			for key := range db.dict {
+				return db, key
+			}
+		}
+		return nil, ""
+	}
+
+	for {
+		for _, db := range dbs {
+			app.evictionPoolPopulate(db, volatile)
+		}
+		if len(evictionPool) == 0 {
+			return nil, ""
+		}
+		// best candidate first, it may be gone since it was sampled
+		for i := len(evictionPool) - 1; i >= 0; i-- {
+			entry := evictionPool[i]
+			evictionPool = evictionPool[:i]
+			if _, ok := entry.db.dict[entry.key]; ok {
+				if !volatile || entry.db.expires[entry.key] {
+					return entry.db, entry.key
+				}
+			}
+		}
+	}
+}
+
+// ROLE: evict keys until the used memory is under maxmemory
+// returns false if it is still over maxmemory (noeviction or no candidates)
+// the evicted keys are sent to the replicas as DELs, a replica doesn't evict,
+// it deletes what its master evicted
+func (app *App) performEvictions() bool {
+	// no writes while the clients are paused
+	if maxmemoryBytes == 0 || role == SLAVE || app.clientsArePaused() {
+		return true
+	}
+	start := latencyStartMonitor()
+	evicted := 0
+	defer func() {
+		if evicted > 0 {
+			app.latencyAddSampleIfNeeded("eviction-cycle", latencyEndMonitor(start))
+		}
+	}()
+	for app.usedMemory() > maxmemoryBytes {
+		if maxmemoryPolicy == MAXMEMORY_NO_EVICTION {
+			return false
+		}
+		db, key := app.selectEvictionKey()
+		if db == nil {
+			return false
+		}
+		app.dbDelete(db, key)
+		app.propagateDeletion(db, key)
+		app.signalModifiedKey(db, key)
+		app.notifyKeyspaceEvent(NOTIFY_EVICTED, "evicted", key, db.id)
+		stats.evictedKeys++
+		evicted++
+	}
+	return true
+}
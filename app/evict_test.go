@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// ROLE: lower maxmemory so exactly one more key than the limit allows is stored
+func setMaxmemoryForOneEviction(server *testServer, client *testClient) {
+	var used int64
+	server.locked(func() { used = server.app.usedMemory() })
+	client.do("CONFIG", "SET", "maxmemory", strconv.FormatInt(used-1, 10))
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	tests := []struct {
+		policy  string
+		evicted string
+	}{
+		// a is read after the others were written
+		{MAXMEMORY_ALLKEYS_LRU, "b"},
+		// c expires first
+		{MAXMEMORY_VOLATILE_TTL, "c"},
+		// only c and d have a TTL, c is the least recently used of them
+		{MAXMEMORY_VOLATILE_LRU, "c"},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			server := startTestServer(t, "maxmemory-policy "+test.policy, "maxmemory-samples 64")
+			client := server.connect(t)
+			client.do("SET", "a", "value")
+			time.Sleep(2 * time.Millisecond)
+			client.do("SET", "b", "value")
+			time.Sleep(2 * time.Millisecond)
+			client.do("SET", "c", "value", "PX", "100000")
+			time.Sleep(2 * time.Millisecond)
+			client.do("SET", "d", "value", "PX", "200000")
+			time.Sleep(2 * time.Millisecond)
+			client.do("GET", "a")
+
+			setMaxmemoryForOneEviction(server, client)
+			expectReply(t, client.do("DBSIZE"), 3)
+			expectReply(t, client.do("GET", test.evicted), nil)
+			server.locked(func() {
+				if stats.evictedKeys != 1 {
+					t.Fatalf("evicted_keys %d", stats.evictedKeys)
+				}
+			})
+		})
+	}
+}
+
+func TestEvictionRefusesWrites(t *testing.T) {
+	server := startTestServer(t, "maxmemory-policy noeviction")
+	client := server.connect(t)
+	client.do("SET", "a", "value")
+	setMaxmemoryForOneEviction(server, client)
+
+	oom := respError("OOM command not allowed when used memory > 'maxmemory'.")
+	expectReply(t, client.do("SET", "b", "value"), oom)
+	expectReply(t, client.do("GET", "a"), "value")
+
+	// volatile policies only evict the keys with a TTL
+	client.do("CONFIG", "SET", "maxmemory-policy", "volatile-lru")
+	expectReply(t, client.do("SET", "b", "value"), oom)
+	expectReply(t, client.do("DEL", "a"), 1)
+	expectReply(t, client.do("SET", "b", "value"), "OK")
+}
+
+func TestEvictionPropagatesDel(t *testing.T) {
+	server := startTestServer(t, "maxmemory-policy allkeys-random")
+	replica := server.connectReplica(t)
+	client := server.connect(t)
+	client.do("SELECT", "3")
+	client.do("SET", "key", "value")
+	replica.expectCommands([]string{"SELECT", "3"}, []string{"SET", "key", "value"})
+
+	client.do("CONFIG", "SET", "maxmemory", "1")
+	replica.expectCommands([]string{"DEL", "key"})
+
+	// evicted before a write inside EXEC, sent before the write
+	client.do("CONFIG", "SET", "maxmemory", "0")
+	client.do("SET", "key", "value")
+	replica.expectCommands([]string{"SET", "key", "value"})
+	// over maxmemory without the eviction of CONFIG SET
+	server.locked(func() { maxmemoryBytes = 1 })
+	client.do("MULTI")
+	client.do("SET", "other", "value")
+	client.do("EXEC")
+	replica.expectCommands([]string{"DEL", "key"}, []string{"MULTI"}, []string{"SET", "other", "value"}, []string{"EXEC"})
+}
+
+func TestReplicaDoesNotEvict(t *testing.T) {
+	server := startTestServer(t, "maxmemory-policy allkeys-random")
+	link := server.connectMaster(t)
+	client := server.connect(t)
+	client.do("CONFIG", "SET", "maxmemory", "1")
+
+	// the writes of the master are applied even over maxmemory
+	link.send("SET", "a", "value")
+	link.send("SET", "b", "value")
+	waitFor(t, func() bool { return len(dbs[0].dict) == 2 })
+	expectReply(t, client.do("GET", "a"), "value")
+	expectReply(t, client.do("DBSIZE"), 2)
+	link.send("DEL", "a")
+	waitFor(t, func() bool { return len(dbs[0].dict) == 1 })
+	expectReply(t, client.do("GET", "b"), "value")
+	server.locked(func() {
+		if stats.evictedKeys != 0 {
+			t.Fatalf("the replica evicted %d keys", stats.evictedKeys)
+		}
+	})
+}
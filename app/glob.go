@@ -0,0 +1,109 @@
+package main
+
+/*
+INFO: Glob-style pattern matching, same rules as Redis stringmatchlen
+*       matches any sequence of characters
+?       matches a single character
+[abc]   matches one of the characters, [^a] negates, [a-z] is a range
+\x      escapes the special character x
+*/
+
+// ROLE: match the string against the glob pattern
+func globMatch(pattern, str string, nocase bool) bool {
+	p, s := 0, 0
+	for p < len(pattern) && s < len(str) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for ; s < len(str); s++ {
+				if globMatch(pattern[p+1:], str[s:], nocase) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			s++
+		case '[':
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for {
+				if p >= len(pattern) {
+					// unterminated class, treat the end of pattern as ']'
+					p--
+					break
+				}
+				if pattern[p] == '\\' && len(pattern)-p >= 2 {
+					p++
+					if pattern[p] == str[s] {
+						match = true
+					}
+				} else if pattern[p] == ']' {
+					break
+				} else if len(pattern)-p >= 3 && pattern[p+1] == '-' {
+					start, end, c := pattern[p], pattern[p+2], str[s]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = lowerByte(start), lowerByte(end), lowerByte(c)
+					}
+					p += 2
+					if c >= start && c <= end {
+						match = true
+					}
+				} else if equalByte(pattern[p], str[s], nocase) {
+					match = true
+				}
+				p++
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s++
+		case '\\':
+			if len(pattern)-p >= 2 {
+				p++
+			}
+			fallthrough
+		default:
+			if !equalByte(pattern[p], str[s], nocase) {
+				return false
+			}
+			s++
+		}
+		p++
+		if s == len(str) {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			break
+		}
+	}
+	return p == len(pattern) && s == len(str)
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return lowerByte(a) == lowerByte(b)
+	}
+	return a == b
+}
+
+func lowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
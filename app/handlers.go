@@ -1,60 +1,91 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
+	"errors"
 	"io"
 	"net"
+	"time"
 )
 
 // ROLE: handle the connection
 // Workflow: Read input -> RESP Parser -> Execute -> Write Output
 func (app *App) handleConnection(connection net.Conn) {
-	defer connection.Close()
-	for {
-		// 1. Read the input from the connection
-		inputdata, err := app.readInput(connection)
-		if err != nil {
-			if err == io.EOF {
-				app.errorLogger.Println("client closed the connection", err)
-			} else {
-				app.errorLogger.Println("failed to read input from client", err)
-			}
+	tlsConnection, isTLS := connection.(*tls.Conn)
+	if isTLS {
+		if err := app.tlsHandshake(tlsConnection); err != nil {
+			app.logVerbose("TLS handshake failed", "client", connection.RemoteAddr().String(), "error", err)
+			connection.Close()
 			return
 		}
-		app.infoLogger.Println("recieved input from client", string(inputdata))
+	}
 
+	serverMutex.Lock()
+	// accepted right before the listeners were closed
+	if shutdownFinished {
+		serverMutex.Unlock()
+		connection.Close()
+		return
+	}
+	if len(clients) >= maxclients {
+		stats.rejectedConnections++
+		serverMutex.Unlock()
+		connection.Write([]byte("-ERR max number of clients reached\r\n"))
+		connection.Close()
+		return
+	}
+	if app.protectedModeRefuses(connection) {
+		serverMutex.Unlock()
+		connection.Write([]byte(PROTECTED_MODE_ERROR))
+		connection.Close()
+		return
+	}
+	app.setTCPKeepAlive(connection)
+	client := app.newClient(connection)
+	stats.totalConnectionsReceived++
+	if isTLS {
+		app.tlsAuthenticateClient(client, tlsConnection)
+	}
+	serverMutex.Unlock()
+	defer func() {
+		serverMutex.Lock()
+		app.freeClient(client)
+		serverMutex.Unlock()
+	}()
+	reader := bufio.NewReader(countingReader{connection})
+	for {
+		// 1. Read the input from the connection and
 		// 2. Parse the input using our own Redis RESP parser
-		commands, err := app.RESP(inputdata)
+		commands, err := app.RESP(reader)
 		if err != nil {
-			app.errorLogger.Println("failed to parse data using RESP", err)
+			// closed by the client, or by CLIENT KILL and the shutdown
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
+				app.logVerbose("Client closed connection", "client", client.addr)
+			} else {
+				app.logVerbose("Protocol error from client", "client", client.addr, "error", err)
+			}
 			return
 		}
-		app.infoLogger.Println("RESP: Write result", commands)
 
-		err = app.ExecuteCommands(commands, connection)
+		// commands run one at a time, like the Redis event loop
+		serverMutex.Lock()
+		// wait for the end of CLIENT PAUSE and of a blocking command,
+		// a client waiting with its command is not idle
+		client.lastInteraction = time.Now()
+		for app.clientPaused(client, commands) || client.flags&CLIENT_BLOCKED != 0 {
+			client.flags |= CLIENT_POSTPONED
+			clientsPauseCond.Wait()
+		}
+		client.flags &^= CLIENT_POSTPONED
+		client.queryBufferSize = reader.Buffered()
+		err = app.ExecuteCommands(commands, client)
+		serverMutex.Unlock()
 		if err != nil {
-			app.errorLogger.Println("failed to execute the commands", err)
+			app.logVerbose("failed to execute the commands", "client", client.addr, "error", err)
 			return
 		}
 
 	}
 
 }
-
-// Role: read the data from the client/connection
-func (app *App) readInput(connection net.Conn) ([]byte, error) {
-	buffer := make([]byte, 1024)
-	_, err := connection.Read(buffer)
-	if err != nil {
-		return nil, err
-	}
-	return buffer, nil
-}
-
-// Role: write data to the client/connection
-func (app *App) WriteToClient(connection net.Conn, dataToSend []byte) error {
-	_, err := connection.Write(dataToSend)
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+/*
+INFO: Health and readiness probes
+Served with /metrics on metrics-port, for the orchestrators:
+/healthz answers 200 while the process is alive.
+/readyz answers 200 once the server can take traffic, 503 with the reason
+otherwise: the RDB file is loading, the listeners are not open yet (or
+closed by the shutdown), the server is shutting down, or on a replica the
+sync with the master is in progress, the master link is down or the master
+sent nothing for more than readiness-max-replica-lag seconds.
+The master pings its replicas every repl-ping-replica-period seconds, the
+lag doesn't count that period so an idle master doesn't look like a lagging
+one.
+*/
+
+// configuration parameter, see the configTable, 0 doesn't check the lag
+var readinessMaxReplicaLag int
+
+// ROLE: serve /healthz, the process is alive
+func (app *App) serveHealthz(writer http.ResponseWriter, request *http.Request) {
+	writer.Write([]byte("OK\n"))
+}
+
+// ROLE: serve /readyz, 503 with the reason when not ready for traffic
+func (app *App) serveReadyz(writer http.ResponseWriter, request *http.Request) {
+	// the load holds the serverMutex, answer without waiting for it
+	problem := "loading the dataset in memory"
+	if !loading.Load() {
+		serverMutex.Lock()
+		problem = app.readinessProblem()
+		serverMutex.Unlock()
+	}
+	if problem != "" {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		writer.Write([]byte(problem + "\n"))
+		return
+	}
+	writer.Write([]byte("OK\n"))
+}
+
+// ROLE: why the server isn't ready for traffic, empty when it is
+// caller must hold the serverMutex
+func (app *App) readinessProblem() string {
+	if shutdownAsap || shutdownInProgress || shutdownFinished {
+		return "shutting down"
+	}
+	if len(serverListeners) == 0 {
+		return "not accepting connections"
+	}
+	if role != SLAVE {
+		return ""
+	}
+	if masterSyncInProgress.Load() {
+		return "sync with the master in progress"
+	}
+	var master *Client
+	for _, client := range clients {
+		if client.flags&CLIENT_MASTER != 0 {
+			master = client
+		}
+	}
+	if master == nil {
+		return "master link down"
+	}
+	// an idle master only pings every repl-ping-replica-period seconds
+	lag := int((time.Since(master.lastInteraction) - time.Duration(replPingReplicaPeriod)*time.Second).Seconds())
+	if readinessMaxReplicaLag > 0 && lag > readinessMaxReplicaLag {
+		return fmt.Sprintf("replica lag %ds over readiness-max-replica-lag %ds", lag, readinessMaxReplicaLag)
+	}
+	return ""
+}
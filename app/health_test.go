@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// ROLE: call /readyz, returns the status code and the body
+func probeReadyz(app *App) (int, string) {
+	recorder := httptest.NewRecorder()
+	app.serveReadyz(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+// ROLE: check /readyz answers the status and the body
+func expectReadyz(t *testing.T, app *App, code int, body string) {
+	t.Helper()
+	if gotCode, gotBody := probeReadyz(app); gotCode != code || gotBody != body {
+		t.Fatalf("/readyz %d %q, want %d %q", gotCode, gotBody, code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	server := startTestServer(t)
+	recorder := httptest.NewRecorder()
+	server.app.serveHealthz(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("/healthz %d", recorder.Code)
+	}
+	expectReadyz(t, server.app, http.StatusOK, "OK\n")
+
+	// answered without the serverMutex, held by the load
+	loading.Store(true)
+	server.locked(func() {
+		done := make(chan string)
+		go func() {
+			_, body := probeReadyz(server.app)
+			done <- body
+		}()
+		if body := <-done; body != "loading the dataset in memory\n" {
+			t.Fatalf("/readyz while loading: %q", body)
+		}
+	})
+	loading.Store(false)
+
+	// before the listeners are open, or after they failed to open
+	var listeners []net.Listener
+	server.locked(func() { listeners, serverListeners = serverListeners, nil })
+	expectReadyz(t, server.app, http.StatusServiceUnavailable, "not accepting connections\n")
+	server.locked(func() { serverListeners = listeners })
+
+	server.locked(func() { shutdownAsap = true })
+	expectReadyz(t, server.app, http.StatusServiceUnavailable, "shutting down\n")
+}
+
+func TestReadyzReplica(t *testing.T) {
+	server := startTestServer(t, "readiness-max-replica-lag 5", "repl-ping-replica-period 10")
+	master := server.connectMaster(t)
+	expectReadyz(t, server.app, http.StatusOK, "OK\n")
+
+	masterSyncInProgress.Store(true)
+	expectReadyz(t, server.app, http.StatusServiceUnavailable, "sync with the master in progress\n")
+	masterSyncInProgress.Store(false)
+
+	// an idle master pings every 10 seconds, it's not a lag
+	setLastInteraction := func(ago time.Duration) {
+		server.locked(func() { server.clientOf(master).lastInteraction = time.Now().Add(-ago) })
+	}
+	setLastInteraction(14 * time.Second)
+	expectReadyz(t, server.app, http.StatusOK, "OK\n")
+	setLastInteraction(20 * time.Second)
+	expectReadyz(t, server.app, http.StatusServiceUnavailable, "replica lag 10s over readiness-max-replica-lag 5s\n")
+	// the stream of the master catches up
+	master.send("PING")
+	waitFor(t, func() bool { return time.Since(server.clientOf(master).lastInteraction) < time.Second })
+	expectReadyz(t, server.app, http.StatusOK, "OK\n")
+
+	master.connection.Close()
+	waitFor(t, func() bool { return server.clientOf(master) == nil })
+	expectReadyz(t, server.app, http.StatusServiceUnavailable, "master link down\n")
+}
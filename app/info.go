@@ -0,0 +1,555 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/*
+INFO: INFO command and the server statistics
+INFO [section ...] reports the state of the server with the Redis field
+names, the monitoring agents parse them. Without argument the default
+sections are returned, all/everything return every section.
+used_memory is the heap of the process, maxmemory applies to
+used_memory_keys, the memory estimated for the keys by the eviction.
+The instantaneous_* metrics are sampled by the serverCron, the rate is the
+average of the last STATS_METRIC_SAMPLES samples.
+CONFIG RESETSTAT clears the stats, commandstats, errorstats and latencystats.
+*/
+
+const (
+	// samples of the instantaneous metrics, one per serverCron loop
+	STATS_METRIC_SAMPLES = 16
+	// distinct error prefixes tracked by errorstats, like Redis
+	ERRORSTATS_LIMIT = 128
+)
+
+// instantaneous metrics
+const (
+	STATS_METRIC_COMMAND = iota
+	STATS_METRIC_NET_INPUT
+	STATS_METRIC_NET_OUTPUT
+	STATS_METRIC_COUNT
+)
+
+// for an instantaneous metric, the per second rate of a counter
+type instantaneousMetric struct {
+	lastSampleTime  time.Time
+	lastSampleCount int64
+	samples         [STATS_METRIC_SAMPLES]float64
+	index           int
+}
+
+// for an INFO section, the default ones are returned by INFO without argument
+type infoSection struct {
+	name      string
+	title     string
+	isDefault bool
+	generate  func(app *App, client *Client) []string
+}
+
+var infoSections = []infoSection{
+	{name: "server", title: "Server", isDefault: true, generate: (*App).infoServer},
+	{name: "clients", title: "Clients", isDefault: true, generate: (*App).infoClients},
+	{name: "memory", title: "Memory", isDefault: true, generate: (*App).infoMemory},
+	{name: "persistence", title: "Persistence", isDefault: true, generate: (*App).infoPersistence},
+	{name: "stats", title: "Stats", isDefault: true, generate: (*App).infoStats},
+	{name: "replication", title: "Replication", isDefault: true, generate: (*App).infoReplication},
+	{name: "cpu", title: "CPU", isDefault: true, generate: (*App).infoCPU},
+	{name: "modules", title: "Modules", isDefault: true, generate: (*App).infoModules},
+	{name: "commandstats", title: "Commandstats", generate: (*App).infoCommandstats},
+	{name: "errorstats", title: "Errorstats", isDefault: true, generate: (*App).infoErrorstats},
+	{name: "latencystats", title: "Latencystats", generate: (*App).infoLatencystats},
+	{name: "cluster", title: "Cluster", isDefault: true, generate: (*App).infoCluster},
+	{name: "keyspace", title: "Keyspace", isDefault: true, generate: (*App).infoKeyspace},
+}
+
+var (
+	// set once at startup
+	serverStartTime time.Time
+	runID           string
+	// bytes read from and written to the connections, updated by the
+	// connection goroutines without the serverMutex
+	netInputBytes  atomic.Int64
+	netOutputBytes atomic.Int64
+	// by STATS_METRIC_*
+	instantaneousMetrics [STATS_METRIC_COUNT]instantaneousMetric
+)
+
+// ROLE: handle INFO [section ...]
+func (app *App) executeINFO(client *Client, commands []string) []byte {
+	requested := make(map[string]bool)
+	for _, section := range commands[1:] {
+		requested[strings.ToLower(section)] = true
+	}
+	if len(requested) == 0 {
+		requested["default"] = true
+	}
+	all := requested["all"] || requested["everything"]
+
+	var sections []string
+	for _, section := range infoSections {
+		if !all && !requested[section.name] && !(requested["default"] && section.isDefault) {
+			continue
+		}
+		var text strings.Builder
+		text.WriteString("# " + section.title + "\r\n")
+		for _, field := range section.generate(app, client) {
+			text.WriteString(field + "\r\n")
+		}
+		sections = append(sections, text.String())
+	}
+	return app.createVerbatimStringResponse(client, strings.Join(sections, "\r\n"))
+}
+
+func (app *App) infoServer(client *Client) []string {
+	uptime := time.Since(serverStartTime)
+	executable, _ := os.Executable()
+	configPath := ""
+	if configFile != "" {
+		configPath, _ = filepath.Abs(configFile)
+	}
+	hz := int(time.Second / ACTIVE_EXPIRE_CYCLE_TIME)
+	return []string{
+		"redis_version:" + REDIS_SERVER_VERSION,
+		"redis_git_sha1:00000000",
+		"redis_git_dirty:0",
+		"redis_mode:standalone",
+		fmt.Sprintf("os:%s %s", runtime.GOOS, runtime.GOARCH),
+		fmt.Sprintf("arch_bits:%d", strconv.IntSize),
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		"process_supervised:no",
+		"run_id:" + runID,
+		fmt.Sprintf("tcp_port:%d", port),
+		fmt.Sprintf("server_time_usec:%d", time.Now().UnixMicro()),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(uptime.Seconds())),
+		fmt.Sprintf("uptime_in_days:%d", int64(uptime.Hours()/24)),
+		fmt.Sprintf("hz:%d", hz),
+		fmt.Sprintf("configured_hz:%d", hz),
+		"executable:" + executable,
+		"config_file:" + configPath,
+		"io_threads_active:0",
+	}
+}
+
+func (app *App) infoClients(client *Client) []string {
+	var blocked, tracking, pubsub, watching, maxInput, maxOutput int
+	for _, c := range clients {
+		if c.flags&CLIENT_BLOCKED != 0 {
+			blocked++
+		}
+		if c.flags&CLIENT_TRACKING != 0 {
+			tracking++
+		}
+		if app.clientSubscriptionCount(c) > 0 {
+			pubsub++
+		}
+		if len(c.watchedKeys) > 0 {
+			watching++
+		}
+		maxInput = max(maxInput, c.queryBufferSize)
+		c.outputMutex.Lock()
+		maxOutput = max(maxOutput, c.outputSize)
+		c.outputMutex.Unlock()
+	}
+	watchedKeys := 0
+	for _, db := range dbs {
+		watchedKeys += len(db.watchedKeys)
+	}
+	return []string{
+		fmt.Sprintf("connected_clients:%d", len(clients)-len(slaveConnections)),
+		"cluster_connections:0",
+		fmt.Sprintf("maxclients:%d", maxclients),
+		fmt.Sprintf("client_recent_max_input_buffer:%d", maxInput),
+		fmt.Sprintf("client_recent_max_output_buffer:%d", maxOutput),
+		fmt.Sprintf("blocked_clients:%d", blocked),
+		fmt.Sprintf("tracking_clients:%d", tracking),
+		fmt.Sprintf("pubsub_clients:%d", pubsub),
+		fmt.Sprintf("watching_clients:%d", watching),
+		"clients_in_timeout_table:0",
+		fmt.Sprintf("total_watched_keys:%d", watchedKeys),
+		"total_blocking_keys:0",
+		"total_blocking_keys_on_nokey:0",
+	}
+}
+
+func (app *App) infoMemory(client *Client) []string {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	if memStats.HeapAlloc > peakAllocated {
+		peakAllocated = memStats.HeapAlloc
+	}
+	used := memStats.HeapAlloc
+
+	overhead := 0
+	for _, db := range dbs {
+		mainOverhead, expiresOverhead := app.databaseOverhead(db)
+		overhead += mainOverhead + expiresOverhead
+	}
+	keysMemory := app.usedMemory()
+	dataset := int(keysMemory) - overhead
+	datasetPercentage, peakPercentage, fragmentation := 0.0, 0.0, 0.0
+	if used > startupAllocated {
+		datasetPercentage = float64(dataset) * 100 / float64(used-startupAllocated)
+	}
+	if peakAllocated > 0 {
+		peakPercentage = float64(used) * 100 / float64(peakAllocated)
+	}
+	if used > 0 {
+		fragmentation = float64(memStats.HeapInuse) / float64(used)
+	}
+
+	var slavesOutput, normalOutput int
+	for _, c := range clients {
+		c.outputMutex.Lock()
+		if c.flags&CLIENT_SLAVE != 0 {
+			slavesOutput += c.outputSize
+		} else {
+			normalOutput += c.outputSize
+		}
+		c.outputMutex.Unlock()
+	}
+
+	return []string{
+		fmt.Sprintf("used_memory:%d", used),
+		"used_memory_human:" + bytesToHuman(used),
+		fmt.Sprintf("used_memory_rss:%d", memStats.Sys),
+		"used_memory_rss_human:" + bytesToHuman(memStats.Sys),
+		fmt.Sprintf("used_memory_peak:%d", peakAllocated),
+		"used_memory_peak_human:" + bytesToHuman(peakAllocated),
+		fmt.Sprintf("used_memory_peak_perc:%.2f%%", peakPercentage),
+		fmt.Sprintf("used_memory_overhead:%d", overhead),
+		fmt.Sprintf("used_memory_startup:%d", startupAllocated),
+		fmt.Sprintf("used_memory_dataset:%d", dataset),
+		fmt.Sprintf("used_memory_dataset_perc:%.2f%%", datasetPercentage),
+		fmt.Sprintf("used_memory_keys:%d", keysMemory),
+		"used_memory_keys_human:" + bytesToHuman(uint64(keysMemory)),
+		fmt.Sprintf("maxmemory:%d", maxmemoryBytes),
+		"maxmemory_human:" + bytesToHuman(uint64(maxmemoryBytes)),
+		"maxmemory_policy:" + maxmemoryPolicy,
+		fmt.Sprintf("mem_fragmentation_ratio:%.2f", fragmentation),
+		fmt.Sprintf("mem_clients_slaves:%d", slavesOutput),
+		fmt.Sprintf("mem_clients_normal:%d", normalOutput),
+		"mem_allocator:go",
+		"lazyfree_pending_objects:0",
+	}
+}
+
+func (app *App) infoPersistence(client *Client) []string {
+	status := "ok"
+	if !lastSaveStatusOK {
+		status = "err"
+	}
+	lastBgsaveSeconds, currentBgsaveSeconds := -1, -1
+	if lastBgsaveDuration >= 0 {
+		lastBgsaveSeconds = int(lastBgsaveDuration.Seconds())
+	}
+	if bgsaveSnapshot != nil {
+		currentBgsaveSeconds = int(time.Since(bgsaveSnapshot.start).Seconds())
+	}
+	return []string{
+		fmt.Sprintf("loading:%d", boolToInt(loading.Load())),
+		"async_loading:0",
+		fmt.Sprintf("rdb_changes_since_last_save:%d", dirty),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", boolToInt(bgsaveSnapshot != nil)),
+		fmt.Sprintf("rdb_last_save_time:%d", lastSaveTime.Unix()),
+		"rdb_last_bgsave_status:" + status,
+		fmt.Sprintf("rdb_last_bgsave_time_sec:%d", lastBgsaveSeconds),
+		fmt.Sprintf("rdb_current_bgsave_time_sec:%d", currentBgsaveSeconds),
+		fmt.Sprintf("rdb_saves:%d", stats.rdbSaves),
+		"aof_enabled:0",
+		"aof_rewrite_in_progress:0",
+		"aof_rewrite_scheduled:0",
+		"aof_last_rewrite_time_sec:-1",
+		"aof_current_rewrite_time_sec:-1",
+		"aof_last_bgrewrite_status:ok",
+		"aof_rewrites:0",
+		"aof_last_write_status:ok",
+	}
+}
+
+func (app *App) infoStats(client *Client) []string {
+	trackingItems := 0
+	for _, ids := range trackingTable {
+		trackingItems += len(ids)
+	}
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", stats.totalConnectionsReceived),
+		fmt.Sprintf("total_commands_processed:%d", stats.totalCommandsProcessed),
+		fmt.Sprintf("instantaneous_ops_per_sec:%d", int64(instantaneousMetrics[STATS_METRIC_COMMAND].value())),
+		fmt.Sprintf("total_net_input_bytes:%d", netInputBytes.Load()),
+		fmt.Sprintf("total_net_output_bytes:%d", netOutputBytes.Load()),
+		fmt.Sprintf("instantaneous_input_kbps:%.2f", instantaneousMetrics[STATS_METRIC_NET_INPUT].value()/1024),
+		fmt.Sprintf("instantaneous_output_kbps:%.2f", instantaneousMetrics[STATS_METRIC_NET_OUTPUT].value()/1024),
+		fmt.Sprintf("rejected_connections:%d", stats.rejectedConnections),
+		fmt.Sprintf("sync_full:%d", stats.syncFull),
+		"sync_partial_ok:0",
+		"sync_partial_err:0",
+		fmt.Sprintf("expired_keys:%d", stats.expiredKeys),
+		fmt.Sprintf("expired_time_cap_reached_count:%d", stats.expiredTimeCapReachedCount),
+		fmt.Sprintf("evicted_keys:%d", stats.evictedKeys),
+		"evicted_clients:0",
+		fmt.Sprintf("keyspace_hits:%d", stats.keyspaceHits),
+		fmt.Sprintf("keyspace_misses:%d", stats.keyspaceMisses),
+		fmt.Sprintf("pubsub_channels:%d", len(pubsubChannels)),
+		fmt.Sprintf("pubsub_patterns:%d", len(pubsubPatterns)),
+		"latest_fork_usec:0",
+		"total_forks:0",
+		fmt.Sprintf("tracking_total_keys:%d", len(trackingTable)),
+		fmt.Sprintf("tracking_total_items:%d", trackingItems),
+		fmt.Sprintf("tracking_total_prefixes:%d", len(trackingPrefixTable)),
+		"unexpected_error_replies:0",
+		fmt.Sprintf("total_error_replies:%d", stats.totalErrorReplies),
+		fmt.Sprintf("client_output_buffer_limit_disconnections:%d", stats.clientOutputBufferLimitDisconnections),
+		fmt.Sprintf("acl_access_denied_auth:%d", stats.aclAccessDeniedAuth),
+		fmt.Sprintf("acl_access_denied_cmd:%d", stats.aclAccessDeniedCmd),
+		fmt.Sprintf("acl_access_denied_key:%d", stats.aclAccessDeniedKey),
+		fmt.Sprintf("acl_access_denied_channel:%d", stats.aclAccessDeniedChannel),
+	}
+}
+
+func (app *App) infoReplication(client *Client) []string {
+	var fields []string
+	if role == MASTER {
+		fields = append(fields, ROLE+":master")
+	} else {
+		host, masterPort, _ := strings.Cut(replicaof, " ")
+		linkStatus, lastIO := "down", -1
+		for _, c := range clients {
+			if c.flags&CLIENT_MASTER != 0 {
+				linkStatus, lastIO = "up", int(time.Since(c.lastInteraction).Seconds())
+			}
+		}
+		fields = append(fields,
+			ROLE+":slave",
+			"master_host:"+host,
+			"master_port:"+masterPort,
+			"master_link_status:"+linkStatus,
+			fmt.Sprintf("master_last_io_seconds_ago:%d", lastIO),
+			fmt.Sprintf("master_sync_in_progress:%d", boolToInt(masterSyncInProgress.Load())),
+			"slave_read_repl_offset:"+MASTER_REPL_OFFSET_VALUE,
+			"slave_repl_offset:"+MASTER_REPL_OFFSET_VALUE,
+			"slave_priority:100",
+			"slave_read_only:1",
+			"replica_announced:1",
+		)
+	}
+	fields = append(fields, fmt.Sprintf("connected_slaves:%d", len(slaveConnections)))
+	for i, slave := range slaveConnections {
+		host, _, _ := net.SplitHostPort(slave.addr)
+		fields = append(fields, fmt.Sprintf("slave%d:ip=%s,port=%d,state=online,offset=%s,lag=%d",
+			i, host, slave.slaveListeningPort, MASTER_REPL_OFFSET_VALUE, replicaLag(slave)))
+	}
+	return append(fields,
+		"master_failover_state:no-failover",
+		MASTER_REPL_ID+":"+MASTER_REPL_ID_VALUE,
+		"master_replid2:0000000000000000000000000000000000000000",
+		MASTER_REPL_OFFSET+":"+MASTER_REPL_OFFSET_VALUE,
+		"second_repl_offset:-1",
+		"repl_backlog_active:0",
+		"repl_backlog_size:0",
+		"repl_backlog_first_byte_offset:0",
+		"repl_backlog_histlen:0",
+	)
+}
+
+func (app *App) infoCPU(client *Client) []string {
+	var self, children syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &self)
+	syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children)
+	seconds := func(tv syscall.Timeval) string {
+		return fmt.Sprintf("%.6f", float64(tv.Nano())/float64(time.Second))
+	}
+	return []string{
+		"used_cpu_sys:" + seconds(self.Stime),
+		"used_cpu_user:" + seconds(self.Utime),
+		"used_cpu_sys_children:" + seconds(children.Stime),
+		"used_cpu_user_children:" + seconds(children.Utime),
+	}
+}
+
+// no modules, the section is empty
+func (app *App) infoModules(client *Client) []string {
+	return nil
+}
+
+// ROLE: cmdstat_<command>:calls=...,usec=...,usec_per_call=...,rejected_calls=...,failed_calls=...
+// for the commands and subcommands called at least once
+func (app *App) infoCommandstats(client *Client) []string {
+	var fields []string
+	for _, command := range commandsWithStats() {
+		if command.calls == 0 && command.rejectedCalls == 0 && command.failedCalls == 0 {
+			continue
+		}
+		perCall := 0.0
+		if command.calls > 0 {
+			perCall = float64(command.microseconds) / float64(command.calls)
+		}
+		fields = append(fields, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			command.fullName, command.calls, command.microseconds, perCall, command.rejectedCalls, command.failedCalls))
+	}
+	return fields
+}
+
+// ROLE: errorstat_<prefix>:count=..., ex: errorstat_WRONGTYPE:count=1
+func (app *App) infoErrorstats(client *Client) []string {
+	prefixes := make([]string, 0, len(stats.errorReplies))
+	for prefix := range stats.errorReplies {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	fields := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		fields = append(fields, fmt.Sprintf("errorstat_%s:count=%d", prefix, stats.errorReplies[prefix]))
+	}
+	return fields
+}
+
+func (app *App) infoCluster(client *Client) []string {
+	return []string{"cluster_enabled:0"}
+}
+
+// ROLE: db<index>:keys=...,expires=...,avg_ttl=... for the databases with keys
+func (app *App) infoKeyspace(client *Client) []string {
+	var fields []string
+	for _, db := range dbs {
+		if len(db.dict) == 0 {
+			continue
+		}
+		fields = append(fields, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=%d", db.id, len(db.dict), len(db.expires), db.avgTTL))
+	}
+	return fields
+}
+
+// ROLE: 1 for true, 0 for false, ex: loading:0
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// ROLE: count the error reply in errorstats, keyed by its first word, ex: ERR, WRONGTYPE
+// new prefixes are ignored once ERRORSTATS_LIMIT are tracked
+func (app *App) trackErrorReply(response []byte) {
+	stats.totalErrorReplies++
+	prefix := "ERR"
+	if line, _, _ := strings.Cut(string(response[1:]), "\r\n"); len(strings.Fields(line)) > 0 {
+		prefix = strings.Fields(line)[0]
+	}
+	if stats.errorReplies == nil {
+		stats.errorReplies = make(map[string]int64)
+	}
+	if _, ok := stats.errorReplies[prefix]; !ok && len(stats.errorReplies) >= ERRORSTATS_LIMIT {
+		return
+	}
+	stats.errorReplies[prefix]++
+}
+
+// ROLE: sample the instantaneous metrics, called by the serverCron
+func (app *App) trackInstantaneousMetrics() {
+	now := time.Now()
+	instantaneousMetrics[STATS_METRIC_COMMAND].track(stats.totalCommandsProcessed, now)
+	instantaneousMetrics[STATS_METRIC_NET_INPUT].track(netInputBytes.Load(), now)
+	instantaneousMetrics[STATS_METRIC_NET_OUTPUT].track(netOutputBytes.Load(), now)
+}
+
+// ROLE: add the rate since the last sample
+func (metric *instantaneousMetric) track(current int64, now time.Time) {
+	if !metric.lastSampleTime.IsZero() {
+		if elapsed := now.Sub(metric.lastSampleTime).Seconds(); elapsed > 0 {
+			metric.samples[metric.index] = float64(current-metric.lastSampleCount) / elapsed
+			metric.index = (metric.index + 1) % STATS_METRIC_SAMPLES
+		}
+	}
+	metric.lastSampleTime = now
+	metric.lastSampleCount = current
+}
+
+// ROLE: per second rate, the average of the samples
+func (metric *instantaneousMetric) value() float64 {
+	sum := 0.0
+	for _, sample := range metric.samples {
+		sum += sample
+	}
+	return sum / STATS_METRIC_SAMPLES
+}
+
+// ROLE: clear the stats reported by INFO, CONFIG RESETSTAT
+// caller must hold the serverMutex
+func (app *App) resetServerStats() {
+	stats = Stats{}
+	netInputBytes.Store(0)
+	netOutputBytes.Store(0)
+	instantaneousMetrics = [STATS_METRIC_COUNT]instantaneousMetric{}
+	for _, command := range commandTable {
+		command.resetStats()
+		for _, subcommand := range command.subcommands {
+			subcommand.resetStats()
+		}
+	}
+}
+
+func (command *Command) resetStats() {
+	command.calls, command.microseconds, command.rejectedCalls, command.failedCalls = 0, 0, 0, 0
+	command.latencyHistogram = nil
+}
+
+// ROLE: the commands and subcommands, they all carry their stats, sorted by full name
+func commandsWithStats() []*Command {
+	var commands []*Command
+	for _, command := range commandTable {
+		commands = append(commands, command)
+		for _, subcommand := range command.subcommands {
+			commands = append(commands, subcommand)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].fullName < commands[j].fullName })
+	return commands
+}
+
+// for the bytes read from a connection, counted in total_net_input_bytes
+type countingReader struct {
+	reader io.Reader
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	netInputBytes.Add(int64(n))
+	return n, err
+}
+
+// ROLE: format the bytes like Redis, ex: 1.50M
+func bytesToHuman(bytes uint64) string {
+	switch {
+	case bytes < 1<<10:
+		return fmt.Sprintf("%dB", bytes)
+	case bytes < 1<<20:
+		return fmt.Sprintf("%.2fK", float64(bytes)/(1<<10))
+	case bytes < 1<<30:
+		return fmt.Sprintf("%.2fM", float64(bytes)/(1<<20))
+	case bytes < 1<<40:
+		return fmt.Sprintf("%.2fG", float64(bytes)/(1<<30))
+	}
+	return fmt.Sprintf("%.2fT", float64(bytes)/(1<<40))
+}
+
+// ROLE: random hex string of the given length, ex: the run_id
+func randomHex(length int) string {
+	random := make([]byte, (length+1)/2)
+	rand.Read(random)
+	return hex.EncodeToString(random)[:length]
+}
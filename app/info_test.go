@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ROLE: the fields of the INFO reply by section title
+func parseInfo(t *testing.T, reply any) map[string]map[string]string {
+	t.Helper()
+	text, ok := reply.(string)
+	if !ok {
+		t.Fatalf("INFO: %v", reply)
+	}
+	sections := make(map[string]map[string]string)
+	var fields map[string]string
+	for _, line := range strings.Split(text, "\r\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			fields = make(map[string]string)
+			sections[line[2:]] = fields
+		case line != "":
+			name, value, _ := strings.Cut(line, ":")
+			fields[name] = value
+		}
+	}
+	return sections
+}
+
+func TestInfoSections(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+
+	defaults := []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Replication", "CPU", "Modules", "Errorstats", "Cluster", "Keyspace"}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, defaults},
+		{[]string{"default"}, defaults},
+		{[]string{"CLIENTS", "memory"}, []string{"Clients", "Memory"}},
+		{[]string{"commandstats"}, []string{"Commandstats"}},
+		{[]string{"all"}, append(defaults, "Commandstats", "Latencystats")},
+		{[]string{"everything"}, append(defaults, "Commandstats", "Latencystats")},
+		{[]string{"nosuch"}, nil},
+	}
+	for _, test := range tests {
+		sections := parseInfo(t, client.do(append([]string{"INFO"}, test.args...)...))
+		if len(sections) != len(test.want) {
+			t.Fatalf("INFO %v: %d sections, want %v", test.args, len(sections), test.want)
+		}
+		for _, title := range test.want {
+			if _, ok := sections[title]; !ok {
+				t.Fatalf("INFO %v without %s", test.args, title)
+			}
+		}
+	}
+
+	// RESP3 gets a verbatim string
+	client.do("HELLO", "3")
+	if server := parseInfo(t, client.do("INFO", "server"))["Server"]; server["redis_version"] != REDIS_SERVER_VERSION {
+		t.Fatalf("RESP3 INFO server: %v", server)
+	}
+}
+
+func TestInfoFields(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	subscriber := server.connect(t)
+	subscriber.do("SUBSCRIBE", "news")
+	server.connectReplica(t)
+
+	client.do("SET", "a", "1")
+	client.do("SET", "b", "1", "PX", "100000")
+	client.do("SELECT", "2")
+	client.do("SET", "c", "1")
+	client.do("GET", "c")
+	client.do("GET", "missing")
+	client.do("WATCH", "c")
+	info := parseInfo(t, client.do("INFO", "all"))
+
+	for section, want := range map[string]map[string]string{
+		"Clients": {"connected_clients": "2", "pubsub_clients": "1", "watching_clients": "1", "total_watched_keys": "1", "blocked_clients": "0"},
+		"Stats": {"keyspace_hits": "1", "keyspace_misses": "1", "pubsub_channels": "1", "sync_full": "1",
+			"total_error_replies": "0"},
+		"Replication": {"role": "master", "connected_slaves": "1"},
+		"Keyspace":    {"db0": "keys=2,expires=1,avg_ttl=0", "db2": "keys=1,expires=0,avg_ttl=0"},
+		"Persistence": {"loading": "0", "rdb_bgsave_in_progress": "0", "rdb_changes_since_last_save": "3"},
+	} {
+		for name, value := range want {
+			if info[section][name] != value {
+				t.Fatalf("%s %s:%s, want %s", section, name, info[section][name], value)
+			}
+		}
+	}
+	if !strings.HasPrefix(info["Commandstats"]["cmdstat_set"], "calls=3,") {
+		t.Fatalf("cmdstat_set:%s", info["Commandstats"]["cmdstat_set"])
+	}
+	if !strings.HasPrefix(info["Replication"]["slave0"], "ip=127.0.0.1,") {
+		t.Fatalf("replica line: %s", info["Replication"]["slave0"])
+	}
+	if _, ok := info["Keyspace"]["db1"]; ok {
+		t.Fatal("empty database in the Keyspace section")
+	}
+	// the memory compared with maxmemory, not the heap
+	server.locked(func() {
+		if keys := info["Memory"]["used_memory_keys"]; keys != strconv.FormatInt(server.app.usedMemory(), 10) || keys == "0" {
+			t.Fatalf("used_memory_keys:%s, eviction compares %d", keys, server.app.usedMemory())
+		}
+	})
+}
+
+func TestInfoErrorAndCommandStats(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+
+	client.do("SELECT", "99")
+	client.do("SET", "key")
+	client.do("NOSUCH")
+	client.do("SET", "key", "value")
+	client.do("INCR", "key")
+	info := parseInfo(t, client.do("INFO", "errorstats", "commandstats", "stats"))
+	expectReply(t, info["Stats"]["total_error_replies"], "4")
+	expectReply(t, info["Errorstats"]["errorstat_ERR"], "count=4")
+	// an arity error is a rejected call, an error of the command a failed one
+	if set := info["Commandstats"]["cmdstat_set"]; !strings.HasPrefix(set, "calls=1,") || !strings.HasSuffix(set, ",rejected_calls=1,failed_calls=0") {
+		t.Fatalf("cmdstat_set:%s", set)
+	}
+	if selectStats := info["Commandstats"]["cmdstat_select"]; !strings.HasSuffix(selectStats, ",rejected_calls=0,failed_calls=1") {
+		t.Fatalf("cmdstat_select:%s", selectStats)
+	}
+
+	expectReply(t, client.do("CONFIG", "RESETSTAT"), "OK")
+	info = parseInfo(t, client.do("INFO", "errorstats", "commandstats"))
+	if len(info["Errorstats"]) != 0 || len(info["Commandstats"]) != 1 {
+		t.Fatalf("after CONFIG RESETSTAT: %v", info)
+	}
+}
+
+func TestInstantaneousMetric(t *testing.T) {
+	var metric instantaneousMetric
+	start := time.Now()
+	metric.track(0, start)
+	// 100 per second during 2 samples, the rate is the average of all the samples
+	metric.track(100, start.Add(time.Second))
+	metric.track(200, start.Add(2*time.Second))
+	if rate := metric.value(); rate != 200.0/STATS_METRIC_SAMPLES {
+		t.Fatalf("rate %f", rate)
+	}
+	for i := 3; i < 3+STATS_METRIC_SAMPLES; i++ {
+		metric.track(int64(i)*100, start.Add(time.Duration(i)*time.Second))
+	}
+	if rate := metric.value(); rate != 100 {
+		t.Fatalf("rate once the samples are full %f", rate)
+	}
+}
+
+func TestBytesToHuman(t *testing.T) {
+	for bytes, want := range map[uint64]string{
+		0: "0B", 1023: "1023B", 1536: "1.50K", 10 << 20: "10.00M", 3 << 30: "3.00G", 2 << 40: "2.00T",
+	} {
+		if human := bytesToHuman(bytes); human != want {
+			t.Fatalf("%d: %s, want %s", bytes, human, want)
+		}
+	}
+}
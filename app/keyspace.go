@@ -0,0 +1,235 @@
+package main
+
+import (
+	"time"
+)
+
+/*
+INFO: Keyspace layer
+Every command reads and writes the keys through these functions, never the
+db map directly. Lazy expiry, active expiry, memory accounting, access
+tracking for eviction and keyspace notifications live here.
+*/
+
+const (
+	// active expiry: keys sampled per loop and max loops per cycle
+	ACTIVE_EXPIRE_SAMPLES    = 20
+	ACTIVE_EXPIRE_MAX_LOOPS  = 16
+	ACTIVE_EXPIRE_CYCLE_TIME = 100 * time.Millisecond
+
+	// lookupKey flags
+	LOOKUP_NOTOUCH  = 1 << 0
+	LOOKUP_NONOTIFY = 1 << 1
+)
+
+// ROLE: check if the value has a TTL which is already over
+func (app *App) isExpired(value *Value) bool {
+	return !value.expiration.IsZero() && time.Now().After(value.expiration)
+}
+
+// ROLE: low level add or overwrite, keeps the expires set and memory in sync
+func (app *App) dbAdd(db *Database, key string, value *Value) {
+	app.snapshotPreserveKey(db, key)
+	if old, ok := db.dict[key]; ok {
+		db.memory -= app.estimateKeyMemory(key, old)
+	}
+	value.encoding = app.detectEncoding(value.value)
+	db.dict[key] = value
+	db.memory += app.estimateKeyMemory(key, value)
+	if value.expiration.IsZero() {
+		delete(db.expires, key)
+	} else {
+		db.expires[key] = true
+	}
+}
+
+// ROLE: low level delete, keeps the expires set and memory in sync
+func (app *App) dbDelete(db *Database, key string) {
+	value, ok := db.dict[key]
+	if !ok {
+		return
+	}
+	app.snapshotPreserveKey(db, key)
+	db.memory -= app.estimateKeyMemory(key, value)
+	delete(db.dict, key)
+	delete(db.expires, key)
+}
+
+// ROLE: delete the key if it is expired (lazy expiry), the replicas get a DEL
+// returns true if the key was deleted, or is only reported as expired:
+// a replica waits for the DEL of its master, the commands of the master still see the key
+func (app *App) expireIfNeeded(db *Database, key string) bool {
+	value, ok := db.dict[key]
+	if !ok || !app.isExpired(value) {
+		return false
+	}
+	if role == SLAVE {
+		return currentClient == nil || currentClient.flags&CLIENT_MASTER == 0
+	}
+	// no writes while the clients are paused, the key is only reported as expired
+	if app.clientsArePaused() {
+		return true
+	}
+	app.dbDelete(db, key)
+	stats.expiredKeys++
+	app.propagateDeletion(db, key)
+	app.touchWatchedKey(db, key, true)
+	app.trackingInvalidateKey(key)
+	app.notifyKeyspaceEvent(NOTIFY_EXPIRED, "expired", key, db.id)
+	return true
+}
+
+// ROLE: send a DEL to the replicas for a key the master deleted by itself (expiry, eviction)
+// inside EXEC it is sent with the writes of the transaction
+func (app *App) propagateDeletion(db *Database, key string) {
+	if role != MASTER {
+		return
+	}
+	commands := []string{"DEL", key}
+	if currentClient != nil && currentClient.flags&CLIENT_EXECUTING_MULTI != 0 {
+		currentClient.pendingPropagation = append(currentClient.pendingPropagation, propagatedCommand{dbid: db.id, commands: commands})
+		return
+	}
+	app.replicationFeedSlaves(db.id, commands)
+}
+
+// ROLE: lookup the key for reading, expired keys are deleted on access
+func (app *App) lookupKeyRead(db *Database, key string) (*Value, bool) {
+	return app.lookupKey(db, key, 0)
+}
+
+// ROLE: lookup the key, flags:
+// LOOKUP_NOTOUCH: the access info used by eviction is not updated
+// LOOKUP_NONOTIFY: no keymiss notification
+func (app *App) lookupKey(db *Database, key string, flags int) (*Value, bool) {
+	if currentClient != nil && currentClient.flags&CLIENT_NO_TOUCH != 0 {
+		flags |= LOOKUP_NOTOUCH
+	}
+	var value *Value
+	ok := false
+	if !app.expireIfNeeded(db, key) {
+		value, ok = db.dict[key]
+	}
+	if !ok {
+		stats.keyspaceMisses++
+		if flags&LOOKUP_NONOTIFY == 0 {
+			app.notifyKeyspaceEvent(NOTIFY_KEY_MISS, "keymiss", key, db.id)
+		}
+		return nil, false
+	}
+	stats.keyspaceHits++
+	if flags&LOOKUP_NOTOUCH == 0 {
+		app.updateAccessInfo(value)
+	}
+	return value, true
+}
+
+// ROLE: add or overwrite the key
+// event is the notification of the command doing the write, ex: "set"
+func (app *App) setKey(db *Database, key string, value *Value, class int, event string) {
+	app.expireIfNeeded(db, key)
+	_, exists := db.dict[key]
+	app.initAccessInfo(value)
+	app.dbAdd(db, key, value)
+	app.signalModifiedKey(db, key)
+	if !exists {
+		app.notifyKeyspaceEvent(NOTIFY_NEW, "new", key, db.id)
+	}
+	app.notifyKeyspaceEvent(class, event, key, db.id)
+}
+
+// ROLE: delete the key, returns true if the key existed
+func (app *App) deleteKey(db *Database, key string) bool {
+	if app.expireIfNeeded(db, key) {
+		return false
+	}
+	if _, ok := db.dict[key]; !ok {
+		return false
+	}
+	app.dbDelete(db, key)
+	app.signalModifiedKey(db, key)
+	return true
+}
+
+// ROLE: hook called every time a key is modified
+func (app *App) signalModifiedKey(db *Database, key string) {
+	dirty++
+	app.touchWatchedKey(db, key, false)
+	app.trackingInvalidateKey(key)
+}
+
+// ROLE: active expiry, sample keys with a TTL and delete the expired ones
+// keeps sampling while more than 25% of the sample was expired,
+// the TTL of the sampled keys gives the avg_ttl of the database
+// a replica doesn't expire keys, its master sends the DELs
+func (app *App) activeExpireCycle() {
+	if role == SLAVE || app.clientsArePaused() {
+		return
+	}
+	start := time.Now()
+	defer func() { app.latencyAddSampleIfNeeded("expire-cycle", time.Since(start)) }()
+	for _, db := range dbs {
+		if len(db.expires) == 0 {
+			db.avgTTL = 0
+			continue
+		}
+		for loop := 0; loop < ACTIVE_EXPIRE_MAX_LOOPS; loop++ {
+			sampled, expired := 0, 0
+			var ttlSum, ttlSamples int64
+			// map iteration order is random, this is our sampling
+			for key := range db.expires {
+				sampled++
+				if app.expireIfNeeded(db, key) {
+					expired++
+				} else if value, ok := db.dict[key]; ok {
+					ttlSum += time.Until(value.expiration).Milliseconds()
+					ttlSamples++
+				}
+				if sampled >= ACTIVE_EXPIRE_SAMPLES {
+					break
+				}
+			}
+			if ttlSamples > 0 {
+				app.updateAverageTTL(db, ttlSum/ttlSamples)
+			}
+			if sampled == 0 || expired*4 <= sampled {
+				break
+			}
+			if time.Since(start) > ACTIVE_EXPIRE_CYCLE_TIME/4 {
+				stats.expiredTimeCapReachedCount++
+				return
+			}
+		}
+	}
+}
+
+// ROLE: blend the average TTL of a sample in the estimate, like Redis
+// every sample weighs 2% so a single one can't move it much
+func (app *App) updateAverageTTL(db *Database, sampleTTL int64) {
+	if db.avgTTL == 0 {
+		db.avgTTL = sampleTTL
+		return
+	}
+	db.avgTTL = db.avgTTL/50*49 + sampleTTL/50
+}
+
+// ROLE: background tasks of the server, runs every ACTIVE_EXPIRE_CYCLE_TIME
+func (app *App) serverCron() {
+	ticker := time.NewTicker(ACTIVE_EXPIRE_CYCLE_TIME)
+	defer ticker.Stop()
+	for loops := 0; ; loops++ {
+		<-ticker.C
+		serverMutex.Lock()
+		app.activeExpireCycle()
+		app.trackInstantaneousMetrics()
+		// once per second
+		if loops%10 == 0 {
+			app.updatePeakMemory()
+			app.closeTimedoutClients()
+			app.replicationCron()
+			app.rdbSaveCron()
+		}
+		app.shutdownCron()
+		serverMutex.Unlock()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryPropagatesDel(t *testing.T) {
+	server := startTestServer(t)
+	replica := server.connectReplica(t)
+	client := server.connect(t)
+
+	client.do("SET", "lazy", "value", "PX", "1")
+	client.do("SET", "active", "value", "PX", "1")
+	replica.expectCommands(
+		[]string{"SELECT", "0"},
+		[]string{"SET", "lazy", "value", "PX", "1"},
+		[]string{"SET", "active", "value", "PX", "1"},
+	)
+	time.Sleep(5 * time.Millisecond)
+
+	// lazy expiry on access
+	expectReply(t, client.do("GET", "lazy"), nil)
+	replica.expectCommands([]string{"DEL", "lazy"})
+
+	// active expiry of the serverCron
+	server.locked(server.app.activeExpireCycle)
+	replica.expectCommands([]string{"DEL", "active"})
+
+	// inside EXEC the DEL is sent with the transaction, before the write
+	client.do("SELECT", "2")
+	client.do("SET", "key", "value", "PX", "1")
+	replica.expectCommands([]string{"SELECT", "2"}, []string{"SET", "key", "value", "PX", "1"})
+	time.Sleep(5 * time.Millisecond)
+	client.do("MULTI")
+	client.do("SET", "key", "new")
+	expectReply(t, client.do("EXEC"), []any{"OK"})
+	replica.expectCommands(
+		[]string{"MULTI"},
+		[]string{"DEL", "key"},
+		[]string{"SET", "key", "new"},
+		[]string{"EXEC"},
+	)
+	replica.expectNoReply()
+}
+
+func TestReplicaWaitsForMasterDel(t *testing.T) {
+	server := startTestServer(t)
+	app := server.app
+	server.locked(func() {
+		role = SLAVE
+		db := dbs[0]
+		app.dbAdd(db, "key", &Value{value: "value", expiration: time.Now().Add(-time.Second)})
+
+		// the clients see the key expired, it is kept until the master deletes it
+		if _, ok := app.lookupKeyRead(db, "key"); ok {
+			t.Fatal("expired key returned to a client of the replica")
+		}
+		app.activeExpireCycle()
+		if _, ok := db.dict["key"]; !ok {
+			t.Fatal("the replica deleted an expired key by itself")
+		}
+		if stats.expiredKeys != 0 {
+			t.Fatalf("expired_keys %d on the replica", stats.expiredKeys)
+		}
+
+		// the commands of the master still see it, then its DEL removes it
+		master := &Client{flags: CLIENT_MASTER, db: db}
+		currentClient = master
+		defer func() { currentClient = nil }()
+		if _, ok := app.lookupKeyRead(db, "key"); !ok {
+			t.Fatal("expired key hidden from the master")
+		}
+		if reply := app.executeDEL(master, []string{"DEL", "key"}); string(reply) != ":1\r\n" {
+			t.Fatalf("DEL from the master: %q", reply)
+		}
+		if _, ok := db.dict["key"]; ok {
+			t.Fatal("key not deleted by the DEL of the master")
+		}
+	})
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+INFO: Latency monitor and latency histograms
+Events running for at least latency-monitor-threshold milliseconds are
+sampled in a time series per event, one sample per second (the worst one)
+and the last LATENCY_TS_LEN seconds with a spike. 0 disables the monitor.
+Events: command, fast-command (the CMD_FAST commands), expire-cycle and
+eviction-cycle.
+With latency-tracking every call is recorded in a histogram of its command,
+HDR-style: 2 significant digits from 1 nanosecond to 1 second. LATENCY
+HISTOGRAM replies them and INFO latencystats reports the percentiles of
+latency-tracking-info-percentiles.
+*/
+
+const (
+	// samples kept per event
+	LATENCY_TS_LEN = 160
+	// width of LATENCY GRAPH
+	LATENCY_GRAPH_COLS = 80
+
+	// latency histograms, values in nanoseconds
+	LATENCY_HISTOGRAM_MIN_VALUE = 1
+	LATENCY_HISTOGRAM_MAX_VALUE = int64(time.Second)
+	// 2 significant digits need 200 sub-buckets, rounded up to a power of 2
+	HISTOGRAM_SUB_BUCKET_COUNT_MAGNITUDE = 8
+	HISTOGRAM_SUB_BUCKET_COUNT           = 1 << HISTOGRAM_SUB_BUCKET_COUNT_MAGNITUDE
+	HISTOGRAM_SUB_BUCKET_HALF_COUNT      = HISTOGRAM_SUB_BUCKET_COUNT / 2
+	// buckets doubling from 256ns, enough for LATENCY_HISTOGRAM_MAX_VALUE
+	HISTOGRAM_BUCKET_COUNT = 23
+	// LATENCY HISTOGRAM reports the buckets from 1024ns, doubling
+	HISTOGRAM_REPORT_FIRST_VALUE = 1024
+)
+
+// for a latency spike, the worst one of its second
+type latencySample struct {
+	time    int64 // unix time in seconds
+	latency int64 // milliseconds
+}
+
+// for the spikes of an event, a ring of LATENCY_TS_LEN samples
+type latencyTimeSeries struct {
+	index   int
+	samples [LATENCY_TS_LEN]latencySample
+	// all time worst latency
+	max int64
+}
+
+// for the latencies of a command, in nanoseconds
+type latencyHistogram struct {
+	counts     []int64
+	totalCount int64
+}
+
+var (
+	// configuration parameters, see the configTable
+	latencyMonitorThreshold        int
+	latencyTracking                bool
+	latencyTrackingInfoPercentiles = []float64{50, 99, 99.9}
+
+	// event name -> its spikes
+	latencyEvents = make(map[string]*latencyTimeSeries)
+)
+
+// ROLE: start timing an event, the zero time when the monitor is disabled
+func latencyStartMonitor() time.Time {
+	if latencyMonitorThreshold == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// ROLE: duration of the event started with latencyStartMonitor
+func latencyEndMonitor(start time.Time) time.Duration {
+	if start.IsZero() {
+		return 0
+	}
+	return time.Since(start)
+}
+
+// ROLE: add a sample to the event if it reached latency-monitor-threshold
+// caller must hold the serverMutex
+func (app *App) latencyAddSampleIfNeeded(event string, duration time.Duration) {
+	if latencyMonitorThreshold == 0 || duration.Milliseconds() < int64(latencyMonitorThreshold) {
+		return
+	}
+	app.latencyAddSample(event, duration.Milliseconds())
+}
+
+// ROLE: add the sample, a second keeps its worst sample only
+func (app *App) latencyAddSample(event string, latency int64) {
+	series, ok := latencyEvents[event]
+	if !ok {
+		series = &latencyTimeSeries{}
+		latencyEvents[event] = series
+	}
+	series.max = max(series.max, latency)
+
+	now := time.Now().Unix()
+	previous := &series.samples[(series.index+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]
+	if previous.time == now {
+		previous.latency = max(previous.latency, latency)
+		return
+	}
+	series.samples[series.index] = latencySample{time: now, latency: latency}
+	series.index = (series.index + 1) % LATENCY_TS_LEN
+}
+
+// ROLE: samples of the series, the oldest first
+func (series *latencyTimeSeries) history() []latencySample {
+	var samples []latencySample
+	for i := 0; i < LATENCY_TS_LEN; i++ {
+		sample := series.samples[(series.index+i)%LATENCY_TS_LEN]
+		if sample.time != 0 {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// ROLE: event names sorted, for stable replies
+func latencyEventNames() []string {
+	names := make([]string, 0, len(latencyEvents))
+	for name := range latencyEvents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ROLE: handle LATENCY LATEST, HISTORY, RESET, GRAPH, DOCTOR, HISTOGRAM, HELP
+func (app *App) executeLATENCY(client *Client, commands []string) []byte {
+	subcommand := strings.ToUpper(commands[1])
+	switch {
+	case subcommand == "HELP" && len(commands) == 2:
+		return []byte(app.createRESPArray([]string{
+			"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"DOCTOR",
+			"    Return a human readable latency analysis report.",
+			"GRAPH <event>",
+			"    Return an ASCII latency graph for the <event> class.",
+			"HISTORY <event>",
+			"    Return time-latency samples for the <event> class.",
+			"LATEST",
+			"    Return the latest latency samples for all events.",
+			"RESET [<event> ...]",
+			"    Reset latency data of one or more <event> classes.",
+			"    (default: reset all data for all event classes)",
+			"HISTOGRAM [COMMAND ...]",
+			"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+			"    If no commands are specified then all histograms are replied.",
+		}))
+	case subcommand == "LATEST" && len(commands) == 2:
+		var events [][]byte
+		for _, name := range latencyEventNames() {
+			series := latencyEvents[name]
+			last := series.samples[(series.index+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]
+			events = append(events, app.createRESPArrayOfElements([][]byte{
+				app.createBulkStringResponse(name),
+				app.createIntegerResponse(int(last.time)),
+				app.createIntegerResponse(int(last.latency)),
+				app.createIntegerResponse(int(series.max)),
+			}))
+		}
+		return app.createRESPArrayOfElements(events)
+	case subcommand == "HISTORY" && len(commands) == 3:
+		var samples [][]byte
+		if series, ok := latencyEvents[commands[2]]; ok {
+			for _, sample := range series.history() {
+				samples = append(samples, app.createRESPArrayOfElements([][]byte{
+					app.createIntegerResponse(int(sample.time)),
+					app.createIntegerResponse(int(sample.latency)),
+				}))
+			}
+		}
+		return app.createRESPArrayOfElements(samples)
+	case subcommand == "RESET":
+		if len(commands) == 2 {
+			count := len(latencyEvents)
+			latencyEvents = make(map[string]*latencyTimeSeries)
+			return app.createIntegerResponse(count)
+		}
+		count := 0
+		for _, name := range commands[2:] {
+			if _, ok := latencyEvents[name]; ok {
+				delete(latencyEvents, name)
+				count++
+			}
+		}
+		return app.createIntegerResponse(count)
+	case subcommand == "GRAPH" && len(commands) == 3:
+		series, ok := latencyEvents[commands[2]]
+		if !ok {
+			return []byte(fmt.Sprintf("-ERR No samples available for event '%s'\r\n", commands[2]))
+		}
+		return app.createVerbatimStringResponse(client, app.latencyGraph(commands[2], series))
+	case subcommand == "DOCTOR" && len(commands) == 2:
+		return app.createVerbatimStringResponse(client, app.latencyDoctor())
+	case subcommand == "HISTOGRAM":
+		return app.latencyHistogramResponse(client, commands[2:])
+	}
+	return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try LATENCY HELP.\r\n", commands[1]))
+}
+
+// ROLE: LATENCY GRAPH, a sparkline of the spikes labeled with how long ago they happened
+func (app *App) latencyGraph(event string, series *latencyTimeSeries) string {
+	samples := series.history()
+	low, high := int64(math.MaxInt64), int64(0)
+	values := make([]int64, len(samples))
+	labels := make([]string, len(samples))
+	now := time.Now().Unix()
+	for i, sample := range samples {
+		low, high = min(low, sample.latency), max(high, sample.latency)
+		values[i] = sample.latency
+		elapsed := now - sample.time
+		switch {
+		case elapsed < 60:
+			labels[i] = fmt.Sprintf("%ds", elapsed)
+		case elapsed < 3600:
+			labels[i] = fmt.Sprintf("%dm", elapsed/60)
+		case elapsed < 3600*24:
+			labels[i] = fmt.Sprintf("%dh", elapsed/3600)
+		default:
+			labels[i] = fmt.Sprintf("%dd", elapsed/(3600*24))
+		}
+	}
+
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "%s - high %d ms, low %d ms (all time high %d ms)\n", event, high, low, series.max)
+	graph.WriteString(strings.Repeat("-", LATENCY_GRAPH_COLS) + "\n")
+	for start := 0; start < len(values); start += LATENCY_GRAPH_COLS {
+		if start != 0 {
+			graph.WriteString("\n")
+		}
+		end := min(start+LATENCY_GRAPH_COLS, len(values))
+		renderSparkline(&graph, values[start:end], labels[start:end], low, high)
+	}
+	return graph.String()
+}
+
+// ROLE: render the values as 4 rows of filled columns, then the vertical labels
+func renderSparkline(output *strings.Builder, values []int64, labels []string, low int64, high int64) {
+	const rows = 4
+	const charset = "_o#"
+	steps := len(charset) * rows
+	relativeMax := float64(high - low)
+	if relativeMax == 0 {
+		relativeMax = 1
+	}
+	line := make([]byte, len(values))
+	for row := 0; row < rows; row++ {
+		for i, value := range values {
+			step := int(float64(value-low) * float64(steps) / relativeMax)
+			step = max(0, min(step, steps-1))
+			line[i] = ' '
+			if index := step - (rows-row-1)*len(charset); index >= 0 && index < len(charset) {
+				line[i] = charset[index]
+			} else if index >= len(charset) {
+				line[i] = '|'
+			}
+		}
+		output.Write(line)
+		output.WriteString("\n")
+	}
+	// a blank line, then the labels written top to bottom
+	output.WriteString(strings.Repeat(" ", len(values)) + "\n")
+	longest := 0
+	for _, label := range labels {
+		longest = max(longest, len(label))
+	}
+	for row := 0; row < longest; row++ {
+		for i, label := range labels {
+			line[i] = ' '
+			if row < len(label) {
+				line[i] = label[row]
+			}
+		}
+		output.Write(line)
+		output.WriteString("\n")
+	}
+}
+
+// ROLE: LATENCY DOCTOR, a report of the spikes of every event and advices
+func (app *App) latencyDoctor() string {
+	if len(latencyEvents) == 0 {
+		if latencyMonitorThreshold == 0 {
+			return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. " +
+				"You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it.\n"
+		}
+		return "Dave, no latency spike was observed during the lifetime of this Redis instance, not in the slightest bit. " +
+			"I honestly think you ought to sleep tonight.\n"
+	}
+
+	var report strings.Builder
+	report.WriteString("Dave, I have observed latency spikes in this Redis instance. You don't mind talking about it, do you Dave?\n\n")
+	for i, name := range latencyEventNames() {
+		series := latencyEvents[name]
+		samples := series.history()
+		var sum int64
+		for _, sample := range samples {
+			sum += sample.latency
+		}
+		average := sum / int64(len(samples))
+		var deviation int64
+		for _, sample := range samples {
+			deviation += max(sample.latency-average, average-sample.latency)
+		}
+		deviation /= int64(len(samples))
+		period := float64(samples[len(samples)-1].time-samples[0].time) / float64(len(samples))
+		spikes := "latency spikes"
+		if len(samples) == 1 {
+			spikes = "latency spike"
+		}
+		fmt.Fprintf(&report, "%d. %s: %d %s (average %dms, mean deviation %dms, period %.2f sec). Worst all time event %dms.\n",
+			i+1, name, len(samples), spikes, average, deviation, period, series.max)
+	}
+
+	report.WriteString("\nI have a few advices for you:\n\n")
+	if _, ok := latencyEvents["command"]; ok {
+		if slowlogLogSlowerThan < 0 {
+			report.WriteString("- The slow log is disabled, enable it with CONFIG SET slowlog-log-slower-than <microseconds> to find the slow commands.\n")
+		} else {
+			report.WriteString("- Check your Slow Log (SLOWLOG GET) to understand what are the commands you are running which are too slow to execute.\n")
+		}
+	}
+	if _, ok := latencyEvents["fast-command"]; ok {
+		report.WriteString("- Commands doing O(1) or O(log(N)) work were slow, the process doesn't get enough CPU time: " +
+			"lower the system load, avoid noisy neighbours and check the garbage collector pauses.\n")
+	}
+	if _, ok := latencyEvents["expire-cycle"]; ok {
+		report.WriteString("- The active expire cycle was slow, many keys expire at the same time. Spread the TTLs of your keys over time.\n")
+	}
+	if _, ok := latencyEvents["eviction-cycle"]; ok {
+		report.WriteString("- Evicting keys was slow, the dataset is often over maxmemory. Raise maxmemory or write less data.\n")
+	}
+	return report.String()
+}
+
+// ROLE: LATENCY HISTOGRAM [command ...], for every command a map of calls and
+// histogram_usec, the cumulative count of calls faster than each bucket
+func (app *App) latencyHistogramResponse(client *Client, names []string) []byte {
+	var selected []*Command
+	if len(names) == 0 {
+		selected = commandsWithStats()
+	} else {
+		for _, name := range names {
+			if command := lookupCommandByFullName(name); command != nil && !slices.Contains(selected, command) {
+				selected = append(selected, command)
+			}
+		}
+	}
+
+	var pairs [][]byte
+	for _, command := range selected {
+		histogram := command.latencyHistogram
+		if histogram == nil {
+			continue
+		}
+		var buckets [][]byte
+		var previous int64
+		for _, bucket := range histogram.cumulativeBuckets(0) {
+			if bucket[1] > previous {
+				buckets = append(buckets, app.createIntegerResponse(int(bucket[0]/1000)), app.createIntegerResponse(int(bucket[1])))
+			}
+			previous = bucket[1]
+		}
+		pairs = append(pairs,
+			app.createBulkStringResponse(command.fullName),
+			app.createMapResponse(client, [][]byte{
+				app.createBulkStringResponse("calls"), app.createIntegerResponse(int(histogram.totalCount)),
+				app.createBulkStringResponse("histogram_usec"), app.createMapResponse(client, buckets),
+			}),
+		)
+	}
+	return app.createMapResponse(client, pairs)
+}
+
+// ROLE: latency_percentiles_usec_<command>:p50=...,p99=...,p99.9=... for INFO latencystats
+func (app *App) infoLatencystats(client *Client) []string {
+	var fields []string
+	for _, command := range commandsWithStats() {
+		histogram := command.latencyHistogram
+		if histogram == nil {
+			continue
+		}
+		percentiles := make([]string, 0, len(latencyTrackingInfoPercentiles))
+		for _, percentile := range latencyTrackingInfoPercentiles {
+			percentiles = append(percentiles, fmt.Sprintf("p%s=%.3f",
+				strconv.FormatFloat(percentile, 'f', -1, 64), float64(histogram.valueAtPercentile(percentile))/1000))
+		}
+		fields = append(fields, fmt.Sprintf("latency_percentiles_usec_%s:%s", command.fullName, strings.Join(percentiles, ",")))
+	}
+	return fields
+}
+
+// ROLE: parse latency-tracking-info-percentiles, ex: "50 99 99.9"
+func parseLatencyPercentiles(value string) ([]float64, error) {
+	var percentiles []float64
+	for _, field := range strings.Fields(value) {
+		percentile, err := strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(percentile) || percentile < 0 || percentile > 100 {
+			return nil, fmt.Errorf("latency-tracking-info-percentiles can't be parsed as a list of percentiles between 0 and 100")
+		}
+		percentiles = append(percentiles, percentile)
+	}
+	return percentiles, nil
+}
+
+func latencyPercentilesString(percentiles []float64) string {
+	fields := make([]string, 0, len(percentiles))
+	for _, percentile := range percentiles {
+		fields = append(fields, strconv.FormatFloat(percentile, 'f', -1, 64))
+	}
+	return strings.Join(fields, " ")
+}
+
+// ROLE: record the duration of a call, clamped to the histogram range
+func (histogram *latencyHistogram) record(duration time.Duration) {
+	if histogram.counts == nil {
+		histogram.counts = make([]int64, (HISTOGRAM_BUCKET_COUNT+1)*HISTOGRAM_SUB_BUCKET_HALF_COUNT)
+	}
+	value := max(LATENCY_HISTOGRAM_MIN_VALUE, min(duration.Nanoseconds(), LATENCY_HISTOGRAM_MAX_VALUE))
+	histogram.counts[histogramIndex(value)]++
+	histogram.totalCount++
+}
+
+// ROLE: highest value of the percentile, in nanoseconds
+func (histogram *latencyHistogram) valueAtPercentile(percentile float64) int64 {
+	countAtPercentile := max(int64(percentile/100*float64(histogram.totalCount)+0.5), 1)
+	var total int64
+	for index, count := range histogram.counts {
+		total += count
+		if total >= countAtPercentile {
+			return histogramHighestEquivalentValue(histogramValueFromIndex(index))
+		}
+	}
+	return 0
+}
+
+// ROLE: [bucket upper value in nanoseconds, calls up to it] from
+// HISTOGRAM_REPORT_FIRST_VALUE, doubling until every call is counted and
+// the bucket of lastValue is reached, ex: 0 for only the buckets with calls
+func (histogram *latencyHistogram) cumulativeBuckets(lastValue int64) [][2]int64 {
+	var buckets [][2]int64
+	var total int64
+	index := 0
+	for level := int64(HISTOGRAM_REPORT_FIRST_VALUE); total < histogram.totalCount || level <= lastValue; level *= 2 {
+		highest := histogramHighestEquivalentValue(level)
+		for ; index < len(histogram.counts) && histogramValueFromIndex(index) <= highest; index++ {
+			total += histogram.counts[index]
+		}
+		buckets = append(buckets, [2]int64{highest, total})
+	}
+	return buckets
+}
+
+// ROLE: index of the value in the counts: a bucket per power of 2 from 256,
+// each one split in HISTOGRAM_SUB_BUCKET_HALF_COUNT sub-buckets
+func histogramIndex(value int64) int {
+	bucket := bits.Len64(uint64(value)|(HISTOGRAM_SUB_BUCKET_COUNT-1)) - HISTOGRAM_SUB_BUCKET_COUNT_MAGNITUDE
+	subBucket := int(value >> bucket)
+	return (bucket+1)*HISTOGRAM_SUB_BUCKET_HALF_COUNT + subBucket - HISTOGRAM_SUB_BUCKET_HALF_COUNT
+}
+
+// ROLE: lowest value counted at the index
+func histogramValueFromIndex(index int) int64 {
+	bucket := index/HISTOGRAM_SUB_BUCKET_HALF_COUNT - 1
+	subBucket := index%HISTOGRAM_SUB_BUCKET_HALF_COUNT + HISTOGRAM_SUB_BUCKET_HALF_COUNT
+	if bucket < 0 {
+		subBucket -= HISTOGRAM_SUB_BUCKET_HALF_COUNT
+		bucket = 0
+	}
+	return int64(subBucket) << bucket
+}
+
+// ROLE: highest value counted with the value, in the same sub-bucket
+func histogramHighestEquivalentValue(value int64) int64 {
+	bucket := bits.Len64(uint64(value)|(HISTOGRAM_SUB_BUCKET_COUNT-1)) - HISTOGRAM_SUB_BUCKET_COUNT_MAGNITUDE
+	lowest := value >> bucket << bucket
+	return lowest + (1 << bucket) - 1
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyAddSample(t *testing.T) {
+	server := startTestServer(t, "latency-monitor-threshold 10")
+	client := server.connect(t)
+
+	server.locked(func() {
+		// under the threshold
+		server.app.latencyAddSampleIfNeeded("command", 9*time.Millisecond)
+		// a second keeps its worst sample
+		server.app.latencyAddSampleIfNeeded("command", 20*time.Millisecond)
+		server.app.latencyAddSampleIfNeeded("command", 50*time.Millisecond)
+		server.app.latencyAddSampleIfNeeded("command", 30*time.Millisecond)
+		server.app.latencyAddSampleIfNeeded("expire-cycle", 15*time.Millisecond)
+	})
+	latest := client.do("LATENCY", "LATEST").([]any)
+	if len(latest) != 2 {
+		t.Fatalf("LATENCY LATEST: %v", latest)
+	}
+	command := latest[0].([]any)
+	expectReply(t, []any{command[0], command[2], command[3]}, []any{"command", int64(50), int64(50)})
+	if now := time.Now().Unix(); command[1].(int64) > now || command[1].(int64) < now-1 {
+		t.Fatalf("time of the sample %v", command[1])
+	}
+	history := client.do("LATENCY", "HISTORY", "command").([]any)
+	if len(history) != 1 || history[0].([]any)[1] != int64(50) {
+		t.Fatalf("LATENCY HISTORY: %v", history)
+	}
+	expectReply(t, client.do("LATENCY", "HISTORY", "nosuch"), []any{})
+
+	expectReply(t, client.do("LATENCY", "RESET", "expire-cycle", "nosuch"), int64(1))
+	expectReply(t, client.do("LATENCY", "RESET"), int64(1))
+	expectReply(t, client.do("LATENCY", "LATEST"), []any{})
+
+	// the monitor is disabled with a 0 threshold
+	client.do("CONFIG", "SET", "latency-monitor-threshold", "0")
+	server.locked(func() { server.app.latencyAddSampleIfNeeded("command", time.Second) })
+	expectReply(t, client.do("LATENCY", "LATEST"), []any{})
+}
+
+func TestLatencyTimeSeriesWraps(t *testing.T) {
+	series := &latencyTimeSeries{}
+	start := time.Now().Unix() - 2*LATENCY_TS_LEN
+	for i := 0; i < LATENCY_TS_LEN+10; i++ {
+		series.samples[series.index] = latencySample{time: start + int64(i), latency: int64(i)}
+		series.index = (series.index + 1) % LATENCY_TS_LEN
+	}
+	samples := series.history()
+	if len(samples) != LATENCY_TS_LEN || samples[0].latency != 10 || samples[len(samples)-1].latency != LATENCY_TS_LEN+9 {
+		t.Fatalf("%d samples from %v to %v", len(samples), samples[0], samples[len(samples)-1])
+	}
+}
+
+func TestLatencyGraphAndDoctor(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+
+	if doctor := client.do("LATENCY", "DOCTOR").(string); !strings.Contains(doctor, "Latency monitoring is disabled") {
+		t.Fatalf("LATENCY DOCTOR while disabled: %s", doctor)
+	}
+	client.do("CONFIG", "SET", "latency-monitor-threshold", "1")
+	if doctor := client.do("LATENCY", "DOCTOR").(string); !strings.Contains(doctor, "no latency spike was observed") {
+		t.Fatalf("LATENCY DOCTOR without spikes: %s", doctor)
+	}
+	expectReply(t, client.do("LATENCY", "GRAPH", "command"), respError("ERR No samples available for event 'command'"))
+
+	server.locked(func() {
+		server.app.latencyAddSample("command", 10)
+		server.app.latencyAddSample("eviction-cycle", 5)
+	})
+	graph := client.do("LATENCY", "GRAPH", "command").(string)
+	if !strings.HasPrefix(graph, "command - high 10 ms, low 10 ms (all time high 10 ms)\n"+strings.Repeat("-", LATENCY_GRAPH_COLS)+"\n") {
+		t.Fatalf("LATENCY GRAPH:\n%s", graph)
+	}
+	doctor := client.do("LATENCY", "DOCTOR").(string)
+	for _, want := range []string{
+		"1. command: 1 latency spike (average 10ms, mean deviation 0ms, period 0.00 sec). Worst all time event 10ms.",
+		"2. eviction-cycle: 1 latency spike",
+		"Check your Slow Log", "Evicting keys was slow",
+	} {
+		if !strings.Contains(doctor, want) {
+			t.Fatalf("LATENCY DOCTOR without %q:\n%s", want, doctor)
+		}
+	}
+	if strings.Contains(doctor, "active expire cycle") {
+		t.Fatalf("LATENCY DOCTOR advices an event without spikes:\n%s", doctor)
+	}
+}
+
+func TestRenderSparkline(t *testing.T) {
+	var output strings.Builder
+	renderSparkline(&output, []int64{0, 5, 10}, []string{"2s", "1s", "0s"}, 0, 10)
+	want := strings.Join([]string{
+		"  #",
+		" _|",
+		" ||",
+		"_||",
+		"   ",
+		"210",
+		"sss",
+	}, "\n") + "\n"
+	if output.String() != want {
+		t.Fatalf("sparkline:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	// every value is counted in a sub-bucket of 2 significant digits
+	for _, value := range []int64{1, 255, 256, 1000, 1023, 1024, 123456, 999999999, LATENCY_HISTOGRAM_MAX_VALUE} {
+		lowest := histogramValueFromIndex(histogramIndex(value))
+		highest := histogramHighestEquivalentValue(value)
+		if lowest > value || highest < value || float64(highest-lowest) > float64(value)/100 {
+			t.Fatalf("%d counted in [%d, %d]", value, lowest, highest)
+		}
+	}
+
+	histogram := &latencyHistogram{}
+	for i := 1; i <= 100; i++ {
+		histogram.record(time.Duration(i) * time.Microsecond)
+	}
+	// clamped to the range
+	histogram.record(0)
+	histogram.record(time.Minute)
+	if histogram.totalCount != 102 {
+		t.Fatalf("%d calls", histogram.totalCount)
+	}
+	for percentile, want := range map[float64]int64{50: 50000, 99: 100000, 100: int64(time.Second)} {
+		if value := histogram.valueAtPercentile(percentile); value < want || float64(value-want) > float64(want)/100 {
+			t.Fatalf("p%v: %dns, want %dns", percentile, value, want)
+		}
+	}
+
+	buckets := histogram.cumulativeBuckets(0)
+	if last := buckets[len(buckets)-1]; last[1] != 102 || last[0] < int64(time.Second) {
+		t.Fatalf("last bucket %v", last)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i][1] < buckets[i-1][1] {
+			t.Fatalf("buckets not cumulative: %v", buckets)
+		}
+	}
+}
+
+func TestLatencyHistogramCommand(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	client.do("SET", "key", "value")
+	client.do("SET", "key", "value")
+
+	reply := client.do("LATENCY", "HISTOGRAM", "set", "get", "nosuch", "SET").([]any)
+	// only the commands with calls, once each
+	if len(reply) != 2 || reply[0] != "set" {
+		t.Fatalf("LATENCY HISTOGRAM: %v", reply)
+	}
+	histogram := reply[1].([]any)
+	if histogram[0] != "calls" || histogram[1] != int64(2) || histogram[2] != "histogram_usec" {
+		t.Fatalf("histogram of set: %v", histogram)
+	}
+	buckets := histogram[3].([]any)
+	if len(buckets) == 0 || buckets[len(buckets)-1] != int64(2) {
+		t.Fatalf("buckets of set: %v", buckets)
+	}
+
+	info := parseInfo(t, client.do("INFO", "latencystats"))
+	if set := info["Latencystats"]["latency_percentiles_usec_set"]; !strings.HasPrefix(set, "p50=") || !strings.Contains(set, ",p99.9=") {
+		t.Fatalf("latency_percentiles_usec_set:%s", set)
+	}
+	client.do("CONFIG", "SET", "latency-tracking-info-percentiles", "90")
+	info = parseInfo(t, client.do("INFO", "latencystats"))
+	if set := info["Latencystats"]["latency_percentiles_usec_set"]; !strings.HasPrefix(set, "p90=") || strings.Contains(set, ",") {
+		t.Fatalf("latency_percentiles_usec_set with one percentile:%s", set)
+	}
+
+	// nothing recorded without latency-tracking
+	client.do("CONFIG", "SET", "latency-tracking", "no")
+	client.do("GET", "key")
+	reply = client.do("LATENCY", "HISTOGRAM", "get").([]any)
+	expectReply(t, reply, []any{})
+}
+
+func TestParseLatencyPercentiles(t *testing.T) {
+	percentiles, err := parseLatencyPercentiles("50 99 99.9 100 0")
+	if err != nil || len(percentiles) != 5 || percentiles[2] != 99.9 {
+		t.Fatalf("%v %v", percentiles, err)
+	}
+	if percentiles, err := parseLatencyPercentiles(""); err != nil || len(percentiles) != 0 {
+		t.Fatalf("empty list: %v %v", percentiles, err)
+	}
+	for _, value := range []string{"101", "-1", "x", "50 nan"} {
+		if _, err := parseLatencyPercentiles(value); err == nil {
+			t.Fatalf("%q accepted", value)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+INFO: Listening sockets
+The server listens on port (and tls-port) of every bind address and on the
+unixsocket, and answers HTTP on metrics-port. An address prefixed with '-' is optional, failing to bind it is
+not an error, ex: "127.0.0.1 -::1" works on a host without IPv6.
+With protected-mode and no password for the default user, only the loopback
+interface and the unix socket are accepted.
+*/
+
+const PROTECTED_MODE_ERROR = "-DENIED Redis is running in protected mode because protected mode is enabled and no password is set for the default user. " +
+	"In this mode connections are only accepted from the loopback interface. " +
+	"If you want to connect from external computers to Redis you may adopt one of the following solutions: " +
+	"1) Just disable protected mode sending the command 'CONFIG SET protected-mode no' from the loopback interface " +
+	"by connecting to Redis from the same host the server is running, however MAKE SURE Redis is not publicly accessible from internet if you do so. " +
+	"2) Alternatively you can just disable the protected mode by setting the protected-mode option to 'no', and then restarting the server. " +
+	"3) If you started the server manually just for testing, restart it with the '--protected-mode no' option. " +
+	"4) Set up an authentication password for the default user. " +
+	"NOTE: You only need to do one of the above things in order for the server to start accepting connections from the outside.\r\n"
+
+// runtime value of protected-mode, changed with CONFIG SET
+var protectedMode bool
+
+// listeners accepting connections, closed by the shutdown
+var serverListeners []net.Listener
+
+// for a bind address
+type bindAddress struct {
+	host     string
+	optional bool
+}
+
+// ROLE: parse the bind addresses, ex: "127.0.0.1 -::1"
+// * and ::* are all the IPv4 and IPv6 interfaces
+func parseBindAddresses(value string) ([]bindAddress, error) {
+	var addresses []bindAddress
+	for _, field := range strings.Fields(value) {
+		address := bindAddress{host: field}
+		if strings.HasPrefix(field, "-") {
+			address.host = field[1:]
+			address.optional = true
+		}
+		switch address.host {
+		case "*":
+			address.host = "0.0.0.0"
+		case "::*":
+			address.host = "::"
+		}
+		if net.ParseIP(address.host) == nil && address.host != "localhost" {
+			return nil, fmt.Errorf("invalid bind address '%s'", field)
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("bind is empty")
+	}
+	return addresses, nil
+}
+
+// ROLE: open the listeners of port, tls-port and unixsocket and accept their connections
+func (app *App) openListeners() error {
+	bindAddresses, err := parseBindAddresses(bind)
+	if err != nil {
+		return fmt.Errorf("invalid bind: %w", err)
+	}
+	var listeners []net.Listener
+	// port 0 disables the plain TCP listeners
+	if port != 0 {
+		// establish socket connection
+		portListeners, err := app.listenToPort(bindAddresses, strconv.Itoa(port), nil)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, portListeners...)
+	}
+	if tlsPort != 0 {
+		config, err := app.tlsServerConfig()
+		if err != nil {
+			closeAll(listeners)
+			return fmt.Errorf("failed to configure TLS: %w", err)
+		}
+		tlsListeners, err := app.listenToPort(bindAddresses, strconv.Itoa(tlsPort), config)
+		if err != nil {
+			closeAll(listeners)
+			return err
+		}
+		listeners = append(listeners, tlsListeners...)
+	}
+	if unixsocket != "" {
+		listner, err := app.listenToUnixSocket(unixsocket, unixsocketperm)
+		if err != nil {
+			closeAll(listeners)
+			return err
+		}
+		listeners = append(listeners, listner)
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("no listener configured, set port, tls-port or unixsocket")
+	}
+
+	serverListeners = listeners
+	for _, listner := range listeners {
+		go app.acceptConnections(listner)
+	}
+	return nil
+}
+
+// ROLE: serve HTTP on metrics-port of every bind address: /metrics, /healthz, /readyz
+// they stay open until the process exits, the shutdown doesn't close them
+func (app *App) openHTTPListeners() error {
+	if metricsPort == 0 {
+		return nil
+	}
+	bindAddresses, err := parseBindAddresses(bind)
+	if err != nil {
+		return fmt.Errorf("invalid bind: %w", err)
+	}
+	listeners, err := app.listenToPort(bindAddresses, strconv.Itoa(metricsPort), nil)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", app.serveMetrics)
+	mux.HandleFunc("GET /healthz", app.serveHealthz)
+	mux.HandleFunc("GET /readyz", app.serveReadyz)
+	for _, listner := range listeners {
+		go http.Serve(listner, mux)
+	}
+	return nil
+}
+
+// ROLE: stop accepting connections, the unix socket file is removed
+func (app *App) closeListeners() {
+	closeAll(serverListeners)
+	serverListeners = nil
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, listner := range listeners {
+		listner.Close()
+	}
+}
+
+// ROLE: listen on the port of every bind address, over TLS with a config
+// IPv4 and IPv6 addresses get their own socket, so "* ::*" doesn't collide
+func (app *App) listenToPort(addresses []bindAddress, port string, config *tls.Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range addresses {
+		network := "tcp"
+		if ip := net.ParseIP(address.host); ip != nil && ip.To4() != nil {
+			network = "tcp4"
+		} else if ip != nil {
+			network = "tcp6"
+		}
+		hostPort := net.JoinHostPort(address.host, port)
+
+		var listner net.Listener
+		var err error
+		if config != nil {
+			listner, err = tls.Listen(network, hostPort, config)
+		} else {
+			listner, err = net.Listen(network, hostPort)
+		}
+		if err != nil {
+			if address.optional {
+				app.logWarning("skipping optional bind address", "address", hostPort, "error", err)
+				continue
+			}
+			closeAll(listeners)
+			return nil, fmt.Errorf("failed to bind to %s: %w", hostPort, err)
+		}
+		app.logNotice("server listening", "address", hostPort)
+		listeners = append(listeners, listner)
+	}
+	return listeners, nil
+}
+
+// ROLE: listen on the unix socket, a stale socket file of a previous run is removed
+// unixsocketperm is octal, ex: 700, 0 keeps the permissions of the umask
+func (app *App) listenToUnixSocket(path string, permissions string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(permissions, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unixsocketperm '%s'", permissions)
+	}
+	os.Remove(path)
+	listner, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the unix socket %s: %w", path, err)
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+			listner.Close()
+			return nil, fmt.Errorf("failed to set the permissions of the unix socket %s: %w", path, err)
+		}
+	}
+	app.logNotice("server listening", "unixsocket", path)
+	return listner, nil
+}
+
+// ROLE: enable TCP keepalive on the client connection, tcp-keepalive 0 disables it
+// caller must hold the serverMutex
+func (app *App) setTCPKeepAlive(connection net.Conn) {
+	if tlsConnection, ok := connection.(*tls.Conn); ok {
+		connection = tlsConnection.NetConn()
+	}
+	tcpConnection, ok := connection.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if tcpKeepalive == 0 {
+		tcpConnection.SetKeepAlive(false)
+		return
+	}
+	tcpConnection.SetKeepAlive(true)
+	tcpConnection.SetKeepAlivePeriod(time.Duration(tcpKeepalive) * time.Second)
+}
+
+// ROLE: tell if the connection is refused by protected-mode
+// caller must hold the serverMutex
+func (app *App) protectedModeRefuses(connection net.Conn) bool {
+	if !protectedMode || defaultUser.flags&USER_FLAG_NOPASS == 0 {
+		return false
+	}
+	address, ok := connection.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		// unix socket
+		return false
+	}
+	return !address.IP.IsLoopback()
+}
+
+// ROLE: parse a yes/no parameter
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("argument must be 'yes' or 'no'")
+}
+
+func yesNoString(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
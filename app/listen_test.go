@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestParseBindAddresses(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []bindAddress
+		valid bool
+	}{
+		{"127.0.0.1", []bindAddress{{"127.0.0.1", false}}, true},
+		{"127.0.0.1 -::1", []bindAddress{{"127.0.0.1", false}, {"::1", true}}, true},
+		{"* ::*", []bindAddress{{"0.0.0.0", false}, {"::", false}}, true},
+		{"-* localhost", []bindAddress{{"0.0.0.0", true}, {"localhost", false}}, true},
+		{"example.com", nil, false},
+		{"127.0.0.1 -", nil, false},
+		{"", nil, false},
+		{"   ", nil, false},
+	}
+	for _, test := range tests {
+		addresses, err := parseBindAddresses(test.value)
+		if (err == nil) != test.valid {
+			t.Fatalf("%q: error %v", test.value, err)
+		}
+		if test.valid && !equalBindAddresses(addresses, test.want) {
+			t.Fatalf("%q: got %v, want %v", test.value, addresses, test.want)
+		}
+	}
+}
+
+func equalBindAddresses(a, b []bindAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ROLE: a free TCP port of the loopback interface
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// ROLE: open the listeners of the config, closed at the end of the test
+func (server *testServer) openListeners(t *testing.T) error {
+	t.Helper()
+	var err error
+	server.locked(func() { err = server.app.openListeners() })
+	t.Cleanup(func() { server.locked(server.app.closeListeners) })
+	return err
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	// the stale socket file of a previous run
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := startTestServer(t, "port 0", "unixsocket "+path, "unixsocketperm 700", "protected-mode yes")
+	if err := server.openListeners(t); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0700 {
+		t.Fatalf("unix socket file: %v %v", info, err)
+	}
+
+	connection, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	client := &testClient{t: t, connection: connection, reader: bufio.NewReader(connection)}
+	// protected-mode accepts the unix socket like the loopback interface
+	expectReply(t, client.do("SET", "key", "value"), "OK")
+	expectReply(t, client.do("GET", "key"), "value")
+
+	// the shutdown removes the socket file
+	server.locked(server.app.closeListeners)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("unix socket file after closing: %v", err)
+	}
+}
+
+func TestOpenListeners(t *testing.T) {
+	tests := []struct {
+		name   string
+		config []string
+		valid  bool
+	}{
+		{"optional address skipped", []string{"bind 127.0.0.1 -192.0.2.1"}, true},
+		{"required address", []string{"bind 127.0.0.1 192.0.2.1"}, false},
+		{"unix socket failure", []string{"bind 127.0.0.1", "unixsocket " + filepath.Join(t.TempDir(), "missing", "redis.sock")}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			port := freePort(t)
+			server := startTestServer(t, append([]string{"port " + port}, test.config...)...)
+			err := server.openListeners(t)
+			if (err == nil) != test.valid {
+				t.Fatalf("open listeners: %v", err)
+			}
+			connection, dialErr := net.Dial("tcp", "127.0.0.1:"+port)
+			if dialErr == nil {
+				connection.Close()
+			}
+			// a failure closes the listeners opened before it
+			if (dialErr == nil) != test.valid {
+				t.Fatalf("connect to port %s: %v", port, dialErr)
+			}
+		})
+	}
+
+	server := startTestServer(t, "port 0")
+	if err := server.openListeners(t); err == nil {
+		t.Fatal("open listeners without any port or unixsocket")
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+INFO: Logging
+The server logs with log/slog at the Redis levels: debug, verbose, notice and
+warning. loglevel sets the minimum level, nothing turns the log off. The lines
+go to the logfile, stdout when it's empty, in the Redis format:
+	12345:M 19 Oct 2026 10:31:10.112 * server listening address=127.0.0.1:6379
+or as JSON objects with log-format json:
+	{"time":"2026-10-19T10:31:10.112+02:00","level":"notice","msg":"server listening","pid":12345,"role":"master","address":"127.0.0.1:6379"}
+With syslog-enabled the messages are also sent to syslog.
+The commands and the data are never logged, MONITOR shows the commands.
+*/
+
+// log levels
+const (
+	LL_DEBUG   = slog.LevelDebug
+	LL_VERBOSE = slog.Level(-2)
+	LL_NOTICE  = slog.LevelInfo
+	LL_WARNING = slog.LevelWarn
+	LL_NOTHING = slog.Level(1 << 30)
+)
+
+// for a log level: its loglevel name and its mark in the Redis format
+type logLevelInfo struct {
+	name  string
+	level slog.Level
+	mark  byte
+}
+
+var logLevels = []logLevelInfo{
+	{"debug", LL_DEBUG, '.'},
+	{"verbose", LL_VERBOSE, '-'},
+	{"notice", LL_NOTICE, '*'},
+	{"warning", LL_WARNING, '#'},
+	{"nothing", LL_NOTHING, ' '},
+}
+
+var syslogFacilities = map[string]syslog.Priority{
+	"user":   syslog.LOG_USER,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+var (
+	// configuration parameters, see the configTable
+	logFile        string
+	syslogEnabled  bool
+	syslogIdent    string
+	syslogFacility string
+	// minimum level logged, set with loglevel
+	logMinLevel slog.LevelVar
+	// log-format json, read by the goroutines logging without the serverMutex
+	logFormatJSON atomic.Bool
+)
+
+// for the server log, writes the Redis format or JSON and sends to syslog
+type logHandler struct {
+	// shared by the handlers derived with WithAttrs
+	mutex  *sync.Mutex
+	out    io.Writer
+	json   slog.Handler
+	syslog *syslog.Writer
+	// "pid:role" prefix of the Redis format
+	prefix string
+	// attributes added with WithAttrs, group of WithGroup
+	attrs []slog.Attr
+	group string
+}
+
+// ROLE: create the server logger from the logfile, log-format and syslog parameters
+func newServerLogger() (*slog.Logger, error) {
+	var out io.Writer = os.Stdout
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Can't open the log file: %w", err)
+		}
+		out = file
+	}
+	roleMark := "M"
+	if role == SLAVE {
+		roleMark = "S"
+	}
+	handler := &logHandler{
+		mutex:  &sync.Mutex{},
+		out:    out,
+		prefix: fmt.Sprintf("%d:%s", os.Getpid(), roleMark),
+	}
+	handler.json = slog.NewJSONHandler(out, &slog.HandlerOptions{
+		Level:       &logMinLevel,
+		ReplaceAttr: replaceLogLevelAttr,
+	}).WithAttrs([]slog.Attr{slog.Int("pid", os.Getpid()), slog.String("role", role)})
+	if syslogEnabled {
+		writer, err := syslog.New(syslogFacilities[syslogFacility]|syslog.LOG_INFO, syslogIdent)
+		if err != nil {
+			return nil, fmt.Errorf("Can't connect to syslog: %w", err)
+		}
+		handler.syslog = writer
+	}
+	return slog.New(handler), nil
+}
+
+func (handler *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= logMinLevel.Level()
+}
+
+func (handler *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	var message strings.Builder
+	message.WriteString(record.Message)
+	for _, attr := range handler.attrs {
+		appendLogAttr(&message, handler.group, attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		appendLogAttr(&message, handler.group, attr)
+		return true
+	})
+	if handler.syslog != nil {
+		handler.sendToSyslog(record.Level, message.String())
+	}
+	if logFormatJSON.Load() {
+		return handler.json.Handle(ctx, record)
+	}
+	line := fmt.Sprintf("%s %s %c %s\n", handler.prefix, record.Time.Format("02 Jan 2006 15:04:05.000"), logLevelOf(record.Level).mark, message.String())
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	_, err := io.WriteString(handler.out, line)
+	return err
+}
+
+func (handler *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *handler
+	derived.attrs = append(append([]slog.Attr{}, handler.attrs...), attrs...)
+	derived.json = handler.json.WithAttrs(attrs)
+	return &derived
+}
+
+func (handler *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return handler
+	}
+	derived := *handler
+	derived.group = handler.group + name + "."
+	derived.json = handler.json.WithGroup(name)
+	return &derived
+}
+
+// ROLE: send the message to syslog with the priority of the level
+func (handler *logHandler) sendToSyslog(level slog.Level, message string) {
+	switch {
+	case level >= LL_WARNING:
+		handler.syslog.Warning(message)
+	case level >= LL_NOTICE:
+		handler.syslog.Notice(message)
+	case level >= LL_VERBOSE:
+		handler.syslog.Info(message)
+	default:
+		handler.syslog.Debug(message)
+	}
+}
+
+// ROLE: append " key=value" to the message, the value is quoted when needed
+func appendLogAttr(message *strings.Builder, group string, attr slog.Attr) {
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, member := range attr.Value.Group() {
+			appendLogAttr(message, group+attr.Key+".", member)
+		}
+		return
+	}
+	value := attr.Value.Resolve().String()
+	if value == "" || strings.ContainsAny(value, " \"=\t\r\n") {
+		value = strconv.Quote(value)
+	}
+	message.WriteString(" " + group + attr.Key + "=" + value)
+}
+
+// ROLE: write the levels with their loglevel name in the JSON log
+func replaceLogLevelAttr(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := attr.Value.Any().(slog.Level); ok {
+			attr.Value = slog.StringValue(logLevelOf(level).name)
+		}
+	}
+	return attr
+}
+
+// ROLE: the highest log level at or below the level
+func logLevelOf(level slog.Level) logLevelInfo {
+	info := logLevels[0]
+	for _, candidate := range logLevels {
+		if level >= candidate.level {
+			info = candidate
+		}
+	}
+	return info
+}
+
+// ROLE: parse the loglevel value
+func parseLogLevel(value string) (slog.Level, error) {
+	for _, info := range logLevels {
+		if strings.EqualFold(value, info.name) {
+			return info.level, nil
+		}
+	}
+	names := make([]string, len(logLevels))
+	for i, info := range logLevels {
+		names[i] = info.name
+	}
+	return 0, fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(names, ", "))
+}
+
+// ROLE: log at the level, the arguments are key value pairs
+// ex: app.logNotice("server listening", "address", hostPort)
+
+func (app *App) logDebug(message string, args ...any) {
+	app.logger.Log(context.Background(), LL_DEBUG, message, args...)
+}
+
+func (app *App) logVerbose(message string, args ...any) {
+	app.logger.Log(context.Background(), LL_VERBOSE, message, args...)
+}
+
+func (app *App) logNotice(message string, args ...any) {
+	app.logger.Log(context.Background(), LL_NOTICE, message, args...)
+}
+
+func (app *App) logWarning(message string, args ...any) {
+	app.logger.Log(context.Background(), LL_WARNING, message, args...)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogLevels(t *testing.T) {
+	for _, info := range logLevels {
+		for _, name := range []string{info.name, strings.ToUpper(info.name)} {
+			if level, err := parseLogLevel(name); err != nil || level != info.level {
+				t.Fatalf("%s: %v %v", name, level, err)
+			}
+		}
+		if logLevelOf(info.level) != info {
+			t.Fatalf("level of %s: %v", info.name, logLevelOf(info.level))
+		}
+	}
+	if _, err := parseLogLevel("info"); err == nil || err.Error() != "argument(s) must be one of the following: debug, verbose, notice, warning, nothing" {
+		t.Fatalf("invalid level: %v", err)
+	}
+	// the slog levels in between get the level below them
+	for level, want := range map[slog.Level]string{
+		slog.Level(-10): "debug", slog.Level(-3): "debug", slog.Level(-1): "verbose",
+		slog.LevelInfo + 1: "notice", slog.LevelError: "warning",
+	} {
+		if name := logLevelOf(level).name; name != want {
+			t.Fatalf("level %v: %s, want %s", level, name, want)
+		}
+	}
+
+	// JSON gets the loglevel names, the attributes of a group keep their value
+	attr := replaceLogLevelAttr(nil, slog.Any(slog.LevelKey, LL_VERBOSE))
+	expectReply(t, attr.Value.String(), "verbose")
+	attr = replaceLogLevelAttr([]string{"request"}, slog.Any(slog.LevelKey, LL_VERBOSE))
+	expectReply(t, attr.Value.Any(), LL_VERBOSE)
+}
+
+func TestLogRedisFormat(t *testing.T) {
+	var out bytes.Buffer
+	handler := &logHandler{mutex: &sync.Mutex{}, out: &out, json: slog.NewJSONHandler(&out, nil), prefix: "12345:M"}
+	at := time.Date(2026, 10, 19, 10, 31, 10, 112_000_000, time.Local)
+
+	logged := func(handler slog.Handler, level slog.Level, message string, args ...any) string {
+		out.Reset()
+		record := slog.NewRecord(at, level, message, 0)
+		record.Add(args...)
+		if err := handler.Handle(context.Background(), record); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	expectReply(t, logged(handler, LL_NOTICE, "server listening", "address", "127.0.0.1:6379"),
+		"12345:M 19 Oct 2026 10:31:10.112 * server listening address=127.0.0.1:6379\n")
+	expectReply(t, logged(handler, LL_WARNING, "quoted", "empty", "", "text", `a "b"`, "equal", "a=b", "count", 3),
+		`12345:M 19 Oct 2026 10:31:10.112 # quoted empty="" text="a \"b\"" equal="a=b" count=3`+"\n")
+	expectReply(t, logged(handler, LL_DEBUG, "debug"), "12345:M 19 Oct 2026 10:31:10.112 . debug\n")
+	expectReply(t, logged(handler, LL_VERBOSE, "verbose"), "12345:M 19 Oct 2026 10:31:10.112 - verbose\n")
+
+	// the attributes of the logger come first, the groups prefix the keys
+	derived := handler.WithAttrs([]slog.Attr{slog.String("client", "id=1")}).WithGroup("replica")
+	expectReply(t, logged(derived, LL_NOTICE, "synced", "offset", 42, slog.Group("rdb", "size", 10)),
+		`12345:M 19 Oct 2026 10:31:10.112 * synced replica.client="id=1" replica.offset=42 replica.rdb.size=10`+"\n")
+}
+
+func TestLogFormatJSON(t *testing.T) {
+	server := startTestServer(t, "log-format json")
+	client := server.connect(t)
+	logFile := filepath.Join(server.dir, "redis.log")
+	lastLine := func() string {
+		content, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		return lines[len(lines)-1]
+	}
+
+	server.app.logWarning("disk full", "dir", server.dir)
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lastLine()), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["level"] != "warning" || entry["msg"] != "disk full" || entry["role"] != "master" || entry["dir"] != server.dir ||
+		entry["pid"] != float64(os.Getpid()) {
+		t.Fatalf("JSON log entry: %v", entry)
+	}
+
+	// loglevel filters both formats
+	client.do("CONFIG", "SET", "loglevel", "warning")
+	server.app.logNotice("filtered")
+	if strings.Contains(lastLine(), "filtered") {
+		t.Fatal("notice logged with loglevel warning")
+	}
+	client.do("CONFIG", "SET", "loglevel", "notice", "log-format", "legacy")
+	server.app.logNotice("legacy line")
+	if line := lastLine(); !strings.HasSuffix(line, " * legacy line") {
+		t.Fatalf("legacy log line: %s", line)
+	}
+	expectReply(t, client.do("CONFIG", "SET", "log-format", "xml"),
+		respError("ERR CONFIG SET failed (possibly related to argument 'log-format') - argument(s) must be one of the following: legacy, json"))
+
+	// the goroutines log without the serverMutex while the format changes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			server.app.logNotice("background")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		client.do("CONFIG", "SET", "log-format", []string{"json", "legacy"}[i%2])
+	}
+	<-done
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+INFO: Prometheus metrics
+With metrics-port the server answers HTTP on the bind addresses, /metrics
+returns the metrics in the Prometheus text format, ex:
+	# HELP redis_commands_total Calls of the command.
+	# TYPE redis_commands_total counter
+	redis_commands_total{cmd="get"} 12
+The values are the counters of INFO, read under the serverMutex like a
+command, so a scrape sees a consistent state. The names follow the
+redis_exporter ones, the dashboards built for it work.
+*/
+
+// configuration parameter, see the configTable, 0 disables the HTTP listeners
+var metricsPort int
+
+// for the metrics of a scrape
+type metricsWriter struct {
+	out strings.Builder
+}
+
+// ROLE: write the HELP and TYPE lines of a metric, type is counter, gauge or histogram
+func (writer *metricsWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(&writer.out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// ROLE: write a sample, labels are name value pairs
+func (writer *metricsWriter) sample(name string, value float64, labels ...string) {
+	writer.out.WriteString(name)
+	if len(labels) > 0 {
+		writer.out.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				writer.out.WriteByte(',')
+			}
+			fmt.Fprintf(&writer.out, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		writer.out.WriteByte('}')
+	}
+	writer.out.WriteByte(' ')
+	writer.out.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	writer.out.WriteByte('\n')
+}
+
+// ROLE: a metric with a single sample
+func (writer *metricsWriter) metric(name string, kind string, help string, value float64) {
+	writer.family(name, kind, help)
+	writer.sample(name, value)
+}
+
+// ROLE: escape the backslashes, quotes and new lines of a label value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// ROLE: serve /metrics
+func (app *App) serveMetrics(writer http.ResponseWriter, request *http.Request) {
+	// the load holds the serverMutex, only the loading state until it's done
+	body := loadingMetricsText()
+	if !loading.Load() {
+		serverMutex.Lock()
+		body = app.metricsText()
+		serverMutex.Unlock()
+	}
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writer.Write([]byte(body))
+}
+
+// ROLE: every metric in the Prometheus text format
+// caller must hold the serverMutex
+func (app *App) metricsText() string {
+	writer := &metricsWriter{}
+	writer.metric("redis_up", "gauge", "Information about the Redis instance.", 1)
+	writer.metric("redis_uptime_in_seconds", "gauge", "Seconds since the server started.", time.Since(serverStartTime).Seconds())
+	app.metricsClients(writer)
+	app.metricsMemory(writer)
+	app.metricsPersistence(writer)
+	app.metricsStats(writer)
+	app.metricsReplication(writer)
+	app.metricsCommands(writer)
+	app.metricsKeyspace(writer)
+	return writer.out.String()
+}
+
+// ROLE: the metrics while the RDB file is loading
+func loadingMetricsText() string {
+	writer := &metricsWriter{}
+	writer.metric("redis_up", "gauge", "Information about the Redis instance.", 1)
+	writer.metric("redis_uptime_in_seconds", "gauge", "Seconds since the server started.", time.Since(serverStartTime).Seconds())
+	writer.metric("redis_loading_dump_file", "gauge", "1 while the RDB file is loading.", 1)
+	return writer.out.String()
+}
+
+func (app *App) metricsClients(writer *metricsWriter) {
+	blocked := 0
+	for _, client := range clients {
+		if client.flags&CLIENT_BLOCKED != 0 {
+			blocked++
+		}
+	}
+	writer.metric("redis_connected_clients", "gauge", "Client connections, without the replicas.", float64(len(clients)-len(slaveConnections)))
+	writer.metric("redis_blocked_clients", "gauge", "Clients waiting on a blocking command.", float64(blocked))
+	writer.metric("redis_max_clients", "gauge", "Maximum number of connected clients (maxclients).", float64(maxclients))
+}
+
+func (app *App) metricsMemory(writer *metricsWriter) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	if memStats.HeapAlloc > peakAllocated {
+		peakAllocated = memStats.HeapAlloc
+	}
+	fragmentation := 0.0
+	if memStats.HeapAlloc > 0 {
+		fragmentation = float64(memStats.HeapInuse) / float64(memStats.HeapAlloc)
+	}
+	writer.metric("redis_memory_used_bytes", "gauge", "Memory allocated by the server.", float64(memStats.HeapAlloc))
+	writer.metric("redis_memory_used_rss_bytes", "gauge", "Memory obtained from the OS.", float64(memStats.Sys))
+	writer.metric("redis_memory_used_peak_bytes", "gauge", "Peak of the memory allocated by the server.", float64(peakAllocated))
+	writer.metric("redis_memory_used_keys_bytes", "gauge", "Memory estimated for the keys, compared with maxmemory.", float64(app.usedMemory()))
+	writer.metric("redis_memory_max_bytes", "gauge", "Memory limit (maxmemory) of redis_memory_used_keys_bytes, 0 when unlimited.", float64(maxmemoryBytes))
+	writer.metric("redis_mem_fragmentation_ratio", "gauge", "Ratio of the heap in use to the memory allocated.", fragmentation)
+}
+
+func (app *App) metricsPersistence(writer *metricsWriter) {
+	status := 0.0
+	if lastSaveStatusOK {
+		status = 1
+	}
+	writer.metric("redis_loading_dump_file", "gauge", "1 while the RDB file is loading.", 0)
+	writer.metric("redis_rdb_changes_since_last_save", "gauge", "Writes since the last save.", float64(dirty))
+	writer.metric("redis_rdb_bgsave_in_progress", "gauge", "1 while a background save is running.", float64(boolToInt(bgsaveSnapshot != nil)))
+	writer.metric("redis_rdb_last_save_timestamp_seconds", "gauge", "Unix time of the last successful save.", float64(lastSaveTime.Unix()))
+	writer.metric("redis_rdb_last_bgsave_status", "gauge", "1 if the last save succeeded.", status)
+	writer.metric("redis_rdb_saves_total", "counter", "Saves since the server started.", float64(stats.rdbSaves))
+	writer.metric("redis_aof_enabled", "gauge", "1 when the AOF is enabled.", 0)
+}
+
+func (app *App) metricsStats(writer *metricsWriter) {
+	writer.metric("redis_connections_received_total", "counter", "Connections accepted by the server.", float64(stats.totalConnectionsReceived))
+	writer.metric("redis_rejected_connections_total", "counter", "Connections rejected because of maxclients.", float64(stats.rejectedConnections))
+	writer.metric("redis_commands_processed_total", "counter", "Commands processed by the server.", float64(stats.totalCommandsProcessed))
+	writer.metric("redis_net_input_bytes_total", "counter", "Bytes read from the network.", float64(netInputBytes.Load()))
+	writer.metric("redis_net_output_bytes_total", "counter", "Bytes written to the network.", float64(netOutputBytes.Load()))
+	writer.metric("redis_expired_keys_total", "counter", "Keys deleted because they expired.", float64(stats.expiredKeys))
+	writer.metric("redis_evicted_keys_total", "counter", "Keys evicted because of maxmemory.", float64(stats.evictedKeys))
+	writer.metric("redis_keyspace_hits_total", "counter", "Successful lookups of keys.", float64(stats.keyspaceHits))
+	writer.metric("redis_keyspace_misses_total", "counter", "Failed lookups of keys.", float64(stats.keyspaceMisses))
+	writer.metric("redis_pubsub_channels", "gauge", "Channels with subscribers.", float64(len(pubsubChannels)))
+	writer.metric("redis_pubsub_patterns", "gauge", "Patterns with subscribers.", float64(len(pubsubPatterns)))
+	writer.metric("redis_client_output_buffer_limit_disconnections_total", "counter", "Clients closed for overcoming the output buffer limits.", float64(stats.clientOutputBufferLimitDisconnections))
+	writer.metric("redis_error_replies_total", "counter", "Error replies sent to the clients.", float64(stats.totalErrorReplies))
+
+	prefixes := make([]string, 0, len(stats.errorReplies))
+	for prefix := range stats.errorReplies {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	writer.family("redis_errors_total", "counter", "Error replies by error prefix.")
+	for _, prefix := range prefixes {
+		writer.sample("redis_errors_total", float64(stats.errorReplies[prefix]), "err", prefix)
+	}
+}
+
+func (app *App) metricsReplication(writer *metricsWriter) {
+	offset, _ := strconv.ParseFloat(MASTER_REPL_OFFSET_VALUE, 64)
+	writer.metric("redis_connected_slaves", "gauge", "Connected replicas.", float64(len(slaveConnections)))
+	writer.metric("redis_master_repl_offset", "gauge", "Replication offset of the master.", offset)
+	writer.family("redis_connected_slave_offset_bytes", "gauge", "Replication offset of the replica.")
+	for _, slave := range slaveConnections {
+		writer.sample("redis_connected_slave_offset_bytes", offset, replicaLabels(slave)...)
+	}
+	writer.family("redis_connected_slave_lag_seconds", "gauge", "Seconds the replica is behind the master.")
+	for _, slave := range slaveConnections {
+		writer.sample("redis_connected_slave_lag_seconds", float64(replicaLag(slave)), replicaLabels(slave)...)
+	}
+	if role == SLAVE {
+		linkUp, lastIO := 0.0, -1.0
+		for _, client := range clients {
+			if client.flags&CLIENT_MASTER != 0 {
+				linkUp, lastIO = 1, time.Since(client.lastInteraction).Seconds()
+			}
+		}
+		writer.metric("redis_master_link_up", "gauge", "1 when the link to the master is up.", linkUp)
+		writer.metric("redis_master_last_io_seconds_ago", "gauge", "Seconds since the last data from the master, -1 when the link is down.", lastIO)
+	}
+}
+
+// ROLE: the labels of a replica, ex: slave_ip="127.0.0.1",slave_port="6380",slave_state="online"
+func replicaLabels(slave *Client) []string {
+	host, _, _ := net.SplitHostPort(slave.addr)
+	return []string{"slave_ip", host, "slave_port", strconv.Itoa(slave.slaveListeningPort), "slave_state", "online"}
+}
+
+// ROLE: commandstats and the latency histograms, by command and subcommand
+func (app *App) metricsCommands(writer *metricsWriter) {
+	commands := commandsWithStats()
+	used := make([]*Command, 0, len(commands))
+	for _, command := range commands {
+		if command.calls > 0 || command.rejectedCalls > 0 || command.failedCalls > 0 {
+			used = append(used, command)
+		}
+	}
+	writer.family("redis_commands_total", "counter", "Calls of the command.")
+	for _, command := range used {
+		writer.sample("redis_commands_total", float64(command.calls), "cmd", command.fullName)
+	}
+	writer.family("redis_commands_duration_seconds_total", "counter", "Time spent running the command.")
+	for _, command := range used {
+		writer.sample("redis_commands_duration_seconds_total", float64(command.microseconds)/1e6, "cmd", command.fullName)
+	}
+	writer.family("redis_commands_rejected_calls_total", "counter", "Calls of the command refused before running.")
+	for _, command := range used {
+		writer.sample("redis_commands_rejected_calls_total", float64(command.rejectedCalls), "cmd", command.fullName)
+	}
+	writer.family("redis_commands_failed_calls_total", "counter", "Calls of the command that replied an error.")
+	for _, command := range used {
+		writer.sample("redis_commands_failed_calls_total", float64(command.failedCalls), "cmd", command.fullName)
+	}
+
+	// the buckets of LATENCY HISTOGRAM, every one up to the maximum so they
+	// don't change between scrapes
+	writer.family("redis_command_latency_seconds", "histogram", "Latency of the command (latency-tracking).")
+	for _, command := range used {
+		histogram := command.latencyHistogram
+		if histogram == nil {
+			continue
+		}
+		for _, bucket := range histogram.cumulativeBuckets(LATENCY_HISTOGRAM_MAX_VALUE) {
+			writer.sample("redis_command_latency_seconds_bucket", float64(bucket[1]),
+				"cmd", command.fullName, "le", strconv.FormatFloat(float64(bucket[0])/1e9, 'g', -1, 64))
+		}
+		writer.sample("redis_command_latency_seconds_bucket", float64(histogram.totalCount), "cmd", command.fullName, "le", "+Inf")
+		writer.sample("redis_command_latency_seconds_sum", float64(command.microseconds)/1e6, "cmd", command.fullName)
+		writer.sample("redis_command_latency_seconds_count", float64(histogram.totalCount), "cmd", command.fullName)
+	}
+}
+
+// ROLE: keys, keys with a TTL and average TTL of every database
+func (app *App) metricsKeyspace(writer *metricsWriter) {
+	writer.family("redis_db_keys", "gauge", "Keys in the database.")
+	for _, db := range dbs {
+		writer.sample("redis_db_keys", float64(len(db.dict)), "db", fmt.Sprintf("db%d", db.id))
+	}
+	writer.family("redis_db_keys_expiring", "gauge", "Keys with a TTL in the database.")
+	for _, db := range dbs {
+		writer.sample("redis_db_keys_expiring", float64(len(db.expires)), "db", fmt.Sprintf("db%d", db.id))
+	}
+	writer.family("redis_db_avg_ttl_seconds", "gauge", "Estimated average TTL of the keys with a TTL.")
+	for _, db := range dbs {
+		writer.sample("redis_db_avg_ttl_seconds", float64(db.avgTTL)/1000, "db", fmt.Sprintf("db%d", db.id))
+	}
+}
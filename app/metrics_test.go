@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ROLE: scrape /metrics, the samples by name and labels, ex: redis_db_keys{db="db0"}
+// every sample must follow the HELP and TYPE lines of its family
+func scrapeMetrics(t *testing.T, app *App) map[string]float64 {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	app.serveMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("Content-Type %s", contentType)
+	}
+
+	samples := make(map[string]float64)
+	families := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n") {
+		if fields := strings.Fields(line); strings.HasPrefix(line, "# TYPE ") && len(fields) == 4 {
+			families[fields[2]] = fields[3]
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		series, text, ok := strings.Cut(line, " ")
+		value, err := strconv.ParseFloat(text, 64)
+		if !ok || err != nil {
+			t.Fatalf("metrics line %q", line)
+		}
+		name, _, _ := strings.Cut(series, "{")
+		if _, ok := families[name]; !ok {
+			family := name
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				family = strings.TrimSuffix(family, suffix)
+			}
+			if families[family] != "histogram" {
+				t.Fatalf("sample %s without its TYPE", series)
+			}
+		}
+		samples[series] = value
+	}
+	return samples
+}
+
+// ROLE: check the value of the samples
+func expectMetrics(t *testing.T, samples map[string]float64, want map[string]float64) {
+	t.Helper()
+	for series, value := range want {
+		if got, ok := samples[series]; !ok || got != value {
+			t.Fatalf("%s %v, want %v", series, got, value)
+		}
+	}
+}
+
+func TestMetricsWriter(t *testing.T) {
+	writer := &metricsWriter{}
+	writer.family("redis_errors_total", "counter", "Error replies by error prefix.")
+	writer.sample("redis_errors_total", 3, "err", "a\"b\\c\nd", "db", "db0")
+	writer.metric("redis_up", "gauge", "Information about the Redis instance.", 0.5)
+	want := "# HELP redis_errors_total Error replies by error prefix.\n" +
+		"# TYPE redis_errors_total counter\n" +
+		`redis_errors_total{err="a\"b\\c\nd",db="db0"} 3` + "\n" +
+		"# HELP redis_up Information about the Redis instance.\n" +
+		"# TYPE redis_up gauge\n" +
+		"redis_up 0.5\n"
+	if writer.out.String() != want {
+		t.Fatalf("metrics:\n%s\nwant:\n%s", writer.out.String(), want)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	server := startTestServer(t, "maxmemory 10mb")
+	client := server.connect(t)
+	client.do("SET", "a", "1")
+	client.do("SET", "b", "1", "PX", "100000")
+	client.do("SET", "c")
+	client.do("GET", "a")
+	client.do("GET", "missing")
+	client.do("SELECT", "99")
+	client.do("NOSUCH")
+
+	samples := scrapeMetrics(t, server.app)
+	expectMetrics(t, samples, map[string]float64{
+		"redis_up":                                                  1,
+		"redis_connected_clients":                                   1,
+		"redis_memory_max_bytes":                                    10 << 20,
+		"redis_loading_dump_file":                                   0,
+		"redis_rdb_changes_since_last_save":                         2,
+		"redis_keyspace_hits_total":                                 1,
+		"redis_keyspace_misses_total":                               1,
+		"redis_error_replies_total":                                 3,
+		`redis_errors_total{err="ERR"}`:                             3,
+		`redis_commands_total{cmd="set"}`:                           2,
+		`redis_commands_rejected_calls_total{cmd="set"}`:            1,
+		`redis_commands_failed_calls_total{cmd="select"}`:           1,
+		`redis_db_keys{db="db0"}`:                                   2,
+		`redis_db_keys_expiring{db="db0"}`:                          1,
+		`redis_command_latency_seconds_count{cmd="set"}`:            2,
+		`redis_command_latency_seconds_bucket{cmd="set",le="+Inf"}`: 2,
+	})
+	// the unused commands and the empty databases are listed too, the used commands only
+	if _, ok := samples[`redis_db_keys{db="db1"}`]; !ok {
+		t.Fatal("no sample of an empty database")
+	}
+	if _, ok := samples[`redis_commands_total{cmd="flushall"}`]; ok {
+		t.Fatal("sample of a command never called")
+	}
+
+	// the buckets are cumulative, every one up to the maximum
+	var bounds []float64
+	for series := range samples {
+		if le, ok := strings.CutPrefix(series, `redis_command_latency_seconds_bucket{cmd="set",le="`); ok && le != `+Inf"}` {
+			bound, err := strconv.ParseFloat(strings.TrimSuffix(le, `"}`), 64)
+			if err != nil {
+				t.Fatalf("bucket %s", series)
+			}
+			bounds = append(bounds, bound)
+		}
+	}
+	slices.Sort(bounds)
+	if len(bounds) < 2 || bounds[len(bounds)-1]*2 < float64(LATENCY_HISTOGRAM_MAX_VALUE)/1e9 {
+		t.Fatalf("buckets of set up to %v", bounds)
+	}
+	var previous float64
+	for _, bound := range bounds {
+		value := samples[`redis_command_latency_seconds_bucket{cmd="set",le="`+strconv.FormatFloat(bound, 'g', -1, 64)+`"}`]
+		if value < previous {
+			t.Fatalf("buckets of set not cumulative at %v", bound)
+		}
+		previous = value
+	}
+	if previous != 2 {
+		t.Fatalf("last bucket of set %v", previous)
+	}
+}
+
+func TestServeMetricsReplication(t *testing.T) {
+	server := startTestServer(t)
+	replica := server.connectReplica(t)
+	port := strconv.Itoa(server.clientOf(replica.testClient).slaveListeningPort)
+	labels := `{slave_ip="127.0.0.1",slave_port="` + port + `",slave_state="online"}`
+	expectMetrics(t, scrapeMetrics(t, server.app), map[string]float64{
+		"redis_connected_slaves":                      1,
+		"redis_connected_clients":                     0,
+		"redis_connected_slave_lag_seconds" + labels:  0,
+		"redis_connected_slave_offset_bytes" + labels: 0,
+	})
+
+	// the link of a replica to its master
+	other := startTestServer(t)
+	other.connectMaster(t)
+	expectMetrics(t, scrapeMetrics(t, other.app), map[string]float64{"redis_master_link_up": 1})
+}
+
+func TestServeMetricsWhileLoading(t *testing.T) {
+	server := startTestServer(t)
+	// answered without the serverMutex, held by the load
+	loading.Store(true)
+	defer loading.Store(false)
+	done := make(chan map[string]float64)
+	server.locked(func() {
+		go func() { done <- scrapeMetrics(t, server.app) }()
+		samples := <-done
+		if len(samples) != 3 || samples["redis_loading_dump_file"] != 1 || samples["redis_up"] != 1 {
+			t.Fatalf("metrics while loading: %v", samples)
+		}
+	})
+}
+
+func TestMetricsHTTPListener(t *testing.T) {
+	port := freePort(t)
+	server := startTestServer(t, "bind 127.0.0.1", "metrics-port "+port)
+	server.locked(func() {
+		if err := server.app.openHTTPListeners(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	base := "http://127.0.0.1:" + port
+	for path, want := range map[string]int{"/metrics": 200, "/healthz": 200, "/": 404} {
+		response, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != want {
+			t.Fatalf("GET %s: %d %s", path, response.StatusCode, body)
+		}
+		if path == "/metrics" && !strings.Contains(string(body), "\nredis_up 1\n") {
+			t.Fatalf("GET /metrics:\n%s", body)
+		}
+	}
+	response, err := http.Post(base+"/metrics", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /metrics: %d", response.StatusCode)
+	}
+}
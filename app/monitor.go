@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+INFO: MONITOR
+A client sending MONITOR receives every command processed by the server,
+as a status reply like Redis:
++1339518083.107412 [0 127.0.0.1:60866] "set" "key" "value"
+The commands are sent before they run, the admin commands are not shown and
+the sensitive arguments, ex: the password of AUTH, are redacted.
+The monitor leaves the mode with RESET or QUIT.
+*/
+
+// clients in MONITOR mode
+var monitors = []*Client{}
+
+// ROLE: handle MONITOR command
+func (app *App) executeMONITOR(client *Client, commands []string) []byte {
+	// replicas can't be monitors, a monitor is already one
+	if client.flags&(CLIENT_SLAVE|CLIENT_MONITOR) != 0 {
+		return nil
+	}
+	client.flags |= CLIENT_MONITOR
+	monitors = append(monitors, client)
+	return []byte("+OK\r\n")
+}
+
+// ROLE: leave MONITOR mode, on RESET and when the client is freed
+// caller must hold the serverMutex
+func (app *App) stopMonitor(client *Client) {
+	if client.flags&CLIENT_MONITOR == 0 {
+		return
+	}
+	client.flags &^= CLIENT_MONITOR
+	for i, monitor := range monitors {
+		if monitor == client {
+			monitors = append(monitors[:i], monitors[i+1:]...)
+			break
+		}
+	}
+}
+
+// ROLE: send the command to the monitors
+// caller must hold the serverMutex
+func (app *App) replicationFeedMonitors(client *Client, commands []string) {
+	if len(monitors) == 0 {
+		return
+	}
+	now := time.Now()
+	var line strings.Builder
+	fmt.Fprintf(&line, "+%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, client.db.id, monitorClientAddress(client))
+	for _, arg := range redactCommandArguments(commands) {
+		line.WriteByte(' ')
+		line.WriteString(representString(arg))
+	}
+	line.WriteString("\r\n")
+	for _, monitor := range monitors {
+		// a monitor over the output buffer limits is closed, it's freed by its connection
+		app.WriteToClient(monitor, []byte(line.String()))
+	}
+}
+
+// ROLE: address of the client in the MONITOR output, unix:<path> for the unix socket
+func monitorClientAddress(client *Client) string {
+	if client.flags&CLIENT_UNIX_SOCKET != 0 {
+		return "unix:" + unixsocket
+	}
+	return client.addr
+}
+
+// ROLE: quote the string, escaping the quotes, backslashes and non printable characters
+// ex: "a\"b\x01"
+func representString(value string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString("\\n")
+		case '\r':
+			quoted.WriteString("\\r")
+		case '\t':
+			quoted.WriteString("\\t")
+		case '\a':
+			quoted.WriteString("\\a")
+		case '\b':
+			quoted.WriteString("\\b")
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&quoted, "\\x%02x", c)
+			} else {
+				quoted.WriteByte(c)
+			}
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
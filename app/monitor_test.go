@@ -0,0 +1,102 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+// ROLE: read the next MONITOR line and check its database, client and arguments
+func expectMonitorLine(t *testing.T, monitor *testClient, db string, address string, args string) {
+	t.Helper()
+	line, ok := monitor.read().(string)
+	pattern := regexp.MustCompile(`^\d+\.\d{6} \[` + regexp.QuoteMeta(db+" "+address) + `\] ` + regexp.QuoteMeta(args) + `$`)
+	if !ok || !pattern.MatchString(line) {
+		t.Fatalf("MONITOR line %q, want [%s %s] %s", line, db, address, args)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	server := startTestServer(t)
+	monitor := server.connect(t)
+	client := server.connect(t)
+	address := server.clientOf(client).addr
+	expectReply(t, monitor.do("MONITOR"), "OK")
+	// already a monitor, no reply
+	monitor.send("MONITOR")
+	monitor.expectNoReply()
+
+	client.do("SET", "key", "a \"b\"\n\x01")
+	expectMonitorLine(t, monitor, "0", address, `"SET" "key" "a \"b\"\n\x01"`)
+	client.do("SELECT", "2")
+	client.do("GET", "key")
+	expectMonitorLine(t, monitor, "0", address, `"SELECT" "2"`)
+	expectMonitorLine(t, monitor, "2", address, `"GET" "key"`)
+
+	// the sensitive arguments are redacted, the admin and rejected commands not shown
+	client.do("AUTH", "secret")
+	client.do("CONFIG", "GET", "port")
+	client.do("GET")
+	client.do("PING")
+	expectMonitorLine(t, monitor, "2", address, `"AUTH" "(redacted)"`)
+	expectMonitorLine(t, monitor, "2", address, `"PING"`)
+
+	// the commands of a transaction are shown when they run, after the EXEC
+	client.do("MULTI")
+	expectMonitorLine(t, monitor, "2", address, `"MULTI"`)
+	client.do("GET", "key")
+	monitor.expectNoReply()
+	client.do("EXEC")
+	expectMonitorLine(t, monitor, "2", address, `"EXEC"`)
+	expectMonitorLine(t, monitor, "2", address, `"GET" "key"`)
+
+	expectReply(t, client.do("MULTI"), "OK")
+	expectReply(t, client.do("MONITOR"), respError("ERR Command not allowed inside a transaction"))
+	client.do("DISCARD")
+	expectMonitorLine(t, monitor, "2", address, `"MULTI"`)
+	expectMonitorLine(t, monitor, "2", address, `"DISCARD"`)
+}
+
+func TestMonitorStops(t *testing.T) {
+	server := startTestServer(t)
+	monitor := server.connect(t)
+	closed := server.connect(t)
+	client := server.connect(t)
+	monitor.do("MONITOR")
+	closed.do("MONITOR")
+	server.locked(func() {
+		if len(monitors) != 2 {
+			t.Fatalf("%d monitors", len(monitors))
+		}
+	})
+
+	// a freed client is no longer a monitor
+	closed.connection.Close()
+	waitFor(t, func() bool { return len(monitors) == 1 })
+
+	// RESET leaves the mode, shown before it runs
+	monitor.send("RESET")
+	expectMonitorLine(t, monitor, "0", server.clientOf(monitor).addr, `"RESET"`)
+	expectReply(t, monitor.read(), "RESET")
+	client.do("PING")
+	monitor.expectNoReply()
+	server.locked(func() {
+		if len(monitors) != 0 || server.clientOf(monitor).flags&CLIENT_MONITOR != 0 {
+			t.Fatalf("%d monitors after RESET", len(monitors))
+		}
+	})
+}
+
+func TestRepresentString(t *testing.T) {
+	for value, want := range map[string]string{
+		"":             `""`,
+		"plain text":   `"plain text"`,
+		`a"b\c`:        `"a\"b\\c"`,
+		"\r\n\t\a\b":   `"\r\n\t\a\b"`,
+		"\x00\x1f\x7f": `"\x00\x1f\x7f"`,
+		"caf\xc3\xa9":  `"caf\xc3\xa9"`,
+	} {
+		if quoted := representString(value); quoted != want {
+			t.Fatalf("%q: %s, want %s", value, quoted, want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+/*
+INFO: Transactions, MULTI/EXEC/DISCARD and optimistic locking with WATCH
+Commands after MULTI are queued and run on EXEC in one go, commands run one
+at a time (serverMutex) so nothing from other clients runs in between.
+WATCH: a write or expiry of a watched key flags the watching clients, their
+EXEC then replies with a null array.
+*/
+
+// for a command queued after MULTI
+type multiCommand struct {
+	command  *Command
+	commands []string
+}
+
+// for a key watched by a client, keys are watched in a database
+type watchedKey struct {
+	db  *Database
+	key string
+}
+
+// ROLE: check if the command controls the transaction, these are never queued
+func isTransactionCommand(command *Command) bool {
+	switch command.name {
+	case "exec", "discard", "multi", "watch", "quit", "reset":
+		return true
+	}
+	return false
+}
+
+// ROLE: a command failed while queueing, EXEC will abort
+func (app *App) flagTransaction(client *Client) {
+	if client.flags&CLIENT_MULTI != 0 {
+		client.flags |= CLIENT_DIRTY_EXEC
+	}
+}
+
+// ROLE: queue the command, it runs on EXEC
+func (app *App) queueMultiCommand(client *Client, command *Command, commands []string) []byte {
+	client.multiState = append(client.multiState, &multiCommand{
+		command:  command,
+		commands: commands,
+	})
+	return []byte("+QUEUED\r\n")
+}
+
+// ROLE: handle MULTI command
+func (app *App) executeMULTI(client *Client, commands []string) []byte {
+	if client.flags&CLIENT_MULTI != 0 {
+		return []byte("-ERR MULTI calls can not be nested\r\n")
+	}
+	client.flags |= CLIENT_MULTI
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle DISCARD command
+func (app *App) executeDISCARD(client *Client, commands []string) []byte {
+	if client.flags&CLIENT_MULTI == 0 {
+		return []byte("-ERR DISCARD without MULTI\r\n")
+	}
+	app.discardTransaction(client)
+	app.unwatchAllKeys(client)
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle EXEC command
+func (app *App) executeEXEC(client *Client, commands []string) []byte {
+	if client.flags&CLIENT_MULTI == 0 {
+		return []byte("-ERR EXEC without MULTI\r\n")
+	}
+	// a queued command was rejected
+	if client.flags&CLIENT_DIRTY_EXEC != 0 {
+		app.discardTransaction(client)
+		app.unwatchAllKeys(client)
+		return []byte("-EXECABORT Transaction discarded because of previous errors.\r\n")
+	}
+	// a watched key was touched, the transaction is not executed
+	if client.flags&CLIENT_DIRTY_CAS != 0 || app.isWatchedKeyExpired(client) {
+		app.discardTransaction(client)
+		app.unwatchAllKeys(client)
+		if client.protocol == 3 {
+			return []byte("_\r\n")
+		}
+		return []byte("*-1\r\n")
+	}
+	// watching is over once EXEC runs, our own writes must not flag us
+	app.unwatchAllKeys(client)
+
+	queue := client.multiState
+	client.flags |= CLIENT_EXECUTING_MULTI
+	responses := make([][]byte, 0, len(queue))
+	for _, queued := range queue {
+		// the permissions may have changed since the command was queued
+		if reason, object := app.aclCheckAllPerm(client, queued.command, queued.commands); reason != ACL_OK {
+			app.addACLLogEntry(client, reason, ACL_LOG_CTX_MULTI, object, "")
+			responses = append(responses, app.rejectCommand(queued.command, queued.commands, app.aclDeniedResponse(client, reason, object)))
+			continue
+		}
+		responses = append(responses, app.call(client, queued.command, queued.commands))
+	}
+	client.flags &^= CLIENT_EXECUTING_MULTI
+
+	// replicas get the writes of the transaction wrapped in MULTI/EXEC
+	pending := client.pendingPropagation
+	app.discardTransaction(client)
+	if len(pending) > 0 {
+		app.replicationFeedSlaves(pending[0].dbid, []string{"MULTI"})
+		for _, write := range pending {
+			app.replicationFeedSlaves(write.dbid, write.commands)
+		}
+		app.replicationFeedSlaves(slaveSelectedDb, []string{"EXEC"})
+	}
+	return app.createRESPArrayOfElements(responses)
+}
+
+// ROLE: reset the transaction state of the client
+func (app *App) discardTransaction(client *Client) {
+	client.multiState = nil
+	client.pendingPropagation = nil
+	client.flags &^= CLIENT_MULTI | CLIENT_DIRTY_EXEC | CLIENT_DIRTY_CAS | CLIENT_EXECUTING_MULTI
+}
+
+// ROLE: handle WATCH key [key ...]
+func (app *App) executeWATCH(client *Client, commands []string) []byte {
+	if client.flags&CLIENT_MULTI != 0 {
+		return []byte("-ERR WATCH inside MULTI is not allowed\r\n")
+	}
+	// no point in watching, EXEC will fail anyway
+	if client.flags&CLIENT_DIRTY_CAS != 0 {
+		return []byte("+OK\r\n")
+	}
+	db := client.db
+	for _, key := range commands[1:] {
+		watched := watchedKey{db: db, key: key}
+		if _, ok := client.watchedKeys[watched]; ok {
+			continue
+		}
+		value, exists := db.dict[key]
+		client.watchedKeys[watched] = exists && app.isExpired(value)
+		if db.watchedKeys[key] == nil {
+			db.watchedKeys[key] = make(map[*Client]bool)
+		}
+		db.watchedKeys[key][client] = true
+	}
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle UNWATCH command
+func (app *App) executeUNWATCH(client *Client, commands []string) []byte {
+	app.unwatchAllKeys(client)
+	client.flags &^= CLIENT_DIRTY_CAS
+	return []byte("+OK\r\n")
+}
+
+// ROLE: stop watching all the keys of the client
+func (app *App) unwatchAllKeys(client *Client) {
+	for watched := range client.watchedKeys {
+		if clients, ok := watched.db.watchedKeys[watched.key]; ok {
+			delete(clients, client)
+			if len(clients) == 0 {
+				delete(watched.db.watchedKeys, watched.key)
+			}
+		}
+		delete(client.watchedKeys, watched)
+	}
+}
+
+// ROLE: flag the clients watching the key, their EXEC will fail
+// expired is true when the key is deleted by expiry, clients that watched
+// an already expired key are not flagged for it
+func (app *App) touchWatchedKey(db *Database, key string, expired bool) {
+	for client := range db.watchedKeys[key] {
+		if expired && client.watchedKeys[watchedKey{db: db, key: key}] {
+			continue
+		}
+		client.flags |= CLIENT_DIRTY_CAS
+	}
+}
+
+// ROLE: flag the clients watching keys of a flushed or swapped database
+// a watched key is touched if it exists in the database or in other,
+// the database its data is replaced with (nil when flushed)
+func (app *App) touchAllWatchedKeysInDb(db *Database, other *Database) {
+	for key, clients := range db.watchedKeys {
+		_, exists := db.dict[key]
+		if !exists && other != nil {
+			_, exists = other.dict[key]
+		}
+		if !exists {
+			continue
+		}
+		for client := range clients {
+			client.flags |= CLIENT_DIRTY_CAS
+		}
+	}
+}
+
+// ROLE: check if a watched key expired since it was watched
+// the expired key may not be deleted yet, so it was not touched
+func (app *App) isWatchedKeyExpired(client *Client) bool {
+	for watched, expiredAtWatch := range client.watchedKeys {
+		if expiredAtWatch {
+			continue
+		}
+		if value, ok := watched.db.dict[watched.key]; ok && app.isExpired(value) {
+			return true
+		}
+	}
+	return false
+}
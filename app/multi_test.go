@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchInvalidation(t *testing.T) {
+	tests := []struct {
+		name string
+		// the change made while the key is watched, by another client
+		touch func(server *testServer, other *testClient)
+		// EXEC is aborted
+		aborted bool
+	}{
+		{"write", func(server *testServer, other *testClient) { other.do("SET", "key", "other") }, true},
+		{"delete", func(server *testServer, other *testClient) { other.do("DEL", "key") }, true},
+		{"write of another key", func(server *testServer, other *testClient) { other.do("SET", "unwatched", "other") }, false},
+		{"write in another db", func(server *testServer, other *testClient) {
+			other.do("SELECT", "1")
+			other.do("SET", "key", "other")
+		}, false},
+		{"flushdb", func(server *testServer, other *testClient) { other.do("FLUSHDB") }, true},
+		{"flushall", func(server *testServer, other *testClient) { other.do("FLUSHALL") }, true},
+		{"swapdb", func(server *testServer, other *testClient) { other.do("SWAPDB", "0", "1") }, true},
+		{"expiry", func(server *testServer, other *testClient) {
+			other.do("SET", "key", "value", "PX", "1")
+			time.Sleep(5 * time.Millisecond)
+		}, true},
+		{"active expiry", func(server *testServer, other *testClient) {
+			other.do("SET", "key", "value", "PX", "1")
+			time.Sleep(5 * time.Millisecond)
+			server.locked(server.app.activeExpireCycle)
+		}, true},
+		{"eviction", func(server *testServer, other *testClient) {
+			other.do("CONFIG", "SET", "maxmemory-policy", "allkeys-random", "maxmemory", "1")
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startTestServer(t)
+			client := server.connect(t)
+			other := server.connect(t)
+			client.do("SET", "key", "value")
+
+			expectReply(t, client.do("WATCH", "key"), "OK")
+			test.touch(server, other)
+			client.do("MULTI")
+			expectReply(t, client.do("SET", "result", "done"), "QUEUED")
+			if test.aborted {
+				expectReply(t, client.do("EXEC"), nil)
+				expectReply(t, client.do("GET", "result"), nil)
+			} else {
+				expectReply(t, client.do("EXEC"), []any{"OK"})
+				expectReply(t, client.do("GET", "result"), "done")
+			}
+		})
+	}
+}
+
+func TestWatchAlreadyExpiredKey(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	client.do("SET", "key", "value", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	// the key was already logically gone, its deletion changes nothing
+	client.do("WATCH", "key")
+	server.locked(server.app.activeExpireCycle)
+	client.do("MULTI")
+	client.do("SET", "result", "done")
+	expectReply(t, client.do("EXEC"), []any{"OK"})
+}
+
+func TestExecAbortAfterQueueError(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+
+	client.do("MULTI")
+	expectReply(t, client.do("SET", "key", "value"), "QUEUED")
+	if _, ok := client.do("NOSUCHCOMMAND").(respError); !ok {
+		t.Fatal("unknown command queued")
+	}
+	if _, ok := client.do("GET").(respError); !ok {
+		t.Fatal("wrong arity queued")
+	}
+	expectReply(t, client.do("EXEC"), respError("EXECABORT Transaction discarded because of previous errors."))
+	expectReply(t, client.do("GET", "key"), nil)
+
+	// the transaction is over
+	expectReply(t, client.do("EXEC"), respError("ERR EXEC without MULTI"))
+	client.do("MULTI")
+	if _, ok := client.do("SAVE").(respError); !ok {
+		t.Fatal("NO_MULTI command queued")
+	}
+	expectReply(t, client.do("EXEC"), respError("EXECABORT Transaction discarded because of previous errors."))
+
+	// a runtime error doesn't abort the others
+	client.do("MULTI")
+	client.do("SELECT", "100")
+	client.do("SET", "key", "value")
+	expectReply(t, client.do("EXEC"), []any{respError("ERR DB index is out of range"), "OK"})
+}
+
+func TestExecPropagatesWrappedWrites(t *testing.T) {
+	server := startTestServer(t)
+	replica := server.connectReplica(t)
+	client := server.connect(t)
+
+	client.do("MULTI")
+	client.do("SET", "a", "1")
+	client.do("GET", "a")
+	client.do("SET", "b", "2")
+	expectReply(t, client.do("EXEC"), []any{"OK", "1", "OK"})
+	replica.expectCommands(
+		[]string{"SELECT", "0"},
+		[]string{"MULTI"},
+		[]string{"SET", "a", "1"},
+		[]string{"SET", "b", "2"},
+		[]string{"EXEC"},
+	)
+
+	// reads only, nothing to propagate
+	client.do("MULTI")
+	client.do("GET", "a")
+	client.do("EXEC")
+	// a write outside of a transaction is not wrapped
+	client.do("SET", "c", "3")
+	replica.expectCommands([]string{"SET", "c", "3"})
+	replica.expectNoReply()
+}
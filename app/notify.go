@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+INFO: Keyspace and keyevent notifications
+Published on pub/sub for every keyspace event whose class is enabled with
+notify-keyspace-events:
+__keyspace@<db>__:<key>     message is the event name
+__keyevent@<db>__:<event>   message is the key name
+*/
+
+// notification classes, same letters as Redis
+const (
+	NOTIFY_KEYSPACE = 1 << iota // K
+	NOTIFY_KEYEVENT             // E
+	NOTIFY_GENERIC              // g
+	NOTIFY_STRING               // $
+	NOTIFY_LIST                 // l
+	NOTIFY_SET                  // s
+	NOTIFY_HASH                 // h
+	NOTIFY_ZSET                 // z
+	NOTIFY_EXPIRED              // x
+	NOTIFY_EVICTED              // e
+	NOTIFY_STREAM               // t
+	NOTIFY_KEY_MISS             // m
+	NOTIFY_NEW                  // n
+
+	// A: alias for g$lshzxet, key-miss and new are not part of it
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET | NOTIFY_HASH |
+		NOTIFY_ZSET | NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM
+)
+
+// enabled classes, parsed from notify-keyspace-events
+var notifyKeyspaceEvents int
+
+var notifyClassCharacters = []struct {
+	character byte
+	class     int
+}{
+	{'g', NOTIFY_GENERIC},
+	{'$', NOTIFY_STRING},
+	{'l', NOTIFY_LIST},
+	{'s', NOTIFY_SET},
+	{'h', NOTIFY_HASH},
+	{'z', NOTIFY_ZSET},
+	{'x', NOTIFY_EXPIRED},
+	{'e', NOTIFY_EVICTED},
+	{'t', NOTIFY_STREAM},
+	{'K', NOTIFY_KEYSPACE},
+	{'E', NOTIFY_KEYEVENT},
+	{'m', NOTIFY_KEY_MISS},
+	{'n', NOTIFY_NEW},
+}
+
+// ROLE: parse the notify-keyspace-events classes string into flags
+func parseNotifyKeyspaceEvents(classes string) (int, error) {
+	flags := 0
+	for i := 0; i < len(classes); i++ {
+		if classes[i] == 'A' {
+			flags |= NOTIFY_ALL
+			continue
+		}
+		found := false
+		for _, notifyClass := range notifyClassCharacters {
+			if notifyClass.character == classes[i] {
+				flags |= notifyClass.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Invalid event class character. Use 'Ag$lshzxeKEtmn'.")
+		}
+	}
+	return flags, nil
+}
+
+// ROLE: convert the flags back to the classes string, used by CONFIG GET
+func notifyKeyspaceEventsString(flags int) string {
+	var classes strings.Builder
+	if flags&NOTIFY_ALL == NOTIFY_ALL {
+		classes.WriteByte('A')
+	}
+	for _, notifyClass := range notifyClassCharacters {
+		if notifyClass.class&NOTIFY_ALL != 0 && flags&NOTIFY_ALL == NOTIFY_ALL {
+			continue
+		}
+		if flags&notifyClass.class != 0 {
+			classes.WriteByte(notifyClass.character)
+		}
+	}
+	return classes.String()
+}
+
+// ROLE: publish the keyspace and keyevent messages if the class is enabled
+func (app *App) notifyKeyspaceEvent(class int, event, key string, dbid int) {
+	if notifyKeyspaceEvents&class == 0 {
+		return
+	}
+	if notifyKeyspaceEvents&NOTIFY_KEYSPACE != 0 {
+		app.publishMessage(fmt.Sprintf("__keyspace@%d__:%s", dbid, key), event)
+	}
+	if notifyKeyspaceEvents&NOTIFY_KEYEVENT != 0 {
+		app.publishMessage(fmt.Sprintf("__keyevent@%d__:%s", dbid, event), key)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNotifyKeyspaceEvents(t *testing.T) {
+	tests := []struct {
+		classes string
+		flags   int
+		want    string
+	}{
+		{"", 0, ""},
+		{"KEA", NOTIFY_KEYSPACE | NOTIFY_KEYEVENT | NOTIFY_ALL, "AKE"},
+		{"Kx", NOTIFY_KEYSPACE | NOTIFY_EXPIRED, "xK"},
+		{"Eg$", NOTIFY_KEYEVENT | NOTIFY_GENERIC | NOTIFY_STRING, "g$E"},
+		{"Em", NOTIFY_KEYEVENT | NOTIFY_KEY_MISS, "Em"},
+	}
+	for _, test := range tests {
+		flags, err := parseNotifyKeyspaceEvents(test.classes)
+		if err != nil || flags != test.flags {
+			t.Fatalf("%q: got %b %v, want %b", test.classes, flags, err, test.flags)
+		}
+		if classes := notifyKeyspaceEventsString(flags); classes != test.want {
+			t.Fatalf("%q: classes %q, want %q", test.classes, classes, test.want)
+		}
+	}
+	if _, err := parseNotifyKeyspaceEvents("Kq"); err == nil {
+		t.Fatal("invalid class accepted")
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	server := startTestServer(t, "notify-keyspace-events KEAnm")
+	subscriber := server.connect(t)
+	client := server.connect(t)
+
+	subscriber.do("PSUBSCRIBE", "__keyspace@0__:*")
+	expectReply(t, subscriber.do("PSUBSCRIBE", "__keyevent@1__:*"), []any{"psubscribe", "__keyevent@1__:*", 2})
+
+	client.do("SET", "key", "value")
+	expectReply(t, subscriber.read(), []any{"pmessage", "__keyspace@0__:*", "__keyspace@0__:key", "new"})
+	expectReply(t, subscriber.read(), []any{"pmessage", "__keyspace@0__:*", "__keyspace@0__:key", "set"})
+
+	client.do("MOVE", "key", "1")
+	expectReply(t, subscriber.read(), []any{"pmessage", "__keyspace@0__:*", "__keyspace@0__:key", "move_from"})
+	expectReply(t, subscriber.read(), []any{"pmessage", "__keyevent@1__:*", "__keyevent@1__:new", "key"})
+	expectReply(t, subscriber.read(), []any{"pmessage", "__keyevent@1__:*", "__keyevent@1__:move_to", "key"})
+
+	client.do("SELECT", "1")
+	expectReply(t, client.do("DEL", "key", "missing"), 1)
+	expectReply(t, subscriber.read(), []any{"pmessage", "__keyevent@1__:*", "__keyevent@1__:del", "key"})
+
+	client.do("GET", "missing")
+	expectReply(t, subscriber.read(), []any{"pmessage", "__keyevent@1__:*", "__keyevent@1__:keymiss", "missing"})
+
+	client.do("SET", "ttl", "value", "PX", "1")
+	subscriber.read()
+	subscriber.read()
+	time.Sleep(5 * time.Millisecond)
+	server.locked(server.app.activeExpireCycle)
+	expectReply(t, subscriber.read(), []any{"pmessage", "__keyevent@1__:*", "__keyevent@1__:expired", "ttl"})
+
+	// only the enabled classes are sent
+	client.do("CONFIG", "SET", "notify-keyspace-events", "Kx")
+	client.do("SELECT", "0")
+	client.do("SET", "other", "value")
+	subscriber.expectNoReply()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+/*
+INFO: OBJECT and MEMORY introspection
+Memory of a key is an estimation of what Go allocates for it: the map entry
+in the db dict (and in the expires set when it has a TTL), the Value struct
+and the bytes of the key and the value.
+*/
+
+// value encodings, as reported by OBJECT ENCODING
+const (
+	OBJ_ENCODING_RAW    = iota // string longer than OBJ_ENCODING_EMBSTR_SIZE_LIMIT
+	OBJ_ENCODING_INT           // string representing a 64 bit integer
+	OBJ_ENCODING_EMBSTR        // short string
+
+	OBJ_ENCODING_EMBSTR_SIZE_LIMIT = 44
+)
+
+var objectEncodingNames = map[uint8]string{
+	OBJ_ENCODING_RAW:    "raw",
+	OBJ_ENCODING_INT:    "int",
+	OBJ_ENCODING_EMBSTR: "embstr",
+}
+
+const (
+	// map entry: key string header, value pointer and the tophash byte,
+	// maps are ~80% full on average so the slots are a bit larger than that
+	DICT_ENTRY_OVERHEAD = (int(unsafe.Sizeof("")) + int(unsafe.Sizeof(&Value{})) + 1) * 5 / 4
+	// expires set entry: key string header, bool and the tophash byte
+	EXPIRES_ENTRY_OVERHEAD = (int(unsafe.Sizeof("")) + 2) * 5 / 4
+	VALUE_STRUCT_SIZE      = int(unsafe.Sizeof(Value{}))
+)
+
+var (
+	// allocated heap memory at startup and the highest seen
+	startupAllocated uint64
+	peakAllocated    uint64
+)
+
+// ROLE: detect the encoding of the string value
+func (app *App) detectEncoding(value string) uint8 {
+	if len(value) <= 20 {
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return OBJ_ENCODING_INT
+		}
+	}
+	if len(value) <= OBJ_ENCODING_EMBSTR_SIZE_LIMIT {
+		return OBJ_ENCODING_EMBSTR
+	}
+	return OBJ_ENCODING_RAW
+}
+
+// ROLE: estimated memory used by the key and its value
+func (app *App) estimateKeyMemory(key string, value *Value) int64 {
+	size := DICT_ENTRY_OVERHEAD + len(key) + VALUE_STRUCT_SIZE + len(value.value)
+	if !value.expiration.IsZero() {
+		// the expires set shares the key bytes
+		size += EXPIRES_ENTRY_OVERHEAD
+	}
+	return int64(size)
+}
+
+// ROLE: record the allocated memory at startup, for MEMORY STATS
+func (app *App) recordStartupMemory() {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	startupAllocated = memStats.HeapAlloc
+	peakAllocated = memStats.HeapAlloc
+}
+
+// ROLE: keep track of the peak allocated memory
+func (app *App) updatePeakMemory() {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	if memStats.HeapAlloc > peakAllocated {
+		peakAllocated = memStats.HeapAlloc
+	}
+}
+
+// ROLE: estimated overhead of the main and the expires hash tables of the database
+func (app *App) databaseOverhead(db *Database) (int, int) {
+	return len(db.dict) * (DICT_ENTRY_OVERHEAD + VALUE_STRUCT_SIZE), len(db.expires) * EXPIRES_ENTRY_OVERHEAD
+}
+
+// ROLE: handle OBJECT ENCODING|REFCOUNT|IDLETIME|FREQ key, OBJECT HELP
+// the lookup does not count as an access of the key
+func (app *App) executeOBJECT(client *Client, commands []string) []byte {
+	subcommand := strings.ToUpper(commands[1])
+	if subcommand == "HELP" && len(commands) == 2 {
+		return []byte(app.createRESPArray([]string{
+			"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		}))
+	}
+	if len(commands) != 3 {
+		return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.\r\n", commands[1]))
+	}
+
+	value, ok := app.lookupKey(client.db, commands[2], LOOKUP_NOTOUCH|LOOKUP_NONOTIFY)
+	lfu := maxmemoryPolicy == MAXMEMORY_ALLKEYS_LFU || maxmemoryPolicy == MAXMEMORY_VOLATILE_LFU
+	switch subcommand {
+	case "ENCODING", "REFCOUNT", "IDLETIME", "FREQ":
+		if !ok {
+			return app.createNullResponse(client)
+		}
+	default:
+		return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.\r\n", commands[1]))
+	}
+
+	switch subcommand {
+	case "ENCODING":
+		return app.createBulkStringResponse(objectEncodingNames[value.encoding])
+	case "REFCOUNT":
+		// values are never shared between keys
+		return app.createIntegerResponse(1)
+	case "IDLETIME":
+		if lfu {
+			return []byte("-ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n")
+		}
+		return app.createIntegerResponse(int(app.evictionIdleMilliseconds(value) / 1000))
+	default:
+		if !lfu {
+			return []byte("-ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n")
+		}
+		return app.createIntegerResponse(int(app.lfuDecrAndReturn(value)))
+	}
+}
+
+// ROLE: handle MEMORY USAGE key [SAMPLES count] | STATS | DOCTOR | HELP
+func (app *App) executeMEMORY(client *Client, commands []string) []byte {
+	subcommand := strings.ToUpper(commands[1])
+	switch {
+	case subcommand == "USAGE" && (len(commands) == 3 || len(commands) == 5):
+		if len(commands) == 5 {
+			// strings have nothing to sample, the count is only validated
+			if !strings.EqualFold(commands[3], "SAMPLES") {
+				return []byte("-ERR syntax error\r\n")
+			}
+			if samples, err := strconv.Atoi(commands[4]); err != nil || samples < 0 {
+				return []byte("-ERR value is not an integer or out of range\r\n")
+			}
+		}
+		value, ok := app.lookupKey(client.db, commands[2], LOOKUP_NOTOUCH|LOOKUP_NONOTIFY)
+		if !ok {
+			return app.createNullResponse(client)
+		}
+		return app.createIntegerResponse(int(app.estimateKeyMemory(commands[2], value)))
+	case subcommand == "STATS" && len(commands) == 2:
+		return app.memoryStats(client)
+	case subcommand == "DOCTOR" && len(commands) == 2:
+		return app.createVerbatimStringResponse(client, app.memoryDoctor())
+	case subcommand == "HELP" && len(commands) == 2:
+		return []byte(app.createRESPArray([]string{
+			"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"DOCTOR",
+			"    Return memory problems reports.",
+			"STATS",
+			"    Return information about the memory usage of the server.",
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value. Nested values are",
+			"    sampled up to <count> times (default: 5, 0 means sample all).",
+		}))
+	}
+	return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try MEMORY HELP.\r\n", commands[1]))
+}
+
+// ROLE: memory report of MEMORY STATS
+func (app *App) memoryStats(client *Client) []byte {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	if memStats.HeapAlloc > peakAllocated {
+		peakAllocated = memStats.HeapAlloc
+	}
+
+	var keysCount, overhead int
+	var slavesOutput int
+	for _, slave := range slaveConnections {
+		slave.outputMutex.Lock()
+		slavesOutput += slave.outputSize
+		slave.outputMutex.Unlock()
+	}
+
+	pairs := [][]byte{
+		app.createBulkStringResponse("peak.allocated"), app.createIntegerResponse(int(peakAllocated)),
+		app.createBulkStringResponse("total.allocated"), app.createIntegerResponse(int(memStats.HeapAlloc)),
+		app.createBulkStringResponse("startup.allocated"), app.createIntegerResponse(int(startupAllocated)),
+		app.createBulkStringResponse("clients.slaves"), app.createIntegerResponse(slavesOutput),
+	}
+	for _, db := range dbs {
+		if len(db.dict) == 0 {
+			continue
+		}
+		mainOverhead, expiresOverhead := app.databaseOverhead(db)
+		keysCount += len(db.dict)
+		overhead += mainOverhead + expiresOverhead
+		pairs = append(pairs,
+			app.createBulkStringResponse(fmt.Sprintf("db.%d", db.id)),
+			app.createMapResponse(client, [][]byte{
+				app.createBulkStringResponse("overhead.hashtable.main"), app.createIntegerResponse(mainOverhead),
+				app.createBulkStringResponse("overhead.hashtable.expires"), app.createIntegerResponse(expiresOverhead),
+			}),
+		)
+	}
+
+	used := int(app.usedMemory())
+	dataset := used - overhead
+	bytesPerKey := 0
+	if keysCount > 0 {
+		bytesPerKey = used / keysCount
+	}
+	datasetPercentage, peakPercentage, fragmentation := 0.0, 0.0, 0.0
+	if memStats.HeapAlloc > startupAllocated {
+		datasetPercentage = float64(dataset) * 100 / float64(memStats.HeapAlloc-startupAllocated)
+	}
+	if peakAllocated > 0 {
+		peakPercentage = float64(memStats.HeapAlloc) * 100 / float64(peakAllocated)
+	}
+	if memStats.HeapAlloc > 0 {
+		fragmentation = float64(memStats.HeapInuse) / float64(memStats.HeapAlloc)
+	}
+
+	pairs = append(pairs,
+		app.createBulkStringResponse("overhead.total"), app.createIntegerResponse(overhead),
+		app.createBulkStringResponse("keys.count"), app.createIntegerResponse(keysCount),
+		app.createBulkStringResponse("keys.bytes-per-key"), app.createIntegerResponse(bytesPerKey),
+		app.createBulkStringResponse("dataset.bytes"), app.createIntegerResponse(dataset),
+		app.createBulkStringResponse("dataset.percentage"), app.createBulkStringResponse(fmt.Sprintf("%.2f", datasetPercentage)),
+		app.createBulkStringResponse("peak.percentage"), app.createBulkStringResponse(fmt.Sprintf("%.2f", peakPercentage)),
+		app.createBulkStringResponse("allocator.allocated"), app.createIntegerResponse(int(memStats.HeapAlloc)),
+		app.createBulkStringResponse("allocator.active"), app.createIntegerResponse(int(memStats.HeapInuse)),
+		app.createBulkStringResponse("allocator.resident"), app.createIntegerResponse(int(memStats.HeapSys)),
+		app.createBulkStringResponse("fragmentation"), app.createBulkStringResponse(fmt.Sprintf("%.2f", fragmentation)),
+		app.createBulkStringResponse("gc.cycles"), app.createIntegerResponse(int(memStats.NumGC)),
+	)
+	return app.createMapResponse(client, pairs)
+}
+
+// ROLE: report of MEMORY DOCTOR, looks for the usual memory problems
+func (app *App) memoryDoctor() string {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	if memStats.HeapAlloc < 5*1024*1024 {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	var issues []string
+	if peakAllocated > 0 && float64(peakAllocated) > float64(memStats.HeapAlloc)*1.5 {
+		issues = append(issues, fmt.Sprintf(" * Peak memory: In the past this instance used more than 150%% the memory that is currently using (%d bytes at peak, %d bytes now). The Go runtime returns memory to the OS slowly, so the process RSS may still be high.", peakAllocated, memStats.HeapAlloc))
+	}
+	if memStats.HeapInuse > 0 && float64(memStats.HeapInuse) > float64(memStats.HeapAlloc)*1.4 {
+		issues = append(issues, fmt.Sprintf(" * High fragmentation: This instance has a heap fragmentation greater than 1.4 (%.2f in use/allocated). Many small objects were freed since the last garbage collection.", float64(memStats.HeapInuse)/float64(memStats.HeapAlloc)))
+	}
+	if maxmemoryBytes > 0 && app.usedMemory() > maxmemoryBytes*9/10 {
+		issues = append(issues, " * Maxmemory: The dataset is close to maxmemory, keys are evicted or writes are refused as per maxmemory-policy.")
+	}
+	for _, slave := range slaveConnections {
+		slave.outputMutex.Lock()
+		big := slave.outputSize > 32*1024*1024
+		slave.outputMutex.Unlock()
+		if big {
+			issues = append(issues, " * Big replica buffers: The replica output buffers are greater than 32MB, a replica is not reading fast enough.")
+			break
+		}
+	}
+
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" + strings.Join(issues, "\n\n") +
+		"\n\nI'm here to keep you safe, Sam. I want to help you."
+}
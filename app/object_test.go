@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		value    string
+		encoding string
+	}{
+		{"12345", "int"},
+		{"-9223372036854775808", "int"},
+		{"9223372036854775808", "embstr"},
+		{"007x", "embstr"},
+		{"", "embstr"},
+		{strings.Repeat("a", OBJ_ENCODING_EMBSTR_SIZE_LIMIT), "embstr"},
+		{strings.Repeat("a", OBJ_ENCODING_EMBSTR_SIZE_LIMIT+1), "raw"},
+	}
+	app := &App{}
+	for _, test := range tests {
+		if encoding := objectEncodingNames[app.detectEncoding(test.value)]; encoding != test.encoding {
+			t.Fatalf("%q: got %s, want %s", test.value, encoding, test.encoding)
+		}
+	}
+}
+
+func TestObjectCommand(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	client.do("SET", "number", "42")
+	client.do("SET", "text", strings.Repeat("x", 100))
+
+	expectReply(t, client.do("OBJECT", "ENCODING", "number"), "int")
+	expectReply(t, client.do("OBJECT", "ENCODING", "text"), "raw")
+	expectReply(t, client.do("OBJECT", "ENCODING", "missing"), nil)
+	expectReply(t, client.do("OBJECT", "REFCOUNT", "number"), 1)
+	expectReply(t, client.do("OBJECT", "NOSUCH", "number"), respError("ERR unknown subcommand or wrong number of arguments for 'NOSUCH'. Try OBJECT HELP."))
+
+	// OBJECT doesn't count as an access, GET does
+	server.locked(func() { dbs[0].dict["number"].lru -= 5000 })
+	expectReply(t, client.do("OBJECT", "IDLETIME", "number"), 5)
+	client.do("GET", "number")
+	expectReply(t, client.do("OBJECT", "IDLETIME", "number"), 0)
+
+	// FREQ only with an LFU policy, IDLETIME only without
+	if _, ok := client.do("OBJECT", "FREQ", "number").(respError); !ok {
+		t.Fatal("OBJECT FREQ without an LFU policy")
+	}
+	client.do("CONFIG", "SET", "maxmemory-policy", "allkeys-lfu")
+	// the counter of a new key, the first access always increments it
+	expectReply(t, client.do("OBJECT", "FREQ", "number"), LFU_INIT_VAL+1)
+	if _, ok := client.do("OBJECT", "IDLETIME", "number").(respError); !ok {
+		t.Fatal("OBJECT IDLETIME with an LFU policy")
+	}
+}
+
+func TestLFUDecay(t *testing.T) {
+	app := &App{}
+	defer func(previous int) { lfuDecayTime = previous }(lfuDecayTime)
+	lfuDecayTime = 1
+	value := &Value{lfuCounter: 10, lfuDecrTime: lfuTimeInMinutes() - 3}
+	if counter := app.lfuDecrAndReturn(value); counter != 7 {
+		t.Fatalf("counter after 3 minutes: %d", counter)
+	}
+	value.lfuDecrTime = lfuTimeInMinutes() - 30
+	if counter := app.lfuDecrAndReturn(value); counter != 0 {
+		t.Fatalf("counter after 30 minutes: %d", counter)
+	}
+	lfuDecayTime = 0
+	if counter := app.lfuDecrAndReturn(value); counter != 10 {
+		t.Fatalf("counter without decay: %d", counter)
+	}
+}
+
+func TestMemoryCommand(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	client.do("SET", "key", "value")
+	client.do("SET", "ttl", "value", "PX", "100000")
+
+	usage := int64(DICT_ENTRY_OVERHEAD + len("key") + VALUE_STRUCT_SIZE + len("value"))
+	expectReply(t, client.do("MEMORY", "USAGE", "key"), usage)
+	expectReply(t, client.do("MEMORY", "USAGE", "ttl"), usage+int64(EXPIRES_ENTRY_OVERHEAD))
+	expectReply(t, client.do("MEMORY", "USAGE", "key", "SAMPLES", "0"), usage)
+	expectReply(t, client.do("MEMORY", "USAGE", "key", "SAMPLES", "x"), respError("ERR value is not an integer or out of range"))
+	expectReply(t, client.do("MEMORY", "USAGE", "missing"), nil)
+
+	// the databases keep their memory in sync with the keys
+	client.do("SET", "key", "longer value")
+	client.do("DEL", "ttl")
+	server.locked(func() {
+		if want := usage + int64(len("longer value")-len("value")); dbs[0].memory != want {
+			t.Fatalf("db memory %d, want %d", dbs[0].memory, want)
+		}
+	})
+
+	stats, ok := client.do("MEMORY", "STATS").([]any)
+	if !ok || len(stats)%2 != 0 {
+		t.Fatalf("MEMORY STATS: %v", stats)
+	}
+	fields := make(map[string]any)
+	for i := 0; i < len(stats); i += 2 {
+		fields[stats[i].(string)] = stats[i+1]
+	}
+	expectReply(t, fields["keys.count"], 1)
+	if _, ok := fields["db.0"]; !ok {
+		t.Fatalf("MEMORY STATS without db.0: %v", stats)
+	}
+	if doctor, ok := client.do("MEMORY", "DOCTOR").(string); !ok || doctor == "" {
+		t.Fatal("empty MEMORY DOCTOR")
+	}
+}
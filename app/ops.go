@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -12,95 +11,288 @@ import (
 // Write RESP Parser
 */
 // Check the commands -> pass it to the executer(ops.go) -> get the result
-func (app *App) ExecuteCommands(commands []string, connection net.Conn) error {
-	mainCommand := commands[0]
-	switch {
-	case strings.EqualFold(mainCommand, "COMMAND"):
-		return app.WriteToClient(connection, []byte("+PONG\r\n"))
-	case strings.EqualFold(mainCommand, "PING"):
-		return app.WriteToClient(connection, []byte("+PONG\r\n"))
-	case strings.EqualFold(mainCommand, "ECHO"):
-		return app.WriteToClient(connection, app.executeECHO(commands))
-	case strings.EqualFold(mainCommand, "SET"):
-		// if there is a slace replica -> send this command to replica
-		// if there is no replica then execute it by myself
-		if role == MASTER {
-			app.infoLogger.Println("Sending commands to replicas")
-			fmt.Println("Connection, ", slaveConnections)
-			for _, conn := range slaveConnections {
-				command := app.createRESPArray(commands)
-				go func(conn net.Conn) {
-					app.infoLogger.Println("Slave Commection Sending to ", &connection)
-					if err := app.WriteToClient(conn, []byte(command)); err != nil {
-						app.errorLogger.Println("failed to send the command to slave", err)
-					}
-				}(conn)
-			}
-			return nil
+func (app *App) ExecuteCommands(commands []string, client *Client) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	// killed while the command was waiting to run
+	if app.isClientClosed(client) {
+		return errClientClosed
+	}
+	client.lastInteraction = time.Now()
+	response := app.processCommand(client, commands)
+	err := app.WriteToClient(client, response)
+	// CLIENT CACHING applies to the next command, or the whole transaction
+	if client.flags&CLIENT_MULTI == 0 && !isClientCachingCommand(commands) {
+		client.flags &^= CLIENT_TRACKING_CACHING
+	}
+	// CLIENT REPLY SKIP skips the reply of the next command only
+	client.flags &^= CLIENT_REPLY_SKIP
+	if client.flags&CLIENT_REPLY_SKIP_NEXT != 0 {
+		client.flags = client.flags&^CLIENT_REPLY_SKIP_NEXT | CLIENT_REPLY_SKIP
+	}
+	if client.flags&CLIENT_CLOSE_AFTER_REPLY != 0 {
+		app.closeClient(client)
+	}
+	return err
+}
+
+// ROLE: validate the command, queue it inside MULTI or execute it
+func (app *App) processCommand(client *Client, commands []string) []byte {
+	command := lookupCommand(commands[0])
+	if command == nil {
+		app.flagTransaction(client)
+		return app.rejectCommand(nil, commands, []byte(fmt.Sprintf("-ERR unknown command '%s', with args beginning with: %s\r\n", commands[0], app.formatArgs(commands[1:]))))
+	}
+	client.lastCommand = command.fullName
+	if subcommand := lookupSubcommand(command, commands); subcommand != nil {
+		client.lastCommand = subcommand.fullName
+	}
+	if (command.arity > 0 && len(commands) != command.arity) || len(commands) < -command.arity {
+		app.flagTransaction(client)
+		return app.rejectCommand(command, commands, []byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", command.name)))
+	}
+	if app.authRequired(client) && command.flags&CMD_NO_AUTH == 0 {
+		app.flagTransaction(client)
+		return app.rejectCommand(command, commands, []byte("-NOAUTH Authentication required.\r\n"))
+	}
+	if reason, object := app.aclCheckAllPerm(client, command, commands); reason != ACL_OK {
+		app.addACLLogEntry(client, reason, ACL_LOG_CTX_TOPLEVEL, object, "")
+		app.flagTransaction(client)
+		return app.rejectCommand(command, commands, app.aclDeniedResponse(client, reason, object))
+	}
+	// RESP2 subscribers can only manage their subscriptions
+	if !app.allowedInSubscriberMode(client, command.name) {
+		return app.rejectCommand(command, commands, []byte(fmt.Sprintf("-ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n", command.name)))
+	}
+	if client.flags&CLIENT_MULTI != 0 && !isTransactionCommand(command) {
+		if command.flags&CMD_NO_MULTI != 0 {
+			app.flagTransaction(client)
+			return app.rejectCommand(command, commands, []byte("-ERR Command not allowed inside a transaction\r\n"))
+		}
+		return app.queueMultiCommand(client, command, commands)
+	}
+	// free memory before running the command, writes are refused when we can't
+	if !app.performEvictions() && command.flags&CMD_DENYOOM != 0 {
+		app.flagTransaction(client)
+		return app.rejectCommand(command, commands, []byte("-OOM command not allowed when used memory > 'maxmemory'.\r\n"))
+	}
+	return app.call(client, command, commands)
+}
+
+// ROLE: count the command refused before running in commandstats and errorstats
+// command is nil for an unknown command
+func (app *App) rejectCommand(command *Command, commands []string, response []byte) []byte {
+	if command != nil {
+		statsCommand(command, commands).rejectedCalls++
+	}
+	app.trackErrorReply(response)
+	return response
+}
+
+// ROLE: the command or subcommand the stats of the call are counted in
+func statsCommand(command *Command, commands []string) *Command {
+	if subcommand := lookupSubcommand(command, commands); subcommand != nil {
+		return subcommand
+	}
+	return command
+}
+
+// ROLE: execute the command and propagate the writes to the replicas
+func (app *App) call(client *Client, command *Command, commands []string) []byte {
+	previousClient := currentClient
+	currentClient = client
+	defer func() { currentClient = previousClient }()
+
+	if command.flags&CMD_ADMIN == 0 {
+		app.replicationFeedMonitors(client, commands)
+	}
+	start := time.Now()
+	response := command.handler(app, client, commands)
+	duration := time.Since(start)
+
+	stats.totalCommandsProcessed++
+	stat := statsCommand(command, commands)
+	stat.calls++
+	stat.microseconds += duration.Microseconds()
+	if isErrorResponse(response) {
+		stat.failedCalls++
+		app.trackErrorReply(response)
+	}
+	if latencyTracking {
+		if stat.latencyHistogram == nil {
+			stat.latencyHistogram = &latencyHistogram{}
 		}
-		fmt.Println("HEY man")
-		res, err := app.executeSET(commands)
+		stat.latencyHistogram.record(duration)
+	}
+	if command.flags&CMD_FAST != 0 {
+		app.latencyAddSampleIfNeeded("fast-command", duration)
+	} else {
+		app.latencyAddSampleIfNeeded("command", duration)
+	}
+	// the commands of a transaction are logged one by one
+	if command.name != "exec" {
+		app.slowlogPushEntryIfNeeded(client, commands, duration)
+	}
+	if command.flags&CMD_READONLY != 0 && client.flags&CLIENT_TRACKING != 0 {
+		app.trackingRememberKeys(client, command, commands)
+	}
+	if command.flags&CMD_WRITE != 0 && !isErrorResponse(response) {
+		app.propagate(client, commands)
+	}
+	return response
+}
+
+// ROLE: send the write command to the replicas
+// inside EXEC the writes are collected and sent wrapped in MULTI/EXEC
+func (app *App) propagate(client *Client, commands []string) {
+	if role != MASTER {
+		return
+	}
+	if client.flags&CLIENT_EXECUTING_MULTI != 0 {
+		client.pendingPropagation = append(client.pendingPropagation, propagatedCommand{
+			dbid:     client.db.id,
+			commands: commands,
+		})
+		return
+	}
+	app.replicationFeedSlaves(client.db.id, commands)
+}
+
+// ROLE: handle QUIT command, the connection is closed once the reply is sent
+func (app *App) executeQUIT(client *Client, commands []string) []byte {
+	client.flags |= CLIENT_CLOSE_AFTER_REPLY
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle COMMAND command
+func (app *App) executeCOMMAND(client *Client, commands []string) []byte {
+	return []byte("+PONG\r\n")
+}
+
+// ROLE: handle REPLCONF command
+func (app *App) executeREPLCONF(client *Client, commands []string) []byte {
+	if len(commands) == 3 && strings.EqualFold(commands[1], "listening-port") {
+		listeningPort, err := strconv.Atoi(commands[2])
 		if err != nil {
-			return err
+			return []byte("-ERR value is not an integer or out of range\r\n")
+		}
+		client.slaveListeningPort = listeningPort
+	}
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle SAVE command
+func (app *App) executeSAVE(client *Client, commands []string) []byte {
+	if bgsaveSnapshot != nil {
+		return []byte("-ERR Background save already in progress\r\n")
+	}
+	response, err := app.SAVE()
+	if err != nil {
+		app.logWarning("SAVE failed", "error", err)
+		return []byte(fmt.Sprintf("-ERR %s\r\n", err))
+	}
+	return response
+}
+
+// ROLE: handle PING command
+// subscribers on RESP2 get an array reply, as the connection is in push mode
+func (app *App) executePING(client *Client, commands []string) []byte {
+	if len(commands) > 2 {
+		return []byte("-ERR wrong number of arguments for 'ping' command\r\n")
+	}
+	if client.protocol == 2 && app.clientSubscriptionCount(client) > 0 {
+		message := ""
+		if len(commands) == 2 {
+			message = commands[1]
 		}
-		return app.WriteToClient(connection, res)
-	case strings.EqualFold(mainCommand, "GET"):
-		return app.WriteToClient(connection, app.executeGET(commands))
-	case strings.EqualFold(mainCommand, "CONFIG"):
-		return app.WriteToClient(connection, app.executeCONFIG(commands))
-	case strings.EqualFold(mainCommand, "KEYS"):
-		return app.WriteToClient(connection, app.executeKEYS(commands))
-	case strings.EqualFold(mainCommand, "save"):
-		response, err := app.SAVE()
+		return []byte(app.createRESPArray([]string{"pong", message}))
+	}
+	if len(commands) == 2 {
+		return app.createBulkStringResponse(commands[1])
+	}
+	return []byte("+PONG\r\n")
+}
+
+// ROLE: handle HELLO [protover [AUTH username password] [SETNAME clientname]] command,
+// switch the RESP protocol version
+func (app *App) executeHELLO(client *Client, commands []string) []byte {
+	protocol := client.protocol
+	if len(commands) >= 2 {
+		var err error
+		protocol, err = strconv.Atoi(commands[1])
 		if err != nil {
-			app.errorLogger.Println(err)
+			return []byte("-ERR Protocol version is not an integer or out of range\r\n")
 		}
-		return app.WriteToClient(connection, response)
-	case strings.EqualFold(mainCommand, "info"):
-		if len(commands) >= 2 && strings.EqualFold(commands[1], "replication") {
-			return app.WriteToClient(connection, app.INFO())
+		if protocol != 2 && protocol != 3 {
+			return []byte("-NOPROTO unsupported protocol version\r\n")
 		}
-		return app.WriteToClient(connection, ErrorResponse)
-	case strings.EqualFold(mainCommand, "REPLCONF"):
-		return app.WriteToClient(connection, []byte("+OK\r\n"))
-	case strings.EqualFold(mainCommand, "PSYNC"):
-		slaveConnections = append(slaveConnections, connection)
-		if err := app.WriteToClient(connection, app.executePSYNC()); err != nil {
-			return err
+	}
+	name, setName := "", false
+	username, password, auth := "", "", false
+	for i := 2; i < len(commands); i++ {
+		if strings.EqualFold(commands[i], "AUTH") && i+2 < len(commands) {
+			username, password, auth = commands[i+1], commands[i+2], true
+			i += 2
+			continue
 		}
-		// master operations
-		if role == MASTER && isFULLRESYNC {
-			fullResyncResponse, err := app.createfullResyncRDBFileResponse()
-			if err != nil {
-				app.errorLogger.Println("failed to send the FULLRESYNC rdb file to slave", err)
-				return err
-			}
-
-			err = app.WriteToClient(connection, fullResyncResponse)
-			if err != nil {
-				app.errorLogger.Println("failed to write the data to the connection", err)
-				return err
-			}
-			app.infoLogger.Println("Successfully send the RDB file for full resync.")
-
-			isFULLRESYNC = false
+		if strings.EqualFold(commands[i], "SETNAME") && i+1 < len(commands) {
+			name, setName = commands[i+1], true
+			i++
+			continue
 		}
-	default:
-		return app.WriteToClient(connection, []byte("- ERR send a valid command\r\n"))
+		return []byte(fmt.Sprintf("-ERR Syntax error in HELLO option '%s'\r\n", commands[i]))
 	}
-	return nil
+	if auth && !app.aclAuthenticateUser(client, username, password) {
+		return []byte("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	}
+	if app.authRequired(client) {
+		return []byte("-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n")
+	}
+	if setName {
+		if !validClientInfoString(name) {
+			return []byte("-ERR Client names cannot contain spaces, newlines or special characters.\r\n")
+		}
+		client.name = name
+	}
+	client.protocol = protocol
+	return app.createMapResponse(client, [][]byte{
+		app.createBulkStringResponse("server"), app.createBulkStringResponse("redis"),
+		app.createBulkStringResponse("version"), app.createBulkStringResponse(REDIS_SERVER_VERSION),
+		app.createBulkStringResponse("proto"), app.createIntegerResponse(client.protocol),
+		app.createBulkStringResponse("id"), app.createIntegerResponse(int(client.id)),
+		app.createBulkStringResponse("mode"), app.createBulkStringResponse("standalone"),
+		app.createBulkStringResponse("role"), app.createBulkStringResponse(role),
+		app.createBulkStringResponse("modules"), app.createRESPArrayOfElements(nil),
+	})
+}
+
+// ROLE: handle RESET command, bring the connection back to its default state
+func (app *App) executeRESET(client *Client, commands []string) []byte {
+	app.discardTransaction(client)
+	app.unwatchAllKeys(client)
+	app.pubsubUnsubscribeAll(client, false)
+	app.disableTracking(client)
+	app.stopMonitor(client)
+	client.protocol = 2
+	client.flags &^= CLIENT_REPLY_OFF | CLIENT_REPLY_SKIP_NEXT | CLIENT_NO_EVICT | CLIENT_NO_TOUCH
+	client.db = dbs[0]
+	// back to the default user, authenticated only if it has no password
+	client.user = defaultUser
+	client.authenticated = defaultUser.flags&USER_FLAG_NOPASS != 0 && defaultUser.flags&USER_FLAG_DISABLED == 0
+	return []byte("+RESET\r\n")
 }
 
 // ROLE: handle KEYS command
-func (app *App) executeKEYS(commands []string) []byte {
+func (app *App) executeKEYS(client *Client, commands []string) []byte {
 	if len(commands) >= 2 && commands[1] == "*" {
-		return app.KEY()
+		return app.KEY(client.db)
 	}
 	return []byte("-ERROR subcommand is missing\r\n")
 }
 
 // ROLE: handle echo command
-func (app *App) executeECHO(commands []string) []byte {
+func (app *App) executeECHO(client *Client, commands []string) []byte {
 	if len(commands) > 1 {
 		size := len(commands[1])
 		res := fmt.Sprintf("$%d\r\n%s\r\n", size, commands[1])
@@ -110,61 +302,74 @@ func (app *App) executeECHO(commands []string) []byte {
 }
 
 // ROLE: Send Commands to command handler(ops.go) and send response
-func (app *App) executeSET(commands []string) ([]byte, error) {
+func (app *App) executeSET(client *Client, commands []string) []byte {
 	if len(commands) >= 5 && strings.EqualFold(commands[3], "PX") {
 		expiry, err := strconv.ParseInt(commands[4], 10, 64)
 		if err != nil {
-			return nil, err
+			return []byte("-ERR value is not an integer or out of range\r\n")
 		}
 		return app.SET(
+			client.db,
 			commands[1],
-			Value{
+			&Value{
 				value:      commands[2],
 				expiration: time.Now().Add(time.Duration(expiry) * time.Millisecond),
 			},
-		), nil
+		)
 	} else if len(commands) >= 3 {
 		return app.SET(
+			client.db,
 			commands[1],
-			Value{
+			&Value{
 				value: commands[2],
 			},
-		), nil
+		)
 	}
-	return []byte("-ERR not enough args: Key or Value missing\r\n"), nil
+	return []byte("-ERR not enough args: Key or Value missing\r\n")
 }
 
 // ROLE: send commands to handler(ops.go) and get the response
-func (app *App) executeGET(commands []string) []byte {
+func (app *App) executeGET(client *Client, commands []string) []byte {
 	if len(commands) >= 2 {
-		return app.GET(commands[1])
+		return app.GET(client.db, commands[1])
 	}
 	return []byte("-ERR not enough args: Key missing\r\n")
 }
 
-// ROLE: handle CONFIG command
-func (app *App) executeCONFIG(commands []string) []byte {
-	if len(commands) == 1 {
-		return []byte("- ERR send a valid command missing GET or SET\r\n")
-	} else if len(commands) == 2 && strings.EqualFold(commands[1], "GET") {
-		return []byte("- ERR send a valid command missing dir or dbfilename\r\n")
-	} else if len(commands) == 2 && !strings.EqualFold(commands[1], "GET") {
-		return []byte("- ERR send a valid command missing GET or SET\r\n")
-	}
-
-	if len(commands) == 3 && strings.EqualFold(commands[2], "dir") {
-		return []byte(app.createRESPArray([]string{"dir", *dir}))
-	} else if len(commands) == 3 && strings.EqualFold(commands[2], "dbfilename") {
-		return []byte(app.createRESPArray([]string{"dbfilename", *dbFileName}))
+// ROLE: handle DEL key [key ...], replies the number of keys deleted
+func (app *App) executeDEL(client *Client, commands []string) []byte {
+	deleted := 0
+	for _, key := range commands[1:] {
+		if app.deleteKey(client.db, key) {
+			app.notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key, client.db.id)
+			deleted++
+		}
 	}
-
-	return []byte("- ERR send a valid command\r\n")
+	return app.createIntegerResponse(deleted)
 }
 
-func (app *App) executePSYNC() []byte {
+func (app *App) executePSYNC(client *Client, commands []string) []byte {
+	client.flags |= CLIENT_SLAVE
+	slaveConnections = append(slaveConnections, client)
+	// the stream of the new replica must start with a SELECT
+	slaveSelectedDb = -1
 	isFULLRESYNC = true
-	response := fmt.Sprintf("+FULLRESYNC %s %s\r\n", MASTER_REPL_ID_VALUE, MASTER_REPL_OFFSET_VALUE)
-	return []byte(response)
+	stats.syncFull++
+	response := []byte(fmt.Sprintf("+FULLRESYNC %s %s\r\n", MASTER_REPL_ID_VALUE, MASTER_REPL_OFFSET_VALUE))
+
+	// master operations
+	if role == MASTER && isFULLRESYNC {
+		fullResyncResponse, err := app.createfullResyncRDBFileResponse()
+		if err != nil {
+			app.logWarning("failed to send the FULLRESYNC rdb file to replica", "replica", client.addr, "error", err)
+			return response
+		}
+		response = append(response, fullResyncResponse...)
+		app.logNotice("Synchronization with replica succeeded", "replica", client.addr)
+
+		isFULLRESYNC = false
+	}
+	return response
 }
 
 /*
@@ -182,26 +387,87 @@ func (app *App) createRESPArray(data []string) string {
 	return respArray
 }
 
+// 2. create a Redis protocol Integer
+func (app *App) createIntegerResponse(number int) []byte {
+	return []byte(fmt.Sprintf(":%d\r\n", number))
+}
+
+// 3. create a Redis protocol Array of already encoded elements
+func (app *App) createRESPArrayOfElements(elements [][]byte) []byte {
+	response := []byte(fmt.Sprintf("*%d\r\n", len(elements)))
+	for _, element := range elements {
+		response = append(response, element...)
+	}
+	return response
+}
+
+// 4. create a Map (RESP3) or a flat Array of key, value pairs (RESP2)
+func (app *App) createMapResponse(client *Client, pairs [][]byte) []byte {
+	if client.protocol != 3 {
+		return app.createRESPArrayOfElements(pairs)
+	}
+	response := []byte(fmt.Sprintf("%%%d\r\n", len(pairs)/2))
+	for _, element := range pairs {
+		response = append(response, element...)
+	}
+	return response
+}
+
+// 5. create a Push (RESP3) or Array (RESP2) for out of band data, ex: pub/sub messages
+func (app *App) createPushResponse(client *Client, elements [][]byte) []byte {
+	if client.protocol != 3 {
+		return app.createRESPArrayOfElements(elements)
+	}
+	response := []byte(fmt.Sprintf(">%d\r\n", len(elements)))
+	for _, element := range elements {
+		response = append(response, element...)
+	}
+	return response
+}
+
+// 6. create a Null as per the client protocol
+func (app *App) createNullResponse(client *Client) []byte {
+	if client.protocol == 3 {
+		return []byte("_\r\n")
+	}
+	return []byte("$-1\r\n")
+}
+
+// 7. check if the response is a Redis protocol Error
+func isErrorResponse(response []byte) bool {
+	return len(response) > 0 && response[0] == ERROR
+}
+
+// 8. format the arguments for error messages: 'arg1' 'arg2'
+func (app *App) formatArgs(args []string) string {
+	var formatted strings.Builder
+	for _, arg := range args {
+		if formatted.Len() >= 128 {
+			break
+		}
+		if len(arg) > 128 {
+			arg = arg[:128]
+		}
+		formatted.WriteString(fmt.Sprintf("'%s' ", arg))
+	}
+	return formatted.String()
+}
+
 /*
 INFO: Handle the execution of the commands
 */
 // ROLE: handle the SET command
-func (app *App) SET(key string, value Value) []byte {
-	db[key] = value
-	app.infoLogger.Println("DB:", db)
+func (app *App) SET(db *Database, key string, value *Value) []byte {
+	app.setKey(db, key, value, NOTIFY_STRING, "set")
 	successResponse := []byte("+OK\r\n")
 	return successResponse
 }
 
 // ROLE: handle the GET command
-func (app *App) GET(key string) []byte {
-	value, ok := db[key]
+func (app *App) GET(db *Database, key string) []byte {
+	// expired keys are deleted by the keyspace layer
+	value, ok := app.lookupKeyRead(db, key)
 	if !ok {
-		return []byte("-1\r\n")
-	}
-
-	if time.Now().After(value.expiration) && !value.expiration.IsZero() {
-		delete(db, key)
 		return []byte("$-1\r\n")
 	}
 	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(value.value), value.value))
@@ -221,14 +487,17 @@ func (app *App) SAVE() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return []byte(fmt.Sprintf("+File Saved in file %s at %s\r\n", *dbFileName, *dir)), nil
+	return []byte(fmt.Sprintf("+File Saved in file %s at %s\r\n", dbFileName, dir)), nil
 }
 
 // get all elements using keys
-func (app *App) KEY() []byte {
+func (app *App) KEY(db *Database) []byte {
 	var keysArray []string
-	if len(db) > 0 {
-		for keys, _ := range db {
+	if len(db.dict) > 0 {
+		for keys, value := range db.dict {
+			if app.isExpired(value) {
+				continue
+			}
 			keysArray = append(keysArray, keys)
 		}
 		return []byte(app.createRESPArray(keysArray))
@@ -236,18 +505,17 @@ func (app *App) KEY() []byte {
 	return []byte("-ERROR: no data is saved\r\n")
 }
 
-// INFO replication execution
-func (app *App) INFO() []byte {
-	rolePair := fmt.Sprintf("%s:%s\r\n", ROLE, role)
-	masterIdPair := fmt.Sprintf("%s:%s\r\n", MASTER_REPL_ID, MASTER_REPL_ID_VALUE)
-	masterOffsetPair := fmt.Sprintf("%s:%s", MASTER_REPL_OFFSET, MASTER_REPL_OFFSET_VALUE)
-
-	return app.createBulkStringResponse(rolePair + masterIdPair + masterOffsetPair)
-}
-
 // ROLE: create bulk string response
 func (app *App) createBulkStringResponse(responseStrings string) []byte {
 	length := len(responseStrings)
 	response := fmt.Sprintf("$%d\r\n%s\r\n", length, responseStrings)
 	return []byte(response)
 }
+
+// 9. create a Verbatim string (RESP3) or Bulk string (RESP2), ex: CLIENT LIST
+func (app *App) createVerbatimStringResponse(client *Client, text string) []byte {
+	if client.protocol != 3 {
+		return app.createBulkStringResponse(text)
+	}
+	return []byte(fmt.Sprintf("=%d\r\ntxt:%s\r\n", len(text)+4, text))
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+INFO: Publish/Subscribe messaging
+The server keeps channel -> subscribers and pattern -> subscribers, the client
+keeps its own channels and patterns so it can be cleaned up on disconnect.
+Messages are queued in the subscriber output buffer, PUBLISH never waits for
+a subscriber to read.
+*/
+
+var (
+	pubsubChannels = make(map[string]map[*Client]bool)
+	pubsubPatterns = make(map[string]map[*Client]bool)
+)
+
+// commands a RESP2 client in subscriber mode is allowed to run
+var subscriberCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+}
+
+// ROLE: number of channels and patterns the client is subscribed to
+func (app *App) clientSubscriptionCount(client *Client) int {
+	return len(client.channels) + len(client.patterns)
+}
+
+// ROLE: check if the command can run while the client is in subscriber mode
+// RESP3 clients can run any command, messages are sent as push frames
+func (app *App) allowedInSubscriberMode(client *Client, command string) bool {
+	if client.protocol == 3 || app.clientSubscriptionCount(client) == 0 {
+		return true
+	}
+	return subscriberCommands[strings.ToLower(command)]
+}
+
+// ROLE: handle SUBSCRIBE channel [channel ...]
+func (app *App) executeSUBSCRIBE(client *Client, commands []string) []byte {
+	if len(commands) < 2 {
+		return []byte("-ERR wrong number of arguments for 'subscribe' command\r\n")
+	}
+	var response []byte
+	for _, channel := range commands[1:] {
+		if !client.channels[channel] {
+			client.channels[channel] = true
+			if pubsubChannels[channel] == nil {
+				pubsubChannels[channel] = make(map[*Client]bool)
+			}
+			pubsubChannels[channel][client] = true
+		}
+		response = append(response, app.createSubscriptionReply(client, "subscribe", &channel)...)
+	}
+	return response
+}
+
+// ROLE: handle PSUBSCRIBE pattern [pattern ...]
+func (app *App) executePSUBSCRIBE(client *Client, commands []string) []byte {
+	if len(commands) < 2 {
+		return []byte("-ERR wrong number of arguments for 'psubscribe' command\r\n")
+	}
+	var response []byte
+	for _, pattern := range commands[1:] {
+		if !client.patterns[pattern] {
+			client.patterns[pattern] = true
+			if pubsubPatterns[pattern] == nil {
+				pubsubPatterns[pattern] = make(map[*Client]bool)
+			}
+			pubsubPatterns[pattern][client] = true
+		}
+		response = append(response, app.createSubscriptionReply(client, "psubscribe", &pattern)...)
+	}
+	return response
+}
+
+// ROLE: handle UNSUBSCRIBE [channel ...]
+// without arguments the client is unsubscribed from all the channels
+func (app *App) executeUNSUBSCRIBE(client *Client, commands []string) []byte {
+	channels := commands[1:]
+	if len(channels) == 0 {
+		if len(client.channels) == 0 {
+			return app.createSubscriptionReply(client, "unsubscribe", nil)
+		}
+		for channel := range client.channels {
+			channels = append(channels, channel)
+		}
+	}
+	var response []byte
+	for _, channel := range channels {
+		app.pubsubUnsubscribeChannel(client, channel)
+		response = append(response, app.createSubscriptionReply(client, "unsubscribe", &channel)...)
+	}
+	return response
+}
+
+// ROLE: handle PUNSUBSCRIBE [pattern ...]
+// without arguments the client is unsubscribed from all the patterns
+func (app *App) executePUNSUBSCRIBE(client *Client, commands []string) []byte {
+	patterns := commands[1:]
+	if len(patterns) == 0 {
+		if len(client.patterns) == 0 {
+			return app.createSubscriptionReply(client, "punsubscribe", nil)
+		}
+		for pattern := range client.patterns {
+			patterns = append(patterns, pattern)
+		}
+	}
+	var response []byte
+	for _, pattern := range patterns {
+		app.pubsubUnsubscribePattern(client, pattern)
+		response = append(response, app.createSubscriptionReply(client, "punsubscribe", &pattern)...)
+	}
+	return response
+}
+
+// ROLE: handle PUBLISH channel message
+func (app *App) executePUBLISH(client *Client, commands []string) []byte {
+	if len(commands) != 3 {
+		return []byte("-ERR wrong number of arguments for 'publish' command\r\n")
+	}
+	receivers := app.publishMessage(commands[1], commands[2])
+	return app.createIntegerResponse(receivers)
+}
+
+// ROLE: handle PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func (app *App) executePUBSUB(client *Client, commands []string) []byte {
+	if len(commands) < 2 {
+		return []byte("-ERR wrong number of arguments for 'pubsub' command\r\n")
+	}
+	switch {
+	case strings.EqualFold(commands[1], "CHANNELS") && len(commands) <= 3:
+		channels := []string{}
+		for channel := range pubsubChannels {
+			if len(commands) == 3 && !globMatch(commands[2], channel, false) {
+				continue
+			}
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+		return []byte(app.createRESPArray(channels))
+	case strings.EqualFold(commands[1], "NUMSUB"):
+		elements := [][]byte{}
+		for _, channel := range commands[2:] {
+			elements = append(elements,
+				app.createBulkStringResponse(channel),
+				app.createIntegerResponse(len(pubsubChannels[channel])),
+			)
+		}
+		return app.createRESPArrayOfElements(elements)
+	case strings.EqualFold(commands[1], "NUMPAT") && len(commands) == 2:
+		return app.createIntegerResponse(len(pubsubPatterns))
+	case strings.EqualFold(commands[1], "HELP"):
+		return []byte(app.createRESPArray([]string{
+			"PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CHANNELS [<pattern>]",
+			"    Return the currently active channels matching a <pattern> (default: '*').",
+			"NUMPAT",
+			"    Return number of subscriptions to patterns.",
+			"NUMSUB [<channel> ...]",
+			"    Return the number of subscribers for the specified channels, excluding",
+			"    pattern subscriptions(default: no channels).",
+		}))
+	}
+	return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try PUBSUB HELP.\r\n", commands[1]))
+}
+
+// ROLE: deliver the message to the channel and pattern subscribers
+// returns the number of clients that received the message
+func (app *App) publishMessage(channel, message string) int {
+	receivers := 0
+	for subscriber := range pubsubChannels[channel] {
+		response := app.createPushResponse(subscriber, [][]byte{
+			app.createBulkStringResponse("message"),
+			app.createBulkStringResponse(channel),
+			app.createBulkStringResponse(message),
+		})
+		if err := app.WriteToClient(subscriber, response); err != nil {
+			app.logVerbose("failed to deliver the message to subscriber", "client", subscriber.addr, "error", err)
+			continue
+		}
+		receivers++
+	}
+	for pattern, subscribers := range pubsubPatterns {
+		if !globMatch(pattern, channel, false) {
+			continue
+		}
+		for subscriber := range subscribers {
+			response := app.createPushResponse(subscriber, [][]byte{
+				app.createBulkStringResponse("pmessage"),
+				app.createBulkStringResponse(pattern),
+				app.createBulkStringResponse(channel),
+				app.createBulkStringResponse(message),
+			})
+			if err := app.WriteToClient(subscriber, response); err != nil {
+				app.logVerbose("failed to deliver the message to subscriber", "client", subscriber.addr, "error", err)
+				continue
+			}
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// ROLE: remove the client from the channel subscribers
+func (app *App) pubsubUnsubscribeChannel(client *Client, channel string) {
+	delete(client.channels, channel)
+	if subscribers, ok := pubsubChannels[channel]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(pubsubChannels, channel)
+		}
+	}
+}
+
+// ROLE: remove the client from the pattern subscribers
+func (app *App) pubsubUnsubscribePattern(client *Client, pattern string) {
+	delete(client.patterns, pattern)
+	if subscribers, ok := pubsubPatterns[pattern]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(pubsubPatterns, pattern)
+		}
+	}
+}
+
+// ROLE: remove every subscription of the client (disconnect, RESET)
+// with notify the client receives the unsubscribe replies
+func (app *App) pubsubUnsubscribeAll(client *Client, notify bool) {
+	for channel := range client.channels {
+		app.pubsubUnsubscribeChannel(client, channel)
+		if notify {
+			app.WriteToClient(client, app.createSubscriptionReply(client, "unsubscribe", &channel))
+		}
+	}
+	for pattern := range client.patterns {
+		app.pubsubUnsubscribePattern(client, pattern)
+		if notify {
+			app.WriteToClient(client, app.createSubscriptionReply(client, "punsubscribe", &pattern))
+		}
+	}
+}
+
+// ROLE: create the (un)subscribe confirmation: kind, channel, subscription count
+// nil name is sent as null, when there was nothing to unsubscribe from
+func (app *App) createSubscriptionReply(client *Client, kind string, name *string) []byte {
+	nameElement := app.createNullResponse(client)
+	if name != nil {
+		nameElement = app.createBulkStringResponse(*name)
+	}
+	return app.createPushResponse(client, [][]byte{
+		app.createBulkStringResponse(kind),
+		nameElement,
+		app.createIntegerResponse(app.clientSubscriptionCount(client)),
+	})
+}
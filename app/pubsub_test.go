@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestPublishPatternSubscribers(t *testing.T) {
+	server := startTestServer(t)
+	subscriber := server.connect(t)
+	publisher := server.connect(t)
+
+	expectReply(t, subscriber.do("PSUBSCRIBE", "news.*", "h?llo"), []any{"psubscribe", "news.*", 1})
+	expectReply(t, subscriber.read(), []any{"psubscribe", "h?llo", 2})
+	expectReply(t, subscriber.do("SUBSCRIBE", "news.tech"), []any{"subscribe", "news.tech", 3})
+
+	// the channel subscription and the pattern both receive it
+	expectReply(t, publisher.do("PUBLISH", "news.tech", "go"), 2)
+	expectReply(t, subscriber.read(), []any{"message", "news.tech", "go"})
+	expectReply(t, subscriber.read(), []any{"pmessage", "news.*", "news.tech", "go"})
+
+	expectReply(t, publisher.do("PUBLISH", "hello", "world"), 1)
+	expectReply(t, subscriber.read(), []any{"pmessage", "h?llo", "hello", "world"})
+
+	expectReply(t, publisher.do("PUBLISH", "news", "nobody"), 0)
+	expectReply(t, publisher.do("PUBSUB", "NUMPAT"), 2)
+
+	expectReply(t, subscriber.do("PUNSUBSCRIBE", "news.*"), []any{"punsubscribe", "news.*", 2})
+	expectReply(t, publisher.do("PUBLISH", "news.sport", "nobody"), 0)
+	subscriber.expectNoReply()
+}
+
+func TestSubscriberModeRestrictions(t *testing.T) {
+	server := startTestServer(t)
+	subscriber := server.connect(t)
+
+	expectReply(t, subscriber.do("SUBSCRIBE", "chat"), []any{"subscribe", "chat", 1})
+	for _, command := range [][]string{{"GET", "key"}, {"SET", "key", "value"}, {"PUBLISH", "chat", "hi"}} {
+		reply, ok := subscriber.do(command...).(respError)
+		want := "ERR Can't execute '" + lookupCommand(command[0]).name + "': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"
+		if !ok || string(reply) != want {
+			t.Fatalf("%s in subscriber mode: got %v", command[0], reply)
+		}
+	}
+	// PING replies with an array in subscriber mode
+	expectReply(t, subscriber.do("PING", "hi"), []any{"pong", "hi"})
+	expectReply(t, subscriber.do("PSUBSCRIBE", "c*"), []any{"psubscribe", "c*", 2})
+
+	// out of subscriber mode once every subscription is gone
+	expectReply(t, subscriber.do("UNSUBSCRIBE"), []any{"unsubscribe", "chat", 1})
+	expectReply(t, subscriber.do("PUNSUBSCRIBE"), []any{"punsubscribe", "c*", 0})
+	expectReply(t, subscriber.do("SET", "key", "value"), "OK")
+
+	// RESP3 clients can run any command while subscribed
+	subscriber.do("HELLO", "3")
+	expectReply(t, subscriber.do("SUBSCRIBE", "chat"), []any{"subscribe", "chat", 1})
+	expectReply(t, subscriber.do("GET", "key"), "value")
+
+	// RESET leaves subscriber mode
+	subscriber.do("HELLO", "2")
+	expectReply(t, subscriber.do("RESET"), "RESET")
+	expectReply(t, subscriber.do("GET", "key"), "value")
+}
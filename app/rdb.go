@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,6 +10,9 @@ import (
 	"io"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,8 +33,6 @@ const (
 	REDIS_VERSION = "0011"
 	REDIS         = "REDIS"
 
-	DB_INDEX = 7
-
 	// Redis Value type
 	STRING_TYPE     = 0x00
 	LIST_TYPE       = 0x01
@@ -39,19 +41,76 @@ const (
 	HASH_TYPE       = 0x04
 )
 
+// for a save point, save after seconds if at least changes keys changed
+type savePoint struct {
+	seconds int
+	changes int
+}
+
+var (
+	// save points, empty when saving is disabled
+	saveParams []savePoint
+	// keys changed since the last save
+	dirty int64
+	// last successful save, the server start before the first one,
+	// and the result of the last save
+	lastSaveTime     time.Time
+	lastSaveStatusOK = true
+	// set while the RDB file is loaded at startup, read without the serverMutex
+	loading atomic.Bool
+)
+
+// ROLE: parse the save points, ex: "3600 1 300 100", "" disables saving
+func parseSaveParams(value string) ([]savePoint, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save points '%s', expected <seconds> <changes> pairs", value)
+	}
+	var points []savePoint
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, fmt.Errorf("invalid save point '%s %s'", fields[i], fields[i+1])
+		}
+		points = append(points, savePoint{seconds: seconds, changes: changes})
+	}
+	return points, nil
+}
+
+// ROLE: save points as shown by CONFIG GET save, ex: "3600 1 300 100"
+func saveParamsString(points []savePoint) string {
+	var fields []string
+	for _, point := range points {
+		fields = append(fields, fmt.Sprintf("%d %d", point.seconds, point.changes))
+	}
+	return strings.Join(fields, " ")
+}
+
+// ROLE: save the RDB file, the save info of INFO persistence is updated
+// caller must hold the serverMutex
 func (app *App) serializeRdbData() error {
 	// check file
 	rdbPath, err := app.checkRDBfile()
 	if err != nil {
+		lastSaveStatusOK = false
 		return err
 	}
 
-	// write to the file
-	err = app.writeRdbFile(rdbPath)
+	// write to a temp file, it replaces the RDB file once complete
+	tempPath, err := app.writeRdbFile(app.createRdbSnapshot(false))
+	if err == nil {
+		err = os.Rename(tempPath, rdbPath)
+	}
 	if err != nil {
+		lastSaveStatusOK = false
 		return err
 	}
 
+	dirty = 0
+	lastSaveTime = time.Now()
+	lastSaveStatusOK = true
+	stats.rdbSaves++
 	return nil
 }
 
@@ -60,7 +119,7 @@ func (app *App) checkRDBfile() (string, error) {
 	if err != nil {
 		return "", err
 	}*/
-	rdbPath := path.Join(*dir, *dbFileName)
+	rdbPath := path.Join(dir, dbFileName)
 
 	// check file exist, if not then create the RDB file
 	_, err := os.Stat(rdbPath)
@@ -77,49 +136,90 @@ func (app *App) checkRDBfile() (string, error) {
 	return rdbPath, nil
 }
 
-func (app *App) writeRdbFile(rdbPath string) error {
-	fmt.Println("starting writer...")
-	var headers = [][]byte{
-		[]byte(REDIS + REDIS_VERSION),
+// ROLE: write the snapshot to its temp file, returns the path of the file
+// the file is removed on error
+func (app *App) writeRdbFile(snapshot *rdbSnapshot) (tempPath string, err error) {
+	tempPath = snapshot.tempPath
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return "", err
 	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(tempPath)
+			tempPath = ""
+		}
+	}()
 
-	file, err := os.OpenFile(rdbPath, os.O_WRONLY, 0640)
-	if err != nil {
-		return err
+	if err = app.writeRdb(file, snapshot); err != nil {
+		return
 	}
-	defer file.Close()
+	err = file.Sync()
+	return
+}
 
-	writer := bufio.NewWriter(file)
+// ROLE: write the snapshot in the RDB format, to the file or to a replica
+func (app *App) writeRdb(out io.Writer, snapshot *rdbSnapshot) error {
+	var headers = [][]byte{
+		[]byte(REDIS + REDIS_VERSION),
+	}
+
+	// the checksum covers everything written before it
+	hash := crc64.New(crc64ECMATable)
+	writer := bufio.NewWriter(io.MultiWriter(out, hash))
 
 	// 1. write headers
 	for _, value := range headers {
-		_, err = writer.Write(value)
-		if err != nil {
+		if _, err := writer.Write(value); err != nil {
 			return err
 		}
 	}
 
 	// 2. write metadata
-	_, err = writer.Write([]byte{FA})
-	if err != nil {
+	if _, err := writer.Write([]byte{FA}); err != nil {
 		return err
 	}
-	err = app.stringEncoding(writer, "redis-ver")
-	if err != nil {
+	if err := app.stringEncoding(writer, "redis-ver"); err != nil {
 		return err
 	}
-	err = app.stringEncoding(writer, "6.0.0")
-	if err != nil {
+	if err := app.stringEncoding(writer, "6.0.0"); err != nil {
+		return err
+	}
+
+	// 3. Database sections, one for every database non empty at the snapshot
+	for _, db := range snapshot.dbs {
+		if err := app.writeDatabaseSection(writer, snapshot, db); err != nil {
+			return err
+		}
+	}
+
+	// 7. end of the rdb file
+	if _, err := writer.Write([]byte{FF}); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
 		return err
 	}
 
-	// 3. Database section
-	_, err = writer.Write([]byte{FE})
+	// 8. An 8-byte checksum of entire file
+	checksumByte := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksumByte, hash.Sum64())
+	_, err := out.Write(checksumByte)
+	return err
+}
+
+// ROLE: write the database as it was at the snapshot
+// in the background the serverMutex is only held to pick the keys, batch by batch
+func (app *App) writeDatabaseSection(writer io.Writer, snapshot *rdbSnapshot, db *snapshotDatabase) error {
+	_, err := writer.Write([]byte{FE})
 	if err != nil {
 		return err
 	}
 	// index of the database
-	lenSizeByte, err := app.lengthEncoding(DB_INDEX)
+	lenSizeByte, err := app.lengthEncoding(db.id)
 	if err != nil {
 		return err
 	}
@@ -134,7 +234,7 @@ func (app *App) writeRdbFile(rdbPath string) error {
 		return err
 	}
 	// actual size of the hashtable
-	lenDbByte, err := app.lengthEncoding(len(db))
+	lenDbByte, err := app.lengthEncoding(db.keys)
 	if err != nil {
 		return err
 	}
@@ -143,7 +243,7 @@ func (app *App) writeRdbFile(rdbPath string) error {
 		return err
 	}
 	// expiry hashtable size
-	lenExpiryTableSizeByte, err := app.lengthEncoding(app.getExpiryHashTableSize())
+	lenExpiryTableSizeByte, err := app.lengthEncoding(db.expires)
 	if err != nil {
 		return err
 	}
@@ -153,58 +253,87 @@ func (app *App) writeRdbFile(rdbPath string) error {
 	}
 
 	// 6. actual key:pair values
-	for key, value := range db {
-		if value.expiration.IsZero() {
-			err := app.writeKeyValuePair(writer, key, value)
-			if err != nil {
-				return err
-			}
-		} else {
-			// 1. Indicates that this key has an expire, ans it is in milliseconds
-			_, err := writer.Write([]byte{FC})
-			if err != nil {
-				return err
-			}
-			// the expiry timestamp
-			timestampByte, err := app.timestampEncoding(value.expiration)
-			if err != nil {
-				return err
-			}
-			if _, err = writer.Write(timestampByte); err != nil {
-				return err
-			}
-			// write key value pair
-			err = app.writeKeyValuePair(writer, key, value)
-			if err != nil {
+	// the values are never modified once in the dict, they are written
+	// without the serverMutex
+	batch := make([]string, 0, RDB_SAVE_BATCH)
+	values := make([]*Value, 0, RDB_SAVE_BATCH)
+	flush := func() error {
+		for i, key := range batch {
+			if err := app.writeKeyValueEntry(writer, key, values[i]); err != nil {
 				return err
 			}
-
 		}
+		batch, values = batch[:0], values[:0]
+		return nil
 	}
 
-	// 7. end of the rdb file
-	if _, err := writer.Write([]byte{FF}); err != nil {
-		return err
+	if snapshot.background {
+		serverMutex.Lock()
+		if snapshot.aborted {
+			serverMutex.Unlock()
+			return errRdbSaveAborted
+		}
 	}
-
-	// 8. An 8-byte checksum of entire file
-	checksumByte, err := app.calculateCRC64Checksum(rdbPath)
-	if err != nil {
-		return err
+	for key, live := range db.dict {
+		value := live
+		if snapshot.background {
+			value = db.valueToSave(key, live, snapshot.epoch)
+		}
+		if value == nil {
+			continue
+		}
+		batch = append(batch, key)
+		values = append(values, value)
+		if len(batch) < RDB_SAVE_BATCH || !snapshot.background {
+			continue
+		}
+		// the writes go on while the batch is written
+		serverMutex.Unlock()
+		err := flush()
+		serverMutex.Lock()
+		if err == nil && snapshot.aborted {
+			err = errRdbSaveAborted
+		}
+		if err != nil {
+			serverMutex.Unlock()
+			return err
+		}
 	}
-	_, err = writer.Write(checksumByte)
-	if err != nil {
-		return err
+	// the keys changed or deleted before the saver reached them
+	for key, preimage := range db.preimages {
+		if value := db.valueToSave(key, preimage, snapshot.epoch); value != nil {
+			batch = append(batch, key)
+			values = append(values, value)
+		}
+	}
+	if snapshot.background {
+		serverMutex.Unlock()
 	}
+	return flush()
+}
 
-	err = writer.Flush()
-	if err != nil {
-		return err
+// ROLE: write the key and its value, with its expire time if it has one
+func (app *App) writeKeyValueEntry(writer io.Writer, key string, value *Value) error {
+	if !value.expiration.IsZero() {
+		// 1. Indicates that this key has an expire, ans it is in milliseconds
+		_, err := writer.Write([]byte{FC})
+		if err != nil {
+			return err
+		}
+		// the expiry timestamp
+		timestampByte, err := app.timestampEncoding(value.expiration)
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write(timestampByte); err != nil {
+			return err
+		}
 	}
-	return nil
+	// write key value pair
+	return app.writeKeyValuePair(writer, key, value)
 }
 
-func (app *App) writeKeyValuePair(writer io.Writer, key string, value Value) error {
+func (app *App) writeKeyValuePair(writer io.Writer, key string, value *Value) error {
 	// 1. write value type
 	_, err := writer.Write([]byte{byte(STRING_TYPE)})
 	if err != nil {
@@ -252,13 +381,11 @@ func (app *App) lengthEncoding(length int) ([]byte, error) {
 	// case 1: 1 byte length: 6 bit actual length and 00 represent it
 	// 6 bit can take upto 64 length
 	if length < 1<<6 {
-		app.infoLogger.Println("1 Byte length encoded")
 		return []byte{byte(length)}, nil
 	}
 
 	// case 2: 2 byte length: 14 bit actual length
 	if length < 1<<14 {
-		app.infoLogger.Println("2 Byte length encoded")
 		return []byte{
 			byte(length>>8) | 0x40,
 			byte(length),
@@ -268,7 +395,6 @@ func (app *App) lengthEncoding(length int) ([]byte, error) {
 	// case 3: 5 byte length: last 4 byte(32 bit) actual length,
 	// first byte is used to represent(only 2MSB bit, discard last 6 bits)
 	if length <= 1<<32-1 {
-		app.infoLogger.Println("5 Byte length decoded")
 		buffer := make([]byte, 5)
 		buffer[0] = 0x80
 		binary.LittleEndian.PutUint32(buffer[1:], uint32(length))
@@ -284,50 +410,6 @@ func (app *App) timestampEncoding(timestamp time.Time) ([]byte, error) {
 	return buffer, nil
 }
 
-// create checkcum encoding
-func (app *App) calculateCRC64Checksum(filepath string) ([]byte, error) {
-	// Open the file
-	file, err := os.Open(filepath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	hash := crc64.New(crc64ECMATable)
-
-	buffer := make([]byte, 64*1024)
-	for {
-		n, err := file.Read(buffer)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-		hash.Write(buffer[:n])
-	}
-
-	// Get the checksum as a uint64
-	checksum := hash.Sum64()
-
-	// Encode the checksum as 8 bytes in little-endian format
-	checksumBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(checksumBytes, checksum)
-
-	return checksumBytes, nil
-}
-
-// ROLE: helper function to get size of hash table of expiry table
-func (app *App) getExpiryHashTableSize() int {
-	var size = 0
-	for _, value := range db {
-		if !value.expiration.IsZero() {
-			size++
-		}
-	}
-	return size
-}
-
 ///////////////////////////////////////////////////
 /*
 ROLE: Deserialize the RDB data
@@ -348,113 +430,98 @@ func (app *App) DeserializeRDB() error {
 	}
 	defer file.Close()
 
-	// create bufio reader to read the file
-	reader := file
-
-	// 1. check header to verify that is redis file
-	headerBuffer := make([]byte, 9)
-	if _, err = io.ReadFull(reader, headerBuffer); err != nil {
-		return err
-	}
-	if string(headerBuffer) != fmt.Sprintf("%s%s", REDIS, REDIS_VERSION) {
-		return fmt.Errorf("rdb file is not a valid Redis file")
-	}
-
-	// 2. read the metadata section
-	for {
-		readedByte := make([]byte, 1)
-		_, err := io.ReadFull(reader, readedByte)
-		if err != nil {
-			return err
-		}
-
-		if readedByte[0] == FE {
-			break
-		}
-	}
-
-	// Database section
-	// 3. read DB Index
-
-	dbByte := make([]byte, 1)
-	_, err = io.ReadFull(reader, dbByte)
+	// nothing to load from the new empty file
+	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
-	app.infoLogger.Println("DBINDEX: ", dbByte)
-
-	// 4. read the Hashtable Size
-	// Reading the FB
-	FBbyte := make([]byte, 1)
-	if _, err = io.ReadFull(reader, FBbyte); err != nil {
-		return err
+	if info.Size() == 0 {
+		return nil
 	}
-	// the actual size
 
-	mainTableSize := make([]byte, 1)
-	_, err = io.ReadFull(reader, mainTableSize)
-	if err != nil {
-		return err
-	}
-	app.infoLogger.Println("Main Table Size:", mainTableSize)
-
-	// if table size is zero then no data
-	//
-	//
-	//
-	//
-	//
+	return app.loadRdb(bufio.NewReader(file))
+}
 
-	// read the size of hashtable
-	ttlHashTableSizeByte := make([]byte, 1)
-	_, err = io.ReadFull(reader, ttlHashTableSizeByte)
-	if err != nil {
+// ROLE: load the keys of the RDB file, from the disk or sent by the master
+// caller must hold the serverMutex
+func (app *App) loadRdb(reader io.Reader) error {
+	// 1. check header to verify that is redis file
+	headerBuffer := make([]byte, 9)
+	if _, err := io.ReadFull(reader, headerBuffer); err != nil {
 		return err
 	}
-	app.infoLogger.Println("TTL Hash Table Size", ttlHashTableSizeByte)
+	if string(headerBuffer[:len(REDIS)]) != REDIS {
+		return fmt.Errorf("rdb file is not a valid Redis file")
+	}
 
-	// 5. Actual Key:Value pairs
-	for i := 0; i < int(mainTableSize[0]); i++ {
-		// case 1: can be FC or FD (tells about timeout expiry)
-		// case 2: if it is not with time expiry than it is value type byte
+	// 2. read the sections, every section starts with its opcode
+	// keys are loaded in the database selected by the last FE
+	db := dbs[0]
+	for {
 		readedByte := make([]byte, 1)
-		_, err := io.ReadFull(reader, readedByte)
-		if err != nil {
+		if _, err := io.ReadFull(reader, readedByte); err != nil {
 			return err
 		}
 
-		// check FC or FD for if it has expiry time with Key Value pair
 		switch readedByte[0] {
-		case FC:
-			app.infoLogger.Println("FC Type")
-			key, value, err := app.helperDeserializeExpiryKeyValue(reader)
+		case FA:
+			// metadata: name and value strings
+			name, err := app.helperDeserializeString(reader)
+			if err != nil {
+				return err
+			}
+			value, err := app.helperDeserializeString(reader)
+			if err != nil {
+				return err
+			}
+			app.logDebug("rdb aux field", "name", name, "value", value)
+		case FE:
+			// 3. read DB Index
+			index, err := app.helperdecodeLength(reader)
+			if err != nil {
+				return err
+			}
+			if index >= len(dbs) {
+				return fmt.Errorf("rdb file has database %d, but the server is configured with %d databases", index, len(dbs))
+			}
+			app.logDebug("rdb select db", "db", index)
+			db = dbs[index]
+		case FB:
+			// 4. read the Hashtable Size, main table and TTL table
+			mainTableSize, err := app.helperdecodeLength(reader)
 			if err != nil {
-				app.errorLogger.Println("Failed to deserialize", err)
 				return err
 			}
-			app.infoLogger.Println("Saving FC key value pair from RDB file...", "KEY:", key, "VALUE:", value)
-			db[key] = value
-		case FD:
-			app.infoLogger.Println("FD type")
-			key, value, err := app.helperDeserializeExpiryKeyValue(reader)
+			ttlHashTableSize, err := app.helperdecodeLength(reader)
 			if err != nil {
-				app.errorLogger.Println("Failed to deserialize", err)
 				return err
 			}
-			app.infoLogger.Println("Saving FD key value pair from RDB file...", "KEY:", key, "VALUE:", value)
-			db[key] = value
+			app.logDebug("rdb resize db", "keys", mainTableSize, "expires", ttlHashTableSize)
+		case FC, FD:
+			// 5. Key:Value pair with expiry, FC in milliseconds FD in seconds
+			key, value, err := app.helperDeserializeExpiryKeyValue(reader, readedByte[0])
+			if err != nil {
+				return err
+			}
+			// already expired keys are not loaded
+			if app.isExpired(value) {
+				continue
+			}
+			app.initAccessInfo(value)
+			app.dbAdd(db, key, value)
+		case FF:
+			// end of the file, followed by the checksum
+			return nil
 		default:
-			app.infoLogger.Println("Without expiry: ", readedByte)
+			// 5. Key:Value pair without expiry, the byte is the value type
 			key, value, err := app.helperDeserailizeKeyValue(reader, readedByte)
 			if err != nil {
-				app.errorLogger.Println("Failed to deserialize", err)
 				return err
 			}
-			app.infoLogger.Println("saving key value pair from RDB file...", "KEY:", key, "VALUE:", value.value)
-			db[key] = value
+			app.initAccessInfo(value)
+			app.dbAdd(db, key, value)
 		}
 	}
-	return nil
 }
 
 /*
@@ -462,29 +529,36 @@ Helper Functions for Deserialization
 */
 // ROLE: Helper
 // 1. Deserialize Key Value Pair which have expiry timeout
-func (app *App) helperDeserializeExpiryKeyValue(reader io.Reader) (string, Value, error) {
+// FC: 8 bytes unix time in milliseconds, FD: 4 bytes unix time in seconds
+func (app *App) helperDeserializeExpiryKeyValue(reader io.Reader, opcode byte) (string, *Value, error) {
 	// read the timestamp
-	timeStampByteBuffer := make([]byte, 8)
-	_, err := io.ReadFull(reader, timeStampByteBuffer)
-	if err != nil {
-		return "", Value{}, err
+	var timeExpiry time.Time
+	if opcode == FD {
+		timeStampByteBuffer := make([]byte, 4)
+		if _, err := io.ReadFull(reader, timeStampByteBuffer); err != nil {
+			return "", nil, err
+		}
+		timeExpiry = time.Unix(int64(binary.LittleEndian.Uint32(timeStampByteBuffer)), 0)
+	} else {
+		timeStampByteBuffer := make([]byte, 8)
+		if _, err := io.ReadFull(reader, timeStampByteBuffer); err != nil {
+			return "", nil, err
+		}
+		timeExpiryBinary := binary.LittleEndian.Uint64(timeStampByteBuffer)
+		timeExpiry = time.UnixMilli(int64(timeExpiryBinary))
 	}
-	timeExpiryBinary := binary.LittleEndian.Uint64(timeStampByteBuffer)
-	timeExpiry := time.UnixMilli(int64(timeExpiryBinary))
-	app.infoLogger.Println("Decoded time", timeExpiry)
 
 	// read the value type byte
 	valueTypeByte := make([]byte, 1)
-	_, err = io.ReadFull(reader, valueTypeByte)
+	_, err := io.ReadFull(reader, valueTypeByte)
 	if err != nil {
-		return "", Value{}, err
+		return "", nil, err
 	}
-	app.infoLogger.Println("Value type Byte", valueTypeByte)
 
 	// decode the key and value
 	key, value, err := app.helperDeserailizeKeyValue(reader, valueTypeByte)
 	if err != nil {
-		return "", Value{}, err
+		return "", nil, err
 	}
 
 	value.expiration = timeExpiry
@@ -494,33 +568,29 @@ func (app *App) helperDeserializeExpiryKeyValue(reader io.Reader) (string, Value
 
 // ROLE: Helper
 // 2. Deserialize KEY VALUE pair
-func (app *App) helperDeserailizeKeyValue(reader io.Reader, valueTypeByte []byte) (string, Value, error) {
+func (app *App) helperDeserailizeKeyValue(reader io.Reader, valueTypeByte []byte) (string, *Value, error) {
 	// read the key
 	key, err := app.helperDeserializeString(reader)
 	if err != nil {
-		return "", Value{}, err
+		return "", nil, err
 	}
-	app.infoLogger.Println("KEY decoded:", key)
 
 	var value string
 	// read the value
 	switch valueTypeByte[0] {
 	case STRING_TYPE:
-		app.infoLogger.Println("Decoding... Found String type")
 		value, err = app.helperDeserializeString(reader)
 		if err != nil {
-			return "", Value{}, err
+			return "", nil, err
 		}
-		app.infoLogger.Println("VALUE decoded:", value)
 	case HASH_TYPE:
 	case LIST_TYPE:
 	case SORTED_SET_TYPE:
 	case SET_TYPE:
 	default:
-		app.infoLogger.Println("Not a valid type to decode")
 	}
 
-	valueData := Value{
+	valueData := &Value{
 		value: value,
 	}
 	return key, valueData, nil
@@ -529,11 +599,18 @@ func (app *App) helperDeserailizeKeyValue(reader io.Reader, valueTypeByte []byte
 // ROLE: Helper
 // 3. Deseraialize the String types helper
 func (app *App) helperDeserializeString(reader io.Reader) (string, error) {
-	length, err := app.helperdecodeLength(reader)
+	buffer := make([]byte, 1)
+	if _, err := io.ReadFull(reader, buffer); err != nil {
+		return "", err
+	}
+	// 11 as MSB bits: the string is an integer, the rest tells its size
+	if buffer[0]&0xC0 == 0xC0 {
+		return app.helperDeserializeIntegerString(reader, buffer[0]&0x3F)
+	}
+	length, err := app.helperdecodeLength(io.MultiReader(bytes.NewReader(buffer), reader))
 	if err != nil {
 		return "", err
 	}
-	app.infoLogger.Println("Decoded Length:", length)
 
 	stringByte := make([]byte, length)
 	if _, err = io.ReadFull(reader, stringByte); err != nil {
@@ -543,6 +620,37 @@ func (app *App) helperDeserializeString(reader io.Reader) (string, error) {
 	return string(stringByte), nil
 }
 
+// ROLE: Helper
+// Deserialize the integer encoded strings
+// 0: 8 bit, 1: 16 bit, 2: 32 bit integer, 3: LZF compressed string
+func (app *App) helperDeserializeIntegerString(reader io.Reader, format byte) (string, error) {
+	var size int
+	switch format {
+	case 0:
+		size = 1
+	case 1:
+		size = 2
+	case 2:
+		size = 4
+	default:
+		return "", fmt.Errorf("unsupported string encoding %d", format)
+	}
+	intBytes := make([]byte, size)
+	if _, err := io.ReadFull(reader, intBytes); err != nil {
+		return "", err
+	}
+	var number int64
+	switch size {
+	case 1:
+		number = int64(int8(intBytes[0]))
+	case 2:
+		number = int64(int16(binary.LittleEndian.Uint16(intBytes)))
+	case 4:
+		number = int64(int32(binary.LittleEndian.Uint32(intBytes)))
+	}
+	return strconv.FormatInt(number, 10), nil
+}
+
 // ROLE: Helper
 // to decode the length
 func (app *App) helperdecodeLength(reader io.Reader) (int, error) {
@@ -557,10 +665,8 @@ func (app *App) helperdecodeLength(reader io.Reader) (int, error) {
 	prefixByte := firstByte & 0xC0
 	switch prefixByte {
 	case 0x00:
-		app.infoLogger.Println("1 Byte length decoded")
 		return int(firstByte & 0x3F), nil
 	case 0x40:
-		app.infoLogger.Println("2 Byte length decoded")
 		nextByte := make([]byte, 1)
 		_, err := io.ReadFull(reader, nextByte)
 		if err != nil {
@@ -572,7 +678,6 @@ func (app *App) helperdecodeLength(reader io.Reader) (int, error) {
 		// the OR with next byte gives full length byte
 		return int(firstByte&0x3F)<<8 | int(nextByte[0]), nil
 	case 0x80:
-		app.infoLogger.Println("5 Byte length decoded")
 		nextBytes := make([]byte, 4)
 		if _, err := io.ReadFull(reader, nextBytes); err != nil {
 			return -1, err
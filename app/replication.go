@@ -1,112 +1,257 @@
 package main
 
 import (
-	"encoding/hex"
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// configuration parameter, see the configTable
+	replPingReplicaPeriod int
+	// last PING sent to the replicas
+	lastReplicationPing time.Time
+	// set during the handshake with the master, read without the serverMutex
+	masterSyncInProgress atomic.Bool
 )
 
 // Send Handshake
 func (app *App) SendHandshake() error {
-	addressArr := strings.Split(*replicaof, " ")
+	masterSyncInProgress.Store(true)
+	defer masterSyncInProgress.Store(false)
+	addressArr := strings.Split(replicaof, " ")
 	if len(addressArr) != 2 {
 		return fmt.Errorf("--replicaof values are not valid.")
 	}
-	address := fmt.Sprintf("%s:%s", addressArr[0], addressArr[1])
+	address := net.JoinHostPort(addressArr[0], addressArr[1])
 
-	connection, err := net.Dial("tcp", address)
+	connection, err := app.dialMaster(address)
 	if err != nil {
 		return err
 	}
 
+	// replies are read line by line, the RDB file and the commands stream
+	// follow on the same connection
+	reader := bufio.NewReader(connection)
+
 	// 1. send PING command to Master
 	PING_COMMAND := "*1\r\n$4\r\nPING\r\n"
 	_, err = connection.Write([]byte(PING_COMMAND))
 	if err != nil {
 		return err
 	}
-	app.infoLogger.Println("Successfully send the PING handshake")
+	app.logNotice("MASTER <-> REPLICA sync started", "master", address)
 
-	pingRes := make([]byte, 7)
-	if _, err := connection.Read(pingRes); err != nil {
+	pingRes, err := reader.ReadString('\n')
+	if err != nil {
 		return err
 	}
-	app.infoLogger.Println("Successfully received response from the PING handshake", string(pingRes))
+	app.logNotice("Master replied to PING, replication can continue...", "reply", strings.TrimSpace(pingRes))
+
+	// authenticate with masteruser/masterauth when the master requires it
+	if masterauth != "" {
+		authCommand := []string{"AUTH", masterauth}
+		if masteruser != "" {
+			authCommand = []string{"AUTH", masteruser, masterauth}
+		}
+		if _, err = connection.Write([]byte(app.createRESPArray(authCommand))); err != nil {
+			return err
+		}
+		authRes, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(authRes, "-") {
+			return fmt.Errorf("failed to authenticate with the master: %s", strings.TrimSpace(authRes))
+		}
+	}
 
 	// 2. send REPLCONF command to master 2 times
 	// First: it'll notify about port on which it(replica/slave) is listening on
 	// Second: it'll send capabilities of the replica.
-	replConfFirstArrayReq := app.createRESPArray([]string{"REPLCONF", "listening-port", *port})
+	replConfFirstArrayReq := app.createRESPArray([]string{"REPLCONF", "listening-port", strconv.Itoa(port)})
 	replConfSecondArrayReq := app.createRESPArray([]string{"REPLCONF", "capa", "psync2"})
 	if _, err = connection.Write([]byte(replConfFirstArrayReq)); err != nil {
 		return err
 	}
-	app.infoLogger.Println("Successfully send the first REPLCONF handshake")
+	app.logVerbose("sent the REPLCONF listening-port handshake")
 
-	responseFirstREPLCONF := make([]byte, 5)
-	if _, err = connection.Read(responseFirstREPLCONF); err != nil {
+	responseFirstREPLCONF, err := reader.ReadString('\n')
+	if err != nil {
 		return err
 	}
-	app.infoLogger.Println("Successfully recieved response from fisrt REPLCONF handshake", string(responseFirstREPLCONF))
+	app.logVerbose("master replied to REPLCONF listening-port", "reply", strings.TrimSpace(responseFirstREPLCONF))
 
 	if _, err = connection.Write([]byte(replConfSecondArrayReq)); err != nil {
 		return err
 	}
-	app.infoLogger.Println("Successfully send the second REPLCONF handshake")
+	app.logVerbose("sent the REPLCONF capa handshake")
 
-	responseSecondREPLCONF := make([]byte, 5)
-	if _, err = connection.Read(responseFirstREPLCONF); err != nil {
-		return nil
+	responseSecondREPLCONF, err := reader.ReadString('\n')
+	if err != nil {
+		return err
 	}
-	app.infoLogger.Println("Successfully recieved response from the second REPLCONF handshake", string(responseSecondREPLCONF))
+	app.logVerbose("master replied to REPLCONF capa", "reply", strings.TrimSpace(responseSecondREPLCONF))
 
 	psyncArrayReq := app.createRESPArray([]string{"PSYNC", "?", "-1"})
 	if _, err := connection.Write([]byte(psyncArrayReq)); err != nil {
 		return err
 	}
-	app.infoLogger.Println("Successfully send the PSYNC handshake")
+	app.logVerbose("sent the PSYNC handshake")
 
-	psyncRes := make([]byte, 54)
-	if _, err = connection.Read(psyncRes); err != nil {
-		return nil
+	psyncRes, err := reader.ReadString('\n')
+	if err != nil {
+		return err
 	}
-	app.infoLogger.Println("Successfully recieved response from the PSYNC handshake", string(psyncRes))
+	app.logNotice("Full resync from master", "reply", strings.TrimSpace(psyncRes))
 
-	rdbFileBuffer := make([]byte, 1024)
-	if _, err = connection.Read(rdbFileBuffer); err != nil {
-		return nil
+	// 3. the rdb file: $<length_of_file>\r\n<contents_of_file>
+	rdbFile, err := app.readRDBFileFromMaster(reader)
+	if err != nil {
+		return err
+	}
+	// the dataset of the master replaces ours
+	app.logNotice("MASTER <-> REPLICA sync: Loading DB in memory", "rdb_bytes", len(rdbFile))
+	serverMutex.Lock()
+	for _, db := range dbs {
+		app.emptyDatabase(db, true)
 	}
-	app.infoLogger.Println("Successfully recieved the rdb file from master", string(rdbFileBuffer))
+	err = app.loadRdb(bytes.NewReader(rdbFile))
+	serverMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to load the rdb file of the master: %w", err)
+	}
+	app.logNotice("MASTER <-> REPLICA sync: Finished with success")
 
-	go func(connection net.Conn) {
-		defer connection.Close()
-		for {
-			buffer := make([]byte, 1024)
-			if _, err = connection.Read(buffer); err != nil {
-				app.errorLogger.Println("failed to read from master", err)
-				return
-			}
-			app.infoLogger.Println("Successfully recieved SET from master", string(buffer))
-		}
-	}(connection)
+	// 4. apply the commands stream of the master
+	masterConnection = connection
+	go app.handleMasterStream(connection, reader)
 
 	return nil
 }
 
-// send by master
-func (app *App) createfullResyncRDBFileResponse() ([]byte, error) {
-	//	$<length_of_file>\r\n<contents_of_file>
-	hexContent := "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
-
-	byteContent, err := hex.DecodeString(hexContent)
+// ROLE: read the RDB file sent by the master for the full resync
+func (app *App) readRDBFileFromMaster(reader *bufio.Reader) ([]byte, error) {
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	header = strings.TrimSpace(header)
+	if !strings.HasPrefix(header, "$") {
+		return nil, fmt.Errorf("unexpected rdb file header from master: %s", header)
+	}
+	size, err := strconv.Atoi(header[1:])
 	if err != nil {
 		return nil, err
 	}
+	rdbFile := make([]byte, size)
+	if _, err = io.ReadFull(reader, rdbFile); err != nil {
+		return nil, err
+	}
+	return rdbFile, nil
+}
+
+// ROLE: execute the commands received from the master
+// the master client gets no replies, see WriteToClient
+func (app *App) handleMasterStream(connection net.Conn, reader *bufio.Reader) {
+	serverMutex.Lock()
+	client := app.newClient(connection)
+	client.flags |= CLIENT_MASTER
+	// the master is trusted, no ACL checks
+	client.user = nil
+	client.authenticated = true
+	serverMutex.Unlock()
+	defer func() {
+		serverMutex.Lock()
+		app.freeClient(client)
+		serverMutex.Unlock()
+	}()
+	for {
+		commands, err := app.RESP(reader)
+		if err != nil {
+			app.logWarning("Connection with master lost.", "error", err)
+			return
+		}
+
+		serverMutex.Lock()
+		client.queryBufferSize = reader.Buffered()
+		err = app.ExecuteCommands(commands, client)
+		serverMutex.Unlock()
+		if err != nil {
+			app.logWarning("failed to execute the command from master", "error", err)
+			return
+		}
+	}
+}
 
-	size := len(byteContent)
+// for a write command waiting to be sent to the replicas
+type propagatedCommand struct {
+	dbid     int
+	commands []string
+}
 
-	response := fmt.Sprintf("$%d\r\n%s", size, string(byteContent))
+// database selected in the replication stream, -1 forces a SELECT
+var slaveSelectedDb = -1
 
-	return []byte(response), nil
+// ROLE: send the command to the replicas
+// a SELECT is sent first when the command runs in another database,
+// dbid -1 is for the commands of no database, ex: PING
+func (app *App) replicationFeedSlaves(dbid int, commands []string) {
+	if len(slaveConnections) == 0 {
+		return
+	}
+	var command string
+	if dbid >= 0 && dbid != slaveSelectedDb {
+		command = app.createRESPArray([]string{"SELECT", strconv.Itoa(dbid)})
+		slaveSelectedDb = dbid
+	}
+	command += app.createRESPArray(commands)
+	for _, slave := range slaveConnections {
+		// queued in the replica output buffer, a slow replica doesn't block us
+		if err := app.WriteToClient(slave, []byte(command)); err != nil {
+			app.logVerbose("failed to send the command to replica", "replica", slave.addr, "error", err)
+		}
+	}
+}
+
+// ROLE: ping the replicas every repl-ping-replica-period seconds, they know
+// the master is alive even when there are no writes
+// caller must hold the serverMutex
+func (app *App) replicationCron() {
+	if role != MASTER || len(slaveConnections) == 0 {
+		return
+	}
+	if time.Since(lastReplicationPing) >= time.Duration(replPingReplicaPeriod)*time.Second {
+		app.replicationFeedSlaves(-1, []string{"PING"})
+		lastReplicationPing = time.Now()
+	}
+}
+
+// ROLE: seconds the replica is behind, since its output buffer was last empty
+// the replicas don't acknowledge the offset, what was queued is what they miss
+func replicaLag(slave *Client) int {
+	slave.outputMutex.Lock()
+	defer slave.outputMutex.Unlock()
+	if slave.outputSize == 0 {
+		return 0
+	}
+	return int(time.Since(slave.outputDrainedTime).Seconds())
+}
+
+// send by master, the dataset at the PSYNC
+// caller must hold the serverMutex
+func (app *App) createfullResyncRDBFileResponse() ([]byte, error) {
+	//	$<length_of_file>\r\n<contents_of_file>
+	var rdbFile bytes.Buffer
+	if err := app.writeRdb(&rdbFile, app.createRdbSnapshot(false)); err != nil {
+		return nil, err
+	}
+	response := fmt.Sprintf("$%d\r\n", rdbFile.Len())
+	return append([]byte(response), rdbFile.Bytes()...), nil
 }
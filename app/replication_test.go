@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+// ROLE: the keys of the database and their value, holding the serverMutex
+func databaseContent(db *Database) map[string]string {
+	content := make(map[string]string)
+	for key, value := range db.dict {
+		content[key] = value.value
+	}
+	return content
+}
+
+func TestFullResyncSendsTheDataset(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	client.do("SET", "a", "1")
+	client.do("SET", "b", "2", "PX", "100000")
+	client.do("SELECT", "3")
+	client.do("SET", "c", "3")
+
+	replica := server.connectReplica(t)
+	if !bytes.HasPrefix(replica.rdb, []byte(REDIS+REDIS_VERSION)) {
+		t.Fatalf("RDB file of the full resync: %q", replica.rdb)
+	}
+	// loaded in place of the dataset, the same keys come back
+	server.locked(func() {
+		for _, db := range dbs {
+			server.app.emptyDatabase(db, false)
+		}
+		if err := server.app.loadRdb(bytes.NewReader(replica.rdb)); err != nil {
+			t.Fatal(err)
+		}
+		expectReply(t, databaseContent(dbs[0]), map[string]string{"a": "1", "b": "2"})
+		expectReply(t, databaseContent(dbs[3]), map[string]string{"c": "3"})
+		if len(dbs[0].expires) != 1 || !dbs[0].expires["b"] {
+			t.Fatalf("expires of db0: %v", dbs[0].expires)
+		}
+	})
+}
+
+// ROLE: listen like a master, answer the handshake of the replica with the
+// RDB file and send the commands stream, returns the address
+func fakeMaster(t *testing.T, rdbResponse []byte, stream ...[]string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { connection.Close() })
+		reader := bufio.NewReader(connection)
+		app := &App{}
+		for {
+			command, err := readTestReply(reader)
+			if err != nil {
+				return
+			}
+			switch name := strings.ToUpper(command.([]any)[0].(string)); name {
+			case "PING":
+				connection.Write([]byte("+PONG\r\n"))
+			case "REPLCONF":
+				connection.Write([]byte("+OK\r\n"))
+			case "PSYNC":
+				connection.Write([]byte("+FULLRESYNC " + strings.Repeat("a", 40) + " 0\r\n"))
+				connection.Write(rdbResponse)
+				for _, commands := range stream {
+					connection.Write([]byte(app.createRESPArray(commands)))
+				}
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestReplicaLoadsTheMasterDataset(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	// the dataset of the master
+	client.do("SET", "a", "1")
+	client.do("SELECT", "3")
+	client.do("SET", "b", "2")
+	var rdbResponse []byte
+	server.locked(func() {
+		var err error
+		if rdbResponse, err = server.app.createfullResyncRDBFileResponse(); err != nil {
+			t.Fatal(err)
+		}
+		for _, db := range dbs {
+			server.app.emptyDatabase(db, false)
+		}
+	})
+	// the dataset of the replica, loaded from its disk
+	client.do("SELECT", "0")
+	client.do("SET", "stale", "x")
+
+	address := fakeMaster(t, rdbResponse, []string{"SET", "c", "3"})
+	server.locked(func() {
+		role = SLAVE
+		replicaof = strings.Replace(address, ":", " ", 1)
+	})
+	if err := server.app.SendHandshake(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return dbs[0].dict["c"] != nil })
+	server.locked(func() {
+		expectReply(t, databaseContent(dbs[0]), map[string]string{"a": "1", "c": "3"})
+		expectReply(t, databaseContent(dbs[3]), map[string]string{"b": "2"})
+	})
+	if masterSyncInProgress.Load() {
+		t.Fatal("sync in progress after the handshake")
+	}
+}
+
+func TestReplicaRejectsAnInvalidRDBFile(t *testing.T) {
+	server := startTestServer(t)
+	address := fakeMaster(t, []byte("$5\r\nhello"))
+	server.locked(func() {
+		role = SLAVE
+		replicaof = strings.Replace(address, ":", " ", 1)
+	})
+	if err := server.app.SendHandshake(); err == nil || !strings.Contains(err.Error(), "rdb file of the master") {
+		t.Fatalf("handshake with an invalid RDB file: %v", err)
+	}
+}
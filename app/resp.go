@@ -2,8 +2,10 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"fmt"
+	"io"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -19,26 +21,24 @@ var ErrorResponse = []byte("- ERR send a valid command\r\n")
 // Redis RESP Parser
 // ROLE: The parser only converts raw input into structured data.
 // It does not execute the command, commands are handled separately(ops.go).
-func (app *App) RESP(inputdata []byte) ([]string, error) {
+// It reads one command from the stream, pipelined commands are read by the next calls.
+func (app *App) RESP(reader *bufio.Reader) ([]string, error) {
 	// 1. Read: Redis Data type -> Go Data Type
-	commands, err := app.readRESP(inputdata)
+	commands, err := app.readRESP(reader)
 	if err != nil {
-		app.errorLogger.Println("failed to parse the input data", err)
+		app.logDebug("failed to parse the input data", "error", err)
 		return nil, err
 	}
 	return commands, nil
 }
 
 // ROLE: Read parser
-func (app *App) readRESP(input []byte) ([]string, error) {
-	// read the input bytes
-	reader := bufio.NewReader(bytes.NewReader(input))
-
+func (app *App) readRESP(reader *bufio.Reader) ([]string, error) {
 	// 1. read a single byte
 	// start with first character which identify its Redis Data Type
 	firstSymbol, err := reader.ReadByte()
 	if err != nil {
-		app.errorLogger.Println("failed to read the firstSymbol", err)
+		app.logDebug("failed to read the firstSymbol", "error", err)
 		return nil, err
 	}
 	// single quoted characters are of type rune which is an alias of int32
@@ -47,7 +47,6 @@ func (app *App) readRESP(input []byte) ([]string, error) {
 	// so we are comparing integer values
 	switch firstSymbol {
 	case ARRAY:
-		app.infoLogger.Println("RESP: Array type input")
 		return app.respHandleArray(reader)
 	case BULK_STRING:
 		app.respHandleBulkString()
@@ -57,10 +56,23 @@ func (app *App) readRESP(input []byte) ([]string, error) {
 
 	case SIMPLE_STRING:
 
+	default:
+		// inline command, ex: PING\r\n from telnet
+		reader.UnreadByte()
+		return app.respHandleInline(reader)
 	}
 	return nil, nil
 }
 
+// ROLE: read an inline command, space separated arguments ending with \n
+func (app *App) respHandleInline(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(line), nil
+}
+
 // ROLE: Read the integer
 // ex: $3, *13 $113
 func (app *App) readInteger(reader *bufio.Reader) (int, error) {
@@ -91,7 +103,7 @@ func (app *App) respHandleArray(reader *bufio.Reader) ([]string, error) {
 	// ex: *4 or *10 or *100
 	length, err := app.readInteger(reader)
 	if err != nil {
-		app.errorLogger.Println("failed to read the length of array", err)
+		app.logDebug("failed to read the length of array", "error", err)
 		return commandArray, err
 	}
 
@@ -104,20 +116,20 @@ func (app *App) respHandleArray(reader *bufio.Reader) ([]string, error) {
 		// ex: $
 		sizeSymbol, err := reader.ReadByte()
 		if err != nil {
-			app.errorLogger.Println("failed to read the size symbol", err)
+			app.logDebug("failed to read the size symbol", "error", err)
 			return commandArray, err
 		}
 		// check and exit here: wrong request
 		if sizeSymbol != '$' {
-			app.infoLogger.Println("invalid request, not as per redis protocol")
-			return nil, err
+			app.logDebug("invalid request, not as per redis protocol")
+			return nil, fmt.Errorf("expected '$', got '%c'", sizeSymbol)
 		}
 
 		// 5. read the size of element
 		// ex: $4
 		size, err := app.readInteger(reader)
 		if err != nil {
-			app.errorLogger.Println("failed to read the size of the element", err)
+			app.logDebug("failed to read the size of the element", "error", err)
 			return commandArray, err
 		}
 
@@ -126,9 +138,9 @@ func (app *App) respHandleArray(reader *bufio.Reader) ([]string, error) {
 
 		// 6. read the actual first element
 		element := make([]byte, size)
-		_, err = reader.Read(element)
+		_, err = io.ReadFull(reader, element)
 		if err != nil {
-			app.errorLogger.Println("failed to read the element", err)
+			app.logDebug("failed to read the element", "error", err)
 			return commandArray, err
 		}
 
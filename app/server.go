@@ -1,12 +1,14 @@
 package main
 
 import (
-	"flag"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"os"
-	"strings"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 /*
@@ -15,17 +17,42 @@ INFO: Entry point of the server
 
 // map to store the data
 var (
-	db           = make(map[string]Value)
+	dbs          []*Database
 	isFULLRESYNC = false
 	// be default
 	role             = MASTER
 	masterConnection net.Conn
-	slaveConnections = []net.Conn{}
-	// flags
-	dir        = flag.String("dir", ".redis/rdb/", "Redis RDB file path")
-	dbFileName = flag.String("dbfilename", "redis.rdb", "Redis RDB file name")
-	port       = flag.String("port", "6379", "Redis-Go server port")
-	replicaof  = flag.String("replicaof", "localhost 6379", "info about the master redis-go replica")
+	slaveConnections = []*Client{}
+	// guards the server state shared between the connections
+	serverMutex sync.Mutex
+	stats       Stats
+	// configuration parameters, see the configTable
+	dir                string
+	dbFileName         string
+	port               int
+	bind               string
+	unixsocket         string
+	unixsocketperm     string
+	shutdownTimeout    int
+	pidfile            string
+	maxclients         int
+	clientTimeout      int
+	tcpKeepalive       int
+	replicaof          string
+	databases          int
+	masteruser         string
+	masterauth         string
+	tlsPort            int
+	tlsCertFile        string
+	tlsKeyFile         string
+	tlsCACertFile      string
+	tlsAuthClients     string
+	tlsAuthClientsUser string
+	tlsProtocols       string
+	tlsCiphers         string
+	tlsReplication     bool
+	tlsClientCertFile  string
+	tlsClientKeyFile   string
 )
 
 const (
@@ -36,57 +63,98 @@ const (
 	MASTER_REPL_OFFSET       = "master_repl_offset"
 	MASTER_REPL_ID_VALUE     = "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
 	MASTER_REPL_OFFSET_VALUE = "0"
+	REDIS_SERVER_VERSION     = "7.2.0"
 )
 
 func main() {
-	// parse the flags
-	flag.Parse()
+	app := App{}
+	serverStartTime = time.Now()
+	lastSaveTime = serverStartTime
+	runID = randomHex(40)
+	// the config file and the --name value overrides
+	if err := app.loadServerConfig(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if replicaof != "" {
+		role = SLAVE
+	}
 
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == "replicaof" {
-			role = SLAVE
-		}
-	})
+	logger, err := newServerLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	app.logger = logger
+
+	app.initDatabases(databases)
+	if err := app.initACL(requirepass, aclFile); err != nil {
+		app.logWarning("failed to load the ACL file", "error", err)
+		os.Exit(1)
+	}
+	// the probes answer while the dataset loads, not ready until it's loaded
+	loading.Store(true)
+	if err := app.openHTTPListeners(); err != nil {
+		app.logWarning(err.Error())
+		os.Exit(1)
+	}
 
-	prefixInfo := fmt.Sprintf("INFO: %s ", strings.ToUpper(role))
-	prefixError := fmt.Sprintf("ERROR: %s ", strings.ToUpper(role))
-	// loggers
-	infoLogger := log.New(os.Stdout, prefixInfo, log.Lshortfile)
-	errorLogger := log.New(os.Stderr, prefixError, log.Lshortfile)
-	app := App{
-		infoLogger:  infoLogger,
-		errorLogger: errorLogger,
+	loadStart := time.Now()
+	serverMutex.Lock()
+	if err := app.DeserializeRDB(); err != nil {
+		app.logWarning("failed to deserialize the rdb file", "error", err)
+	} else {
+		app.logNotice("DB loaded from disk", "seconds", fmt.Sprintf("%.3f", time.Since(loadStart).Seconds()))
 	}
+	loading.Store(false)
+	serverMutex.Unlock()
 
+	// the dataset of the master replaces the one loaded from the disk
 	if role == SLAVE {
 		err := app.SendHandshake()
 		if err != nil {
-			app.errorLogger.Println("failed to send the handshake", err)
+			app.logWarning("failed to send the handshake", "error", err)
 			return
 		}
 	}
 
-	err := app.DeserializeRDB()
-	if err != nil {
-		app.errorLogger.Println("failed to deserialize the rdb file", err)
-	}
+	app.recordStartupMemory()
+	go app.serverCron()
 
-	address := fmt.Sprintf("0.0.0.0:%s", *port)
-	app.infoLogger.Println("server starting at port", address)
-	// establish socket connection
-	listner, err := net.Listen("tcp", address)
+	serverMutex.Lock()
+	err = app.openListeners()
+	serverMutex.Unlock()
 	if err != nil {
-		app.errorLogger.Println("Failed to bind to port", address)
+		app.logWarning(err.Error())
 		os.Exit(1)
 	}
-	defer listner.Close()
+	if err := app.createPidFile(); err != nil {
+		app.logWarning("failed to write the pidfile", "error", err)
+	}
+	app.logNotice("Ready to accept connections")
 
+	// SIGTERM and SIGINT schedule a shutdown, like the SHUTDOWN command,
+	// SIGHUP reloads the config file
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go app.handleSignals(signals)
+
+	<-shutdownDone
+	app.logWarning("Redis is now ready to exit, bye bye...")
+}
+
+// ROLE: accept the connections of the listener
+func (app *App) acceptConnections(listner net.Listener) {
 	for {
 		// start accepting connection on the socket address and port
 		// INFO: blocking call
 		connection, err := listner.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			// closed by the shutdown
+			return
+		}
 		if err != nil {
-			app.errorLogger.Println("failed to accept connection: ", err.Error())
+			app.logWarning("failed to accept connection", "error", err)
 			os.Exit(1)
 		}
 
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+INFO: Test harness
+A test starts the server in the test process, on a random port of the
+loopback, with a config file in a temp dir. The server state is global, the
+tests can't run in parallel. The serverCron doesn't run, the tests call the
+cron functions themselves holding the serverMutex.
+*/
+
+// how long a test client waits for a reply
+const TEST_TIMEOUT = 5 * time.Second
+
+// for a server started by a test
+type testServer struct {
+	app      *App
+	listener net.Listener
+	addr     string
+	dir      string
+}
+
+// for an error reply
+type respError string
+
+// ROLE: start a server with the config lines, stopped at the end of the test
+func startTestServer(t *testing.T, configLines ...string) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	lines := append([]string{
+		"dir " + dir,
+		"logfile " + filepath.Join(dir, "redis.log"),
+		"save \"\"",
+	}, configLines...)
+	file := filepath.Join(dir, "redis.conf")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	app := &App{}
+	if err := app.loadServerConfig([]string{file}); err != nil {
+		t.Fatal(err)
+	}
+	logger, err := newServerLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.logger = logger
+	resetServerState(app)
+	app.initDatabases(databases)
+	if err := app.initACL(requirepass, aclFile); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverListeners = []net.Listener{listener}
+	go app.acceptConnections(listener)
+	server := &testServer{app: app, listener: listener, addr: listener.Addr().String(), dir: dir}
+	t.Cleanup(server.stop)
+	return server
+}
+
+// ROLE: bring the global server state back to a fresh start
+// caller must hold the serverMutex
+func resetServerState(app *App) {
+	role = MASTER
+	masterConnection = nil
+	slaveConnections = nil
+	slaveSelectedDb = -1
+	isFULLRESYNC = false
+	clients = make(map[int64]*Client)
+	currentClient = nil
+	clientsPauseType = CLIENT_PAUSE_OFF
+	monitors = nil
+	pubsubChannels = make(map[string]map[*Client]bool)
+	pubsubPatterns = make(map[string]map[*Client]bool)
+	trackingTable = make(map[string]map[int64]bool)
+	trackingPrefixTable = make(map[string]map[*Client]bool)
+	slowlog, slowlogNextID = nil, 0
+	latencyEvents = make(map[string]*latencyTimeSeries)
+	aclLog, aclLogNextID = nil, 0
+	evictionPool, nextRandomDb = nil, 0
+	dirty = 0
+	serverStartTime = time.Now()
+	lastSaveTime = serverStartTime
+	lastSaveStatusOK = true
+	runID = randomHex(40)
+	loading.Store(false)
+	masterSyncInProgress.Store(false)
+	bgsaveSnapshot, bgsaveScheduled = nil, false
+	lastBgsaveDuration = -1
+	shutdownAsap, shutdownInProgress, shutdownFinished = false, false, false
+	shutdownBlockedClients = nil
+	shutdownDone = make(chan struct{})
+	app.resetServerStats()
+}
+
+// ROLE: close the listener and the clients
+func (server *testServer) stop() {
+	server.listener.Close()
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	for _, client := range clients {
+		server.app.freeClient(client)
+	}
+}
+
+// ROLE: run the function holding the serverMutex, like a command
+func (server *testServer) locked(fn func()) {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	fn()
+}
+
+// for a connection to the test server
+type testClient struct {
+	t          *testing.T
+	connection net.Conn
+	reader     *bufio.Reader
+}
+
+// ROLE: connect a client, closed at the end of the test
+func (server *testServer) connect(t *testing.T) *testClient {
+	t.Helper()
+	connection, err := net.Dial("tcp", server.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { connection.Close() })
+	client := &testClient{t: t, connection: connection, reader: bufio.NewReader(connection)}
+	// the server knows the client once it answered
+	client.do("PING")
+	return client
+}
+
+// ROLE: send the command and read its reply
+func (client *testClient) do(args ...string) any {
+	client.t.Helper()
+	client.send(args...)
+	return client.read()
+}
+
+// ROLE: send the command without reading the reply
+func (client *testClient) send(args ...string) {
+	client.t.Helper()
+	var command strings.Builder
+	command.WriteString(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		command.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+	if _, err := client.connection.Write([]byte(command.String())); err != nil {
+		client.t.Fatal(err)
+	}
+}
+
+// ROLE: read the next reply
+func (client *testClient) read() any {
+	client.t.Helper()
+	client.connection.SetReadDeadline(time.Now().Add(TEST_TIMEOUT))
+	reply, err := readTestReply(client.reader)
+	if err != nil {
+		client.t.Fatal(err)
+	}
+	return reply
+}
+
+// ROLE: check no reply is waiting to be read
+func (client *testClient) expectNoReply() {
+	client.t.Helper()
+	client.connection.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if reply, err := readTestReply(client.reader); err == nil {
+		client.t.Fatalf("unexpected reply %v", reply)
+	}
+}
+
+// ROLE: decode a RESP2 or RESP3 reply
+// simple and bulk strings are strings, errors respError, integers int64,
+// nulls nil, and arrays, push, maps and sets []any
+func readTestReply(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '$', '=':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		if line[0] == '=' {
+			return string(data[4:size]), nil
+		}
+		return string(data[:size]), nil
+	case '*', '>', '~', '%':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		if line[0] == '%' {
+			count *= 2
+		}
+		elements := make([]any, count)
+		for i := range elements {
+			if elements[i], err = readTestReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// for a connection doing the replica side of the replication
+type testReplica struct {
+	*testClient
+	// RDB file of the full resync
+	rdb []byte
+}
+
+// ROLE: connect a replica, it gets the full resync then the commands stream
+func (server *testServer) connectReplica(t *testing.T) *testReplica {
+	t.Helper()
+	client := server.connect(t)
+	if reply := client.do("REPLCONF", "listening-port", "6380"); reply != "OK" {
+		t.Fatalf("REPLCONF: %v", reply)
+	}
+	client.send("PSYNC", "?", "-1")
+	if reply, ok := client.read().(string); !ok || !strings.HasPrefix(reply, "FULLRESYNC") {
+		t.Fatalf("PSYNC: %v", reply)
+	}
+	header, err := client.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(header)[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no CRLF after the RDB file
+	rdb := make([]byte, size)
+	if _, err := io.ReadFull(client.reader, rdb); err != nil {
+		t.Fatal(err)
+	}
+	return &testReplica{testClient: client, rdb: rdb}
+}
+
+// ROLE: the server side of the test client
+// caller must hold the serverMutex
+func (server *testServer) clientOf(client *testClient) *Client {
+	for _, serverClient := range clients {
+		if serverClient.addr == client.connection.LocalAddr().String() {
+			return serverClient
+		}
+	}
+	return nil
+}
+
+// ROLE: make the server a replica and connect a client as its master
+// the master link gets no replies, like handleMasterStream
+func (server *testServer) connectMaster(t *testing.T) *testClient {
+	t.Helper()
+	client := server.connect(t)
+	server.locked(func() {
+		role = SLAVE
+		master := server.clientOf(client)
+		master.flags |= CLIENT_MASTER
+		master.user = nil
+		master.authenticated = true
+	})
+	return client
+}
+
+// ROLE: read the next command of the replication stream
+func (replica *testReplica) readCommand() []string {
+	replica.t.Helper()
+	elements, ok := replica.read().([]any)
+	if !ok {
+		replica.t.Fatalf("expected a command in the replication stream")
+	}
+	command := make([]string, len(elements))
+	for i, element := range elements {
+		command[i] = element.(string)
+	}
+	return command
+}
+
+// ROLE: read the next commands and compare them with the expected ones
+func (replica *testReplica) expectCommands(expected ...[]string) {
+	replica.t.Helper()
+	for _, want := range expected {
+		got := replica.readCommand()
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			replica.t.Fatalf("replication stream: got %q, want %q", got, want)
+		}
+	}
+}
+
+// ROLE: wait until the condition holds, checked holding the serverMutex
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(TEST_TIMEOUT)
+	for {
+		serverMutex.Lock()
+		done := condition()
+		serverMutex.Unlock()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ROLE: compare a reply with the expected one
+func expectReply(t *testing.T, got any, want any) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+INFO: Graceful shutdown (SHUTDOWN, SIGTERM and SIGINT)
+The listeners are closed and the writes paused while the replicas catch up,
+at most shutdown-timeout seconds. A replica has caught up once its output
+buffer, the replication stream, is written to its connection.
+Then the RDB is saved, with SAVE or when save points are configured, the
+clients are closed once their pending replies are written and the pidfile
+is removed. SHUTDOWN ABORT cancels a shutdown waiting for the replicas.
+*/
+
+// SHUTDOWN flags
+const (
+	SHUTDOWN_NOFLAGS = 0
+	SHUTDOWN_SAVE    = 1 << iota // SAVE, save even without save points
+	SHUTDOWN_NOSAVE              // NOSAVE, don't save even with save points
+	SHUTDOWN_NOW                 // NOW, don't wait for the replicas
+	SHUTDOWN_FORCE               // FORCE, ignore the errors saving the RDB
+)
+
+// at most that long to write the pending replies of the clients before exiting
+const SHUTDOWN_FLUSH_TIMEOUT = time.Second
+
+var (
+	// a signal asked for a shutdown, started by the serverCron
+	shutdownAsap bool
+	// shutdown waiting for the replicas until the deadline
+	shutdownInProgress bool
+	shutdownFlags      int
+	shutdownDeadline   time.Time
+	// clients blocked on SHUTDOWN, they get an error if it fails
+	shutdownBlockedClients []*Client
+	// the shutdown finished, no client is accepted anymore
+	shutdownFinished bool
+	// closed once the clients are flushed, main returns
+	shutdownDone = make(chan struct{})
+	// writer goroutines of the clients, waited before exiting
+	clientWriters sync.WaitGroup
+)
+
+// ROLE: handle SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]
+func (app *App) executeSHUTDOWN(client *Client, commands []string) []byte {
+	flags := SHUTDOWN_NOFLAGS
+	abort := false
+	for _, option := range commands[1:] {
+		switch strings.ToUpper(option) {
+		case "NOSAVE":
+			flags |= SHUTDOWN_NOSAVE
+		case "SAVE":
+			flags |= SHUTDOWN_SAVE
+		case "NOW":
+			flags |= SHUTDOWN_NOW
+		case "FORCE":
+			flags |= SHUTDOWN_FORCE
+		case "ABORT":
+			abort = true
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+	if (abort && flags != SHUTDOWN_NOFLAGS) || (flags&SHUTDOWN_SAVE != 0 && flags&SHUTDOWN_NOSAVE != 0) {
+		return []byte("-ERR syntax error\r\n")
+	}
+
+	if abort {
+		if !shutdownInProgress {
+			return []byte("-ERR No shutdown in progress.\r\n")
+		}
+		app.abortShutdown()
+		return []byte("+OK\r\n")
+	}
+	if shutdownInProgress {
+		return []byte("-ERR Shutdown already in progress.\r\n")
+	}
+
+	if !app.prepareForShutdown(flags) {
+		return []byte("-ERR Errors trying to SHUTDOWN. Check logs.\r\n")
+	}
+	if shutdownInProgress {
+		// waiting for the replicas, the reply comes if the shutdown fails
+		client.flags |= CLIENT_BLOCKED
+		shutdownBlockedClients = append(shutdownBlockedClients, client)
+	}
+	// on success the connection is closed without a reply
+	return nil
+}
+
+// ROLE: start the shutdown, stop accepting clients and pause the writes
+// while the replicas catch up, or finish it right away
+// returns false if the shutdown failed
+// caller must hold the serverMutex
+func (app *App) prepareForShutdown(flags int) bool {
+	app.logWarning("User requested shutdown...")
+	app.closeListeners()
+
+	timeout := time.Duration(shutdownTimeout) * time.Second
+	if flags&SHUTDOWN_NOW == 0 && timeout > 0 && len(slaveConnections) > 0 {
+		shutdownInProgress = true
+		shutdownFlags = flags
+		shutdownDeadline = time.Now().Add(timeout)
+		app.pauseClients(CLIENT_PAUSE_WRITE, shutdownDeadline)
+		app.logNotice("Waiting for replicas before shutting down")
+		return true
+	}
+	return app.finishShutdown(flags)
+}
+
+// ROLE: finish the shutdown once the replicas caught up or the deadline passed
+// called by the serverCron, caller must hold the serverMutex
+func (app *App) shutdownCron() {
+	if shutdownAsap && !shutdownInProgress && !shutdownFinished {
+		if !app.prepareForShutdown(SHUTDOWN_NOFLAGS) {
+			app.logWarning("SIGTERM received but errors trying to shut down the server, check the logs for more information")
+			shutdownAsap = false
+		}
+		return
+	}
+	if !shutdownInProgress {
+		return
+	}
+	if !app.replicasCaughtUp() {
+		if time.Now().Before(shutdownDeadline) {
+			return
+		}
+		app.logWarning("Replicas didn't catch up within shutdown-timeout, shutting down anyway")
+	}
+	shutdownInProgress = false
+	if !app.finishShutdown(shutdownFlags) {
+		app.replyToShutdownBlockedClients("-ERR Errors trying to SHUTDOWN. Check logs.\r\n")
+		app.unpauseClients()
+		shutdownAsap = false
+	}
+}
+
+// ROLE: tell if every replica received the whole replication stream
+func (app *App) replicasCaughtUp() bool {
+	for _, slave := range slaveConnections {
+		slave.outputMutex.Lock()
+		pending := slave.outputSize
+		slave.outputMutex.Unlock()
+		if pending > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ROLE: save the RDB, close the clients and remove the pidfile
+// returns false, and accepts clients again, if the RDB can't be saved without FORCE
+// caller must hold the serverMutex
+func (app *App) finishShutdown(flags int) bool {
+	app.abortBackgroundSave()
+	if flags&SHUTDOWN_SAVE != 0 || (len(saveParams) > 0 && flags&SHUTDOWN_NOSAVE == 0) {
+		app.logNotice("Saving the final RDB snapshot before exiting.")
+		if err := app.serializeRdbData(); err != nil {
+			if flags&SHUTDOWN_FORCE == 0 {
+				app.logWarning("Error trying to save the DB, can't exit.", "error", err)
+				if err := app.openListeners(); err != nil {
+					app.logWarning("failed to accept clients again", "error", err)
+				}
+				return false
+			}
+			app.logWarning("Error trying to save the DB, exiting anyway (FORCE).", "error", err)
+		}
+	}
+
+	app.removePidFile()
+	shutdownFinished = true
+	for _, client := range clients {
+		app.freeClient(client)
+	}
+	go app.exitAfterFlush()
+	return true
+}
+
+// ROLE: cancel the shutdown waiting for the replicas
+// caller must hold the serverMutex
+func (app *App) abortShutdown() {
+	app.logWarning("Shutdown aborted")
+	shutdownInProgress = false
+	shutdownAsap = false
+	app.replyToShutdownBlockedClients("-ERR Errors trying to SHUTDOWN. Check logs.\r\n")
+	app.unpauseClients()
+	if err := app.openListeners(); err != nil {
+		app.logWarning("failed to accept clients again", "error", err)
+	}
+}
+
+// ROLE: unblock the clients waiting on SHUTDOWN with the reply
+func (app *App) replyToShutdownBlockedClients(reply string) {
+	for _, client := range shutdownBlockedClients {
+		client.flags &^= CLIENT_BLOCKED
+		app.WriteToClient(client, []byte(reply))
+	}
+	shutdownBlockedClients = nil
+	clientsPauseCond.Broadcast()
+}
+
+// ROLE: wait for the clients to get their pending replies, then let main return
+func (app *App) exitAfterFlush() {
+	flushed := make(chan struct{})
+	go func() {
+		clientWriters.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(SHUTDOWN_FLUSH_TIMEOUT):
+		app.logWarning("Some clients didn't read their pending replies")
+	}
+	close(shutdownDone)
+}
+
+// ROLE: schedule a shutdown on SIGTERM and SIGINT, reload the config on SIGHUP
+// a second SIGINT during the shutdown exits right away
+func (app *App) handleSignals(signals chan os.Signal) {
+	for signal := range signals {
+		serverMutex.Lock()
+		if signal == syscall.SIGHUP {
+			app.reloadConfig()
+			serverMutex.Unlock()
+			continue
+		}
+		if shutdownAsap && signal == syscall.SIGINT {
+			app.logWarning("You insist... exiting now.")
+			app.removePidFile()
+			os.Exit(1)
+		}
+		app.logWarning("Received signal, scheduling shutdown...", "signal", signal.String())
+		shutdownAsap = true
+		serverMutex.Unlock()
+	}
+}
+
+// ROLE: write the process ID to the pidfile
+func (app *App) createPidFile() error {
+	if pidfile == "" {
+		return nil
+	}
+	return os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+func (app *App) removePidFile() {
+	if pidfile == "" {
+		return
+	}
+	if err := os.Remove(pidfile); err != nil && !os.IsNotExist(err) {
+		app.logWarning("failed to remove the pidfile", "error", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ROLE: check the client connection is closed by the server
+func expectClosed(t *testing.T, client *testClient) {
+	t.Helper()
+	client.connection.SetReadDeadline(time.Now().Add(TEST_TIMEOUT))
+	if _, err := client.reader.ReadByte(); err == nil {
+		t.Fatal("connection still open")
+	}
+}
+
+// ROLE: wait for the shutdown to let main return
+func expectShutdownDone(t *testing.T) {
+	t.Helper()
+	select {
+	case <-shutdownDone:
+	case <-time.After(TEST_TIMEOUT):
+		t.Fatal("shutdown not done")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	dir := t.TempDir()
+	pidfile := filepath.Join(dir, "redis.pid")
+	server := startTestServer(t, "pidfile "+pidfile)
+	server.locked(func() {
+		if err := server.app.createPidFile(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	client := server.connect(t)
+	other := server.connect(t)
+	client.do("SET", "key", "value")
+
+	for _, command := range [][]string{{"SHUTDOWN", "SAVE", "NOSAVE"}, {"SHUTDOWN", "ABORT", "NOW"}, {"SHUTDOWN", "LATER"}} {
+		expectReply(t, client.do(command...), respError("ERR syntax error"))
+	}
+	expectReply(t, client.do("SHUTDOWN", "ABORT"), respError("ERR No shutdown in progress."))
+
+	// SAVE writes the RDB even without save points, then every client is closed
+	client.send("SHUTDOWN", "SAVE")
+	expectClosed(t, client)
+	expectClosed(t, other)
+	expectShutdownDone(t)
+	if _, err := os.Stat(filepath.Join(server.dir, dbFileName)); err != nil {
+		t.Fatalf("no RDB after SHUTDOWN SAVE: %v", err)
+	}
+	if _, err := os.Stat(pidfile); !os.IsNotExist(err) {
+		t.Fatalf("pidfile after the shutdown: %v", err)
+	}
+	if connection, err := net.Dial("tcp", server.addr); err == nil {
+		connection.Close()
+		t.Fatal("connection accepted after the shutdown")
+	}
+}
+
+func TestShutdownSaveError(t *testing.T) {
+	port := freePort(t)
+	server := startTestServer(t, "port "+port)
+	client := server.connect(t)
+	// the RDB can't replace a directory
+	if err := os.MkdirAll(filepath.Join(server.dir, dbFileName, "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server goes on, listening again
+	expectReply(t, client.do("SHUTDOWN", "SAVE"), respError("ERR Errors trying to SHUTDOWN. Check logs."))
+	server.locked(func() {
+		if shutdownFinished || len(serverListeners) == 0 {
+			t.Fatalf("shutdown finished %v, %d listeners", shutdownFinished, len(serverListeners))
+		}
+	})
+	t.Cleanup(func() { server.locked(server.app.closeListeners) })
+	again := &testServer{addr: "127.0.0.1:" + port}
+	expectReply(t, again.connect(t).do("PING"), "PONG")
+
+	// FORCE exits anyway
+	client.send("SHUTDOWN", "SAVE", "FORCE")
+	expectClosed(t, client)
+	expectShutdownDone(t)
+}
+
+func TestShutdownWaitsForReplicas(t *testing.T) {
+	port := freePort(t)
+	server := startTestServer(t, "port "+port, "shutdown-timeout 10")
+	replica := server.connectReplica(t)
+	client := server.connect(t)
+	writer := server.connect(t)
+	admin := server.connect(t)
+
+	// the writes are paused while waiting, the blocked client gets an error on ABORT
+	client.send("SHUTDOWN", "NOSAVE")
+	waitFor(t, func() bool { return shutdownInProgress })
+	expectReply(t, admin.do("SHUTDOWN"), respError("ERR Shutdown already in progress."))
+	writer.send("SET", "key", "value")
+	writer.expectNoReply()
+	expectReply(t, admin.do("SHUTDOWN", "ABORT"), "OK")
+	expectReply(t, client.read(), respError("ERR Errors trying to SHUTDOWN. Check logs."))
+	expectReply(t, writer.read(), "OK")
+	replica.expectCommands([]string{"SELECT", "0"}, []string{"SET", "key", "value"})
+	t.Cleanup(func() { server.locked(server.app.closeListeners) })
+
+	// the shutdown finishes once the replica received the stream
+	client.send("SHUTDOWN", "NOSAVE")
+	waitFor(t, func() bool { return shutdownInProgress })
+	server.locked(server.app.shutdownCron)
+	expectClosed(t, client)
+	expectClosed(t, replica.testClient)
+	expectShutdownDone(t)
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	server := startTestServer(t)
+	client := server.connect(t)
+	// scheduled by the signal handler, started by the serverCron
+	server.locked(func() {
+		shutdownAsap = true
+		server.app.shutdownCron()
+	})
+	expectClosed(t, client)
+	expectShutdownDone(t)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+INFO: Slow log
+The commands running for at least slowlog-log-slower-than microseconds are
+logged, with their arguments and the client, in a list of at most
+slowlog-max-len entries, the newest first. A negative threshold disables
+the slow log, 0 logs every command.
+Only the handler of the command is timed, like in the commandstats: not the
+I/O, the checks rejecting a command nor the time spent waiting for a CLIENT
+PAUSE. The commands of a transaction are logged one by one, not the EXEC.
+*/
+
+const (
+	// arguments and bytes per argument kept in an entry
+	SLOWLOG_ENTRY_MAX_ARGC   = 32
+	SLOWLOG_ENTRY_MAX_STRING = 128
+)
+
+// for a slow log entry
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	// truncated and redacted arguments
+	args []string
+	// address and name of the client
+	peerID     string
+	clientName string
+}
+
+var (
+	// configuration parameters, see the configTable
+	slowlogLogSlowerThan int
+	slowlogMaxLen        int
+
+	// entries, the newest first
+	slowlog       []*slowlogEntry
+	slowlogNextID int64
+)
+
+// ROLE: log the command if it ran for longer than slowlog-log-slower-than
+// caller must hold the serverMutex
+func (app *App) slowlogPushEntryIfNeeded(client *Client, commands []string, duration time.Duration) {
+	if slowlogLogSlowerThan < 0 || duration.Microseconds() < int64(slowlogLogSlowerThan) {
+		return
+	}
+	entry := &slowlogEntry{
+		id:         slowlogNextID,
+		time:       time.Now(),
+		duration:   duration,
+		args:       slowlogTruncateArguments(redactCommandArguments(commands)),
+		peerID:     client.addr,
+		clientName: client.name,
+	}
+	slowlogNextID++
+	slowlog = append([]*slowlogEntry{entry}, slowlog...)
+	if len(slowlog) > slowlogMaxLen {
+		slowlog = slowlog[:slowlogMaxLen]
+	}
+}
+
+// ROLE: keep at most SLOWLOG_ENTRY_MAX_ARGC arguments of SLOWLOG_ENTRY_MAX_STRING bytes,
+// ex: "... (4 more arguments)", "... (1000 more bytes)"
+func slowlogTruncateArguments(commands []string) []string {
+	count := min(len(commands), SLOWLOG_ENTRY_MAX_ARGC)
+	args := make([]string, count)
+	for i := 0; i < count; i++ {
+		if count != len(commands) && i == count-1 {
+			args[i] = fmt.Sprintf("... (%d more arguments)", len(commands)-count+1)
+			break
+		}
+		args[i] = commands[i]
+		if len(args[i]) > SLOWLOG_ENTRY_MAX_STRING {
+			args[i] = fmt.Sprintf("%s... (%d more bytes)", args[i][:SLOWLOG_ENTRY_MAX_STRING], len(args[i])-SLOWLOG_ENTRY_MAX_STRING)
+		}
+	}
+	return args
+}
+
+// ROLE: handle SLOWLOG GET [count], LEN, RESET, HELP
+func (app *App) executeSLOWLOG(client *Client, commands []string) []byte {
+	subcommand := strings.ToUpper(commands[1])
+	switch {
+	case subcommand == "HELP" && len(commands) == 2:
+		return []byte(app.createRESPArray([]string{
+			"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET [<count>]",
+			"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+			"    Entries are made of:",
+			"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+			"    client name",
+			"LEN",
+			"    Return the length of the slowlog.",
+			"RESET",
+			"    Reset the slowlog.",
+		}))
+	case subcommand == "RESET" && len(commands) == 2:
+		slowlog = nil
+		return []byte("+OK\r\n")
+	case subcommand == "LEN" && len(commands) == 2:
+		return app.createIntegerResponse(len(slowlog))
+	case subcommand == "GET" && len(commands) <= 3:
+		count := 10
+		if len(commands) == 3 {
+			var err error
+			count, err = strconv.Atoi(commands[2])
+			if err != nil {
+				return []byte("-ERR value is not an integer or out of range\r\n")
+			}
+			if count < -1 {
+				return []byte("-ERR count should be greater than or equal to -1\r\n")
+			}
+			if count == -1 {
+				count = len(slowlog)
+			}
+		}
+		count = min(count, len(slowlog))
+		entries := make([][]byte, 0, count)
+		for _, entry := range slowlog[:count] {
+			entries = append(entries, app.createRESPArrayOfElements([][]byte{
+				app.createIntegerResponse(int(entry.id)),
+				app.createIntegerResponse(int(entry.time.Unix())),
+				app.createIntegerResponse(int(entry.duration.Microseconds())),
+				[]byte(app.createRESPArray(entry.args)),
+				app.createBulkStringResponse(entry.peerID),
+				app.createBulkStringResponse(entry.clientName),
+			}))
+		}
+		return app.createRESPArrayOfElements(entries)
+	}
+	return []byte(fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try SLOWLOG HELP.\r\n", commands[1]))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ROLE: the arguments of the slow log entries, the newest first
+func slowlogArguments(t *testing.T, client *testClient) [][]any {
+	t.Helper()
+	entries, ok := client.do("SLOWLOG", "GET", "-1").([]any)
+	if !ok {
+		t.Fatal("SLOWLOG GET: not an array")
+	}
+	args := make([][]any, len(entries))
+	for i, entry := range entries {
+		args[i] = entry.([]any)[3].([]any)
+	}
+	return args
+}
+
+func TestSlowlog(t *testing.T) {
+	server := startTestServer(t, "slowlog-log-slower-than 0", "slowlog-max-len 3")
+	client := server.connect(t)
+	client.do("CLIENT", "SETNAME", "logged")
+	client.do("SLOWLOG", "RESET")
+
+	client.do("SET", "key", "value")
+	// rejected before running, not logged
+	client.do("SET", "key")
+	client.do("NOSUCH")
+	client.do("AUTH", "user", "secret")
+	args := slowlogArguments(t, client)
+	expectReply(t, args, [][]any{{"AUTH", "(redacted)", "(redacted)"}, {"SET", "key", "value"}, {"SLOWLOG", "RESET"}})
+
+	entry := client.do("SLOWLOG", "GET", "1").([]any)[0].([]any)
+	if entry[4] != server.clientOf(client).addr || entry[5] != "logged" {
+		t.Fatalf("entry of the client: %v", entry)
+	}
+	// the newest entries are kept
+	expectReply(t, client.do("SLOWLOG", "LEN"), int64(3))
+	expectReply(t, client.do("SLOWLOG", "RESET"), "OK")
+	expectReply(t, client.do("SLOWLOG", "LEN"), int64(1))
+
+	// the commands of a transaction are logged, not the EXEC
+	client.do("SLOWLOG", "RESET")
+	client.do("MULTI")
+	client.do("GET", "key")
+	client.do("EXEC")
+	expectReply(t, slowlogArguments(t, client), [][]any{{"GET", "key"}, {"MULTI"}, {"SLOWLOG", "RESET"}})
+
+	expectReply(t, client.do("SLOWLOG", "GET", "-2"), respError("ERR count should be greater than or equal to -1"))
+	expectReply(t, client.do("SLOWLOG", "GET", "x"), respError("ERR value is not an integer or out of range"))
+
+	// a negative threshold disables the slow log
+	client.do("CONFIG", "SET", "slowlog-log-slower-than", "-1")
+	client.do("SLOWLOG", "RESET")
+	client.do("SET", "key", "value")
+	expectReply(t, client.do("SLOWLOG", "LEN"), int64(0))
+}
+
+func TestSlowlogTimesTheHandler(t *testing.T) {
+	server := startTestServer(t, "slowlog-log-slower-than 0")
+	admin := server.connect(t)
+	client := server.connect(t)
+
+	// the time spent waiting for the end of a pause is not part of the duration
+	admin.do("CLIENT", "PAUSE", "10000", "WRITE")
+	client.send("SET", "key", "value")
+	time.Sleep(200 * time.Millisecond)
+	admin.do("SLOWLOG", "RESET")
+	admin.do("CLIENT", "UNPAUSE")
+	expectReply(t, client.read(), "OK")
+
+	entry := admin.do("SLOWLOG", "GET", "1").([]any)[0].([]any)
+	expectReply(t, entry[3], []any{"SET", "key", "value"})
+	if duration := entry[2].(int64); duration >= (200 * time.Millisecond).Microseconds() {
+		t.Fatalf("duration of the SET %dus, with the pause", duration)
+	}
+}
+
+func TestSlowlogTruncateArguments(t *testing.T) {
+	long := strings.Repeat("x", SLOWLOG_ENTRY_MAX_STRING+10)
+	args := slowlogTruncateArguments([]string{"SET", "key", long})
+	expectReply(t, args[2], strings.Repeat("x", SLOWLOG_ENTRY_MAX_STRING)+"... (10 more bytes)")
+
+	many := make([]string, SLOWLOG_ENTRY_MAX_ARGC+5)
+	args = slowlogTruncateArguments(many)
+	if len(args) != SLOWLOG_ENTRY_MAX_ARGC || args[SLOWLOG_ENTRY_MAX_ARGC-1] != "... (6 more arguments)" {
+		t.Fatalf("truncated arguments: %d, last %q", len(args), args[len(args)-1])
+	}
+	if len(slowlogTruncateArguments(make([]string, SLOWLOG_ENTRY_MAX_ARGC))) != SLOWLOG_ENTRY_MAX_ARGC {
+		t.Fatal("arguments truncated at the limit")
+	}
+}
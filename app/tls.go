@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+INFO: TLS for the client connections and the replication link
+Clients connect to tls-port, with tls-auth-clients their certificate must be
+signed by tls-ca-cert-file (yes, or optional when one is sent). With
+tls-auth-clients-user CN, which also requires tls-ca-cert-file, the client is
+authenticated as the ACL user named as the certificate Common Name.
+*/
+
+// handshakes taking longer are dropped, a silent client can't hold a goroutine forever
+const TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"TLSv1":   tls.VersionTLS10,
+	"TLSv1.1": tls.VersionTLS11,
+	"TLSv1.2": tls.VersionTLS12,
+	"TLSv1.3": tls.VersionTLS13,
+}
+
+// ROLE: parse tls-protocols, ex: "TLSv1.2 TLSv1.3"
+// returns the lowest and highest versions, the range in between is allowed
+func parseTLSProtocols(value string) (uint16, uint16, error) {
+	var minVersion, maxVersion uint16
+	for _, name := range strings.Fields(value) {
+		version, ok := tlsVersions[name]
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid tls-protocols '%s', expected TLSv1, TLSv1.1, TLSv1.2 or TLSv1.3", name)
+		}
+		if minVersion == 0 || version < minVersion {
+			minVersion = version
+		}
+		if version > maxVersion {
+			maxVersion = version
+		}
+	}
+	if minVersion == 0 {
+		return 0, 0, fmt.Errorf("tls-protocols is empty")
+	}
+	return minVersion, maxVersion, nil
+}
+
+// ROLE: parse tls-ciphers, the TLSv1.2 cipher suites separated by ':'
+// names are the IANA ones, ex: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+func parseTLSCiphers(value string) ([]uint16, error) {
+	if value == "" {
+		return nil, nil
+	}
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	var ciphers []uint16
+	for _, name := range strings.Split(value, ":") {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite '%s' in tls-ciphers", name)
+		}
+		ciphers = append(ciphers, id)
+	}
+	return ciphers, nil
+}
+
+// ROLE: load the CA certificates used to verify the peers
+func loadTLSCACertificates(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tls-ca-cert-file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificate found in tls-ca-cert-file %s", file)
+	}
+	return pool, nil
+}
+
+// ROLE: settings shared by the server and the replication client configs
+func (app *App) tlsBaseConfig() (*tls.Config, error) {
+	minVersion, maxVersion, err := parseTLSProtocols(tlsProtocols)
+	if err != nil {
+		return nil, err
+	}
+	ciphers, err := parseTLSCiphers(tlsCiphers)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:   minVersion,
+		MaxVersion:   maxVersion,
+		CipherSuites: ciphers,
+	}, nil
+}
+
+// ROLE: config of the tls-port listener
+func (app *App) tlsServerConfig() (*tls.Config, error) {
+	if tlsCertFile == "" || tlsKeyFile == "" {
+		return nil, fmt.Errorf("tls-cert-file and tls-key-file are required with tls-port")
+	}
+	config, err := app.tlsBaseConfig()
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the server certificate: %w", err)
+	}
+	config.Certificates = []tls.Certificate{certificate}
+
+	config.ClientCAs, err = loadTLSCACertificates(tlsCACertFile)
+	if err != nil {
+		return nil, err
+	}
+	// without a CA, the client certificates would be verified against the system roots
+	switch strings.ToLower(tlsAuthClients) {
+	case "yes":
+		if config.ClientCAs == nil {
+			return nil, fmt.Errorf("tls-auth-clients requires tls-ca-cert-file")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		if config.ClientCAs == nil {
+			return nil, fmt.Errorf("tls-auth-clients optional requires tls-ca-cert-file")
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "no":
+		config.ClientAuth = tls.NoClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients '%s', expected yes, no or optional", tlsAuthClients)
+	}
+	if tlsAuthClientsUser != "" {
+		if !strings.EqualFold(tlsAuthClientsUser, "CN") {
+			return nil, fmt.Errorf("invalid tls-auth-clients-user '%s', expected CN", tlsAuthClientsUser)
+		}
+		// a user is only trusted from a certificate signed by our CA
+		if config.ClientCAs == nil {
+			return nil, fmt.Errorf("tls-auth-clients-user requires tls-ca-cert-file")
+		}
+	}
+	return config, nil
+}
+
+// ROLE: config of the TLS replication link, our certificate is sent if the master asks for it
+func (app *App) tlsReplicationConfig(host string) (*tls.Config, error) {
+	config, err := app.tlsBaseConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.ServerName = host
+	config.RootCAs, err = loadTLSCACertificates(tlsCACertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// tls-client-cert-file defaults to the server certificate
+	certFile, keyFile := tlsClientCertFile, tlsClientKeyFile
+	if certFile == "" {
+		certFile, keyFile = tlsCertFile, tlsKeyFile
+	}
+	if certFile != "" && keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// ROLE: dial the master, over TLS with tls-replication
+func (app *App) dialMaster(address string) (net.Conn, error) {
+	if !tlsReplication {
+		return net.Dial("tcp", address)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	config, err := app.tlsReplicationConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", address, config)
+}
+
+// ROLE: complete the TLS handshake of an accepted connection
+func (app *App) tlsHandshake(connection *tls.Conn) error {
+	connection.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	if err := connection.Handshake(); err != nil {
+		return err
+	}
+	return connection.SetDeadline(time.Time{})
+}
+
+// ROLE: with tls-auth-clients-user CN, authenticate the client as the ACL user
+// named as its certificate Common Name, if there is such an enabled user
+// caller must hold the serverMutex
+func (app *App) tlsAuthenticateClient(client *Client, connection *tls.Conn) {
+	if tlsAuthClientsUser == "" {
+		return
+	}
+	certificates := connection.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return
+	}
+	user, ok := users[certificates[0].Subject.CommonName]
+	if !ok || user.flags&USER_FLAG_DISABLED != 0 {
+		return
+	}
+	client.user = user
+	client.authenticated = true
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a test certificate and the files of its PEM encoding
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	tls         tls.Certificate
+	certFile    string
+	keyFile     string
+}
+
+// ROLE: create a certificate signed by the parent, self-signed without a parent
+func newTestCertificate(t *testing.T, name string, parent *testCertificate, isCA bool) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	result := &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certFile:    filepath.Join(dir, name+".crt"),
+		keyFile:     filepath.Join(dir, name+".key"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(result.certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(result.keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if result.tls, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// the certificates of a TLS test: our CA, the server, a client of our CA and one of another CA
+type testPKI struct {
+	ca, server, client, rogue *testCertificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	ca := newTestCertificate(t, "test-ca", nil, true)
+	rogueCA := newTestCertificate(t, "rogue-ca", nil, true)
+	return &testPKI{
+		ca:     ca,
+		server: newTestCertificate(t, "server", ca, false),
+		client: newTestCertificate(t, "alice", ca, false),
+		rogue:  newTestCertificate(t, "alice", rogueCA, false),
+	}
+}
+
+// ROLE: open a TLS listener on the test server with the current tls-* config
+func (server *testServer) listenTLS(t *testing.T) string {
+	t.Helper()
+	var address string
+	server.locked(func() {
+		config, err := server.app.tlsServerConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		go server.app.acceptConnections(listener)
+		address = listener.Addr().String()
+	})
+	return address
+}
+
+// ROLE: connect over TLS, with the client certificate if any, and send a command
+// returns the reply, or the error of a refused handshake
+func connectTLS(t *testing.T, address string, pki *testPKI, certificate *testCertificate, args ...string) (any, error) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.ca.certificate)
+	config := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	if certificate != nil {
+		// sent even when the server doesn't list its CA as acceptable
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &certificate.tls, nil
+		}
+	}
+	connection, err := tls.Dial("tcp", address, config)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { connection.Close() })
+	connection.SetDeadline(time.Now().Add(TEST_TIMEOUT))
+	client := &testClient{t: t, connection: connection, reader: bufio.NewReader(connection)}
+	client.send(args...)
+	// with TLSv1.3 a refused client certificate shows up on the first read
+	if _, err := client.reader.Peek(1); err != nil {
+		return nil, err
+	}
+	return client.read(), nil
+}
+
+func TestTLSServerConfigRequiresCA(t *testing.T) {
+	pki := newTestPKI(t)
+	tests := []struct {
+		authClients, authClientsUser, caFile string
+		valid                                bool
+	}{
+		{"yes", "", pki.ca.certFile, true},
+		{"yes", "", "", false},
+		{"optional", "", pki.ca.certFile, true},
+		{"optional", "", "", false},
+		{"no", "", "", true},
+		{"no", "CN", pki.ca.certFile, true},
+		// the system roots must not authenticate users
+		{"no", "CN", "", false},
+	}
+	startTestServer(t)
+	app := &App{}
+	for _, test := range tests {
+		tlsCertFile, tlsKeyFile = pki.server.certFile, pki.server.keyFile
+		tlsAuthClients, tlsAuthClientsUser, tlsCACertFile = test.authClients, test.authClientsUser, test.caFile
+		if _, err := app.tlsServerConfig(); (err == nil) != test.valid {
+			t.Fatalf("tls-auth-clients %s, tls-auth-clients-user %q, CA %q: error %v", test.authClients, test.authClientsUser, test.caFile, err)
+		}
+	}
+}
+
+func TestTLSAuthClients(t *testing.T) {
+	pki := newTestPKI(t)
+	tests := []struct {
+		authClients            string
+		none, ourCA, anotherCA bool
+	}{
+		{"yes", false, true, false},
+		{"optional", true, true, false},
+		{"no", true, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.authClients, func(t *testing.T) {
+			server := startTestServer(t,
+				"tls-cert-file "+pki.server.certFile, "tls-key-file "+pki.server.keyFile,
+				"tls-ca-cert-file "+pki.ca.certFile, "tls-auth-clients "+test.authClients)
+			address := server.listenTLS(t)
+			for _, client := range []struct {
+				certificate *testCertificate
+				accepted    bool
+			}{{nil, test.none}, {pki.client, test.ourCA}, {pki.rogue, test.anotherCA}} {
+				reply, err := connectTLS(t, address, pki, client.certificate, "PING")
+				if (err == nil) != client.accepted || (err == nil && reply != "PONG") {
+					t.Fatalf("certificate %v: reply %v, error %v", client.certificate != nil, reply, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSAuthClientsUser(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startTestServer(t,
+		"tls-cert-file "+pki.server.certFile, "tls-key-file "+pki.server.keyFile,
+		"tls-ca-cert-file "+pki.ca.certFile, "tls-auth-clients optional", "tls-auth-clients-user CN",
+		"requirepass secret")
+	admin := server.connect(t)
+	admin.do("AUTH", "secret")
+	admin.do("ACL", "SETUSER", "alice", "on", ">other", "+@all", "~*")
+	address := server.listenTLS(t)
+
+	// the Common Name of a certificate of our CA logs in as the user
+	reply, err := connectTLS(t, address, pki, pki.client, "ACL", "WHOAMI")
+	if err != nil || reply != "alice" {
+		t.Fatalf("client certificate of alice: reply %v, error %v", reply, err)
+	}
+	// without a certificate, the client must authenticate
+	reply, err = connectTLS(t, address, pki, nil, "ACL", "WHOAMI")
+	if err != nil || reply != respError("NOAUTH Authentication required.") {
+		t.Fatalf("no client certificate: reply %v, error %v", reply, err)
+	}
+	// a disabled user is not authenticated
+	admin.do("ACL", "SETUSER", "alice", "off")
+	reply, err = connectTLS(t, address, pki, pki.client, "ACL", "WHOAMI")
+	if err != nil || reply != respError("NOAUTH Authentication required.") {
+		t.Fatalf("client certificate of a disabled user: reply %v, error %v", reply, err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+INFO: Server assisted client side caching (CLIENT TRACKING)
+Default mode: the server remembers the keys read by the client and sends an
+invalidation message the first time one of them is modified, the key is then
+forgotten until the client reads it again.
+BCAST mode: the client gets an invalidation message for every modified key
+matching one of its prefixes, nothing is remembered per key.
+RESP3 clients get "invalidate" push messages, RESP2 clients need to redirect
+them to another connection subscribed to __redis__:invalidate.
+*/
+
+const TRACKING_INVALIDATE_CHANNEL = "__redis__:invalidate"
+
+var (
+	// key -> IDs of the clients which may have it cached
+	trackingTable = make(map[string]map[int64]bool)
+	// BCAST prefix -> clients subscribed to it
+	trackingPrefixTable = make(map[string]map[*Client]bool)
+)
+
+// ROLE: enable tracking for the client, options are the CLIENT_TRACKING_* flags
+func (app *App) enableTracking(client *Client, redirect int64, options int, prefixes []string) {
+	client.flags |= CLIENT_TRACKING
+	client.flags &^= CLIENT_TRACKING_BROKEN_REDIR | CLIENT_TRACKING_BCAST | CLIENT_TRACKING_OPTIN |
+		CLIENT_TRACKING_OPTOUT | CLIENT_TRACKING_NOLOOP
+	client.flags |= options & (CLIENT_TRACKING_BCAST | CLIENT_TRACKING_OPTIN | CLIENT_TRACKING_OPTOUT | CLIENT_TRACKING_NOLOOP)
+	client.trackingRedirect = redirect
+
+	if options&CLIENT_TRACKING_BCAST == 0 {
+		return
+	}
+	// no prefix means every key
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	for _, prefix := range prefixes {
+		if trackingPrefixTable[prefix] == nil {
+			trackingPrefixTable[prefix] = make(map[*Client]bool)
+		}
+		trackingPrefixTable[prefix][client] = true
+		client.trackingPrefixes[prefix] = true
+	}
+}
+
+// ROLE: disable tracking for the client
+// the keys it read are left in the tracking table, they are dropped on the next invalidation
+func (app *App) disableTracking(client *Client) {
+	if client.flags&CLIENT_TRACKING == 0 {
+		return
+	}
+	for prefix := range client.trackingPrefixes {
+		delete(trackingPrefixTable[prefix], client)
+		if len(trackingPrefixTable[prefix]) == 0 {
+			delete(trackingPrefixTable, prefix)
+		}
+	}
+	client.trackingPrefixes = make(map[string]bool)
+	client.flags &^= CLIENT_TRACKING | CLIENT_TRACKING_BROKEN_REDIR | CLIENT_TRACKING_BCAST |
+		CLIENT_TRACKING_OPTIN | CLIENT_TRACKING_OPTOUT | CLIENT_TRACKING_CACHING | CLIENT_TRACKING_NOLOOP
+	client.trackingRedirect = 0
+}
+
+// ROLE: check the new prefixes don't overlap with each other or the ones of the client
+// returns the error response
+func (app *App) checkPrefixCollisions(client *Client, prefixes []string) []byte {
+	for i, prefix := range prefixes {
+		for existing := range client.trackingPrefixes {
+			if strings.HasPrefix(existing, prefix) || strings.HasPrefix(prefix, existing) {
+				return []byte(fmt.Sprintf("-ERR Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.\r\n", prefix, existing))
+			}
+		}
+		for j := i + 1; j < len(prefixes); j++ {
+			if strings.HasPrefix(prefixes[j], prefix) || strings.HasPrefix(prefix, prefixes[j]) {
+				return []byte(fmt.Sprintf("-ERR Prefix '%s' overlaps with another provided prefix '%s'. Prefixes for a single client must not overlap.\r\n", prefix, prefixes[j]))
+			}
+		}
+	}
+	return nil
+}
+
+// ROLE: remember the keys read by the command, in default mode
+// with OPTIN only after CLIENT CACHING YES, with OPTOUT unless CLIENT CACHING NO
+func (app *App) trackingRememberKeys(client *Client, command *Command, commands []string) {
+	if client.flags&CLIENT_TRACKING == 0 || client.flags&CLIENT_TRACKING_BCAST != 0 {
+		return
+	}
+	caching := client.flags&CLIENT_TRACKING_CACHING != 0
+	if (client.flags&CLIENT_TRACKING_OPTIN != 0 && !caching) || (client.flags&CLIENT_TRACKING_OPTOUT != 0 && caching) {
+		return
+	}
+	for _, key := range getKeysFromCommand(command, commands) {
+		if trackingTable[key] == nil {
+			trackingTable[key] = make(map[int64]bool)
+		}
+		trackingTable[key][client.id] = true
+	}
+}
+
+// ROLE: send the invalidation message to the client, or the client it redirects to
+// keys is the invalidated key, nil for a flush
+func (app *App) sendTrackingMessage(client *Client, keys []string) {
+	target := client
+	if client.trackingRedirect != 0 {
+		target = clients[client.trackingRedirect]
+		if target == nil {
+			// tell the client once its redirection is broken, RESP3 only
+			if client.flags&CLIENT_TRACKING_BROKEN_REDIR == 0 {
+				client.flags |= CLIENT_TRACKING_BROKEN_REDIR
+				if client.protocol == 3 {
+					app.WriteToClient(client, app.createPushResponse(client, [][]byte{
+						app.createBulkStringResponse("tracking-redir-broken"),
+						app.createIntegerResponse(int(client.trackingRedirect)),
+					}))
+				}
+			}
+			return
+		}
+	}
+
+	payload := app.createNullResponse(target)
+	if keys != nil {
+		elements := make([][]byte, 0, len(keys))
+		for _, key := range keys {
+			elements = append(elements, app.createBulkStringResponse(key))
+		}
+		payload = app.createRESPArrayOfElements(elements)
+	}
+
+	switch {
+	case target.protocol == 3:
+		app.WriteToClient(target, app.createPushResponse(target, [][]byte{
+			app.createBulkStringResponse("invalidate"), payload,
+		}))
+	case client.trackingRedirect != 0 && target.channels[TRACKING_INVALIDATE_CHANNEL]:
+		app.WriteToClient(target, app.createPushResponse(target, [][]byte{
+			app.createBulkStringResponse("message"), app.createBulkStringResponse(TRACKING_INVALIDATE_CHANNEL), payload,
+		}))
+	default:
+		// RESP2 without redirection, there is no way to send it
+	}
+}
+
+// ROLE: the key was modified, invalidate it for the clients which may have it cached
+// with NOLOOP the client which modified the key is not notified
+func (app *App) trackingInvalidateKey(key string) {
+	for prefix, subscribers := range trackingPrefixTable {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for client := range subscribers {
+			if client.flags&CLIENT_TRACKING_NOLOOP != 0 && client == currentClient {
+				continue
+			}
+			app.sendTrackingMessage(client, []string{key})
+		}
+	}
+
+	ids, ok := trackingTable[key]
+	if !ok {
+		return
+	}
+	delete(trackingTable, key)
+	for id := range ids {
+		client := clients[id]
+		// the client went away or changed its tracking mode meanwhile
+		if client == nil || client.flags&CLIENT_TRACKING == 0 || client.flags&CLIENT_TRACKING_BCAST != 0 {
+			continue
+		}
+		if client.flags&CLIENT_TRACKING_NOLOOP != 0 && client == currentClient {
+			continue
+		}
+		app.sendTrackingMessage(client, []string{key})
+	}
+}
+
+// ROLE: a database was flushed, every tracking client invalidates its whole cache
+func (app *App) trackingInvalidateKeysOnFlush() {
+	for _, client := range clients {
+		if client.flags&CLIENT_TRACKING != 0 {
+			app.sendTrackingMessage(client, nil)
+		}
+	}
+	trackingTable = make(map[string]map[int64]bool)
+}
+
+// ROLE: handle CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func (app *App) clientTRACKING(client *Client, commands []string) []byte {
+	var (
+		options  int
+		redirect int64
+		prefixes []string
+	)
+	for i := 3; i < len(commands); i++ {
+		switch option := strings.ToUpper(commands[i]); {
+		case option == "REDIRECT" && i+1 < len(commands):
+			if redirect != 0 {
+				return []byte("-ERR A client can only redirect to a single other client\r\n")
+			}
+			id, err := strconv.ParseInt(commands[i+1], 10, 64)
+			if err != nil {
+				return []byte("-ERR value is not an integer or out of range\r\n")
+			}
+			// redirecting to ourselves is the same as no redirection
+			if id != client.id {
+				redirect = id
+			}
+			i++
+		case option == "PREFIX" && i+1 < len(commands):
+			prefixes = append(prefixes, commands[i+1])
+			i++
+		case option == "BCAST":
+			options |= CLIENT_TRACKING_BCAST
+		case option == "OPTIN":
+			options |= CLIENT_TRACKING_OPTIN
+		case option == "OPTOUT":
+			options |= CLIENT_TRACKING_OPTOUT
+		case option == "NOLOOP":
+			options |= CLIENT_TRACKING_NOLOOP
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+
+	switch strings.ToUpper(commands[2]) {
+	case "ON":
+		if options&CLIENT_TRACKING_BCAST == 0 && len(prefixes) > 0 {
+			return []byte("-ERR PREFIX option requires BCAST mode to be enabled\r\n")
+		}
+		if client.flags&CLIENT_TRACKING != 0 && (client.flags^options)&CLIENT_TRACKING_BCAST != 0 {
+			return []byte("-ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.\r\n")
+		}
+		if options&CLIENT_TRACKING_OPTIN != 0 && options&CLIENT_TRACKING_OPTOUT != 0 {
+			return []byte("-ERR You can't use both OPTIN and OPTOUT modes together\r\n")
+		}
+		if options&CLIENT_TRACKING_BCAST != 0 && options&(CLIENT_TRACKING_OPTIN|CLIENT_TRACKING_OPTOUT) != 0 {
+			return []byte("-ERR OPTIN and OPTOUT are not compatible with BCAST\r\n")
+		}
+		if redirect != 0 && clients[redirect] == nil {
+			return []byte("-ERR The client ID you want redirect to does not exist\r\n")
+		}
+		if errResponse := app.checkPrefixCollisions(client, prefixes); errResponse != nil {
+			return errResponse
+		}
+		app.enableTracking(client, redirect, options, prefixes)
+	case "OFF":
+		app.disableTracking(client)
+	default:
+		return []byte("-ERR syntax error\r\n")
+	}
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle CLIENT CACHING YES|NO, applies to the next command (or transaction)
+func (app *App) clientCACHING(client *Client, commands []string) []byte {
+	if client.flags&CLIENT_TRACKING == 0 || client.flags&(CLIENT_TRACKING_OPTIN|CLIENT_TRACKING_OPTOUT) == 0 {
+		return []byte("-ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled\r\n")
+	}
+	switch strings.ToUpper(commands[2]) {
+	case "YES":
+		if client.flags&CLIENT_TRACKING_OPTIN == 0 {
+			return []byte("-ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.\r\n")
+		}
+	case "NO":
+		if client.flags&CLIENT_TRACKING_OPTOUT == 0 {
+			return []byte("-ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.\r\n")
+		}
+	default:
+		return []byte("-ERR syntax error\r\n")
+	}
+	client.flags |= CLIENT_TRACKING_CACHING
+	return []byte("+OK\r\n")
+}
+
+// ROLE: handle CLIENT GETREDIR, -1 when tracking is off, 0 without redirection
+func (app *App) clientGETREDIR(client *Client) []byte {
+	if client.flags&CLIENT_TRACKING == 0 {
+		return app.createIntegerResponse(-1)
+	}
+	return app.createIntegerResponse(int(client.trackingRedirect))
+}
+
+// ROLE: handle CLIENT TRACKINGINFO
+func (app *App) clientTRACKINGINFO(client *Client) []byte {
+	var flags []string
+	if client.flags&CLIENT_TRACKING == 0 {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		for _, flag := range []struct {
+			mask int
+			name string
+		}{
+			{CLIENT_TRACKING_BCAST, "bcast"},
+			{CLIENT_TRACKING_OPTIN, "optin"},
+			{CLIENT_TRACKING_OPTOUT, "optout"},
+			{CLIENT_TRACKING_CACHING, "caching-yes"},
+			{CLIENT_TRACKING_NOLOOP, "noloop"},
+			{CLIENT_TRACKING_BROKEN_REDIR, "broken_redirect"},
+		} {
+			if client.flags&flag.mask == 0 {
+				continue
+			}
+			name := flag.name
+			if flag.mask == CLIENT_TRACKING_CACHING && client.flags&CLIENT_TRACKING_OPTOUT != 0 {
+				name = "caching-no"
+			}
+			flags = append(flags, name)
+		}
+	}
+
+	redirect := -1
+	if client.flags&CLIENT_TRACKING != 0 {
+		redirect = int(client.trackingRedirect)
+	}
+	prefixes := make([]string, 0, len(client.trackingPrefixes))
+	for prefix := range client.trackingPrefixes {
+		prefixes = append(prefixes, prefix)
+	}
+
+	return app.createMapResponse(client, [][]byte{
+		app.createBulkStringResponse("flags"), []byte(app.createRESPArray(flags)),
+		app.createBulkStringResponse("redirect"), app.createIntegerResponse(redirect),
+		app.createBulkStringResponse("prefixes"), []byte(app.createRESPArray(prefixes)),
+	})
+}
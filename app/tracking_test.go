@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestTrackingDefaultMode(t *testing.T) {
+	server := startTestServer(t)
+	cache := server.connect(t)
+	writer := server.connect(t)
+	cache.do("HELLO", "3")
+	expectReply(t, cache.do("CLIENT", "TRACKING", "ON"), "OK")
+
+	writer.do("SET", "a", "1")
+	writer.do("SET", "b", "1")
+	cache.do("GET", "a")
+	// only the keys read are invalidated, once
+	writer.do("SET", "b", "2")
+	writer.do("SET", "a", "2")
+	expectReply(t, cache.read(), []any{"invalidate", []any{"a"}})
+	writer.do("SET", "a", "3")
+	cache.expectNoReply()
+
+	// flushes invalidate everything with a null
+	cache.do("GET", "a")
+	writer.do("FLUSHALL")
+	expectReply(t, cache.read(), []any{"invalidate", nil})
+
+	// the own writes too, unless NOLOOP
+	cache.do("GET", "a")
+	expectReply(t, cache.do("SET", "a", "4"), []any{"invalidate", []any{"a"}})
+	cache.read()
+	cache.do("CLIENT", "TRACKING", "ON", "NOLOOP")
+	cache.do("GET", "a")
+	expectReply(t, cache.do("SET", "a", "5"), "OK")
+
+	expectReply(t, cache.do("CLIENT", "TRACKING", "OFF"), "OK")
+	expectReply(t, cache.do("CLIENT", "GETREDIR"), -1)
+	writer.do("SET", "a", "6")
+	cache.expectNoReply()
+}
+
+func TestTrackingRedirect(t *testing.T) {
+	server := startTestServer(t)
+	cache := server.connect(t)
+	invalidations := server.connect(t)
+	writer := server.connect(t)
+
+	id := invalidations.do("CLIENT", "ID").(int64)
+	invalidations.do("SUBSCRIBE", TRACKING_INVALIDATE_CHANNEL)
+	redirect := []string{"CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(id, 10)}
+	expectReply(t, cache.do(redirect...), "OK")
+	expectReply(t, cache.do("CLIENT", "GETREDIR"), id)
+
+	cache.do("GET", "key")
+	writer.do("SET", "key", "value")
+	expectReply(t, invalidations.read(), []any{"message", TRACKING_INVALIDATE_CHANNEL, []any{"key"}})
+
+	// the redirection breaks when the other client goes away
+	invalidations.connection.Close()
+	waitFor(t, func() bool { return clients[id] == nil })
+	cache.do("HELLO", "3")
+	cache.do("GET", "key")
+	writer.do("SET", "key", "other")
+	expectReply(t, cache.read(), []any{"tracking-redir-broken", id})
+	expectReply(t, cache.do("CLIENT", "TRACKING", "ON", "REDIRECT", "12345"), respError("ERR The client ID you want redirect to does not exist"))
+}
+
+func TestTrackingBroadcast(t *testing.T) {
+	server := startTestServer(t)
+	cache := server.connect(t)
+	writer := server.connect(t)
+	cache.do("HELLO", "3")
+
+	expectReply(t, cache.do("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "us"), respError("ERR Prefix 'user:' overlaps with another provided prefix 'us'. Prefixes for a single client must not overlap."))
+	expectReply(t, cache.do("CLIENT", "TRACKING", "ON", "PREFIX", "user:"), respError("ERR PREFIX option requires BCAST mode to be enabled"))
+	expectReply(t, cache.do("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "cart:"), "OK")
+
+	// no read needed, every key of the prefixes
+	writer.do("SET", "user:1", "a")
+	expectReply(t, cache.read(), []any{"invalidate", []any{"user:1"}})
+	writer.do("SET", "user:1", "b")
+	expectReply(t, cache.read(), []any{"invalidate", []any{"user:1"}})
+	writer.do("SET", "other", "a")
+	cache.expectNoReply()
+	expectReply(t, cache.do("CLIENT", "TRACKING", "ON"), respError("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode."))
+}
+
+func TestTrackingOptInOptOut(t *testing.T) {
+	server := startTestServer(t)
+	cache := server.connect(t)
+	writer := server.connect(t)
+	cache.do("HELLO", "3")
+
+	expectReply(t, cache.do("CLIENT", "CACHING", "YES"), respError("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled"))
+	cache.do("CLIENT", "TRACKING", "ON", "OPTIN")
+	cache.do("GET", "skipped")
+	cache.do("CLIENT", "CACHING", "YES")
+	cache.do("GET", "cached")
+	// CACHING applies to the next command only
+	cache.do("GET", "skipped-too")
+	writer.do("SET", "skipped", "x")
+	writer.do("SET", "skipped-too", "x")
+	writer.do("SET", "cached", "x")
+	expectReply(t, cache.read(), []any{"invalidate", []any{"cached"}})
+
+	cache.do("CLIENT", "TRACKING", "OFF")
+	cache.do("CLIENT", "TRACKING", "ON", "OPTOUT")
+	cache.do("CLIENT", "CACHING", "NO")
+	cache.do("GET", "skipped")
+	cache.do("GET", "cached")
+	writer.do("SET", "skipped", "y")
+	writer.do("SET", "cached", "y")
+	expectReply(t, cache.read(), []any{"invalidate", []any{"cached"}})
+
+	info := cache.do("CLIENT", "TRACKINGINFO").([]any)
+	expectReply(t, info[0:2], []any{"flags", []any{"on", "optout"}})
+}